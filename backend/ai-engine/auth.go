@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 角色名稱，對應 openapi.yaml 的 x-roles。
+const (
+	RoleTeamMember  = "team_member"
+	RoleTeamManager = "team_manager"
+	RoleSuperAdmin  = "super_admin"
+	// RoleChangePublisher 為 CI/CD 回報變更使用的服務帳號角色。
+	RoleChangePublisher = "change_publisher"
+)
+
+// reportRoles 為可建立與查詢分析報告的角色。
+var reportRoles = []string{RoleTeamMember, RoleTeamManager, RoleSuperAdmin}
+
+// changePublisherRoles 為可回報部署與設定變更的角色。
+var changePublisherRoles = []string{RoleChangePublisher, RoleTeamManager, RoleSuperAdmin}
+
+const (
+	defaultRolesClaim          = "roles"
+	keycloakRolesClaim         = "realm_access.roles"
+	defaultTeamsClaim          = "teams"
+	defaultJWKSRefreshInterval = time.Hour
+	// jwksMinRefreshInterval 限制遇到未知 kid 時重新抓取 JWKS 的頻率。
+	jwksMinRefreshInterval = 30 * time.Second
+	maxJWKSBytes           = 1 << 20
+)
+
+var (
+	// ErrAuthNotConfigured 代表未設定任何 JWT 驗證金鑰。
+	ErrAuthNotConfigured = errors.New("jwt verification key is not configured")
+	// ErrMissingBearerToken 代表請求未帶 Bearer 權杖。
+	ErrMissingBearerToken = errors.New("missing bearer token")
+	// ErrJWKSKeyNotFound 代表 JWKS 中找不到權杖指定的金鑰。
+	ErrJWKSKeyNotFound = errors.New("jwks key not found")
+)
+
+// AuthConfig 設定 JWT 驗證，HS256 與 RS256 (JWKS) 可同時啟用。
+type AuthConfig struct {
+	// HS256Secret 為 HS256 簽章的共用密鑰。
+	HS256Secret string
+	// JWKSFile 與 JWKSURL 提供 RS256 公鑰，擇一設定。
+	JWKSFile string
+	JWKSURL  string
+	// JWKSRefreshInterval 為 JWKSURL 的重新載入間隔，預設 1 小時。
+	JWKSRefreshInterval time.Duration
+	// Issuer 與 Audience 設定時驗證 iss 與 aud claim。
+	Issuer   string
+	Audience string
+	// RolesClaim 為角色 claim 路徑，以點分隔巢狀欄位；未設定時依序使用 roles 與 Keycloak 的 realm_access.roles。
+	RolesClaim string
+	// TeamsClaim 為所屬團隊 claim 路徑，預設 teams。
+	TeamsClaim string
+	// Leeway 為驗證 exp/nbf 時容許的時鐘誤差。
+	Leeway     time.Duration
+	HTTPClient *http.Client
+}
+
+// Principal 為通過驗證的請求者。
+type Principal struct {
+	Subject  string   `json:"sub"`
+	Username string   `json:"username,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	Teams    []string `json:"teams,omitempty"`
+}
+
+// Name 回傳記錄於報告的使用者名稱，未提供 username 時使用 subject。
+func (p Principal) Name() string {
+	if p.Username != "" {
+		return p.Username
+	}
+	return p.Subject
+}
+
+// HasAnyRole 判斷請求者是否具備任一指定角色。
+func (p Principal) HasAnyRole(roles ...string) bool {
+	for _, role := range roles {
+		if slices.Contains(p.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator 驗證 Bearer JWT 並檢查角色。
+type Authenticator struct {
+	secret     []byte
+	jwks       *jwksKeySet
+	parser     *jwt.Parser
+	rolesClaim string
+	teamsClaim string
+}
+
+// NewAuthenticator 依設定建立驗證器；JWKSFile 於建立時載入，JWKSURL 於首次驗證時抓取。
+func NewAuthenticator(cfg AuthConfig) (*Authenticator, error) {
+	if cfg.HS256Secret == "" && cfg.JWKSFile == "" && cfg.JWKSURL == "" {
+		return nil, ErrAuthNotConfigured
+	}
+	if cfg.JWKSFile != "" && cfg.JWKSURL != "" {
+		return nil, errors.New("JWKSFile 與 JWKSURL 只能擇一設定")
+	}
+
+	auth := &Authenticator{rolesClaim: cfg.RolesClaim, teamsClaim: cfg.TeamsClaim}
+	if auth.teamsClaim == "" {
+		auth.teamsClaim = defaultTeamsClaim
+	}
+	var methods []string
+	if cfg.HS256Secret != "" {
+		auth.secret = []byte(cfg.HS256Secret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if cfg.JWKSFile != "" || cfg.JWKSURL != "" {
+		auth.jwks = newJWKSKeySet(cfg)
+		if cfg.JWKSFile != "" {
+			raw, err := os.ReadFile(cfg.JWKSFile)
+			if err != nil {
+				return nil, fmt.Errorf("無法讀取 JWKS 檔案: %w", err)
+			}
+			keys, err := parseJWKS(raw)
+			if err != nil {
+				return nil, err
+			}
+			auth.jwks.keys = keys
+		}
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	auth.parser = jwt.NewParser(opts...)
+	return auth, nil
+}
+
+// Authenticate 驗證權杖簽章與時效，回傳請求者資訊。
+func (a *Authenticator) Authenticate(ctx context.Context, rawToken string) (Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (any, error) {
+		switch token.Method.Alg() {
+		case jwt.SigningMethodHS256.Alg():
+			return a.secret, nil
+		case jwt.SigningMethodRS256.Alg():
+			kid, _ := token.Header["kid"].(string)
+			return a.jwks.key(ctx, kid)
+		default:
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+	})
+	if err != nil {
+		return Principal{}, err
+	}
+
+	subject, _ := claims.GetSubject()
+	principal := Principal{Subject: subject, Roles: a.roles(claims), Teams: claimStrings(claims, a.teamsClaim)}
+	for _, key := range []string{"preferred_username", "email"} {
+		if value, ok := claims[key].(string); ok && value != "" {
+			principal.Username = value
+			break
+		}
+	}
+	if principal.Name() == "" {
+		return Principal{}, errors.New("token has no subject")
+	}
+	return principal, nil
+}
+
+// roles 自設定的 claim 路徑取出角色，未設定時依序嘗試 roles 與 realm_access.roles。
+func (a *Authenticator) roles(claims jwt.MapClaims) []string {
+	if a.rolesClaim != "" {
+		return claimStrings(claims, a.rolesClaim)
+	}
+	if roles := claimStrings(claims, defaultRolesClaim); roles != nil {
+		return roles
+	}
+	return claimStrings(claims, keycloakRolesClaim)
+}
+
+// claimStrings 依點分隔的路徑取出 claim，支援字串陣列或以空白分隔的字串。
+func claimStrings(claims jwt.MapClaims, path string) []string {
+	var value any = map[string]any(claims)
+	for _, part := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Require 回傳驗證 Bearer 權杖並要求任一指定角色的中介層；驗證器為 nil 時不做任何檢查。
+func (a *Authenticator) Require(roles ...string) gin.HandlerFunc {
+	if a == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		rawToken, err := bearerToken(c.GetHeader("Authorization"))
+		if err == nil {
+			var principal Principal
+			if principal, err = a.Authenticate(c.Request.Context(), rawToken); err == nil {
+				if len(roles) > 0 && !principal.HasAnyRole(roles...) {
+					c.AbortWithStatusJSON(http.StatusForbidden, errorResponse{Error: "權限不足"})
+					return
+				}
+				c.Request = c.Request.WithContext(withPrincipal(c.Request.Context(), principal))
+				c.Next()
+				return
+			}
+		}
+		_ = c.Error(err)
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse{Error: "未提供有效的存取權杖"})
+	}
+}
+
+func bearerToken(header string) (string, error) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", ErrMissingBearerToken
+	}
+	return strings.TrimSpace(token), nil
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext 取出通過驗證的請求者，未啟用驗證時回傳 false。
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// requesterName 回傳請求者名稱，未驗證時為空字串。
+func requesterName(ctx context.Context) string {
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		return principal.Name()
+	}
+	return ""
+}
+
+// requesterTeam 回傳請求者的第一個團隊，供成本依團隊彙總；未驗證或無團隊時為空字串。
+func requesterTeam(ctx context.Context) string {
+	if principal, ok := PrincipalFromContext(ctx); ok && len(principal.Teams) > 0 {
+		return principal.Teams[0]
+	}
+	return ""
+}
+
+// principalTeams 回傳請求者可存取的團隊；未啟用驗證或為超級管理員時 scoped 為 false，代表不限團隊。
+// scoped 為 true 時回傳值不為 nil，未屬於任何團隊的使用者為空切片。
+func principalTeams(ctx context.Context) (teams []string, scoped bool) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.HasAnyRole(RoleSuperAdmin) {
+		return nil, false
+	}
+	return append([]string{}, principal.Teams...), true
+}
+
+// canAccessReport 判斷請求者可否讀取或變更報告：未啟用驗證、超級管理員、報告建立者與同團隊成員可存取；
+// 未記錄建立者與團隊的報告 (於驗證啟用前建立) 開放給所有具報告角色的使用者。
+func canAccessReport(ctx context.Context, report AnalysisReport) bool {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.HasAnyRole(RoleSuperAdmin) {
+		return true
+	}
+	if report.RequestedBy == "" && report.Team == "" {
+		return true
+	}
+	if report.RequestedBy != "" && (report.RequestedBy == principal.Name() || report.RequestedBy == principal.Subject) {
+		return true
+	}
+	return report.Team != "" && slices.Contains(principal.Teams, report.Team)
+}
+
+// scopeReportQuery 將列表查詢限定為請求者可存取的報告，規則與 canAccessReport 相同；
+// 未啟用驗證或為超級管理員時不限定。
+func scopeReportQuery(ctx context.Context, query ReportQuery) ReportQuery {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.HasAnyRole(RoleSuperAdmin) {
+		return query
+	}
+	query.Scoped = true
+	query.Teams = nil
+	for _, team := range principal.Teams {
+		if team != "" && !slices.Contains(query.Teams, team) {
+			query.Teams = append(query.Teams, team)
+		}
+	}
+	query.Requesters = nil
+	for _, name := range []string{principal.Name(), principal.Subject} {
+		if name != "" && !slices.Contains(query.Requesters, name) {
+			query.Requesters = append(query.Requesters, name)
+		}
+	}
+	return query
+}
+
+// jwksKeySet 保存 RS256 公鑰，來源為 URL 時定期重新載入，遇到未知 kid 時提前重新抓取。
+type jwksKeySet struct {
+	url             string
+	client          *http.Client
+	refreshInterval time.Duration
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	lastErr     error
+	// refreshing 於抓取進行中時非 nil，抓取結束時關閉。
+	refreshing chan struct{}
+}
+
+func newJWKSKeySet(cfg AuthConfig) *jwksKeySet {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	interval := cfg.JWKSRefreshInterval
+	if interval <= 0 {
+		interval = defaultJWKSRefreshInterval
+	}
+	return &jwksKeySet{url: cfg.JWKSURL, client: client, refreshInterval: interval}
+}
+
+// key 依 kid 取得公鑰；權杖未指定 kid 且僅有一把金鑰時使用該金鑰。
+func (s *jwksKeySet) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	if s.url != "" {
+		if err := s.refresh(ctx, kid); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.lookupLocked(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrJWKSKeyNotFound, kid)
+}
+
+// refresh 於金鑰過期或遇到未知 kid 時重新抓取 JWKS，抓取期間不持有鎖。
+// 同時只有一個請求負責抓取，遇到未知 kid 的其他請求等待其結果，已知 kid 的請求直接沿用現有金鑰；
+// 兩次抓取至少間隔 jwksMinRefreshInterval，避免偽造 kid 的權杖放大對 JWKS 端點的請求。
+func (s *jwksKeySet) refresh(ctx context.Context, kid string) error {
+	s.mu.Lock()
+	now := time.Now()
+	_, known := s.lookupLocked(kid)
+	if known && now.Sub(s.fetchedAt) < s.refreshInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	if done := s.refreshing; done != nil {
+		s.mu.Unlock()
+		if known {
+			return nil
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.keys) == 0 {
+			return s.lastErr
+		}
+		return nil
+	}
+	if now.Sub(s.lastAttempt) < jwksMinRefreshInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastAttempt = now
+	done := make(chan struct{})
+	s.refreshing = done
+	s.mu.Unlock()
+
+	// 抓取結果由等待中的請求共用，不隨發起請求的用戶端中斷而取消；逾時由 HTTP client 控制。
+	keys, err := s.fetch(context.WithoutCancel(ctx))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshing = nil
+	s.lastErr = err
+	close(done)
+	if err == nil {
+		s.keys = keys
+		s.fetchedAt = now
+		return nil
+	}
+	// 抓取失敗時沿用既有金鑰，避免 JWKS 端點短暫異常造成全面拒絕。
+	if len(s.keys) == 0 {
+		return err
+	}
+	return nil
+}
+
+func (s *jwksKeySet) lookupLocked(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+func (s *jwksKeySet) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("無法建立 JWKS 請求: %w", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("無法取得 JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS 端點回傳狀態 %d", resp.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSBytes))
+	if err != nil {
+		return nil, fmt.Errorf("讀取 JWKS 失敗: %w", err)
+	}
+	return parseJWKS(raw)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// parseJWKS 解析 JWK Set 中用於簽章的 RSA 公鑰。
+func parseJWKS(raw []byte) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("無法解析 JWKS: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("JWKS 金鑰 %q 的 n 格式錯誤: %w", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("JWKS 金鑰 %q 的 e 格式錯誤", jwk.Kid)
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS 中沒有可用的 RSA 簽章金鑰")
+	}
+	return keys, nil
+}
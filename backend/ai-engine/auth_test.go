@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testJWTSecret = "test-secret"
+
+func signHS256(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatalf("簽署權杖失敗: %v", err)
+	}
+	return token
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("簽署權杖失敗: %v", err)
+	}
+	return signed
+}
+
+func userClaims(username string, roles ...string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":                "user-" + username,
+		"preferred_username": username,
+		"roles":              roles,
+		"exp":                time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// newTestJWKS 產生 RSA 金鑰與對應的 JWK Set 內容。
+func newTestJWKS(t *testing.T, kid string) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("產生 RSA 金鑰失敗: %v", err)
+	}
+	raw, _ := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	return key, raw
+}
+
+func authedRequest(method, target, token string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req
+}
+
+func TestAuthRoutesRequireTokenAndRoles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth, err := NewAuthenticator(AuthConfig{HS256Secret: testJWTSecret})
+	if err != nil {
+		t.Fatalf("建立驗證器失敗: %v", err)
+	}
+	repo := NewInMemoryReportRepository()
+	service := NewAnalysisService(repo, &stubGenerator{result: &GeneratedReport{}}, AnalysisServiceConfig{Auth: auth})
+	router := SetupRouter(service)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		want   int
+	}{
+		{name: "缺少權杖", method: http.MethodGet, target: "/api/v1/analysis/ai-insights", want: http.StatusUnauthorized},
+		{name: "權杖格式錯誤", method: http.MethodGet, target: "/api/v1/analysis/ai-insights", token: signHS256(t, userClaims("alice", RoleTeamMember))[:20] + "x", want: http.StatusUnauthorized},
+		{name: "已過期", method: http.MethodGet, target: "/api/v1/analysis/ai-insights", token: signHS256(t, jwt.MapClaims{"sub": "alice", "roles": []string{RoleTeamMember}, "exp": time.Now().Add(-time.Hour).Unix()}), want: http.StatusUnauthorized},
+		{name: "缺少角色", method: http.MethodGet, target: "/api/v1/analysis/ai-insights", token: signHS256(t, userClaims("bob", "viewer")), want: http.StatusForbidden},
+		{name: "團隊成員", method: http.MethodGet, target: "/api/v1/analysis/ai-insights", token: signHS256(t, userClaims("alice", RoleTeamMember)), want: http.StatusOK},
+		{name: "管理端點需超級管理員", method: http.MethodGet, target: "/api/v1/admin/analysis-queue", token: signHS256(t, userClaims("carol", RoleTeamManager)), want: http.StatusForbidden},
+		{name: "超級管理員", method: http.MethodGet, target: "/api/v1/admin/analysis-queue", token: signHS256(t, userClaims("root", RoleSuperAdmin)), want: http.StatusOK},
+		{name: "指標端點不需驗證", method: http.MethodGet, target: "/api/v1/metrics", want: http.StatusOK},
+		{name: "健康檢查不需驗證", method: http.MethodGet, target: "/healthz", want: http.StatusOK},
+	}
+	for _, tc := range tests {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, authedRequest(tc.method, tc.target, tc.token, nil))
+		if resp.Code != tc.want {
+			t.Fatalf("%s: 預期 %d，實際為 %d (%s)", tc.name, tc.want, resp.Code, resp.Body.String())
+		}
+		if resp.Code == http.StatusUnauthorized && resp.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("%s: 401 應附帶 WWW-Authenticate 標頭", tc.name)
+		}
+	}
+
+	// 建立與取消皆記錄權杖中的使用者，忽略請求內容自填的取消者。
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, authedRequest(http.MethodPost, "/api/v1/events/evt-auth/ai-analysis", signHS256(t, userClaims("alice", RoleTeamMember)), nil))
+	if resp.Code != http.StatusAccepted {
+		t.Fatalf("建立報告應回傳 202，實際為 %d", resp.Code)
+	}
+	service.Wait()
+	report, _ := repo.GetLatestByEvent("evt-auth")
+	if report.RequestedBy != "alice" {
+		t.Fatalf("requested_by 應為 alice，實際為 %q", report.RequestedBy)
+	}
+
+	generator := newBlockingGenerator()
+	service = NewAnalysisService(NewInMemoryReportRepository(), generator, AnalysisServiceConfig{ProcessingTimeout: 5 * time.Second, Auth: auth})
+	router = SetupRouter(service)
+	pending, _ := service.CreateReport(context.Background(), "evt-auth-cancel", CreateAnalysisRequest{})
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, authedRequest(http.MethodPost, "/api/v1/ai/analysis-reports/"+pending.ReportID+"/cancel",
+		signHS256(t, userClaims("dave", RoleTeamManager)), []byte(`{"cancelled_by":"mallory"}`)))
+	service.Wait()
+	var cancelled AnalysisReport
+	_ = json.Unmarshal(resp.Body.Bytes(), &cancelled)
+	if resp.Code != http.StatusOK || cancelled.CancelledBy != "dave" {
+		t.Fatalf("取消者應為權杖使用者，實際為 %d %q", resp.Code, cancelled.CancelledBy)
+	}
+}
+
+func TestAuthRS256WithJWKSFile(t *testing.T) {
+	key, jwks := newTestJWKS(t, "kid-1")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatalf("寫入 JWKS 失敗: %v", err)
+	}
+	auth, err := NewAuthenticator(AuthConfig{JWKSFile: path, Issuer: "https://sso.example.com/realms/sre"})
+	if err != nil {
+		t.Fatalf("建立驗證器失敗: %v", err)
+	}
+
+	// Keycloak 將角色放在 realm_access.roles。
+	claims := jwt.MapClaims{
+		"sub":                "f1d2",
+		"preferred_username": "erin",
+		"iss":                "https://sso.example.com/realms/sre",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"realm_access":       map[string]any{"roles": []string{"offline_access", RoleTeamManager}},
+	}
+	principal, err := auth.Authenticate(context.Background(), signRS256(t, key, "kid-1", claims))
+	if err != nil {
+		t.Fatalf("RS256 權杖驗證失敗: %v", err)
+	}
+	if principal.Name() != "erin" || !principal.HasAnyRole(RoleTeamManager) {
+		t.Fatalf("請求者資訊錯誤: %+v", principal)
+	}
+
+	if _, err := auth.Authenticate(context.Background(), signRS256(t, key, "kid-unknown", claims)); err == nil {
+		t.Fatalf("未知的 kid 應驗證失敗")
+	}
+	claims["iss"] = "https://evil.example.com"
+	if _, err := auth.Authenticate(context.Background(), signRS256(t, key, "kid-1", claims)); err == nil {
+		t.Fatalf("issuer 不符應驗證失敗")
+	}
+	// 團隊可設定為其他 claim 路徑，以空白分隔的字串亦可。
+	auth, err = NewAuthenticator(AuthConfig{JWKSFile: path, TeamsClaim: "groups.teams"})
+	if err != nil {
+		t.Fatalf("建立驗證器失敗: %v", err)
+	}
+	claims["groups"] = map[string]any{"teams": "sre platform"}
+	principal, err = auth.Authenticate(context.Background(), signRS256(t, key, "kid-1", claims))
+	if err != nil || !slices.Equal(principal.Teams, []string{"sre", "platform"}) {
+		t.Fatalf("團隊資訊錯誤: %+v (%v)", principal, err)
+	}
+
+	// 僅設定 JWKS 時不接受 HS256 權杖。
+	if _, err := auth.Authenticate(context.Background(), signHS256(t, userClaims("alice", RoleSuperAdmin))); err == nil {
+		t.Fatalf("未設定 HS256 密鑰時應拒絕 HS256 權杖")
+	}
+}
+
+func TestAuthRS256WithJWKSURL(t *testing.T) {
+	key, jwks := newTestJWKS(t, "kid-remote")
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(jwks)
+	}))
+	defer server.Close()
+
+	auth, err := NewAuthenticator(AuthConfig{JWKSURL: server.URL})
+	if err != nil {
+		t.Fatalf("建立驗證器失敗: %v", err)
+	}
+	token := signRS256(t, key, "kid-remote", userClaims("frank", RoleTeamMember))
+	for i := 0; i < 3; i++ {
+		if _, err := auth.Authenticate(context.Background(), token); err != nil {
+			t.Fatalf("RS256 權杖驗證失敗: %v", err)
+		}
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Fatalf("JWKS 應快取，實際抓取 %d 次", got)
+	}
+
+	if _, err := NewAuthenticator(AuthConfig{}); !errors.Is(err, ErrAuthNotConfigured) {
+		t.Fatalf("未設定金鑰應回傳 ErrAuthNotConfigured，實際為 %v", err)
+	}
+}
+
+func TestJWKSRefreshDoesNotBlockKnownKeys(t *testing.T) {
+	keyA, jwksA := newTestJWKS(t, "kid-a")
+	keyB, jwksB := newTestJWKS(t, "kid-b")
+	var rotated struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	for _, raw := range [][]byte{jwksA, jwksB} {
+		var set struct {
+			Keys []json.RawMessage `json:"keys"`
+		}
+		_ = json.Unmarshal(raw, &set)
+		rotated.Keys = append(rotated.Keys, set.Keys...)
+	}
+	rotatedJWKS, _ := json.Marshal(rotated)
+
+	var fetches atomic.Int32
+	refreshing := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if fetches.Add(1) == 1 {
+			_, _ = w.Write(jwksA)
+			return
+		}
+		close(refreshing)
+		<-release
+		_, _ = w.Write(rotatedJWKS)
+	}))
+	defer server.Close()
+
+	auth, err := NewAuthenticator(AuthConfig{JWKSURL: server.URL})
+	if err != nil {
+		t.Fatalf("建立驗證器失敗: %v", err)
+	}
+	tokenA := signRS256(t, keyA, "kid-a", userClaims("grace", RoleTeamMember))
+	tokenB := signRS256(t, keyB, "kid-b", userClaims("heidi", RoleTeamMember))
+	if _, err := auth.Authenticate(context.Background(), tokenA); err != nil {
+		t.Fatalf("RS256 權杖驗證失敗: %v", err)
+	}
+	// 最小間隔內遇到未知 kid 不重新抓取。
+	if _, err := auth.Authenticate(context.Background(), tokenB); err == nil || fetches.Load() != 1 {
+		t.Fatalf("最小間隔內不應重新抓取 JWKS，抓取 %d 次 (%v)", fetches.Load(), err)
+	}
+
+	auth.jwks.mu.Lock()
+	auth.jwks.lastAttempt = time.Now().Add(-2 * jwksMinRefreshInterval)
+	auth.jwks.mu.Unlock()
+
+	errs := make(chan error, 3)
+	for range 3 {
+		go func() {
+			_, err := auth.Authenticate(context.Background(), tokenB)
+			errs <- err
+		}()
+	}
+	<-refreshing
+
+	// 抓取進行中時已知 kid 的驗證不受阻擋。
+	done := make(chan error, 1)
+	go func() {
+		_, err := auth.Authenticate(context.Background(), tokenA)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("已知 kid 驗證失敗: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("抓取 JWKS 期間不應阻擋已知 kid 的驗證")
+	}
+
+	close(release)
+	for range 3 {
+		if err := <-errs; err != nil {
+			t.Fatalf("新金鑰驗證失敗: %v", err)
+		}
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Fatalf("同時遇到未知 kid 應只抓取一次，實際抓取 %d 次", got)
+	}
+}
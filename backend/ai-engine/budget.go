@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// 模型等級，記錄於報告的 tier 欄位。
+const (
+	ModelTierPrimary  = "primary"
+	ModelTierEconomy  = "economy"
+	ModelTierTemplate = "template"
+)
+
+// BudgetPolicy 決定團隊預算用盡後的處理方式。
+type BudgetPolicy string
+
+const (
+	// BudgetPolicyEconomy 改用較便宜的模型。
+	BudgetPolicyEconomy BudgetPolicy = "economy"
+	// BudgetPolicyTemplate 改用不呼叫 LLM 的模板產生器。
+	BudgetPolicyTemplate BudgetPolicy = "template"
+	// BudgetPolicyReject 拒絕建立分析。
+	BudgetPolicyReject BudgetPolicy = "reject"
+)
+
+// 預算期間，依 UTC 計算。
+const (
+	BudgetPeriodDaily   = "daily"
+	BudgetPeriodMonthly = "monthly"
+)
+
+const defaultBudgetRefreshInterval = time.Minute
+
+var (
+	// ErrBudgetExceeded 代表團隊預算已用盡且策略為拒絕。
+	ErrBudgetExceeded = errors.New("team budget exceeded")
+	// ErrInvalidBudgetConfig 代表預算設定無效。
+	ErrInvalidBudgetConfig = errors.New("invalid budget config")
+)
+
+// BudgetExceededError 描述用盡的預算與下次重置時間。
+type BudgetExceededError struct {
+	Team     string
+	Period   string
+	LimitUSD float64
+	SpentUSD float64
+	ResetAt  time.Time
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("team %q %s budget exceeded (%.4f/%.4f USD), resets at %s",
+		e.Team, e.Period, e.SpentUSD, e.LimitUSD, e.ResetAt.Format(time.RFC3339))
+}
+
+func (e *BudgetExceededError) Unwrap() error {
+	return ErrBudgetExceeded
+}
+
+// BudgetLimit 為團隊的每日與每月預算 (USD)，0 代表不限制。
+type BudgetLimit struct {
+	DailyUSD   float64 `json:"daily_usd"`
+	MonthlyUSD float64 `json:"monthly_usd"`
+}
+
+func (l BudgetLimit) enabled() bool {
+	return l.DailyUSD > 0 || l.MonthlyUSD > 0
+}
+
+// BudgetConfig 設定各團隊的 LLM 預算與用盡後的降級策略。
+// 花費以報告記錄的成本計算，儲存庫即為預算狀態，服務重啟後不會遺失。
+type BudgetConfig struct {
+	// Default 套用於未個別設定的團隊 (包含未驗證或無團隊的請求)。
+	Default BudgetLimit
+	// Teams 依團隊名稱覆寫預算。
+	Teams map[string]BudgetLimit
+	// Policy 預設為 reject。
+	Policy BudgetPolicy
+	// EconomyGenerator 為 economy 策略使用的較便宜模型。
+	EconomyGenerator ReportGenerator
+	// TemplateGenerator 為 template 策略使用的模板產生器。
+	TemplateGenerator ReportGenerator
+	// RefreshInterval 為自儲存庫重新計算花費的間隔，讓多個副本的花費彼此同步，預設 1 分鐘。
+	RefreshInterval time.Duration
+}
+
+// Enabled 回傳是否設定了任何預算。
+func (c BudgetConfig) Enabled() bool {
+	if c.Default.enabled() {
+		return true
+	}
+	for _, limit := range c.Teams {
+		if limit.enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate 確認策略有效且所需的降級產生器已設定。
+func (c BudgetConfig) Validate() error {
+	switch c.policy() {
+	case BudgetPolicyReject:
+	case BudgetPolicyEconomy:
+		if c.EconomyGenerator == nil {
+			return fmt.Errorf("%w: economy 策略需設定較便宜的模型", ErrInvalidBudgetConfig)
+		}
+	case BudgetPolicyTemplate:
+		if c.TemplateGenerator == nil {
+			return fmt.Errorf("%w: template 策略需設定模板產生器", ErrInvalidBudgetConfig)
+		}
+	default:
+		return fmt.Errorf("%w: 不支援的策略 %q", ErrInvalidBudgetConfig, c.Policy)
+	}
+	return nil
+}
+
+func (c BudgetConfig) policy() BudgetPolicy {
+	if c.Policy == "" {
+		return BudgetPolicyReject
+	}
+	return c.Policy
+}
+
+func (c BudgetConfig) limitFor(team string) BudgetLimit {
+	if limit, ok := c.Teams[team]; ok {
+		return limit
+	}
+	return c.Default
+}
+
+// LoadBudgetLimits 讀取 JSON 格式的團隊預算，例如 {"sre": {"daily_usd": 5, "monthly_usd": 100}}。
+func LoadBudgetLimits(path string) (map[string]BudgetLimit, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("無法讀取團隊預算: %w", err)
+	}
+	var limits map[string]BudgetLimit
+	if err := json.Unmarshal(raw, &limits); err != nil {
+		return nil, fmt.Errorf("團隊預算格式錯誤: %w", err)
+	}
+	for team, limit := range limits {
+		if limit.DailyUSD < 0 || limit.MonthlyUSD < 0 {
+			return nil, fmt.Errorf("%w: 團隊 %s 的預算不可為負數", ErrInvalidBudgetConfig, team)
+		}
+	}
+	return limits, nil
+}
+
+// budgetPeriodRange 回傳 now 所在期間的起訖時間 (UTC)。
+func budgetPeriodRange(period string, now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	if period == BudgetPeriodMonthly {
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// budgetSpend 為單一期間各團隊的花費快取。
+type budgetSpend struct {
+	start    time.Time
+	end      time.Time
+	byTeam   map[string]float64
+	loadedAt time.Time
+}
+
+// budgetTracker 自儲存庫彙總各團隊本期花費並快取，報告完成時累加，定期重新載入。
+type budgetTracker struct {
+	cfg     BudgetConfig
+	repo    ReportRepository
+	refresh time.Duration
+
+	mu     sync.Mutex
+	spends map[string]*budgetSpend
+}
+
+func newBudgetTracker(cfg BudgetConfig, repo ReportRepository) *budgetTracker {
+	if !cfg.Enabled() {
+		return nil
+	}
+	refresh := cfg.RefreshInterval
+	if refresh <= 0 {
+		refresh = defaultBudgetRefreshInterval
+	}
+	return &budgetTracker{cfg: cfg, repo: repo, refresh: refresh, spends: make(map[string]*budgetSpend)}
+}
+
+// check 判斷團隊是否已用盡任一期間的預算；兩者皆用盡時回傳較晚重置的期間。
+func (b *budgetTracker) check(team string, now time.Time) (*BudgetExceededError, error) {
+	limit := b.cfg.limitFor(team)
+	var exceeded *BudgetExceededError
+	for _, item := range []struct {
+		period string
+		limit  float64
+	}{
+		{BudgetPeriodDaily, limit.DailyUSD},
+		{BudgetPeriodMonthly, limit.MonthlyUSD},
+	} {
+		if item.limit <= 0 {
+			continue
+		}
+		spend, err := b.spent(item.period, team, now)
+		if err != nil {
+			return nil, err
+		}
+		if spend >= item.limit {
+			_, resetAt := budgetPeriodRange(item.period, now)
+			if exceeded == nil || resetAt.After(exceeded.ResetAt) {
+				exceeded = &BudgetExceededError{Team: team, Period: item.period, LimitUSD: item.limit, SpentUSD: spend, ResetAt: resetAt}
+			}
+		}
+	}
+	return exceeded, nil
+}
+
+// spent 回傳團隊在 now 所在期間的花費，快取過期時自儲存庫重新計算。
+func (b *budgetTracker) spent(period, team string, now time.Time) (float64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	spend, ok := b.spends[period]
+	if !ok || !now.Before(spend.end) || now.Sub(spend.loadedAt) >= b.refresh {
+		start, end := budgetPeriodRange(period, now)
+		records, err := b.repo.ListUsage(start, end)
+		if err != nil {
+			return 0, fmt.Errorf("無法計算團隊花費: %w", err)
+		}
+		spend = &budgetSpend{start: start, end: end, byTeam: make(map[string]float64), loadedAt: now}
+		for _, record := range records {
+			spend.byTeam[record.Team] += record.Usage.CostUSD
+		}
+		b.spends[period] = spend
+	}
+	return spend.byTeam[team], nil
+}
+
+// record 將每次產生器呼叫 (含失敗與重試) 的成本依呼叫開始時間計入快取，下次重新載入前即反映於預算判斷。
+func (b *budgetTracker) record(team string, billedAt time.Time, cost float64) {
+	if b == nil || cost <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, spend := range b.spends {
+		if !billedAt.Before(spend.start) && billedAt.Before(spend.end) {
+			spend.byTeam[team] += cost
+		}
+	}
+}
+
+// selectTier 依團隊預算決定報告使用的模型等級；預算用盡且策略為拒絕時回傳 BudgetExceededError。
+// 無法計算花費時記錄警告並使用主要模型，避免儲存庫短暫故障阻擋分析。
+func (s *AnalysisService) selectTier(ctx context.Context, team string) (string, error) {
+	if s.budget == nil {
+		return ModelTierPrimary, nil
+	}
+	tier, exceeded, err := s.tierForBudget(team)
+	if err != nil {
+		s.logger.WarnContext(ctx, "預算檢查失敗，使用主要模型", slog.String("team", team), slog.String(logKeyError, err.Error()))
+		return ModelTierPrimary, nil
+	}
+	if exceeded == nil {
+		return tier, nil
+	}
+	return s.degradeTier(ctx, team, tier, exceeded)
+}
+
+// recheckTier 於任務開始執行時重新檢查預算：排隊期間其他報告的花費可能已用盡預算，
+// 此時改用較低成本的等級，策略為拒絕時回傳 BudgetExceededError。
+// 等級未改變時不重複計入指標；預算於排隊期間重置時沿用原等級。
+func (s *AnalysisService) recheckTier(ctx context.Context, report AnalysisReport) (string, error) {
+	if s.budget == nil {
+		return report.Tier, nil
+	}
+	tier, exceeded, err := s.tierForBudget(report.Team)
+	if err != nil {
+		s.logger.WarnContext(ctx, "預算檢查失敗，沿用原模型等級", slog.String("team", report.Team), slog.String(logKeyError, err.Error()))
+		return report.Tier, nil
+	}
+	if exceeded == nil || tier == report.Tier {
+		return report.Tier, nil
+	}
+	return s.degradeTier(ctx, report.Team, tier, exceeded)
+}
+
+// tierForBudget 依團隊目前花費回傳模型等級與超出的預算；策略對應的產生器未設定時等級為空字串。
+func (s *AnalysisService) tierForBudget(team string) (string, *BudgetExceededError, error) {
+	exceeded, err := s.budget.check(team, time.Now())
+	if err != nil || exceeded == nil {
+		return ModelTierPrimary, nil, err
+	}
+	tier := ""
+	switch s.budget.cfg.policy() {
+	case BudgetPolicyEconomy:
+		tier = ModelTierEconomy
+	case BudgetPolicyTemplate:
+		tier = ModelTierTemplate
+	}
+	if _, ok := s.tierGenerators[tier]; !ok {
+		return "", exceeded, nil
+	}
+	return tier, exceeded, nil
+}
+
+// degradeTier 記錄預算用盡時的降級或拒絕，tier 為空字串時回傳 exceeded。
+func (s *AnalysisService) degradeTier(ctx context.Context, team, tier string, exceeded *BudgetExceededError) (string, error) {
+	if tier == "" {
+		s.metrics.budgetExceeded.WithLabelValues("rejected").Inc()
+		return "", exceeded
+	}
+	s.metrics.budgetExceeded.WithLabelValues(tier).Inc()
+	s.logger.InfoContext(ctx, "團隊預算已用盡，改用較低成本的模型等級",
+		slog.String("team", team),
+		slog.String("period", exceeded.Period),
+		slog.String("tier", tier),
+	)
+	return tier, nil
+}
+
+// generatorForTier 回傳模型等級對應的產生器，未設定或舊報告沒有等級時使用主要產生器。
+func (s *AnalysisService) generatorForTier(tier string) ReportGenerator {
+	if generator, ok := s.tierGenerators[tier]; ok {
+		return generator
+	}
+	return s.generator
+}
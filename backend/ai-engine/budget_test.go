@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// budgetGenerator 回傳指定模型的固定 token 用量，依價格表計算成本。
+func budgetGenerator(model string) *stubGenerator {
+	return &stubGenerator{result: &GeneratedReport{
+		EventSummary: model + " 產生的報告",
+		Usage:        &LLMUsage{Provider: "openai", Model: model, PromptTokens: 1000, CompletionTokens: 1000, TotalTokens: 2000},
+	}}
+}
+
+// budgetTestPrices 讓 premium 每份報告花費 0.01 USD，economy 花費 0.001 USD。
+var budgetTestPrices = PriceTable{
+	"premium": {PromptPerMillion: 5, CompletionPerMillion: 5},
+	"economy": {PromptPerMillion: 0.5, CompletionPerMillion: 0.5},
+}
+
+func teamContext(team string) context.Context {
+	return withPrincipal(context.Background(), Principal{Subject: "user-" + team, Username: team, Teams: []string{team}})
+}
+
+func TestBudgetFallsBackToEconomyModel(t *testing.T) {
+	repo := newTestSQLRepository(t)
+	metrics := NewMetrics()
+	newService := func() *AnalysisService {
+		return NewAnalysisService(repo, budgetGenerator("premium"), AnalysisServiceConfig{
+			Prices:  budgetTestPrices,
+			Metrics: metrics,
+			Budget: BudgetConfig{
+				Default:          BudgetLimit{DailyUSD: 0.015},
+				Policy:           BudgetPolicyEconomy,
+				EconomyGenerator: budgetGenerator("economy"),
+			},
+		})
+	}
+	service := newService()
+
+	// 前兩份報告合計 0.02 USD，第二份建立時花費 0.01 仍低於每日預算。
+	var tiers []string
+	for _, eventID := range []string{"evt-budget-1", "evt-budget-2", "evt-budget-3"} {
+		report, err := service.CreateReport(teamContext("sre"), eventID, CreateAnalysisRequest{})
+		if err != nil {
+			t.Fatalf("建立報告失敗: %v", err)
+		}
+		service.Wait()
+		tiers = append(tiers, report.Tier)
+	}
+	if tiers[0] != ModelTierPrimary || tiers[1] != ModelTierPrimary || tiers[2] != ModelTierEconomy {
+		t.Fatalf("模型等級錯誤: %v", tiers)
+	}
+	latest, _ := repo.GetLatestByEvent("evt-budget-3")
+	if latest.Tier != ModelTierEconomy || latest.Usage == nil || latest.Usage.Model != "economy" || latest.Usage.CostUSD != 0.001 {
+		t.Fatalf("預算用盡後應以較便宜的模型產生: %+v", latest)
+	}
+
+	// 其他團隊的預算各自計算。
+	other, err := service.CreateReport(teamContext("payments"), "evt-budget-other", CreateAnalysisRequest{})
+	if err != nil || other.Tier != ModelTierPrimary {
+		t.Fatalf("其他團隊應使用主要模型: %+v (%v)", other, err)
+	}
+	service.Wait()
+
+	// 重新啟動後自儲存庫重建花費，仍判定為預算用盡。
+	restarted := newService()
+	report, err := restarted.CreateReport(teamContext("sre"), "evt-budget-4", CreateAnalysisRequest{})
+	if err != nil || report.Tier != ModelTierEconomy {
+		t.Fatalf("重啟後應延續預算狀態: %+v (%v)", report, err)
+	}
+	restarted.Wait()
+
+	if got := testutil.ToFloat64(metrics.budgetExceeded.WithLabelValues(ModelTierEconomy)); got != 2 {
+		t.Fatalf("降級次數應為 2，實際為 %v", got)
+	}
+}
+
+func TestBudgetFallsBackToTemplate(t *testing.T) {
+	repo := NewInMemoryReportRepository()
+	service := NewAnalysisService(repo, budgetGenerator("premium"), AnalysisServiceConfig{
+		Prices: budgetTestPrices,
+		Budget: BudgetConfig{
+			Teams:             map[string]BudgetLimit{"sre": {MonthlyUSD: 0.01}},
+			Policy:            BudgetPolicyTemplate,
+			TemplateGenerator: newTestTemplateGenerator(0),
+		},
+	})
+	for _, eventID := range []string{"evt-tpl-1", "evt-tpl-2"} {
+		if _, err := service.CreateReport(teamContext("sre"), eventID, CreateAnalysisRequest{}); err != nil {
+			t.Fatalf("建立報告失敗: %v", err)
+		}
+		service.Wait()
+	}
+	report, _ := repo.GetLatestByEvent("evt-tpl-2")
+	if report.Tier != ModelTierTemplate || report.Usage == nil || report.Usage.Provider != usageProviderTemplate {
+		t.Fatalf("預算用盡後應改用模板產生器: %+v", report)
+	}
+
+	// 未設定預算的團隊不受限制。
+	unlimited, err := service.CreateReport(teamContext("payments"), "evt-tpl-other", CreateAnalysisRequest{})
+	if err != nil || unlimited.Tier != ModelTierPrimary {
+		t.Fatalf("未設定預算的團隊應使用主要模型: %+v (%v)", unlimited, err)
+	}
+	service.Wait()
+}
+
+func TestBudgetRejectsWhenExhausted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth, err := NewAuthenticator(AuthConfig{HS256Secret: testJWTSecret})
+	if err != nil {
+		t.Fatalf("建立驗證器失敗: %v", err)
+	}
+	repo := NewInMemoryReportRepository()
+	service := NewAnalysisService(repo, budgetGenerator("premium"), AnalysisServiceConfig{
+		Auth:   auth,
+		Prices: budgetTestPrices,
+		Budget: BudgetConfig{Default: BudgetLimit{DailyUSD: 0.01, MonthlyUSD: 0.01}},
+	})
+	router := SetupRouter(service)
+
+	claims := userClaims("alice", RoleTeamMember)
+	claims["teams"] = []string{"sre"}
+	token := signHS256(t, claims)
+	create := func(eventID string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, authedRequest(http.MethodPost, "/api/v1/events/"+eventID+"/ai-analysis", token, nil))
+		return resp
+	}
+
+	if resp := create("evt-reject-1"); resp.Code != http.StatusAccepted {
+		t.Fatalf("預算內應回傳 202，實際為 %d", resp.Code)
+	}
+	service.Wait()
+
+	resp := create("evt-reject-2")
+	if resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("預算用盡應回傳 429，實際為 %d: %s", resp.Code, resp.Body.String())
+	}
+	// 每日與每月預算皆用盡時，需等到較晚的每月重置。
+	_, monthEnd := budgetPeriodRange(BudgetPeriodMonthly, time.Now())
+	seconds, err := strconv.Atoi(resp.Header().Get("Retry-After"))
+	if err != nil || time.Duration(seconds)*time.Second < time.Until(monthEnd)-time.Minute {
+		t.Fatalf("Retry-After 應為每月預算重置時間，實際為 %q", resp.Header().Get("Retry-After"))
+	}
+	if _, err := repo.GetLatestByEvent("evt-reject-2"); !errors.Is(err, ErrReportNotFound) {
+		t.Fatalf("被拒絕的請求不應建立報告: %v", err)
+	}
+
+	_, err = service.CreateReport(teamContext("sre"), "evt-reject-3", CreateAnalysisRequest{})
+	var exceeded *BudgetExceededError
+	if !errors.As(err, &exceeded) || exceeded.Team != "sre" || exceeded.Period != BudgetPeriodMonthly || exceeded.SpentUSD != 0.01 {
+		t.Fatalf("應回傳 BudgetExceededError，實際為 %v", err)
+	}
+}
+
+// gatedGenerator 在 gate 關閉前不會呼叫 inner，用於讓多份報告同時排隊。
+type gatedGenerator struct {
+	gate  chan struct{}
+	inner ReportGenerator
+}
+
+func (g *gatedGenerator) Generate(ctx context.Context, input GenerationInput) (*GeneratedReport, error) {
+	select {
+	case <-g.gate:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return g.inner.Generate(ctx, input)
+}
+
+func TestBudgetRecheckedWhenJobStarts(t *testing.T) {
+	metrics := NewMetrics()
+	generator := &gatedGenerator{gate: make(chan struct{}), inner: budgetGenerator("premium")}
+	repo := NewInMemoryReportRepository()
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		Workers: 1,
+		Prices:  budgetTestPrices,
+		Metrics: metrics,
+		Budget: BudgetConfig{
+			Default:          BudgetLimit{DailyUSD: 0.015},
+			Policy:           BudgetPolicyEconomy,
+			EconomyGenerator: budgetGenerator("economy"),
+		},
+	})
+
+	// 三份報告建立時皆未花費，等級為 primary；第三份開始執行時前兩份已用盡預算。
+	var ids []string
+	for _, eventID := range []string{"evt-queued-1", "evt-queued-2", "evt-queued-3"} {
+		report, err := service.CreateReport(teamContext("sre"), eventID, CreateAnalysisRequest{})
+		if err != nil || report.Tier != ModelTierPrimary {
+			t.Fatalf("建立報告失敗: %+v (%v)", report, err)
+		}
+		ids = append(ids, report.ReportID)
+	}
+	close(generator.gate)
+	service.Wait()
+
+	last, _ := repo.Get(ids[2])
+	if last.Tier != ModelTierEconomy || last.Usage == nil || last.Usage.Model != "economy" {
+		t.Fatalf("開始執行時應依最新花費降級: %+v", last)
+	}
+	if got := testutil.ToFloat64(metrics.budgetExceeded.WithLabelValues(ModelTierEconomy)); got != 1 {
+		t.Fatalf("降級次數應為 1，實際為 %v", got)
+	}
+}
+
+func TestBudgetCountsFailedAttempts(t *testing.T) {
+	usage := LLMUsage{Provider: "openai", Model: "premium", PromptTokens: 1000, CompletionTokens: 1000}
+	generator := &gatedGenerator{gate: make(chan struct{}), inner: &stubGenerator{err: &UsageError{Usage: usage, Err: ErrLLMInvalidResponse}}}
+	repo := NewInMemoryReportRepository()
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		Workers: 1,
+		Retry:   fastRetryPolicy(1),
+		Prices:  budgetTestPrices,
+		Budget:  BudgetConfig{Default: BudgetLimit{DailyUSD: 0.01}},
+	})
+
+	failed, err := service.CreateReport(teamContext("sre"), "evt-failed-spend", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	queued, err := service.CreateReport(teamContext("sre"), "evt-after-failure", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("排隊中的報告建立時預算尚未用盡: %v", err)
+	}
+	close(generator.gate)
+	service.Wait()
+
+	stored, _ := repo.Get(failed.ReportID)
+	if stored.Status != ReportStatusFailed || stored.Usage == nil || stored.Usage.CostUSD != 0.01 {
+		t.Fatalf("失敗的報告應記錄花費: %+v", stored)
+	}
+	// 失敗嘗試的花費計入預算，排隊中的報告開始執行時即被拒絕，不再呼叫產生器。
+	rejected, _ := repo.Get(queued.ReportID)
+	var exceeded *BudgetExceededError
+	if rejected.Status != ReportStatusFailed || len(rejected.Attempts) != 0 || rejected.ErrorMessage == "" {
+		t.Fatalf("預算用盡時應放棄執行: %+v", rejected)
+	}
+	if _, err := service.CreateReport(teamContext("sre"), "evt-rejected", CreateAnalysisRequest{}); !errors.As(err, &exceeded) || exceeded.SpentUSD != 0.01 {
+		t.Fatalf("失敗嘗試的花費應計入預算，實際為 %v", err)
+	}
+}
+
+func TestBudgetConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   BudgetConfig
+		valid bool
+	}{
+		{name: "預設拒絕", cfg: BudgetConfig{Default: BudgetLimit{DailyUSD: 1}}, valid: true},
+		{name: "economy 缺少模型", cfg: BudgetConfig{Policy: BudgetPolicyEconomy}},
+		{name: "template 缺少產生器", cfg: BudgetConfig{Policy: BudgetPolicyTemplate}},
+		{name: "未知策略", cfg: BudgetConfig{Policy: "shrug"}},
+		{name: "template", cfg: BudgetConfig{Policy: BudgetPolicyTemplate, TemplateGenerator: newTestTemplateGenerator(0)}, valid: true},
+	}
+	for _, tc := range tests {
+		err := tc.cfg.Validate()
+		if tc.valid != (err == nil) || (err != nil && !errors.Is(err, ErrInvalidBudgetConfig)) {
+			t.Fatalf("%s: 驗證結果錯誤 %v", tc.name, err)
+		}
+	}
+}
+
+// usageOnCancelGenerator 阻塞至 context 結束，回傳中斷前已計費的用量。
+type usageOnCancelGenerator struct {
+	started chan struct{}
+}
+
+func (g *usageOnCancelGenerator) Generate(ctx context.Context, _ GenerationInput) (*GeneratedReport, error) {
+	g.started <- struct{}{}
+	<-ctx.Done()
+	return nil, &UsageError{Usage: LLMUsage{Provider: "openai", Model: "premium", PromptTokens: 1000, CompletionTokens: 1000}, Err: ctx.Err()}
+}
+
+func TestInterruptedAttemptUsagePersisted(t *testing.T) {
+	tests := []struct {
+		name      string
+		interrupt func(service *AnalysisService, reportID string)
+		status    ReportStatus
+	}{
+		{
+			name: "取消",
+			interrupt: func(service *AnalysisService, reportID string) {
+				if _, err := service.CancelReport(context.Background(), reportID, "alice"); err != nil {
+					t.Fatalf("取消報告失敗: %v", err)
+				}
+				service.Wait()
+			},
+			status: ReportStatusCancelled,
+		},
+		{
+			name: "服務關閉",
+			interrupt: func(service *AnalysisService, _ string) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_ = service.Shutdown(ctx)
+			},
+			status: ReportStatusFailed,
+		},
+	}
+	for _, tc := range tests {
+		generator := &usageOnCancelGenerator{started: make(chan struct{}, 1)}
+		repo := NewInMemoryReportRepository()
+		service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+			ProcessingTimeout: 5 * time.Second,
+			Workers:           1,
+			Prices:            budgetTestPrices,
+			Budget:            BudgetConfig{Default: BudgetLimit{DailyUSD: 1}},
+		})
+		report, err := service.CreateReport(teamContext("sre"), "evt-interrupted", CreateAnalysisRequest{})
+		if err != nil {
+			t.Fatalf("%s: 建立報告失敗: %v", tc.name, err)
+		}
+		<-generator.started
+		tc.interrupt(service, report.ReportID)
+
+		stored, _ := repo.Get(report.ReportID)
+		if stored.Status != tc.status || len(stored.Attempts) != 1 || stored.Attempts[0].Usage == nil {
+			t.Fatalf("%s: 中斷的嘗試應保存於報告: %+v", tc.name, stored)
+		}
+		if stored.Usage == nil || stored.Usage.CostUSD != 0.01 {
+			t.Fatalf("%s: 中斷的呼叫仍應記錄花費: %+v", tc.name, stored.Usage)
+		}
+		// 預算自儲存庫重新載入時仍能算回中斷呼叫的花費。
+		records, err := repo.ListUsage(stored.CreatedAt.Add(-time.Hour), time.Now().Add(time.Hour))
+		if err != nil || len(records) != 1 || records[0].Usage.CostUSD != 0.01 {
+			t.Fatalf("%s: 用量紀錄錯誤: %+v (%v)", tc.name, records, err)
+		}
+	}
+}
+
+func TestUsageBilledByAttemptStart(t *testing.T) {
+	created := time.Date(2026, 3, 31, 23, 59, 0, 0, time.UTC)
+	retried := time.Date(2026, 4, 1, 0, 1, 0, 0, time.UTC)
+	usage := func(cost float64) *LLMUsage {
+		return &LLMUsage{Provider: "openai", Model: "premium", CostUSD: cost, Priced: true}
+	}
+	report := AnalysisReport{
+		ReportID:  "rpt-month-boundary",
+		EventID:   "evt-month-boundary",
+		Team:      "sre",
+		Status:    ReportStatusSuccess,
+		CreatedAt: created,
+		UpdatedAt: retried.Add(time.Minute),
+		Attempts: []AnalysisAttempt{
+			{Attempt: 1, StartedAt: created, FinishedAt: created.Add(time.Minute), Usage: usage(0.01)},
+			{Attempt: 2, StartedAt: retried, FinishedAt: retried.Add(time.Minute), Usage: usage(0.02)},
+		},
+		Usage: usage(0.03),
+	}
+	march := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	may := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	for name, repo := range map[string]ReportRepository{
+		"memory": NewInMemoryReportRepository(),
+		"sql":    newTestSQLRepository(t),
+	} {
+		if _, err := repo.Create(report); err != nil {
+			t.Fatalf("%s: 建立報告失敗: %v", name, err)
+		}
+		// 跨月重試的花費計入嘗試開始的月份，而非報告建立的月份。
+		for _, period := range []struct {
+			from, to time.Time
+			cost     float64
+		}{{march, april, 0.01}, {april, may, 0.02}} {
+			records, err := repo.ListUsage(period.from, period.to)
+			if err != nil || len(records) != 1 || records[0].Usage.CostUSD != period.cost {
+				t.Fatalf("%s: %s 起的用量錯誤: %+v (%v)", name, period.from.Format("2006-01"), records, err)
+			}
+		}
+		records, err := repo.ListUsage(march, may)
+		if err != nil {
+			t.Fatalf("%s: 查詢用量失敗: %v", name, err)
+		}
+		summary := summarizeUsage(UsageQuery{From: march, To: may}, records)
+		if summary.Total.Reports != 1 || summary.Total.CostUSD != 0.03 || len(summary.ByDay) != 2 {
+			t.Fatalf("%s: 同一份報告應只計一次並依嘗試日期分組: %+v", name, summary)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCancelReportRunningAndQueued(t *testing.T) {
+	repo := NewInMemoryReportRepository()
+	generator := newBlockingGenerator()
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		ProcessingTimeout: 5 * time.Second,
+		Workers:           1,
+	})
+
+	running, err := service.CreateReport(context.Background(), "evt-running", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	<-generator.started
+	queued, err := service.CreateReport(context.Background(), "evt-queued", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+
+	cancelled, err := service.CancelReport(context.Background(), queued.ReportID, "alice")
+	if err != nil {
+		t.Fatalf("取消等待中的報告失敗: %v", err)
+	}
+	if cancelled.Status != ReportStatusCancelled || cancelled.CancelledBy != "alice" || cancelled.CancelledAt == nil {
+		t.Fatalf("取消資訊錯誤: %+v", cancelled)
+	}
+	if stats := service.QueueStats(); stats.Depth != 0 {
+		t.Fatalf("取消後應自佇列移除，實際深度為 %d", stats.Depth)
+	}
+
+	if _, err := service.CancelReport(context.Background(), running.ReportID, ""); err != nil {
+		t.Fatalf("取消執行中的報告失敗: %v", err)
+	}
+	// 產生器的 context 被取消後應返回，不需釋放 blockingGenerator。
+	service.Wait()
+
+	report, _ := repo.Get(running.ReportID)
+	if report.Status != ReportStatusCancelled || report.CancelledBy != anonymousCanceller {
+		t.Fatalf("執行中的報告應維持 CANCELLED，實際為 %s (%s)", report.Status, report.CancelledBy)
+	}
+	if report.ErrorMessage != "" {
+		t.Fatalf("取消後不應寫入失敗訊息: %s", report.ErrorMessage)
+	}
+
+	current, err := service.CancelReport(context.Background(), running.ReportID, "bob")
+	if !errors.Is(err, ErrReportNotCancellable) || current.Status != ReportStatusCancelled {
+		t.Fatalf("已結束的報告應回傳 ErrReportNotCancellable，實際為 %v", err)
+	}
+
+	// 取消為終止狀態，可再為事件產生新版本。
+	if _, err := service.RegenerateReport(context.Background(), "evt-running", CreateAnalysisRequest{}); err != nil {
+		t.Fatalf("取消後應可重新產生報告: %v", err)
+	}
+	close(generator.release)
+	service.Wait()
+}
+
+func TestCancelAnalysisReportEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := NewInMemoryReportRepository()
+	generator := newBlockingGenerator()
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{ProcessingTimeout: 5 * time.Second, Workers: 1})
+	router := SetupRouter(service)
+
+	report, err := service.CreateReport(context.Background(), "evt-cancel", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	<-generator.started
+
+	body := bytes.NewBufferString(`{"cancelled_by":"oncall"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/ai/analysis-reports/"+report.ReportID+"/cancel", body)
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("預期回傳 200，實際為 %d", resp.Code)
+	}
+	var cancelled AnalysisReport
+	if err := json.Unmarshal(resp.Body.Bytes(), &cancelled); err != nil {
+		t.Fatalf("解析回應失敗: %v", err)
+	}
+	if cancelled.Status != ReportStatusCancelled || cancelled.CancelledBy != "oncall" {
+		t.Fatalf("取消回應錯誤: %+v", cancelled)
+	}
+	service.Wait()
+
+	againReq := httptest.NewRequest(http.MethodDelete, "/api/v1/ai/analysis-reports/"+report.ReportID, nil)
+	againResp := httptest.NewRecorder()
+	router.ServeHTTP(againResp, againReq)
+	if againResp.Code != http.StatusConflict {
+		t.Fatalf("重複取消應回傳 409，實際為 %d", againResp.Code)
+	}
+
+	missingReq := httptest.NewRequest(http.MethodDelete, "/api/v1/ai/analysis-reports/rpt-missing", nil)
+	missingResp := httptest.NewRecorder()
+	router.ServeHTTP(missingResp, missingReq)
+	if missingResp.Code != http.StatusNotFound {
+		t.Fatalf("不存在的報告應回傳 404，實際為 %d", missingResp.Code)
+	}
+}
+
+func TestCancelReportRequiresOwnership(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth, err := NewAuthenticator(AuthConfig{HS256Secret: testJWTSecret})
+	if err != nil {
+		t.Fatalf("建立驗證器失敗: %v", err)
+	}
+	generator := newBlockingGenerator()
+	service := NewAnalysisService(NewInMemoryReportRepository(), generator, AnalysisServiceConfig{ProcessingTimeout: 5 * time.Second, Workers: 1, Auth: auth})
+	router := SetupRouter(service)
+
+	alice := withPrincipal(context.Background(), Principal{Subject: "user-alice", Username: "alice", Roles: []string{RoleTeamMember}, Teams: []string{"payments"}})
+	report, err := service.CreateReport(alice, "evt-owned", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	<-generator.started
+
+	// 其他團隊的成員無法取消。
+	outsider := userClaims("bob", RoleTeamManager)
+	outsider["teams"] = []string{"search"}
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, authedRequest(http.MethodDelete, "/api/v1/ai/analysis-reports/"+report.ReportID, signHS256(t, outsider), nil))
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("其他團隊取消應回傳 403，實際為 %d", resp.Code)
+	}
+	if current, _ := service.GetReport(context.Background(), report.ReportID); current.Status != ReportStatusRunning {
+		t.Fatalf("未授權的取消不應改變狀態，實際為 %s", current.Status)
+	}
+
+	teammate := withPrincipal(context.Background(), Principal{Subject: "user-carol", Username: "carol", Roles: []string{RoleTeamMember}, Teams: []string{"sre", "payments"}})
+	cancelled, err := service.CancelReport(teammate, report.ReportID, "")
+	if err != nil || cancelled.Status != ReportStatusCancelled || cancelled.CancelledBy != "carol" {
+		t.Fatalf("同團隊成員應可取消: %+v (%v)", cancelled, err)
+	}
+	service.Wait()
+}
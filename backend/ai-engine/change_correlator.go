@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultChangeLookBehind = 2 * time.Hour
+	defaultMaxChanges       = 5
+	// defaultChangeHalfLife 為時間衰減的半衰期，距事件越久的變更分數越低。
+	defaultChangeHalfLife = 30 * time.Minute
+	// changeClockTolerance 容許 CI/CD 回報時間略晚於告警觸發時間。
+	changeClockTolerance = time.Minute
+)
+
+// 變更與事件資源的關係。
+const (
+	ChangeRelationDirect     = "direct"
+	ChangeRelationUpstream   = "upstream"
+	ChangeRelationDownstream = "downstream"
+)
+
+// changeRelationWeights 為各關係的分數權重，直接作用於事件資源的變更最可疑。
+var changeRelationWeights = map[string]float64{
+	ChangeRelationDirect:     1.0,
+	ChangeRelationUpstream:   0.6,
+	ChangeRelationDownstream: 0.4,
+}
+
+var changeRelationLabels = map[string]string{
+	ChangeRelationDirect:     "",
+	ChangeRelationUpstream:   "上游依賴 ",
+	ChangeRelationDownstream: "下游服務 ",
+}
+
+// changeTypeWeights 為各變更類型的分數權重。
+var changeTypeWeights = map[string]float64{
+	ChangeTypeDeployment: 1.0,
+	ChangeTypeConfig:     0.9,
+}
+
+// ChangeCorrelatorConfig 設定變更關聯的方式。
+type ChangeCorrelatorConfig struct {
+	Changes ChangeRepository
+	// Topology 用於找出事件資源的上下游節點，未設定時僅比對事件資源本身。
+	Topology TopologyProvider
+	// LookBehind 為事件觸發前查詢變更的區間，預設 2 小時。
+	LookBehind time.Duration
+	// MaxChanges 為轉為證據的變更數，依分數排序，預設 5。
+	MaxChanges int
+	// HalfLife 為時間衰減的半衰期，預設 30 分鐘。
+	HalfLife time.Duration
+}
+
+// ChangeCorrelator 找出事件前作用於事件資源及其拓撲相鄰節點的變更，依可疑程度排序後轉為 CHANGE 證據。
+type ChangeCorrelator struct {
+	changes    ChangeRepository
+	topology   TopologyProvider
+	lookBehind time.Duration
+	maxChanges int
+	halfLife   time.Duration
+}
+
+// NewChangeCorrelator 建立變更關聯來源。
+func NewChangeCorrelator(cfg ChangeCorrelatorConfig) *ChangeCorrelator {
+	if cfg.Changes == nil {
+		panic("change repository is required")
+	}
+	correlator := &ChangeCorrelator{
+		changes:    cfg.Changes,
+		topology:   cfg.Topology,
+		lookBehind: cfg.LookBehind,
+		maxChanges: cfg.MaxChanges,
+		halfLife:   cfg.HalfLife,
+	}
+	if correlator.lookBehind <= 0 {
+		correlator.lookBehind = defaultChangeLookBehind
+	}
+	if correlator.maxChanges <= 0 {
+		correlator.maxChanges = defaultMaxChanges
+	}
+	if correlator.halfLife <= 0 {
+		correlator.halfLife = defaultChangeHalfLife
+	}
+	return correlator
+}
+
+// Name 實作 ContextEnricher。
+func (c *ChangeCorrelator) Name() string {
+	return "changes"
+}
+
+// rankedChange 為評分後的變更。
+type rankedChange struct {
+	change        ChangeEvent
+	relation      string
+	target        string
+	minutesBefore int
+	score         float64
+}
+
+// Enrich 查詢事件前的變更並依分數排序；拓撲無法取得時僅比對事件資源，並回傳部分結果與錯誤。
+func (c *ChangeCorrelator) Enrich(ctx context.Context, event EventFacts) ([]EvidenceItem, error) {
+	relations, topologyErr := c.targets(ctx, event)
+	if len(relations) == 0 {
+		return nil, topologyErr
+	}
+	targets := make([]string, 0, len(relations))
+	for target := range relations {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	changes, err := c.changes.ListChanges(ChangeQuery{
+		From:    event.TriggeredAt.Add(-c.lookBehind),
+		To:      event.TriggeredAt.Add(changeClockTolerance),
+		Targets: targets,
+		Limit:   maxChangeLimit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("查詢變更失敗: %w", err)
+	}
+
+	environment := event.Labels["environment"]
+	if environment == "" {
+		environment = event.Labels["env"]
+	}
+	var ranked []rankedChange
+	for _, change := range changes {
+		if environment != "" && change.Environment != "" && !strings.EqualFold(environment, change.Environment) {
+			continue
+		}
+		candidate, ok := c.rank(change, relations, event.TriggeredAt)
+		if ok {
+			ranked = append(ranked, candidate)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].change.OccurredAt.After(ranked[j].change.OccurredAt)
+	})
+	if len(ranked) > c.maxChanges {
+		ranked = ranked[:c.maxChanges]
+	}
+
+	evidence := make([]EvidenceItem, len(ranked))
+	for i, candidate := range ranked {
+		evidence[i] = changeEvidence(candidate, i+1)
+	}
+	return evidence, topologyErr
+}
+
+// targets 回傳需比對的服務與資源識別，以及各自與事件資源的關係。
+func (c *ChangeCorrelator) targets(ctx context.Context, event EventFacts) (map[string]string, error) {
+	relations := make(map[string]string)
+	add := func(target, relation string) {
+		if target == "" {
+			return
+		}
+		if current, ok := relations[target]; ok && changeRelationWeights[current] >= changeRelationWeights[relation] {
+			return
+		}
+		relations[target] = relation
+	}
+	for _, target := range []string{event.ResourceID, event.ResourceName, event.Labels["service"]} {
+		add(target, ChangeRelationDirect)
+	}
+	if c.topology == nil {
+		return relations, nil
+	}
+
+	graph, err := c.topology.Topology(ctx)
+	if err != nil {
+		return relations, fmt.Errorf("取得拓撲失敗，僅比對事件資源: %w", err)
+	}
+	origin, ok := graph.Locate(event)
+	if !ok {
+		return relations, nil
+	}
+	add(origin.ID, ChangeRelationDirect)
+	add(origin.Name, ChangeRelationDirect)
+	for _, node := range graph.Dependencies(origin.ID) {
+		add(node.ID, ChangeRelationUpstream)
+		add(node.Name, ChangeRelationUpstream)
+	}
+	for _, node := range graph.Consumers(origin.ID) {
+		add(node.ID, ChangeRelationDownstream)
+		add(node.Name, ChangeRelationDownstream)
+	}
+	return relations, nil
+}
+
+// rank 依關係、變更類型與距事件的時間計算分數，取變更涉及目標中最接近事件資源的關係。
+func (c *ChangeCorrelator) rank(change ChangeEvent, relations map[string]string, triggeredAt time.Time) (rankedChange, bool) {
+	candidate := rankedChange{change: change}
+	for _, target := range change.Targets() {
+		relation, ok := relations[target]
+		if !ok {
+			continue
+		}
+		if candidate.relation == "" || changeRelationWeights[relation] > changeRelationWeights[candidate.relation] {
+			candidate.relation, candidate.target = relation, target
+		}
+	}
+	if candidate.relation == "" {
+		return rankedChange{}, false
+	}
+
+	elapsed := max(triggeredAt.Sub(change.OccurredAt), 0)
+	candidate.minutesBefore = int(math.Round(elapsed.Minutes()))
+	decay := math.Pow(0.5, elapsed.Seconds()/c.halfLife.Seconds())
+	typeWeight, ok := changeTypeWeights[change.Type]
+	if !ok {
+		typeWeight = changeTypeWeights[ChangeTypeConfig]
+	}
+	candidate.score = math.Round(changeRelationWeights[candidate.relation]*typeWeight*decay*1000) / 1000
+	return candidate, true
+}
+
+// changeEvidence 將評分後的變更轉為 CHANGE 證據，描述同時作為可能原因。
+func changeEvidence(candidate rankedChange, rank int) EvidenceItem {
+	change := candidate.change
+	cause := describeChange(candidate)
+	var link *EvidenceLink
+	if change.PipelineURL != "" {
+		link = &EvidenceLink{Name: "CI/CD 流程", URL: change.PipelineURL}
+	}
+	occurredAt := change.OccurredAt
+	metadata := map[string]any{
+		"change_id":      change.ChangeID,
+		"change_type":    change.Type,
+		"target":         candidate.target,
+		"relation":       candidate.relation,
+		"minutes_before": candidate.minutesBefore,
+		"score":          candidate.score,
+		"rank":           rank,
+		"probable_cause": cause,
+	}
+	for key, value := range map[string]string{
+		"service":          change.Service,
+		"environment":      change.Environment,
+		"version":          change.Version,
+		"previous_version": change.PreviousVersion,
+		"commit_sha":       change.CommitSHA,
+		"repository":       change.Repository,
+		"author":           change.Author,
+		"source":           change.Source,
+	} {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+	if len(change.Resources) > 0 {
+		metadata["resources"] = change.Resources
+	}
+	return EvidenceItem{
+		Type:        EvidenceTypeChange,
+		Description: cause,
+		Link:        link,
+		Timestamp:   &occurredAt,
+		Metadata:    metadata,
+	}
+}
+
+// describeChange 產生變更的摘要，例如「上游依賴 payments 於事件前 12 分鐘部署 v2.3.1 (commit 1a2b3c4)」。
+func describeChange(candidate rankedChange) string {
+	change := candidate.change
+	var b strings.Builder
+	b.WriteString(changeRelationLabels[candidate.relation])
+	b.WriteString(candidate.target)
+	fmt.Fprintf(&b, " 於事件前 %d 分鐘", candidate.minutesBefore)
+	if change.Type == ChangeTypeDeployment {
+		b.WriteString("部署")
+		if change.Version != "" {
+			b.WriteString(" " + change.Version)
+			if change.PreviousVersion != "" {
+				b.WriteString(" (原 " + change.PreviousVersion + ")")
+			}
+		}
+	} else {
+		b.WriteString("變更設定")
+	}
+	if sha := change.CommitSHA; sha != "" {
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		b.WriteString(" commit " + sha)
+	}
+	if change.Summary != "" {
+		b.WriteString("：" + truncateRunes(change.Summary, 200))
+	}
+	return b.String()
+}
+
+// mergeProbableCauses 將收集到的 CHANGE 證據依排名置於產生器推論的可能原因之前，並略過重複項目。
+func mergeProbableCauses(collected []EvidenceItem, generated []string) []string {
+	var causes []string
+	seen := make(map[string]bool)
+	for _, item := range collected {
+		if item.Type != EvidenceTypeChange {
+			continue
+		}
+		if cause, _ := item.Metadata["probable_cause"].(string); cause != "" && !seen[cause] {
+			seen[cause] = true
+			causes = append(causes, cause)
+		}
+	}
+	if len(causes) == 0 {
+		return generated
+	}
+	for _, cause := range generated {
+		if !seen[cause] {
+			seen[cause] = true
+			causes = append(causes, cause)
+		}
+	}
+	return causes
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 變更類型。
+const (
+	ChangeTypeDeployment = "DEPLOYMENT"
+	ChangeTypeConfig     = "CONFIG"
+)
+
+const (
+	// maxChangeClockSkew 為允許 CI/CD 回報的變更時間超前伺服器時間的上限。
+	maxChangeClockSkew = 5 * time.Minute
+	defaultChangeLimit = 100
+	maxChangeLimit     = 500
+)
+
+var (
+	// ErrInvalidChange 代表變更事件內容無效。
+	ErrInvalidChange = errors.New("invalid change event")
+	// ErrChangeAlreadyExists 代表相同 change_id 的變更已記錄。
+	ErrChangeAlreadyExists = errors.New("change event already exists")
+	// ErrInvalidChangeQuery 代表變更查詢條件無效。
+	ErrInvalidChangeQuery = errors.New("invalid change query")
+)
+
+// ChangeEvent 為 CI/CD 回報的部署或設定變更。
+type ChangeEvent struct {
+	// ChangeID 由呼叫端提供時作為冪等鍵，重送相同編號不會重複記錄。
+	ChangeID string `json:"change_id"`
+	Type     string `json:"type"`
+	Service  string `json:"service,omitempty"`
+	// Resources 為受影響資源的編號或名稱，對應拓撲節點。
+	Resources       []string       `json:"resources,omitempty"`
+	Environment     string         `json:"environment,omitempty"`
+	Summary         string         `json:"summary,omitempty"`
+	Version         string         `json:"version,omitempty"`
+	PreviousVersion string         `json:"previous_version,omitempty"`
+	CommitSHA       string         `json:"commit_sha,omitempty"`
+	Repository      string         `json:"repository,omitempty"`
+	Author          string         `json:"author,omitempty"`
+	PipelineURL     string         `json:"pipeline_url,omitempty"`
+	Source          string         `json:"source,omitempty"`
+	Metadata        map[string]any `json:"metadata,omitempty"`
+	OccurredAt      time.Time      `json:"occurred_at"`
+	SubmittedBy     string         `json:"submitted_by,omitempty"`
+	ReceivedAt      time.Time      `json:"received_at"`
+}
+
+// Clone 建立變更事件的副本。
+func (c ChangeEvent) Clone() ChangeEvent {
+	c.Resources = slices.Clone(c.Resources)
+	c.Metadata = maps.Clone(c.Metadata)
+	return c
+}
+
+// Targets 回傳變更涉及的服務與資源識別，用於比對事件資源。
+func (c ChangeEvent) Targets() []string {
+	targets := make([]string, 0, len(c.Resources)+1)
+	if c.Service != "" {
+		targets = append(targets, c.Service)
+	}
+	for _, resource := range c.Resources {
+		if resource != "" && !slices.Contains(targets, resource) {
+			targets = append(targets, resource)
+		}
+	}
+	return targets
+}
+
+// CreateChangeRequest 為 CI/CD 回報變更的輸入格式。
+type CreateChangeRequest struct {
+	ChangeID        string         `json:"change_id"`
+	Type            string         `json:"type"`
+	Service         string         `json:"service"`
+	Resources       []string       `json:"resources"`
+	Environment     string         `json:"environment"`
+	Summary         string         `json:"summary"`
+	Version         string         `json:"version"`
+	PreviousVersion string         `json:"previous_version"`
+	CommitSHA       string         `json:"commit_sha"`
+	Repository      string         `json:"repository"`
+	Author          string         `json:"author"`
+	PipelineURL     string         `json:"pipeline_url"`
+	Source          string         `json:"source"`
+	Metadata        map[string]any `json:"metadata"`
+	// OccurredAt 未提供時為收到請求的時間。
+	OccurredAt *time.Time `json:"occurred_at"`
+}
+
+// toChange 驗證請求並轉為變更事件。
+func (r CreateChangeRequest) toChange(now time.Time) (ChangeEvent, error) {
+	change := ChangeEvent{
+		ChangeID:        strings.TrimSpace(r.ChangeID),
+		Type:            strings.ToUpper(strings.TrimSpace(r.Type)),
+		Service:         strings.TrimSpace(r.Service),
+		Environment:     strings.TrimSpace(r.Environment),
+		Summary:         strings.TrimSpace(r.Summary),
+		Version:         strings.TrimSpace(r.Version),
+		PreviousVersion: strings.TrimSpace(r.PreviousVersion),
+		CommitSHA:       strings.TrimSpace(r.CommitSHA),
+		Repository:      strings.TrimSpace(r.Repository),
+		Author:          strings.TrimSpace(r.Author),
+		PipelineURL:     strings.TrimSpace(r.PipelineURL),
+		Source:          strings.TrimSpace(r.Source),
+		Metadata:        maps.Clone(r.Metadata),
+		OccurredAt:      now,
+		ReceivedAt:      now,
+	}
+	for _, resource := range r.Resources {
+		if resource = strings.TrimSpace(resource); resource != "" && !slices.Contains(change.Resources, resource) {
+			change.Resources = append(change.Resources, resource)
+		}
+	}
+	if r.OccurredAt != nil {
+		change.OccurredAt = r.OccurredAt.UTC()
+	}
+
+	switch change.Type {
+	case ChangeTypeDeployment, ChangeTypeConfig:
+	default:
+		return ChangeEvent{}, fmt.Errorf("%w: type 需為 %s 或 %s", ErrInvalidChange, ChangeTypeDeployment, ChangeTypeConfig)
+	}
+	if change.Service == "" && len(change.Resources) == 0 {
+		return ChangeEvent{}, fmt.Errorf("%w: 需提供 service 或 resources", ErrInvalidChange)
+	}
+	if len(change.ChangeID) > 128 {
+		return ChangeEvent{}, fmt.Errorf("%w: change_id 過長", ErrInvalidChange)
+	}
+	if change.OccurredAt.After(now.Add(maxChangeClockSkew)) {
+		return ChangeEvent{}, fmt.Errorf("%w: occurred_at 不可晚於現在", ErrInvalidChange)
+	}
+	if change.PipelineURL != "" {
+		parsed, err := url.Parse(change.PipelineURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return ChangeEvent{}, fmt.Errorf("%w: pipeline_url 需為 http/https 網址", ErrInvalidChange)
+		}
+	}
+	if change.ChangeID == "" {
+		change.ChangeID = uuid.NewString()
+	}
+	return change, nil
+}
+
+// ChangeQuery 為變更查詢條件，Targets 比對服務或任一資源，皆為空時不篩選。
+type ChangeQuery struct {
+	From    time.Time
+	To      time.Time
+	Targets []string
+	Limit   int
+}
+
+// Normalize 套用預設值並驗證時間範圍。
+func (q ChangeQuery) Normalize(now time.Time) (ChangeQuery, error) {
+	if q.To.IsZero() {
+		q.To = now
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-24 * time.Hour)
+	}
+	if !q.From.Before(q.To) {
+		return q, fmt.Errorf("%w: from 需早於 to", ErrInvalidChangeQuery)
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultChangeLimit
+	}
+	if q.Limit > maxChangeLimit {
+		return q, fmt.Errorf("%w: limit 最大為 %d", ErrInvalidChangeQuery, maxChangeLimit)
+	}
+	return q, nil
+}
+
+// Matches 判斷變更是否符合查詢條件。
+func (q ChangeQuery) Matches(change ChangeEvent) bool {
+	if change.OccurredAt.Before(q.From) || !change.OccurredAt.Before(q.To) {
+		return false
+	}
+	if len(q.Targets) == 0 {
+		return true
+	}
+	for _, target := range change.Targets() {
+		if slices.Contains(q.Targets, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangeRepository 定義變更事件的儲存介面。
+type ChangeRepository interface {
+	// CreateChange 記錄變更；change_id 已存在時回傳既有變更與 ErrChangeAlreadyExists。
+	CreateChange(change ChangeEvent) (ChangeEvent, error)
+	// ListChanges 依發生時間遞減回傳符合條件的變更，最多 query.Limit 筆。
+	ListChanges(query ChangeQuery) ([]ChangeEvent, error)
+}
+
+// InMemoryChangeRepository 使用記憶體儲存變更事件。
+type InMemoryChangeRepository struct {
+	mu      sync.RWMutex
+	changes map[string]ChangeEvent
+}
+
+// NewInMemoryChangeRepository 建立記憶體變更儲存庫。
+func NewInMemoryChangeRepository() *InMemoryChangeRepository {
+	return &InMemoryChangeRepository{changes: make(map[string]ChangeEvent)}
+}
+
+// CreateChange 實作 ChangeRepository。
+func (r *InMemoryChangeRepository) CreateChange(change ChangeEvent) (ChangeEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.changes[change.ChangeID]; ok {
+		return existing.Clone(), ErrChangeAlreadyExists
+	}
+	r.changes[change.ChangeID] = change.Clone()
+	return change.Clone(), nil
+}
+
+// ListChanges 實作 ChangeRepository。
+func (r *InMemoryChangeRepository) ListChanges(query ChangeQuery) ([]ChangeEvent, error) {
+	r.mu.RLock()
+	var changes []ChangeEvent
+	for _, change := range r.changes {
+		if query.Matches(change) {
+			changes = append(changes, change.Clone())
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(changes, func(i, j int) bool {
+		if !changes[i].OccurredAt.Equal(changes[j].OccurredAt) {
+			return changes[i].OccurredAt.After(changes[j].OccurredAt)
+		}
+		return changes[i].ChangeID < changes[j].ChangeID
+	})
+	if query.Limit > 0 && len(changes) > query.Limit {
+		changes = changes[:query.Limit]
+	}
+	return changes, nil
+}
+
+// changeRepositoryFor 優先沿用同時支援變更儲存的報告儲存庫 (例如 SQL)，否則使用記憶體。
+func changeRepositoryFor(repo ReportRepository) ChangeRepository {
+	if changes, ok := repo.(ChangeRepository); ok {
+		return changes
+	}
+	return NewInMemoryChangeRepository()
+}
+
+// RecordChange 驗證並記錄 CI/CD 回報的變更；重送相同 change_id 時回傳既有變更與 ErrChangeAlreadyExists。
+func (s *AnalysisService) RecordChange(ctx context.Context, req CreateChangeRequest) (ChangeEvent, error) {
+	change, err := req.toChange(time.Now().UTC())
+	if err != nil {
+		return ChangeEvent{}, err
+	}
+	change.SubmittedBy = requesterName(ctx)
+	return s.changes.CreateChange(change)
+}
+
+// ListChanges 查詢已記錄的變更。
+func (s *AnalysisService) ListChanges(query ChangeQuery) ([]ChangeEvent, error) {
+	query, err := query.Normalize(time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return s.changes.ListChanges(query)
+}
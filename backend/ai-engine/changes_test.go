@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var changeTestTrigger = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// changeTestTopology 中 web 呼叫 checkout，checkout 依賴 payments。
+var changeTestTopology = TopologyGraph{
+	Nodes: []TopologyNode{
+		{ID: "svc-web", Name: "web"},
+		{ID: "svc-checkout", Name: "checkout"},
+		{ID: "svc-payments", Name: "payments"},
+		{ID: "svc-search", Name: "search"},
+	},
+	Edges: []TopologyEdge{
+		{Source: "svc-web", Target: "svc-checkout"},
+		{Source: "svc-checkout", Target: "svc-payments"},
+	},
+}
+
+func TestChangeIngestAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth, err := NewAuthenticator(AuthConfig{HS256Secret: testJWTSecret})
+	if err != nil {
+		t.Fatalf("建立驗證器失敗: %v", err)
+	}
+	service := NewAnalysisService(NewInMemoryReportRepository(), &stubGenerator{}, AnalysisServiceConfig{Auth: auth})
+	router := SetupRouter(service)
+	publisher := signHS256(t, userClaims("ci-bot", RoleChangePublisher))
+	member := signHS256(t, userClaims("alice", RoleTeamMember))
+
+	body := []byte(`{"change_id":"deploy-42","type":"deployment","service":"checkout","version":"v1.4.2","commit_sha":"9f8e7d6c5b4a","pipeline_url":"https://ci.example.com/runs/42","occurred_at":"2024-05-01T11:50:00Z"}`)
+	tests := []struct {
+		name   string
+		token  string
+		body   []byte
+		status int
+	}{
+		{name: "一般成員不可回報", token: member, body: body, status: http.StatusForbidden},
+		{name: "新變更", token: publisher, body: body, status: http.StatusCreated},
+		{name: "重送相同編號", token: publisher, body: body, status: http.StatusOK},
+		{name: "未知類型", token: publisher, body: []byte(`{"type":"restart","service":"checkout"}`), status: http.StatusBadRequest},
+		{name: "缺少服務", token: publisher, body: []byte(`{"type":"CONFIG"}`), status: http.StatusBadRequest},
+		{name: "無效流程網址", token: publisher, body: []byte(`{"type":"CONFIG","service":"checkout","pipeline_url":"ftp://ci"}`), status: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, authedRequest(http.MethodPost, "/api/v1/changes", tc.token, tc.body))
+		if rec.Code != tc.status {
+			t.Fatalf("%s: 預期 %d，實際為 %d: %s", tc.name, tc.status, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, authedRequest(http.MethodGet, "/api/v1/changes?service=checkout&from=2024-05-01T11:00:00Z&to=2024-05-01T12:00:00Z", member, nil))
+	var page struct {
+		Changes []ChangeEvent `json:"changes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("查詢變更失敗: %d %s", rec.Code, rec.Body.String())
+	}
+	if len(page.Changes) != 1 {
+		t.Fatalf("重送不應重複記錄: %+v", page.Changes)
+	}
+	change := page.Changes[0]
+	if change.Type != ChangeTypeDeployment || change.SubmittedBy != "ci-bot" || change.Version != "v1.4.2" {
+		t.Fatalf("變更內容錯誤: %+v", change)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, authedRequest(http.MethodGet, "/api/v1/changes?from=2024-05-01T12:00:00Z&to=2024-05-01T11:00:00Z", member, nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("無效的時間範圍應回傳 400，實際為 %d", rec.Code)
+	}
+}
+
+func TestChangeRequestDefaults(t *testing.T) {
+	now := changeTestTrigger
+	change, err := CreateChangeRequest{Type: " config ", Resources: []string{"db-1", " ", "db-1"}}.toChange(now)
+	if err != nil {
+		t.Fatalf("建立變更失敗: %v", err)
+	}
+	if change.ChangeID == "" || !change.OccurredAt.Equal(now) || len(change.Resources) != 1 || change.Type != ChangeTypeConfig {
+		t.Fatalf("預設值錯誤: %+v", change)
+	}
+	future := now.Add(time.Hour)
+	if _, err := (CreateChangeRequest{Type: ChangeTypeConfig, Service: "api", OccurredAt: &future}).toChange(now); !errors.Is(err, ErrInvalidChange) {
+		t.Fatalf("未來時間應視為無效，實際為 %v", err)
+	}
+}
+
+func TestSQLChangeRepository(t *testing.T) {
+	repo := newTestSQLRepository(t)
+	create := func(id, service string, resources []string, minutes int) {
+		t.Helper()
+		_, err := repo.CreateChange(ChangeEvent{
+			ChangeID:   id,
+			Type:       ChangeTypeDeployment,
+			Service:    service,
+			Resources:  resources,
+			OccurredAt: changeTestTrigger.Add(time.Duration(minutes) * time.Minute),
+			ReceivedAt: changeTestTrigger,
+		})
+		if err != nil {
+			t.Fatalf("建立變更失敗: %v", err)
+		}
+	}
+	create("c1", "checkout", nil, -30)
+	create("c2", "", []string{"db_1", "cache"}, -10)
+	create("c3", "checkout_v2", nil, -5)
+	create("c4", "checkout", nil, -300)
+
+	existing, err := repo.CreateChange(ChangeEvent{ChangeID: "c1", Type: ChangeTypeConfig, Service: "other", OccurredAt: changeTestTrigger})
+	if !errors.Is(err, ErrChangeAlreadyExists) || existing.Service != "checkout" {
+		t.Fatalf("重複編號應回傳既有變更: %+v (%v)", existing, err)
+	}
+
+	changes, err := repo.ListChanges(ChangeQuery{
+		From:    changeTestTrigger.Add(-time.Hour),
+		To:      changeTestTrigger,
+		Targets: []string{"checkout", "db_1"},
+		Limit:   10,
+	})
+	if err != nil {
+		t.Fatalf("查詢變更失敗: %v", err)
+	}
+	// checkout_v2 不應因 LIKE 的 _ 萬用字元而符合，c4 不在時間範圍內。
+	if len(changes) != 2 || changes[0].ChangeID != "c2" || changes[1].ChangeID != "c1" {
+		t.Fatalf("查詢結果錯誤: %+v", changes)
+	}
+	if len(changes[0].Resources) != 2 {
+		t.Fatalf("資源應完整保存: %+v", changes[0])
+	}
+}
+
+func TestChangeCorrelatorRanksChanges(t *testing.T) {
+	changes := NewInMemoryChangeRepository()
+	for _, change := range []ChangeEvent{
+		// 上游依賴 5 分鐘前的部署：0.6 × 0.891 ≈ 0.535。
+		{ChangeID: "payments-deploy", Type: ChangeTypeDeployment, Service: "payments", Version: "v2.3.1", OccurredAt: changeTestTrigger.Add(-5 * time.Minute)},
+		// 事件服務 40 分鐘前的設定變更：0.9 × 0.397 ≈ 0.357。
+		{ChangeID: "checkout-config", Type: ChangeTypeConfig, Resources: []string{"svc-checkout"}, Summary: "調整連線池", OccurredAt: changeTestTrigger.Add(-40 * time.Minute)},
+		// 事件服務 10 分鐘前的部署：1.0 × 0.794 ≈ 0.794。
+		{ChangeID: "checkout-deploy", Type: ChangeTypeDeployment, Service: "checkout", Version: "v1.4.2", PreviousVersion: "v1.4.1", CommitSHA: "9f8e7d6c5b4a", PipelineURL: "https://ci.example.com/runs/42", OccurredAt: changeTestTrigger.Add(-10 * time.Minute)},
+		{ChangeID: "unrelated", Type: ChangeTypeDeployment, Service: "search", OccurredAt: changeTestTrigger.Add(-time.Minute)},
+		{ChangeID: "too-old", Type: ChangeTypeDeployment, Service: "checkout", OccurredAt: changeTestTrigger.Add(-3 * time.Hour)},
+		{ChangeID: "other-env", Type: ChangeTypeDeployment, Service: "checkout", Environment: "staging", OccurredAt: changeTestTrigger.Add(-2 * time.Minute)},
+	} {
+		if _, err := changes.CreateChange(change); err != nil {
+			t.Fatalf("建立變更失敗: %v", err)
+		}
+	}
+	correlator := NewChangeCorrelator(ChangeCorrelatorConfig{
+		Changes:  changes,
+		Topology: StaticTopologyProvider{Graph: changeTestTopology},
+	})
+
+	evidence, err := correlator.Enrich(context.Background(), EventFacts{
+		ResourceName: "checkout",
+		TriggeredAt:  changeTestTrigger,
+		Labels:       map[string]string{"environment": "production"},
+	})
+	if err != nil {
+		t.Fatalf("關聯變更失敗: %v", err)
+	}
+	var ids []string
+	for _, item := range evidence {
+		ids = append(ids, item.Metadata["change_id"].(string))
+	}
+	if strings.Join(ids, ",") != "checkout-deploy,payments-deploy,checkout-config" {
+		t.Fatalf("排序錯誤: %v", ids)
+	}
+
+	top := evidence[0]
+	if top.Type != EvidenceTypeChange || top.Link == nil || top.Link.URL != "https://ci.example.com/runs/42" {
+		t.Fatalf("變更證據錯誤: %+v", top)
+	}
+	if top.Metadata["commit_sha"] != "9f8e7d6c5b4a" || top.Metadata["relation"] != ChangeRelationDirect || top.Metadata["minutes_before"] != 10 || top.Metadata["rank"] != 1 {
+		t.Fatalf("變更證據內容錯誤: %+v", top.Metadata)
+	}
+	if top.Description != "checkout 於事件前 10 分鐘部署 v1.4.2 (原 v1.4.1) commit 9f8e7d6" {
+		t.Fatalf("描述錯誤: %s", top.Description)
+	}
+	if evidence[1].Metadata["relation"] != ChangeRelationUpstream || !strings.HasPrefix(evidence[1].Description, "上游依賴 payments") {
+		t.Fatalf("上游變更錯誤: %+v", evidence[1])
+	}
+	if evidence[2].Metadata["target"] != "svc-checkout" {
+		t.Fatalf("應以資源編號比對事件節點: %+v", evidence[2])
+	}
+}
+
+type failingTopology struct{}
+
+func (failingTopology) Topology(context.Context) (TopologyGraph, error) {
+	return TopologyGraph{}, errors.New("unavailable")
+}
+
+func TestChangeCorrelatorFallsBackWithoutTopology(t *testing.T) {
+	changes := NewInMemoryChangeRepository()
+	_, _ = changes.CreateChange(ChangeEvent{ChangeID: "c1", Type: ChangeTypeDeployment, Service: "checkout", OccurredAt: changeTestTrigger.Add(-time.Minute)})
+	_, _ = changes.CreateChange(ChangeEvent{ChangeID: "c2", Type: ChangeTypeDeployment, Service: "payments", OccurredAt: changeTestTrigger.Add(-time.Minute)})
+
+	correlator := NewChangeCorrelator(ChangeCorrelatorConfig{Changes: changes, Topology: failingTopology{}})
+	evidence, err := correlator.Enrich(context.Background(), EventFacts{ResourceName: "checkout", TriggeredAt: changeTestTrigger})
+	if err == nil || len(evidence) != 1 || evidence[0].Metadata["change_id"] != "c1" {
+		t.Fatalf("拓撲失敗時應僅比對事件資源並回傳錯誤: %+v (%v)", evidence, err)
+	}
+}
+
+func TestAnalysisRanksRecentChangesAsProbableCauses(t *testing.T) {
+	changes := NewInMemoryChangeRepository()
+	generator := &recordingGenerator{result: &GeneratedReport{
+		EventSummary:      "結帳錯誤率上升",
+		RootCauseAnalysis: RootCauseAnalysis{Text: "疑似資料庫連線耗盡", ProbableCauses: []string{"資料庫連線池耗盡"}},
+	}}
+	repo := NewInMemoryReportRepository()
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		Changes: changes,
+		Enrichers: []ContextEnricher{NewChangeCorrelator(ChangeCorrelatorConfig{
+			Changes:  changes,
+			Topology: StaticTopologyProvider{Graph: changeTestTopology},
+		})},
+	})
+
+	triggeredAt := time.Now().UTC()
+	occurredAt := triggeredAt.Add(-15 * time.Minute)
+	if _, err := service.RecordChange(context.Background(), CreateChangeRequest{
+		ChangeID:   "payments-deploy",
+		Type:       ChangeTypeDeployment,
+		Service:    "payments",
+		Version:    "v2.3.1",
+		OccurredAt: &occurredAt,
+	}); err != nil {
+		t.Fatalf("記錄變更失敗: %v", err)
+	}
+
+	report, err := service.CreateReport(context.Background(), "evt-change", CreateAnalysisRequest{EventContext: map[string]any{
+		"resource_id":  "svc-checkout",
+		"triggered_at": triggeredAt.Format(time.RFC3339),
+	}})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	service.Wait()
+
+	if len(generator.inputs) != 1 || len(generator.inputs[0].Evidence) != 1 || generator.inputs[0].Evidence[0].Type != EvidenceTypeChange {
+		t.Fatalf("產生器應收到變更證據: %+v", generator.inputs)
+	}
+	stored, _ := repo.Get(report.ReportID)
+	if stored.Status != ReportStatusSuccess || stored.RootCauseAnalysis == nil {
+		t.Fatalf("報告應完成: %+v", stored)
+	}
+	causes := stored.RootCauseAnalysis.ProbableCauses
+	if len(causes) != 2 || causes[0] != "上游依賴 payments 於事件前 15 分鐘部署 v2.3.1" || causes[1] != "資料庫連線池耗盡" {
+		t.Fatalf("可能原因應先列出近期變更: %v", causes)
+	}
+	if len(stored.Evidence) != 1 || stored.Evidence[0].Metadata["version"] != "v2.3.1" {
+		t.Fatalf("報告應包含變更證據: %+v", stored.Evidence)
+	}
+}
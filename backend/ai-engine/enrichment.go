@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const defaultEnrichmentTimeout = 15 * time.Second
+
+// 證據類型，對應 API 合約的 EvidenceItem.type。
+const (
+	EvidenceTypeMetric = "METRIC"
+	EvidenceTypeLog    = "LOG"
+	EvidenceTypeTrace  = "TRACE"
+	EvidenceTypeChange = "CHANGE"
+)
+
+// 豐富化結果標籤。
+const (
+	enrichmentOutcomeSuccess = "success"
+	enrichmentOutcomeEmpty   = "empty"
+	enrichmentOutcomeError   = "error"
+)
+
+// ContextEnricher 於呼叫產生器前向外部系統收集事件相關的證據。
+type ContextEnricher interface {
+	// Name 為豐富化來源名稱，用於日誌、追蹤與指標標籤。
+	Name() string
+	// Enrich 回傳收集到的證據；部分查詢失敗時可同時回傳已收集的證據與錯誤。
+	Enrich(ctx context.Context, event EventFacts) ([]EvidenceItem, error)
+}
+
+// EventFacts 為自 event_context 解析出的事件資訊，欄位名稱對應 API 合約的 EventDetail。
+type EventFacts struct {
+	EventID      string
+	ResourceID   string
+	ResourceName string
+	RuleName     string
+	Severity     string
+	// TriggeredAt 缺少時為建立分析的時間。
+	TriggeredAt time.Time
+	ResolvedAt  *time.Time
+	// Labels 合併 labels、metadata.labels 與 key:value 格式的 tags。
+	Labels map[string]string
+}
+
+// parseEventFacts 自呼叫端提供的 event_context 取出豐富化所需的欄位，格式不符的欄位會被忽略。
+func parseEventFacts(eventID string, eventContext map[string]any, now time.Time) EventFacts {
+	facts := EventFacts{
+		EventID:      eventID,
+		ResourceID:   stringField(eventContext, "resource_id"),
+		ResourceName: stringField(eventContext, "resource_name"),
+		RuleName:     stringField(eventContext, "rule_name"),
+		Severity:     stringField(eventContext, "severity"),
+		TriggeredAt:  now.UTC(),
+		Labels:       map[string]string{},
+	}
+	if triggered, ok := timeField(eventContext, "triggered_at"); ok {
+		facts.TriggeredAt = triggered
+	}
+	if resolved, ok := timeField(eventContext, "resolved_at"); ok {
+		facts.ResolvedAt = &resolved
+	}
+
+	if tags, ok := eventContext["tags"].([]any); ok {
+		for _, tag := range tags {
+			text, _ := tag.(string)
+			key, value, found := strings.Cut(text, ":")
+			if !found {
+				key, value, found = strings.Cut(text, "=")
+			}
+			if found && strings.TrimSpace(key) != "" {
+				facts.Labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+	if metadata, ok := eventContext["metadata"].(map[string]any); ok {
+		maps.Copy(facts.Labels, stringMap(metadata["labels"]))
+	}
+	maps.Copy(facts.Labels, stringMap(eventContext["labels"]))
+	return facts
+}
+
+// Window 回傳事件前 before 至事件後 after 的時間區間；已解決的事件延伸至解決時間，結束時間不超過 now。
+func (f EventFacts) Window(before, after time.Duration, now time.Time) (time.Time, time.Time) {
+	start := f.TriggeredAt.Add(-before)
+	end := f.TriggeredAt.Add(after)
+	if f.ResolvedAt != nil && f.ResolvedAt.Add(after).After(end) {
+		end = f.ResolvedAt.Add(after)
+	}
+	if end.After(now) {
+		end = now
+	}
+	if !start.Before(end) {
+		start = end.Add(-before)
+	}
+	return start.UTC(), end.UTC()
+}
+
+func stringField(values map[string]any, key string) string {
+	value, _ := values[key].(string)
+	return strings.TrimSpace(value)
+}
+
+func timeField(values map[string]any, key string) (time.Time, bool) {
+	value := stringField(values, key)
+	if value == "" {
+		return time.Time{}, false
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return parsed.UTC(), true
+}
+
+// stringMap 將 JSON 物件轉為字串對應，略過非字串的值。
+func stringMap(value any) map[string]string {
+	object, ok := value.(map[string]any)
+	if !ok {
+		return nil
+	}
+	result := make(map[string]string, len(object))
+	for key, item := range object {
+		if text, ok := item.(string); ok {
+			result[key] = text
+		}
+	}
+	return result
+}
+
+// enrich 並行呼叫所有豐富化來源，單一來源失敗或逾時僅記錄警告，不影響分析。
+// 結果依來源設定順序排列，確保報告內容穩定。
+func (s *AnalysisService) enrich(ctx context.Context, logger *slog.Logger, input GenerationInput) []EvidenceItem {
+	if len(s.enrichers) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.enrichmentTimeout)
+	defer cancel()
+	facts := parseEventFacts(input.EventID, input.EventContext, time.Now())
+
+	results := make([][]EvidenceItem, len(s.enrichers))
+	var wg sync.WaitGroup
+	for i, enricher := range s.enrichers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = s.runEnricher(ctx, logger, enricher, facts)
+		}()
+	}
+	wg.Wait()
+
+	var evidence []EvidenceItem
+	for _, items := range results {
+		evidence = append(evidence, items...)
+	}
+	return evidence
+}
+
+func (s *AnalysisService) runEnricher(ctx context.Context, logger *slog.Logger, enricher ContextEnricher, facts EventFacts) []EvidenceItem {
+	ctx, span := startSpan(ctx, "ContextEnricher.Enrich", attribute.String("ai_engine.enricher", enricher.Name()))
+	started := time.Now()
+	items, err := enricher.Enrich(ctx, facts)
+	elapsed := time.Since(started)
+
+	outcome := enrichmentOutcomeSuccess
+	switch {
+	case err != nil:
+		outcome = enrichmentOutcomeError
+		logger.WarnContext(ctx, "收集事件證據失敗",
+			slog.String("enricher", enricher.Name()),
+			durationAttr(elapsed),
+			slog.Int("evidence_count", len(items)),
+			slog.String(logKeyError, err.Error()),
+		)
+	case len(items) == 0:
+		outcome = enrichmentOutcomeEmpty
+	}
+	s.metrics.enrichmentDuration.WithLabelValues(enricher.Name(), outcome).Observe(elapsed.Seconds())
+	span.SetAttributes(attribute.Int("ai_engine.evidence_count", len(items)))
+	endSpan(span, err)
+	return items
+}
+
+// mergeEvidence 將收集到的證據置於產生器引用的證據之前，並略過描述相同的重複項目。
+func mergeEvidence(collected, generated []EvidenceItem) []EvidenceItem {
+	if len(collected) == 0 {
+		return generated
+	}
+	merged := cloneEvidence(collected)
+	seen := make(map[string]bool, len(merged))
+	for _, item := range merged {
+		seen[item.Type+"\x00"+item.Description] = true
+	}
+	for _, item := range generated {
+		if !seen[item.Type+"\x00"+item.Description] {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+// formatLabels 以 Prometheus 選擇器格式輸出標籤，鍵值依字母排序。
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s=%q", key, labels[key])
+	}
+	return strings.Join(parts, ",")
+}
@@ -56,14 +56,34 @@ func (g *TemplateReportGenerator) Generate(ctx context.Context, input Generation
 		return nil, ErrNoTemplates
 	}
 
-	if g.delay > 0 {
-		select {
-		case <-time.After(g.delay):
-		case <-ctx.Done():
-			return nil, ctx.Err()
+	if err := sleepContext(ctx, g.delay); err != nil {
+		return nil, err
+	}
+	return g.render(input), nil
+}
+
+// GenerateStream 將模擬耗時平均分配給各區塊，依序推送摘要、根本原因與建議措施等內容。
+func (g *TemplateReportGenerator) GenerateStream(ctx context.Context, input GenerationInput, emit func(ReportChunk) error) (*GeneratedReport, error) {
+	if len(g.templates) == 0 {
+		return nil, ErrNoTemplates
+	}
+
+	payload := g.render(input)
+	sections := reportSections(payload)
+	step := g.delay / time.Duration(len(sections))
+	for _, chunk := range sections {
+		if err := sleepContext(ctx, step); err != nil {
+			return nil, err
+		}
+		if err := emit(chunk); err != nil {
+			return nil, err
 		}
 	}
+	return payload, nil
+}
 
+// render 依事件編號挑選模板並補上預設摘要。
+func (g *TemplateReportGenerator) render(input GenerationInput) *GeneratedReport {
 	index := 0
 	if input.EventID != "" {
 		index = int(crc32.ChecksumIEEE([]byte(input.EventID))) % len(g.templates)
@@ -73,6 +93,21 @@ func (g *TemplateReportGenerator) Generate(ctx context.Context, input Generation
 	if payload.EventSummary == "" && input.EventID != "" {
 		payload.EventSummary = fmt.Sprintf("事件 %s 的分析報告", input.EventID)
 	}
+	payload.Usage = &LLMUsage{Provider: usageProviderTemplate, Model: usageProviderTemplate}
+	return &payload
+}
 
-	return &payload, nil
+// sleepContext 等待指定時間，context 結束時提早返回錯誤。
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
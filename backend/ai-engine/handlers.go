@@ -3,32 +3,80 @@ package main
 import (
 	"errors"
 	"io"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // SetupRouter 建立路由設定。
 func SetupRouter(service *AnalysisService) *gin.Engine {
 	router := gin.New()
-	router.Use(gin.Recovery())
+	// otelgin 自 traceparent 標頭延續上游 trace，並為每個請求建立 server span。
+	router.Use(
+		otelgin.Middleware(defaultServiceName, otelgin.WithPropagators(tracePropagator())),
+		// 存取日誌與指標置於 recovery 外層，panic 的請求也會以 500 記錄。
+		AccessLogMiddleware(service.logger),
+		service.metrics.Middleware(),
+		recoveryMiddleware(service.logger),
+	)
 
 	handler := &analysisHandler{service: service}
+	requireReportRole := service.auth.Require(reportRoles...)
+	// 限流於驗證之後執行，才能依使用者與團隊計算額度。
+	rateLimit := service.rateLimitMiddleware()
 
 	api := router.Group("/api/v1")
 	{
-		events := api.Group("/events")
-		events.POST("/:eventId/ai-analysis", handler.createAnalysisReport)
+		events := api.Group("/events", requireReportRole)
+		events.POST("/:eventId/ai-analysis", rateLimit, handler.createAnalysisReport)
+		events.GET("/:eventId/analysis", handler.getLatestEventAnalysis)
+		events.POST("/:eventId/analysis", rateLimit, handler.regenerateEventAnalysis)
+		events.GET("/:eventId/analysis/versions", handler.listEventAnalysisVersions)
+		events.GET("/:eventId/analysis/diff", handler.diffEventAnalysisVersions)
 	}
 
-	ai := api.Group("/ai")
+	ai := api.Group("/ai", requireReportRole)
 	{
 		ai.GET("/analysis-reports/:reportId", handler.getAnalysisReport)
+		ai.DELETE("/analysis-reports/:reportId", handler.cancelAnalysisReport)
+		ai.POST("/analysis-reports/:reportId/cancel", handler.cancelAnalysisReport)
+		ai.GET("/analysis-reports/:reportId/stream", handler.streamAnalysisReport)
+	}
+
+	analysis := api.Group("/analysis", requireReportRole)
+	{
+		analysis.GET("/ai-insights", handler.listAIInsights)
+		analysis.GET("/ai-insights/:reportId", handler.getAnalysisReport)
+	}
+
+	changes := api.Group("/changes")
+	{
+		changes.POST("", service.auth.Require(changePublisherRoles...), handler.recordChange)
+		changes.GET("", requireReportRole, handler.listChanges)
+	}
+
+	// 成本彙總僅開放給管理者；團隊管理者限定所屬團隊，超級管理員可查看所有團隊。
+	api.GET("/analysis/ai-usage", service.auth.Require(RoleTeamManager, RoleSuperAdmin), handler.getAIUsage)
+
+	// 指標與健康檢查端點供 Prometheus 與 Kubernetes 使用，不需驗證。
+	api.GET("/metrics", gin.WrapH(service.metrics.Handler()))
+
+	admin := api.Group("/admin", service.auth.Require(RoleSuperAdmin))
+	{
+		admin.GET("/analysis-queue", handler.getQueueStats)
 	}
 
 	router.GET("/healthz", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
+	router.GET("/readyz", handler.getReadiness)
 
 	return router
 }
@@ -38,10 +86,15 @@ type analysisHandler struct {
 }
 
 type createAnalysisResponse struct {
-	ReportID string       `json:"report_id"`
-	Status   ReportStatus `json:"status"`
+	ReportID      string       `json:"report_id"`
+	Status        ReportStatus `json:"status"`
+	QueuePosition int          `json:"queue_position,omitempty"`
+	QueueDepth    int          `json:"queue_depth,omitempty"`
 }
 
+// reportForbiddenMessage 為查看他人報告時的錯誤訊息。
+const reportForbiddenMessage = "僅報告建立者、同團隊成員或超級管理員可查看分析報告"
+
 type errorResponse struct {
 	Error string `json:"error"`
 }
@@ -54,32 +107,156 @@ type conflictResponse struct {
 
 func (h *analysisHandler) createAnalysisReport(c *gin.Context) {
 	eventID := c.Param("eventId")
-	var req CreateAnalysisRequest
-	if c.Request.Body != nil {
-		if err := c.ShouldBindJSON(&req); err != nil {
-			if !errors.Is(err, io.EOF) {
-				c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的 JSON 請求"})
-				return
-			}
-		}
+	req, ok := bindCreateAnalysisRequest(c)
+	if !ok {
+		return
 	}
 
-	report, err := h.service.CreateReport(c.Request.Context(), eventID, req)
+	ctx, span := startSpan(c.Request.Context(), "analysisHandler.createAnalysisReport", attribute.String("ai_engine.event_id", eventID))
+	report, err := h.service.CreateReport(ctx, eventID, req)
+	endSpan(span, err)
+	if err != nil {
+		h.writeSubmitError(c, report, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, createAnalysisResponse{
+		ReportID:      report.ReportID,
+		Status:        report.Status,
+		QueuePosition: report.QueuePosition,
+		QueueDepth:    report.QueueDepth,
+	})
+}
+
+func (h *analysisHandler) regenerateEventAnalysis(c *gin.Context) {
+	eventID := c.Param("eventId")
+	req, ok := bindCreateAnalysisRequest(c)
+	if !ok {
+		return
+	}
+
+	report, err := h.service.RegenerateReport(c.Request.Context(), eventID, req)
+	if err != nil {
+		h.writeSubmitError(c, report, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, report)
+}
+
+func (h *analysisHandler) getLatestEventAnalysis(c *gin.Context) {
+	report, err := h.service.GetLatestReport(c.Request.Context(), c.Param("eventId"))
 	if err != nil {
 		switch {
 		case errors.Is(err, ErrEventIDRequired):
 			c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的事件編號"})
-		case errors.Is(err, ErrReportAlreadyExists):
-			c.JSON(http.StatusConflict, conflictResponse{Error: "分析報告已存在", ReportID: report.ReportID, Status: report.Status})
-		case errors.Is(err, ErrNoTemplates):
-			c.JSON(http.StatusServiceUnavailable, errorResponse{Error: "暫無可用的分析模板"})
+		case errors.Is(err, ErrReportNotFound):
+			c.JSON(http.StatusNotFound, errorResponse{Error: "找不到分析報告"})
+		case errors.Is(err, ErrReportForbidden):
+			c.JSON(http.StatusForbidden, errorResponse{Error: reportForbiddenMessage})
 		default:
-			c.JSON(http.StatusInternalServerError, errorResponse{Error: "建立分析報告時發生錯誤"})
+			c.JSON(http.StatusInternalServerError, errorResponse{Error: "查詢分析報告時發生錯誤"})
 		}
 		return
 	}
 
-	c.JSON(http.StatusAccepted, createAnalysisResponse{ReportID: report.ReportID, Status: report.Status})
+	c.JSON(http.StatusOK, report)
+}
+
+type reportVersionsResponse struct {
+	Items []ReportVersionSummary `json:"items"`
+}
+
+func (h *analysisHandler) listEventAnalysisVersions(c *gin.Context) {
+	versions, err := h.service.ListReportVersions(c.Request.Context(), c.Param("eventId"))
+	if err != nil {
+		writeVersionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, reportVersionsResponse{Items: versions})
+}
+
+func (h *analysisHandler) diffEventAnalysisVersions(c *gin.Context) {
+	fromVersion, fromErr := parseOptionalInt(c.Query("from"))
+	toVersion, toErr := parseOptionalInt(c.Query("to"))
+	if fromErr != nil || toErr != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的版本號"})
+		return
+	}
+
+	diff, err := h.service.DiffReportVersions(c.Request.Context(), c.Param("eventId"), fromVersion, toVersion)
+	if err != nil {
+		writeVersionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, diff)
+}
+
+func writeVersionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrEventIDRequired):
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的事件編號"})
+	case errors.Is(err, ErrInvalidVersion):
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的版本號"})
+	case errors.Is(err, ErrReportNotFound):
+		c.JSON(http.StatusNotFound, errorResponse{Error: "找不到分析報告版本"})
+	case errors.Is(err, ErrReportForbidden):
+		c.JSON(http.StatusForbidden, errorResponse{Error: reportForbiddenMessage})
+	default:
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "查詢分析報告版本時發生錯誤"})
+	}
+}
+
+func parseOptionalInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// bindCreateAnalysisRequest 解析可省略的 JSON 請求內容，失敗時直接回傳 400。
+func bindCreateAnalysisRequest(c *gin.Context) (CreateAnalysisRequest, bool) {
+	var req CreateAnalysisRequest
+	if c.Request.Body != nil {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			if !errors.Is(err, io.EOF) {
+				c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的 JSON 請求"})
+				return req, false
+			}
+		}
+	}
+	return req, true
+}
+
+// writeSubmitError 將建立或重新產生報告時的錯誤轉換為 HTTP 回應。
+func (h *analysisHandler) writeSubmitError(c *gin.Context, report AnalysisReport, err error) {
+	switch {
+	case errors.Is(err, ErrEventIDRequired):
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的事件編號"})
+	case errors.Is(err, ErrInvalidCallbackURL):
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的回呼網址 (最多 5 個 http/https 網址)"})
+	case errors.Is(err, ErrInvalidRelatedEvent):
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的關聯事件 (最多 100 筆且需包含 event_id)"})
+	case errors.Is(err, ErrReportAlreadyExists):
+		c.JSON(http.StatusConflict, conflictResponse{Error: "分析報告已存在", ReportID: report.ReportID, Status: report.Status})
+	case errors.Is(err, ErrReportInProgress):
+		c.JSON(http.StatusConflict, conflictResponse{Error: "分析報告仍在處理中", ReportID: report.ReportID, Status: report.Status})
+	case errors.Is(err, ErrQueueFull):
+		c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(h.service.QueueRetryAfter())))
+		c.JSON(http.StatusTooManyRequests, errorResponse{Error: "分析佇列已滿，請稍後再試"})
+	case errors.Is(err, ErrBudgetExceeded):
+		var exceeded *BudgetExceededError
+		if errors.As(err, &exceeded) {
+			c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(time.Until(exceeded.ResetAt))))
+		}
+		c.JSON(http.StatusTooManyRequests, errorResponse{Error: "團隊的 AI 分析預算已用盡，請待預算重置或聯絡管理者"})
+	case errors.Is(err, ErrServiceShuttingDown):
+		c.JSON(http.StatusServiceUnavailable, errorResponse{Error: "服務正在關閉，暫不接受新的分析請求"})
+	case errors.Is(err, ErrNoTemplates):
+		c.JSON(http.StatusServiceUnavailable, errorResponse{Error: "暫無可用的分析模板"})
+	default:
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "建立分析報告時發生錯誤"})
+	}
 }
 
 func (h *analysisHandler) getAnalysisReport(c *gin.Context) {
@@ -94,9 +271,313 @@ func (h *analysisHandler) getAnalysisReport(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的報告編號"})
 			return
 		}
+		if errors.Is(err, ErrReportForbidden) {
+			c.JSON(http.StatusForbidden, errorResponse{Error: reportForbiddenMessage})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, errorResponse{Error: "查詢分析報告時發生錯誤"})
 		return
 	}
 
 	c.JSON(http.StatusOK, report)
 }
+
+func (h *analysisHandler) listAIInsights(c *gin.Context) {
+	query, ok := bindReportQuery(c)
+	if !ok {
+		return
+	}
+
+	page, err := h.service.ListReports(c.Request.Context(), query)
+	if err != nil {
+		if errors.Is(err, ErrInvalidReportQuery) {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的查詢參數"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "查詢分析報告時發生錯誤"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// bindReportQuery 解析列表查詢參數，格式錯誤時直接回傳 400。
+// status 可用逗號分隔多個狀態；created_from 與 created_to 採 RFC 3339 格式。
+func bindReportQuery(c *gin.Context) (ReportQuery, bool) {
+	query := ReportQuery{
+		EventID:   c.Query("event_id"),
+		Severity:  c.Query("severity"),
+		SortBy:    c.Query("sort_by"),
+		SortOrder: c.Query("sort_order"),
+	}
+
+	for _, value := range strings.Split(c.Query("status"), ",") {
+		status := ReportStatus(strings.ToUpper(strings.TrimSpace(value)))
+		if status == "" {
+			continue
+		}
+		switch status {
+		case ReportStatusPending, ReportStatusRunning, ReportStatusSuccess, ReportStatusFailed, ReportStatusCancelled:
+			query.Statuses = append(query.Statuses, status)
+		default:
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的報告狀態"})
+			return query, false
+		}
+	}
+
+	var err error
+	if query.Page, err = parseOptionalInt(c.Query("page")); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的分頁參數"})
+		return query, false
+	}
+	if query.PageSize, err = parseOptionalInt(c.Query("page_size")); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的分頁參數"})
+		return query, false
+	}
+	if query.CreatedFrom, err = parseOptionalTime(c.Query("created_from")); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的時間範圍"})
+		return query, false
+	}
+	if query.CreatedTo, err = parseOptionalTime(c.Query("created_to")); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的時間範圍"})
+		return query, false
+	}
+	return query, true
+}
+
+func parseOptionalTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// getAIUsage 依日、團隊與模型彙總 LLM 用量與成本。
+// from 與 to 可為 RFC 3339 或 YYYY-MM-DD (UTC)；日期格式的 to 包含當日。
+func (h *analysisHandler) getAIUsage(c *gin.Context) {
+	from, err := parseUsageTime(c.Query("from"), false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的時間範圍"})
+		return
+	}
+	to, err := parseUsageTime(c.Query("to"), true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的時間範圍"})
+		return
+	}
+
+	query := UsageQuery{From: from, To: to, Team: strings.TrimSpace(c.Query("team"))}
+	// 超級管理員以外的管理者僅能查看所屬團隊的用量。
+	if teams, scoped := principalTeams(c.Request.Context()); scoped {
+		if query.Team != "" && !slices.Contains(teams, query.Team) {
+			c.JSON(http.StatusForbidden, errorResponse{Error: "僅可查看所屬團隊的 AI 用量"})
+			return
+		}
+		query.Teams = teams
+	}
+
+	summary, err := h.service.SummarizeUsage(query)
+	if err != nil {
+		if errors.Is(err, ErrInvalidUsageQuery) {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的時間範圍 (最長 366 天)"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "彙總 AI 用量時發生錯誤"})
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+func parseUsageTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if day, err := time.Parse(time.DateOnly, value); err == nil {
+		if endOfDay {
+			day = day.AddDate(0, 0, 1)
+		}
+		return day, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// recordChange 記錄 CI/CD 回報的變更；重送相同 change_id 時回傳既有變更與 200。
+func (h *analysisHandler) recordChange(c *gin.Context) {
+	var req CreateChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的變更內容"})
+		return
+	}
+
+	change, err := h.service.RecordChange(c.Request.Context(), req)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, change)
+	case errors.Is(err, ErrChangeAlreadyExists):
+		c.JSON(http.StatusOK, change)
+	case errors.Is(err, ErrInvalidChange):
+		c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "記錄變更時發生錯誤"})
+	}
+}
+
+// listChanges 依服務或資源與時間範圍查詢變更；service 與 resource 可用逗號分隔多個值。
+func (h *analysisHandler) listChanges(c *gin.Context) {
+	var query ChangeQuery
+	for _, param := range []string{"service", "resource"} {
+		for _, value := range strings.Split(c.Query(param), ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				query.Targets = append(query.Targets, value)
+			}
+		}
+	}
+	from, err := parseOptionalTime(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的時間範圍"})
+		return
+	}
+	to, err := parseOptionalTime(c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的時間範圍"})
+		return
+	}
+	if from != nil {
+		query.From = *from
+	}
+	if to != nil {
+		query.To = *to
+	}
+	if query.Limit, err = parseOptionalInt(c.Query("limit")); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的筆數上限"})
+		return
+	}
+
+	changes, err := h.service.ListChanges(query)
+	if err != nil {
+		if errors.Is(err, ErrInvalidChangeQuery) {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "查詢變更時發生錯誤"})
+		return
+	}
+	if changes == nil {
+		changes = []ChangeEvent{}
+	}
+	c.JSON(http.StatusOK, gin.H{"changes": changes})
+}
+
+// streamHeartbeatInterval 為 SSE 連線保持活躍的註解訊息間隔。
+const streamHeartbeatInterval = 15 * time.Second
+
+// streamAnalysisReport 以 Server-Sent Events 推送報告狀態變化與部分內容，報告結束後關閉連線。
+// 部分內容為暫定預覽，最後的 SUCCESS status 事件附帶合併證據與影響範圍後的完整報告。
+func (h *analysisHandler) streamAnalysisReport(c *gin.Context) {
+	report, events, unsubscribe, err := h.service.SubscribeReport(c.Request.Context(), c.Param("reportId"))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrReportIDRequired):
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的報告編號"})
+		case errors.Is(err, ErrReportNotFound):
+			c.JSON(http.StatusNotFound, errorResponse{Error: "找不到分析報告"})
+		case errors.Is(err, ErrReportForbidden):
+			c.JSON(http.StatusForbidden, errorResponse{Error: reportForbiddenMessage})
+		default:
+			c.JSON(http.StatusInternalServerError, errorResponse{Error: "查詢分析報告時發生錯誤"})
+		}
+		return
+	}
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.SSEvent(ReportEventStatus, ReportStreamEvent{Type: ReportEventStatus, ReportID: report.ReportID, Status: report.Status, Report: &report})
+	c.Writer.Flush()
+	if isTerminalStatus(report.Status) {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// 訂閱已中斷，補送最新快照後結束，客戶端可重新連線。
+				if latest, err := h.service.GetReport(c.Request.Context(), report.ReportID); err == nil {
+					c.SSEvent(ReportEventStatus, ReportStreamEvent{Type: ReportEventStatus, ReportID: latest.ReportID, Status: latest.Status, Report: &latest})
+				}
+				return false
+			}
+			c.SSEvent(event.Type, event)
+			return !(event.Type == ReportEventStatus && isTerminalStatus(event.Status))
+		case <-heartbeat.C:
+			_, _ = io.WriteString(w, ": keep-alive\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+type cancelAnalysisRequest struct {
+	CancelledBy string `json:"cancelled_by"`
+}
+
+func (h *analysisHandler) cancelAnalysisReport(c *gin.Context) {
+	var req cancelAnalysisRequest
+	if c.Request.Body != nil {
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的 JSON 請求"})
+			return
+		}
+	}
+
+	report, err := h.service.CancelReport(c.Request.Context(), c.Param("reportId"), req.CancelledBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrReportIDRequired):
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "請提供有效的報告編號"})
+		case errors.Is(err, ErrReportNotFound):
+			c.JSON(http.StatusNotFound, errorResponse{Error: "找不到分析報告"})
+		case errors.Is(err, ErrReportForbidden):
+			c.JSON(http.StatusForbidden, errorResponse{Error: "僅報告建立者、同團隊成員或超級管理員可取消分析"})
+		case errors.Is(err, ErrReportNotCancellable):
+			c.JSON(http.StatusConflict, conflictResponse{Error: "分析報告已結束，無法取消", ReportID: report.ReportID, Status: report.Status})
+		default:
+			c.JSON(http.StatusInternalServerError, errorResponse{Error: "取消分析報告時發生錯誤"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *analysisHandler) getQueueStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.QueueStats())
+}
+
+// getReadiness 回傳各元件的就緒狀態，任一元件 down 時回傳 503 讓 Kubernetes 停止導入流量。
+func (h *analysisHandler) getReadiness(c *gin.Context) {
+	status := h.service.CheckReadiness(c.Request.Context())
+	code := http.StatusOK
+	if !status.Ready() {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, status)
+}
+
+// retryAfterSeconds 將等待時間轉換為 Retry-After 標頭使用的秒數 (至少 1 秒)。
+func retryAfterSeconds(d time.Duration) int {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
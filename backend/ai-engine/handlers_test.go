@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -174,3 +175,69 @@ func TestCreateReportInvalidJSON(t *testing.T) {
 		t.Fatalf("預期回傳 400，實際為 %d", resp.Code)
 	}
 }
+
+func TestRegenerateEventAnalysisEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := NewInMemoryReportRepository()
+	generator := &stubGenerator{result: &GeneratedReport{EventSummary: "測試事件"}}
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{ProcessingTimeout: time.Second})
+	router := SetupRouter(service)
+
+	getLatest := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/events/evt-regen/analysis", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := getLatest(); resp.Code != http.StatusNotFound {
+		t.Fatalf("尚無報告時應回傳 404，實際為 %d", resp.Code)
+	}
+
+	createReq := httptest.NewRequest(http.MethodPost, "/api/v1/events/evt-regen/ai-analysis", strings.NewReader("{}"))
+	createReq.Header.Set("Content-Type", "application/json")
+	createResp := httptest.NewRecorder()
+	router.ServeHTTP(createResp, createReq)
+	if createResp.Code != http.StatusAccepted {
+		t.Fatalf("建立報告應回傳 202，實際為 %d", createResp.Code)
+	}
+	var first createAnalysisResponse
+	if err := json.Unmarshal(createResp.Body.Bytes(), &first); err != nil {
+		t.Fatalf("解析建立回應失敗: %v", err)
+	}
+	service.Wait()
+
+	regenReq := httptest.NewRequest(http.MethodPost, "/api/v1/events/evt-regen/analysis", nil)
+	regenResp := httptest.NewRecorder()
+	router.ServeHTTP(regenResp, regenReq)
+	if regenResp.Code != http.StatusAccepted {
+		t.Fatalf("重新產生應回傳 202，實際為 %d", regenResp.Code)
+	}
+	var regenerated AnalysisReport
+	if err := json.Unmarshal(regenResp.Body.Bytes(), &regenerated); err != nil {
+		t.Fatalf("解析重新產生回應失敗: %v", err)
+	}
+	if regenerated.Version != 2 || regenerated.ReportID == first.ReportID {
+		t.Fatalf("重新產生應建立第 2 版的新報告，實際為 %+v", regenerated)
+	}
+
+	service.Wait()
+
+	latestResp := getLatest()
+	if latestResp.Code != http.StatusOK {
+		t.Fatalf("預期回傳 200，實際為 %d", latestResp.Code)
+	}
+	var latest AnalysisReport
+	if err := json.Unmarshal(latestResp.Body.Bytes(), &latest); err != nil {
+		t.Fatalf("解析最新報告失敗: %v", err)
+	}
+	if latest.Version < 2 || latest.Status != ReportStatusSuccess {
+		t.Fatalf("最新報告應為已完成的新版本，實際為 %+v", latest)
+	}
+
+	original, err := service.GetReport(context.Background(), first.ReportID)
+	if err != nil || original.Version != 1 {
+		t.Fatalf("舊版本應保留，實際為 %+v (%v)", original, err)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// 受影響資源在影響範圍中的角色。
+const (
+	AffectedRoleOrigin     = "origin"
+	AffectedRoleUpstream   = "upstream_dependency"
+	AffectedRoleDownstream = "downstream_consumer"
+)
+
+// defaultBlastRadiusDepth 為自事件資源向上下游展開的最大層數。
+const defaultBlastRadiusDepth = 3
+
+// maxImpactNamesListed 為影響說明中列出的節點名稱上限。
+const maxImpactNamesListed = 5
+
+// BlastRadius 為依拓撲計算的事件影響範圍。
+type BlastRadius struct {
+	Origin AffectedResource `json:"origin"`
+	// Upstream 為事件資源直接或間接依賴的節點，可能是根因所在。
+	Upstream []AffectedResource `json:"upstream_dependencies,omitempty"`
+	// Downstream 為直接或間接呼叫事件資源的節點，會受到事件波及。
+	Downstream []AffectedResource `json:"downstream_consumers,omitempty"`
+	// EntryPoints 為受影響呼叫鏈最外層 (沒有其他呼叫端) 的節點名稱，通常直接面對使用者。
+	EntryPoints []string `json:"entry_points,omitempty"`
+}
+
+// BlastRadius 自 origin 沿相依關係向上下游展開至 maxDepth 層，已歸類的節點不重複列出。
+func (g TopologyGraph) BlastRadius(origin TopologyNode, maxDepth int) BlastRadius {
+	if maxDepth <= 0 {
+		maxDepth = defaultBlastRadiusDepth
+	}
+	radius := BlastRadius{Origin: affectedResource(origin, AffectedRoleOrigin, 0)}
+	visited := map[string]bool{origin.ID: true}
+	radius.Upstream = g.walk(origin, maxDepth, AffectedRoleUpstream, g.Dependencies, visited)
+	radius.Downstream = g.walk(origin, maxDepth, AffectedRoleDownstream, g.Consumers, visited)
+
+	// 事件資源本身沒有呼叫端時，即為使用者直接存取的入口。
+	candidates := append([]AffectedResource{radius.Origin}, radius.Downstream...)
+	for _, resource := range candidates {
+		if len(g.Consumers(resource.ID)) == 0 {
+			radius.EntryPoints = append(radius.EntryPoints, resource.Name)
+		}
+	}
+	return radius
+}
+
+// walk 以廣度優先展開 next 回傳的相鄰節點，結果依層數與名稱排序。
+func (g TopologyGraph) walk(origin TopologyNode, maxDepth int, role string, next func(string) []TopologyNode, visited map[string]bool) []AffectedResource {
+	var resources []AffectedResource
+	frontier := []TopologyNode{origin}
+	for depth := 1; depth <= maxDepth && len(frontier) > 0; depth++ {
+		var layer []TopologyNode
+		for _, node := range frontier {
+			for _, neighbour := range next(node.ID) {
+				if visited[neighbour.ID] {
+					continue
+				}
+				visited[neighbour.ID] = true
+				layer = append(layer, neighbour)
+			}
+		}
+		sort.Slice(layer, func(i, j int) bool { return layer[i].Name < layer[j].Name })
+		for _, node := range layer {
+			resources = append(resources, affectedResource(node, role, depth))
+		}
+		frontier = layer
+	}
+	return resources
+}
+
+func affectedResource(node TopologyNode, role string, depth int) AffectedResource {
+	return AffectedResource{
+		ID:     node.ID,
+		Name:   node.Name,
+		Type:   strings.ToUpper(node.Type),
+		Role:   role,
+		Depth:  depth,
+		Status: node.Status,
+	}
+}
+
+// AffectedResources 依事件資源、下游呼叫端、上游依賴的順序列出受影響資源。
+func (b BlastRadius) AffectedResources() []AffectedResource {
+	resources := make([]AffectedResource, 0, 1+len(b.Downstream)+len(b.Upstream))
+	resources = append(resources, b.Origin)
+	resources = append(resources, b.Downstream...)
+	return append(resources, b.Upstream...)
+}
+
+// UserImpact 依受影響的入口與下游節點狀態描述對使用者的影響。
+func (b BlastRadius) UserImpact() string {
+	var text strings.Builder
+	switch {
+	case len(b.Downstream) == 0:
+		fmt.Fprintf(&text, "%s 沒有其他呼叫端，為使用者直接存取的入口，使用者請求直接受影響", b.Origin.Name)
+	case len(b.EntryPoints) == 0:
+		fmt.Fprintf(&text, "%s 異常波及 %d 個下游呼叫端 (%s)", b.Origin.Name, len(b.Downstream), joinNames(resourceNames(b.Downstream)))
+	default:
+		fmt.Fprintf(&text, "%s 異常可能經由 %s 影響使用者請求，共波及 %d 個下游呼叫端", b.Origin.Name, joinNames(b.EntryPoints), len(b.Downstream))
+	}
+
+	var degraded []string
+	for _, resource := range b.Downstream {
+		switch resource.Status {
+		case "warning", "critical", "offline":
+			degraded = append(degraded, resource.Name)
+		}
+	}
+	if len(degraded) > 0 {
+		fmt.Fprintf(&text, "，其中 %s 已呈現異常", joinNames(degraded))
+	}
+	text.WriteString("。")
+	return text.String()
+}
+
+func resourceNames(resources []AffectedResource) []string {
+	names := make([]string, len(resources))
+	for i, resource := range resources {
+		names[i] = resource.Name
+	}
+	return names
+}
+
+// joinNames 以頓號串接名稱，超過上限時以「等 N 個」結尾。
+func joinNames(names []string) string {
+	if len(names) <= maxImpactNamesListed {
+		return strings.Join(names, "、")
+	}
+	return fmt.Sprintf("%s 等 %d 個", strings.Join(names[:maxImpactNamesListed], "、"), len(names))
+}
+
+// assessImpact 依拓撲計算事件影響範圍；未設定拓撲、取得失敗或找不到事件資源時回傳 nil，沿用產生器的判斷。
+func (s *AnalysisService) assessImpact(ctx context.Context, logger *slog.Logger, input GenerationInput) *BlastRadius {
+	if s.topology == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.enrichmentTimeout)
+	defer cancel()
+	ctx, span := startSpan(ctx, "AnalysisService.assessImpact")
+
+	graph, err := s.topology.Topology(ctx)
+	if err != nil {
+		logger.WarnContext(ctx, "取得拓撲失敗，影響範圍沿用產生器判斷", slog.String(logKeyError, err.Error()))
+		endSpan(span, err)
+		return nil
+	}
+	origin, ok := graph.Locate(parseEventFacts(input.EventID, input.EventContext, time.Now()))
+	if !ok {
+		logger.DebugContext(ctx, "拓撲中找不到事件資源，影響範圍沿用產生器判斷")
+		endSpan(span, nil)
+		return nil
+	}
+	radius := graph.BlastRadius(origin, s.blastRadiusDepth)
+	span.SetAttributes(
+		attribute.String("ai_engine.topology_origin", origin.ID),
+		attribute.Int("ai_engine.upstream_count", len(radius.Upstream)),
+		attribute.Int("ai_engine.downstream_count", len(radius.Downstream)),
+	)
+	endSpan(span, nil)
+	return &radius
+}
+
+// applyImpact 以拓撲計算的受影響資源與使用者影響取代產生器的推測。
+func applyImpact(report *GeneratedReport, radius *BlastRadius) {
+	if report == nil || radius == nil {
+		return
+	}
+	report.ImpactAssessment.AffectedResources = radius.AffectedResources()
+	report.ImpactAssessment.UserImpact = radius.UserImpact()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// impactTestTopology: gateway → web → checkout → {payments → ledger, inventory}；admin → checkout。
+var impactTestTopology = TopologyGraph{
+	Nodes: []TopologyNode{
+		{ID: "gw", Name: "gateway", Type: "gateway", Status: "critical"},
+		{ID: "web", Name: "web", Type: "service", Status: "warning"},
+		{ID: "admin", Name: "admin", Type: "service", Status: "healthy"},
+		{ID: "checkout", Name: "checkout", Type: "service", Status: "critical"},
+		{ID: "payments", Name: "payments", Type: "service", Status: "healthy"},
+		{ID: "inventory", Name: "inventory", Type: "database", Status: "healthy"},
+		{ID: "ledger", Name: "ledger", Type: "database", Status: "healthy"},
+	},
+	Edges: []TopologyEdge{
+		{Source: "gw", Target: "web"},
+		{Source: "web", Target: "checkout"},
+		{Source: "admin", Target: "checkout"},
+		{Source: "checkout", Target: "payments"},
+		{Source: "checkout", Target: "inventory"},
+		{Source: "payments", Target: "ledger"},
+		// 回呼造成的環狀關係不應重複列出節點。
+		{Source: "payments", Target: "checkout"},
+	},
+}
+
+func TestBlastRadius(t *testing.T) {
+	origin, _ := impactTestTopology.Node("checkout")
+	radius := impactTestTopology.BlastRadius(origin, 3)
+
+	describe := func(resources []AffectedResource) string {
+		parts := make([]string, len(resources))
+		for i, resource := range resources {
+			parts[i] = resource.Name + "/" + resource.Role + "/" + strconv.Itoa(resource.Depth)
+		}
+		return strings.Join(parts, ",")
+	}
+	if got := describe(radius.Upstream); got != "inventory/upstream_dependency/1,payments/upstream_dependency/1,ledger/upstream_dependency/2" {
+		t.Fatalf("上游依賴錯誤: %s", got)
+	}
+	if got := describe(radius.Downstream); got != "admin/downstream_consumer/1,web/downstream_consumer/1,gateway/downstream_consumer/2" {
+		t.Fatalf("下游呼叫端錯誤: %s", got)
+	}
+	if strings.Join(radius.EntryPoints, ",") != "admin,gateway" {
+		t.Fatalf("入口錯誤: %v", radius.EntryPoints)
+	}
+
+	affected := radius.AffectedResources()
+	if len(affected) != 7 || affected[0].Role != AffectedRoleOrigin || affected[0].Type != "SERVICE" || affected[0].Status != "critical" {
+		t.Fatalf("受影響資源錯誤: %+v", affected)
+	}
+	want := "checkout 異常可能經由 admin、gateway 影響使用者請求，共波及 3 個下游呼叫端，其中 web、gateway 已呈現異常。"
+	if got := radius.UserImpact(); got != want {
+		t.Fatalf("使用者影響錯誤: %s", got)
+	}
+
+	shallow := impactTestTopology.BlastRadius(origin, 1)
+	if len(shallow.Downstream) != 2 || len(shallow.Upstream) != 2 || strings.Join(shallow.EntryPoints, ",") != "admin" {
+		t.Fatalf("深度限制錯誤: %+v", shallow)
+	}
+
+	entry, _ := impactTestTopology.Node("gw")
+	if got := impactTestTopology.BlastRadius(entry, 3).UserImpact(); got != "gateway 沒有其他呼叫端，為使用者直接存取的入口，使用者請求直接受影響。" {
+		t.Fatalf("入口事件的使用者影響錯誤: %s", got)
+	}
+}
+
+func TestHTTPTopologyProviderCachesAndServesStale(t *testing.T) {
+	var calls atomic.Int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path != "/api/v1/topology" || r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if failing.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(impactTestTopology)
+	}))
+	defer server.Close()
+
+	provider, err := NewHTTPTopologyProvider(HTTPTopologyProviderConfig{BaseURL: server.URL + "/api/v1/", BearerToken: "token", CacheTTL: time.Hour})
+	if err != nil {
+		t.Fatalf("建立拓撲提供者失敗: %v", err)
+	}
+	for range 2 {
+		graph, err := provider.Topology(context.Background())
+		if err != nil || len(graph.Nodes) != len(impactTestTopology.Nodes) {
+			t.Fatalf("取得拓撲失敗: %+v (%v)", graph, err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("快取期間不應重新呼叫 API，實際呼叫 %d 次", calls.Load())
+	}
+
+	// 快取過期後 API 失敗時沿用先前的拓撲，且於重試間隔內不再呼叫 API。
+	provider.loadedAt = time.Now().Add(-2 * time.Hour)
+	provider.lastAttempt = provider.loadedAt
+	failing.Store(true)
+	for range 2 {
+		if graph, err := provider.Topology(context.Background()); err != nil || len(graph.Edges) != len(impactTestTopology.Edges) {
+			t.Fatalf("API 失敗時應沿用先前的拓撲: %v", err)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("重新載入失敗後應等待重試間隔，實際呼叫 %d 次", calls.Load())
+	}
+
+	cold, _ := NewHTTPTopologyProvider(HTTPTopologyProviderConfig{BaseURL: server.URL + "/api/v1"})
+	if _, err := cold.Topology(context.Background()); err == nil {
+		t.Fatal("尚未載入過拓撲時應回傳錯誤")
+	}
+}
+
+func TestHTTPTopologyProviderRefreshesOutsideLock(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) > 1 {
+			<-release
+		}
+		_ = json.NewEncoder(w).Encode(impactTestTopology)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	provider, _ := NewHTTPTopologyProvider(HTTPTopologyProviderConfig{BaseURL: server.URL, CacheTTL: time.Hour})
+	if _, err := provider.Topology(context.Background()); err != nil {
+		t.Fatalf("取得拓撲失敗: %v", err)
+	}
+	provider.loadedAt = time.Now().Add(-2 * time.Hour)
+	provider.lastAttempt = provider.loadedAt
+
+	// 第一個請求負責重新載入並卡在 API，其餘請求應立即取得先前的拓撲。
+	go func() { _, _ = provider.Topology(context.Background()) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for calls.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := provider.Topology(context.Background())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("重新載入期間應沿用先前的拓撲: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("重新載入期間其他請求不應被阻塞")
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("同時只應有一個重新載入，實際呼叫 %d 次", calls.Load())
+	}
+}
+
+func TestHTTPTopologyProviderResponseLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"nodes":[{"id":"`))
+		_, _ = w.Write(bytes.Repeat([]byte("x"), maxTopologyResponseBytes))
+		_, _ = w.Write([]byte(`"}]}`))
+	}))
+	defer server.Close()
+
+	provider, _ := NewHTTPTopologyProvider(HTTPTopologyProviderConfig{BaseURL: server.URL})
+	if _, err := provider.Topology(context.Background()); err == nil || !strings.Contains(err.Error(), "上限") {
+		t.Fatalf("回應超過上限時應回傳錯誤，實際為 %v", err)
+	}
+}
+
+func TestLoadTopologyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topology.json")
+	raw, _ := json.Marshal(impactTestTopology)
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatalf("寫入快照失敗: %v", err)
+	}
+	graph, err := LoadTopologyFile(path)
+	if err != nil || len(graph.Nodes) != len(impactTestTopology.Nodes) {
+		t.Fatalf("讀取快照失敗: %+v (%v)", graph, err)
+	}
+	if _, err := LoadTopologyFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("檔案不存在時應回傳錯誤")
+	}
+}
+
+func TestAnalysisUsesTopologyImpact(t *testing.T) {
+	generator := &recordingGenerator{result: &GeneratedReport{
+		EventSummary: "結帳失敗",
+		ImpactAssessment: ImpactAssessment{
+			Text:              "結帳服務錯誤率上升",
+			AffectedResources: []AffectedResource{{ID: "svc-guess", Name: "guess", Role: "primary"}},
+			UserImpact:        "部分使用者受影響",
+			Severity:          "HIGH",
+		},
+	}}
+	repo := NewInMemoryReportRepository()
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		Topology: StaticTopologyProvider{Graph: impactTestTopology},
+	})
+
+	located, err := service.CreateReport(context.Background(), "evt-impact", CreateAnalysisRequest{EventContext: map[string]any{"resource_name": "Checkout"}})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	unknown, err := service.CreateReport(context.Background(), "evt-unknown", CreateAnalysisRequest{EventContext: map[string]any{"resource_id": "vm-404"}})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	service.Wait()
+
+	prompt, err := buildAnalysisPrompt(generator.inputs[0])
+	if generator.inputs[0].EventID != "evt-impact" {
+		prompt, err = buildAnalysisPrompt(generator.inputs[1])
+	}
+	if err != nil || !strings.Contains(prompt, "依服務拓撲計算的影響範圍") || !strings.Contains(prompt, `"downstream_consumers"`) {
+		t.Fatalf("提示詞應包含影響範圍: %s (%v)", prompt, err)
+	}
+
+	stored, _ := repo.Get(located.ReportID)
+	impact := stored.ImpactAssessment
+	if impact == nil || len(impact.AffectedResources) != 7 || impact.AffectedResources[0].ID != "checkout" || impact.AffectedResources[0].Role != AffectedRoleOrigin {
+		t.Fatalf("受影響資源應來自拓撲: %+v", impact)
+	}
+	if !strings.HasPrefix(impact.UserImpact, "checkout 異常可能經由") || impact.Text != "結帳服務錯誤率上升" || impact.Severity != "HIGH" {
+		t.Fatalf("影響評估內容錯誤: %+v", impact)
+	}
+
+	// 拓撲中找不到事件資源時沿用產生器的判斷。
+	fallback, _ := repo.Get(unknown.ReportID)
+	if fallback.ImpactAssessment == nil || len(fallback.ImpactAssessment.AffectedResources) != 1 || fallback.ImpactAssessment.UserImpact != "部分使用者受影響" {
+		t.Fatalf("找不到事件資源時應沿用產生器結果: %+v", fallback.ImpactAssessment)
+	}
+}
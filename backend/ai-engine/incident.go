@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// relatedEventsContextKey 為請求提供的關聯事件保存在事件上下文中的鍵，復原與重新分析時沿用。
+	relatedEventsContextKey  = "related_events"
+	maxRelatedEvents         = 100
+	defaultCorrelationWindow = 30 * time.Minute
+	defaultEventsTimeout     = 10 * time.Second
+	// maxRelatedEventsResponseBytes 為關聯事件 API 回應的讀取上限；分析最多採用 maxRelatedEvents 筆，
+	// 4 MiB 足以容納含摘要的完整清單，同時避免異常回應耗盡記憶體。
+	maxRelatedEventsResponseBytes = 4 << 20
+)
+
+// EvidenceTypeEvent 為同一事故中其他關聯事件的證據類型。
+const EvidenceTypeEvent = "EVENT"
+
+// TopologyRoleSameResource 代表關聯事件與主事件發生於同一資源。
+const TopologyRoleSameResource = "same_resource"
+
+var (
+	// ErrInvalidRelatedEvent 代表請求提供的關聯事件缺少編號或數量超過上限。
+	ErrInvalidRelatedEvent = errors.New("invalid related event")
+	// ErrEventsURLRequired 代表未設定主後端事件 API 位址。
+	ErrEventsURLRequired = errors.New("events api url is required")
+)
+
+// RelatedEvent 對應 API 合約的 EventRelatedItem，另可附帶資源資訊供拓撲比對。
+type RelatedEvent struct {
+	EventID      string     `json:"event_id"`
+	Relationship string     `json:"relationship,omitempty"`
+	Summary      string     `json:"summary,omitempty"`
+	Severity     string     `json:"severity,omitempty"`
+	Status       string     `json:"status,omitempty"`
+	TriggeredAt  *time.Time `json:"triggered_at,omitempty"`
+	ResourceID   string     `json:"resource_id,omitempty"`
+	ResourceName string     `json:"resource_name,omitempty"`
+}
+
+// validateRelatedEvents 檢查請求提供的關聯事件。
+func validateRelatedEvents(events []RelatedEvent) error {
+	if len(events) > maxRelatedEvents {
+		return fmt.Errorf("%w: 最多 %d 筆", ErrInvalidRelatedEvent, maxRelatedEvents)
+	}
+	for _, event := range events {
+		if strings.TrimSpace(event.EventID) == "" {
+			return fmt.Errorf("%w: 缺少 event_id", ErrInvalidRelatedEvent)
+		}
+	}
+	return nil
+}
+
+// withRelatedEvents 回傳附帶關聯事件的事件上下文副本，未提供關聯事件時原樣回傳。
+func withRelatedEvents(eventContext map[string]any, events []RelatedEvent) map[string]any {
+	if len(events) == 0 {
+		return eventContext
+	}
+	merged := maps.Clone(eventContext)
+	if merged == nil {
+		merged = make(map[string]any, 1)
+	}
+	merged[relatedEventsContextKey] = events
+	return merged
+}
+
+// relatedEventsFromContext 讀取事件上下文中的關聯事件；自資料庫載入時為 JSON 解碼後的結構，格式錯誤時略過。
+func relatedEventsFromContext(eventContext map[string]any) []RelatedEvent {
+	switch value := eventContext[relatedEventsContextKey].(type) {
+	case nil:
+		return nil
+	case []RelatedEvent:
+		return value
+	default:
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil
+		}
+		var events []RelatedEvent
+		if json.Unmarshal(raw, &events) != nil {
+			return nil
+		}
+		return events
+	}
+}
+
+// RelatedEventsProvider 查詢與事件相關的其他事件。
+type RelatedEventsProvider interface {
+	RelatedEvents(ctx context.Context, eventID string) ([]RelatedEvent, error)
+}
+
+// HTTPRelatedEventsProviderConfig 設定主後端的 /events/{event_id}/related API。
+type HTTPRelatedEventsProviderConfig struct {
+	// BaseURL 為主後端 API 根路徑，例如 http://backend:8080/api/v1。
+	BaseURL     string
+	BearerToken string
+	// Timeout 僅在未提供 HTTPClient 時套用。
+	Timeout    time.Duration
+	HTTPClient *http.Client
+}
+
+// HTTPRelatedEventsProvider 自主後端查詢關聯事件。
+type HTTPRelatedEventsProvider struct {
+	baseURL     string
+	bearerToken string
+	client      *http.Client
+}
+
+// NewHTTPRelatedEventsProvider 建立關聯事件 API 提供者。
+func NewHTTPRelatedEventsProvider(cfg HTTPRelatedEventsProviderConfig) (*HTTPRelatedEventsProvider, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if baseURL == "" {
+		return nil, ErrEventsURLRequired
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = defaultEventsTimeout
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	return &HTTPRelatedEventsProvider{baseURL: baseURL, bearerToken: cfg.BearerToken, client: client}, nil
+}
+
+// RelatedEvents 實作 RelatedEventsProvider；事件不存在時回傳空結果。
+func (p *HTTPRelatedEventsProvider) RelatedEvents(ctx context.Context, eventID string) ([]RelatedEvent, error) {
+	endpoint := p.baseURL + "/events/" + url.PathEscape(eventID) + "/related"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("無法建立關聯事件請求: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.bearerToken)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("呼叫關聯事件 API 失敗: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("關聯事件 API 回應 %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxRelatedEventsResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("讀取關聯事件 API 回應失敗: %w", err)
+	}
+	if len(raw) > maxRelatedEventsResponseBytes {
+		return nil, fmt.Errorf("關聯事件 API 回應超過 %d 位元組上限", maxRelatedEventsResponseBytes)
+	}
+	var payload struct {
+		Items []RelatedEvent `json:"items"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("關聯事件 API 回應格式錯誤: %w", err)
+	}
+	return payload.Items, nil
+}
+
+// CorrelatedEvent 為歸入同一事故的關聯事件。
+type CorrelatedEvent struct {
+	RelatedEvent
+	// OffsetMinutes 為相對主事件觸發時間的分鐘數，負值代表較主事件早。
+	OffsetMinutes *int `json:"offset_minutes,omitempty"`
+	// TopologyRole 為事件資源在主事件影響範圍中的角色，不在範圍內時為空。
+	TopologyRole string `json:"topology_role,omitempty"`
+}
+
+// CorrelatedIncident 為主事件與時間、拓撲相近的關聯事件組成的事故。
+type CorrelatedIncident struct {
+	PrimaryEventID string    `json:"primary_event_id"`
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	// Events 依觸發時間排序，不含主事件。
+	Events []CorrelatedEvent `json:"events"`
+	// Resources 為事故涉及的資源名稱，主事件資源在前。
+	Resources []string `json:"resources,omitempty"`
+}
+
+// correlateEvents 合併請求提供與主後端查得的關聯事件，保留主事件前後 correlationWindow 內的事件；皆無時回傳 nil。
+func (s *AnalysisService) correlateEvents(ctx context.Context, logger *slog.Logger, input GenerationInput) *CorrelatedIncident {
+	candidates := relatedEventsFromContext(input.EventContext)
+	if s.relatedEvents != nil {
+		fetchCtx, cancel := context.WithTimeout(ctx, s.enrichmentTimeout)
+		fetchCtx, span := startSpan(fetchCtx, "RelatedEventsProvider.RelatedEvents")
+		fetched, err := s.relatedEvents.RelatedEvents(fetchCtx, input.EventID)
+		span.SetAttributes(attribute.Int("ai_engine.related_event_count", len(fetched)))
+		endSpan(span, err)
+		cancel()
+		if err != nil {
+			logger.WarnContext(ctx, "查詢關聯事件失敗，僅使用請求提供的關聯事件", slog.String(logKeyError, err.Error()))
+		}
+		candidates = append(candidates, fetched...)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return buildIncident(parseEventFacts(input.EventID, input.EventContext, time.Now()), candidates, input.Impact, s.correlationWindow)
+}
+
+// buildIncident 依時間與拓撲將候選事件歸為事故；同一事件重複出現時採用先出現者 (請求提供者優先)。
+func buildIncident(primary EventFacts, candidates []RelatedEvent, radius *BlastRadius, window time.Duration) *CorrelatedIncident {
+	roles := make(map[string]string)
+	if radius != nil {
+		for _, resource := range radius.AffectedResources() {
+			role := resource.Role
+			if role == AffectedRoleOrigin {
+				role = TopologyRoleSameResource
+			}
+			roles[resource.ID] = role
+			roles[strings.ToLower(resource.Name)] = role
+		}
+	}
+
+	incident := &CorrelatedIncident{PrimaryEventID: primary.EventID, Start: primary.TriggeredAt, End: primary.TriggeredAt}
+	seen := map[string]bool{primary.EventID: true}
+	for _, candidate := range candidates {
+		if candidate.EventID == "" || seen[candidate.EventID] {
+			continue
+		}
+		seen[candidate.EventID] = true
+		event := CorrelatedEvent{RelatedEvent: candidate}
+		event.Severity = strings.ToLower(event.Severity)
+		if candidate.TriggeredAt != nil {
+			offset := candidate.TriggeredAt.Sub(primary.TriggeredAt)
+			if offset.Abs() > window {
+				continue
+			}
+			minutes := int(math.Round(offset.Minutes()))
+			event.OffsetMinutes = &minutes
+			if candidate.TriggeredAt.Before(incident.Start) {
+				incident.Start = *candidate.TriggeredAt
+			}
+			if candidate.TriggeredAt.After(incident.End) {
+				incident.End = *candidate.TriggeredAt
+			}
+		}
+		if role, ok := roles[candidate.ResourceID]; ok && candidate.ResourceID != "" {
+			event.TopologyRole = role
+		} else if role, ok := roles[strings.ToLower(candidate.ResourceName)]; ok && candidate.ResourceName != "" {
+			event.TopologyRole = role
+		}
+		incident.Events = append(incident.Events, event)
+	}
+	if len(incident.Events) == 0 {
+		return nil
+	}
+
+	// 觸發時間未知的事件排在最後。
+	sort.SliceStable(incident.Events, func(i, j int) bool {
+		a, b := incident.Events[i].TriggeredAt, incident.Events[j].TriggeredAt
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return a.Before(*b)
+	})
+
+	addResource := func(name string) {
+		if name != "" && !containsFold(incident.Resources, name) {
+			incident.Resources = append(incident.Resources, name)
+		}
+	}
+	addResource(primary.ResourceName)
+	for _, event := range incident.Events {
+		addResource(event.ResourceName)
+	}
+	return incident
+}
+
+func containsFold(values []string, target string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Evidence 將關聯事件轉為 EVENT 證據。
+func (i *CorrelatedIncident) Evidence() []EvidenceItem {
+	if i == nil {
+		return nil
+	}
+	evidence := make([]EvidenceItem, len(i.Events))
+	for index, event := range i.Events {
+		var text strings.Builder
+		fmt.Fprintf(&text, "關聯事件 %s", event.EventID)
+		if event.Severity != "" {
+			fmt.Fprintf(&text, " (%s)", event.Severity)
+		}
+		if event.ResourceName != "" {
+			fmt.Fprintf(&text, " 發生於 %s", event.ResourceName)
+		}
+		if event.OffsetMinutes != nil {
+			switch offset := *event.OffsetMinutes; {
+			case offset < 0:
+				fmt.Fprintf(&text, "，早於主事件 %d 分鐘", -offset)
+			case offset > 0:
+				fmt.Fprintf(&text, "，晚於主事件 %d 分鐘", offset)
+			default:
+				text.WriteString("，與主事件同時觸發")
+			}
+		}
+		if event.Summary != "" {
+			text.WriteString("：" + truncateRunes(event.Summary, 200))
+		}
+
+		metadata := map[string]any{"event_id": event.EventID}
+		for key, value := range map[string]string{
+			"relationship":  event.Relationship,
+			"severity":      event.Severity,
+			"status":        event.Status,
+			"resource_id":   event.ResourceID,
+			"resource_name": event.ResourceName,
+			"topology_role": event.TopologyRole,
+		} {
+			if value != "" {
+				metadata[key] = value
+			}
+		}
+		if event.OffsetMinutes != nil {
+			metadata["offset_minutes"] = *event.OffsetMinutes
+		}
+		evidence[index] = EvidenceItem{
+			Type:        EvidenceTypeEvent,
+			Description: text.String(),
+			Timestamp:   event.TriggeredAt,
+			Metadata:    metadata,
+		}
+	}
+	return evidence
+}
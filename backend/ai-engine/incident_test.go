@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var incidentTestTrigger = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func incidentTestTime(minutes int) *time.Time {
+	at := incidentTestTrigger.Add(time.Duration(minutes) * time.Minute)
+	return &at
+}
+
+func TestBuildIncident(t *testing.T) {
+	origin, _ := impactTestTopology.Node("checkout")
+	radius := impactTestTopology.BlastRadius(origin, 3)
+	primary := EventFacts{EventID: "evt-1", ResourceName: "checkout", TriggeredAt: incidentTestTrigger}
+
+	incident := buildIncident(primary, []RelatedEvent{
+		{EventID: "evt-web", Severity: "WARNING", ResourceName: "web", TriggeredAt: incidentTestTime(4), Summary: "web 5xx 上升"},
+		{EventID: "evt-db", ResourceID: "ledger", ResourceName: "ledger", TriggeredAt: incidentTestTime(-6)},
+		{EventID: "evt-unknown-time", ResourceName: "search"},
+		{EventID: "evt-old", ResourceName: "checkout", TriggeredAt: incidentTestTime(-90)},
+		{EventID: "evt-1", ResourceName: "checkout", TriggeredAt: incidentTestTime(0)},
+		{EventID: "evt-web", Summary: "重複"},
+		{EventID: "evt-same", ResourceName: "Checkout", TriggeredAt: incidentTestTime(0)},
+	}, &radius, 30*time.Minute)
+	if incident == nil {
+		t.Fatal("應歸納出事故")
+	}
+
+	var ids []string
+	for _, event := range incident.Events {
+		ids = append(ids, event.EventID+"/"+event.TopologyRole)
+	}
+	want := "evt-db/upstream_dependency,evt-same/same_resource,evt-web/downstream_consumer,evt-unknown-time/"
+	if strings.Join(ids, ",") != want {
+		t.Fatalf("事故事件錯誤: %s", strings.Join(ids, ","))
+	}
+	if !incident.Start.Equal(*incidentTestTime(-6)) || !incident.End.Equal(*incidentTestTime(4)) {
+		t.Fatalf("事故時間範圍錯誤: %s ~ %s", incident.Start, incident.End)
+	}
+	if strings.Join(incident.Resources, ",") != "checkout,ledger,web,search" {
+		t.Fatalf("事故資源錯誤: %v", incident.Resources)
+	}
+
+	evidence := incident.Evidence()
+	if len(evidence) != 4 || evidence[0].Type != EvidenceTypeEvent || evidence[0].Metadata["offset_minutes"] != -6 {
+		t.Fatalf("關聯事件證據錯誤: %+v", evidence)
+	}
+	if evidence[2].Description != "關聯事件 evt-web (warning) 發生於 web，晚於主事件 4 分鐘：web 5xx 上升" {
+		t.Fatalf("證據描述錯誤: %s", evidence[2].Description)
+	}
+	if evidence[3].Timestamp != nil || evidence[3].Metadata["offset_minutes"] != nil {
+		t.Fatalf("未知時間的事件不應有時間: %+v", evidence[3])
+	}
+
+	if buildIncident(primary, []RelatedEvent{{EventID: "evt-old", TriggeredAt: incidentTestTime(-90)}}, nil, 30*time.Minute) != nil {
+		t.Fatal("沒有事件落在時間範圍內時不應產生事故")
+	}
+}
+
+func TestRelatedEventsFromStoredContext(t *testing.T) {
+	// 自資料庫載入的事件上下文為 JSON 解碼後的結構。
+	raw, _ := json.Marshal(withRelatedEvents(map[string]any{"resource_name": "checkout"}, []RelatedEvent{{EventID: "evt-2", TriggeredAt: incidentTestTime(1)}}))
+	var stored map[string]any
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		t.Fatalf("解碼失敗: %v", err)
+	}
+	events := relatedEventsFromContext(stored)
+	if len(events) != 1 || events[0].EventID != "evt-2" || !events[0].TriggeredAt.Equal(*incidentTestTime(1)) {
+		t.Fatalf("關聯事件解析錯誤: %+v", events)
+	}
+	if relatedEventsFromContext(map[string]any{relatedEventsContextKey: "invalid"}) != nil {
+		t.Fatal("格式錯誤時應略過")
+	}
+}
+
+func TestHTTPRelatedEventsProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.EscapedPath() {
+		case "/api/v1/events/evt%2F1/related":
+			_ = json.NewEncoder(w).Encode(map[string]any{"items": []map[string]any{
+				{"event_id": "evt-2", "relationship": "same_service", "summary": "延遲上升", "severity": "warning", "triggered_at": "2024-05-01T12:03:00Z"},
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewHTTPRelatedEventsProvider(HTTPRelatedEventsProviderConfig{BaseURL: server.URL + "/api/v1", BearerToken: "token"})
+	if err != nil {
+		t.Fatalf("建立提供者失敗: %v", err)
+	}
+	events, err := provider.RelatedEvents(context.Background(), "evt/1")
+	if err != nil || len(events) != 1 || events[0].Relationship != "same_service" || !events[0].TriggeredAt.Equal(*incidentTestTime(3)) {
+		t.Fatalf("關聯事件錯誤: %+v (%v)", events, err)
+	}
+	if events, err := provider.RelatedEvents(context.Background(), "evt-missing"); err != nil || events != nil {
+		t.Fatalf("事件不存在時應回傳空結果: %+v (%v)", events, err)
+	}
+
+	unauthorized, _ := NewHTTPRelatedEventsProvider(HTTPRelatedEventsProviderConfig{BaseURL: server.URL + "/api/v1"})
+	if _, err := unauthorized.RelatedEvents(context.Background(), "evt/1"); err == nil {
+		t.Fatal("API 錯誤時應回傳錯誤")
+	}
+	oversized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"items":[{"event_id":"`))
+		_, _ = w.Write([]byte(strings.Repeat("x", maxRelatedEventsResponseBytes)))
+		_, _ = w.Write([]byte(`"}]}`))
+	}))
+	defer oversized.Close()
+	limited, _ := NewHTTPRelatedEventsProvider(HTTPRelatedEventsProviderConfig{BaseURL: oversized.URL})
+	if _, err := limited.RelatedEvents(context.Background(), "evt-1"); err == nil || !strings.Contains(err.Error(), "上限") {
+		t.Fatalf("回應超過上限時應回傳錯誤，實際為 %v", err)
+	}
+	if _, err := NewHTTPRelatedEventsProvider(HTTPRelatedEventsProviderConfig{}); !errors.Is(err, ErrEventsURLRequired) {
+		t.Fatalf("未設定網址應回傳 ErrEventsURLRequired，實際為 %v", err)
+	}
+}
+
+type stubRelatedEvents struct {
+	events []RelatedEvent
+	err    error
+}
+
+func (s stubRelatedEvents) RelatedEvents(context.Context, string) ([]RelatedEvent, error) {
+	return s.events, s.err
+}
+
+func TestAnalysisIncludesCorrelatedEvents(t *testing.T) {
+	generator := &recordingGenerator{result: &GeneratedReport{
+		EventSummary: "結帳事故",
+		Evidence:     []EvidenceItem{{Type: EvidenceTypeLog, Description: "大量逾時"}},
+	}}
+	repo := NewInMemoryReportRepository()
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		RelatedEvents: stubRelatedEvents{events: []RelatedEvent{
+			{EventID: "evt-web", Summary: "主後端提供的重複事件", TriggeredAt: incidentTestTime(2)},
+			{EventID: "evt-payments", Severity: "critical", ResourceName: "payments", TriggeredAt: incidentTestTime(-3)},
+		}},
+	})
+
+	if _, err := service.CreateReport(context.Background(), "evt-invalid", CreateAnalysisRequest{RelatedEvents: []RelatedEvent{{Summary: "缺少編號"}}}); !errors.Is(err, ErrInvalidRelatedEvent) {
+		t.Fatalf("缺少 event_id 應回傳 ErrInvalidRelatedEvent，實際為 %v", err)
+	}
+
+	report, err := service.CreateReport(context.Background(), "evt-checkout", CreateAnalysisRequest{
+		EventContext:  map[string]any{"resource_name": "checkout", "triggered_at": incidentTestTrigger.Format(time.RFC3339)},
+		RelatedEvents: []RelatedEvent{{EventID: "evt-web", Summary: "web 5xx 上升", ResourceName: "web", TriggeredAt: incidentTestTime(2)}},
+	})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	service.Wait()
+
+	input := generator.inputs[0]
+	if input.Incident == nil || len(input.Incident.Events) != 2 || input.Incident.Events[1].Summary != "web 5xx 上升" {
+		t.Fatalf("產生器應收到事故內容: %+v", input.Incident)
+	}
+	prompt, err := buildAnalysisPrompt(input)
+	if err != nil || !strings.Contains(prompt, "以下 2 個關聯事件與本事件屬於同一事故") || strings.Contains(prompt, `"related_events"`) {
+		t.Fatalf("提示詞應以事故段落列出關聯事件: %s (%v)", prompt, err)
+	}
+
+	stored, _ := repo.Get(report.ReportID)
+	if len(stored.Evidence) != 3 || stored.Evidence[0].Metadata["event_id"] != "evt-payments" ||
+		stored.Evidence[1].Metadata["event_id"] != "evt-web" || stored.Evidence[2].Type != EvidenceTypeLog {
+		t.Fatalf("報告應列出關聯事件證據: %+v", stored.Evidence)
+	}
+	// 請求提供的關聯事件保存於事件上下文，復原時沿用。
+	if events := relatedEventsFromContext(stored.EventContext); len(events) != 1 || events[0].EventID != "evt-web" {
+		t.Fatalf("事件上下文應保存關聯事件: %+v", stored.EventContext)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultLogLookBehind  = 15 * time.Minute
+	defaultLogLookAhead   = 15 * time.Minute
+	defaultLogLimit       = 1000
+	defaultLogMaxPatterns = 5
+	defaultLogMaxSamples  = 3
+	// maxLogSampleLength 為證據中每行範例的最大字元數，避免單行過長佔滿提示詞。
+	maxLogSampleLength = 300
+	logPatternWildcard = "<*>"
+)
+
+// defaultLogServiceLabels 為判斷事件所屬服務時依序採用的事件標籤。
+var defaultLogServiceLabels = []string{"service", "service_name", "app", "job"}
+
+// LogSearch 為日誌查詢條件。
+type LogSearch struct {
+	Service string
+	Start   time.Time
+	End     time.Time
+	Limit   int
+}
+
+// LogLine 為單行錯誤等級的日誌。
+type LogLine struct {
+	Timestamp time.Time
+	Message   string
+}
+
+// LogSource 為可查詢錯誤日誌的後端，例如 Loki 或 Elasticsearch。
+type LogSource interface {
+	// Name 為來源名稱，同時作為豐富化來源名稱。
+	Name() string
+	// Query 回傳查詢語句，記錄於證據中供人工重現。
+	Query(search LogSearch) string
+	Search(ctx context.Context, search LogSearch) ([]LogLine, error)
+	// Link 回傳可檢視查詢結果的網址。
+	Link(search LogSearch) string
+}
+
+// LogEnricherConfig 設定日誌證據的收集方式。
+type LogEnricherConfig struct {
+	Source LogSource
+	// LookBehind 與 LookAhead 為事件觸發前後的查詢區間，預設各 15 分鐘。
+	LookBehind time.Duration
+	LookAhead  time.Duration
+	// Limit 為每次查詢最多取回的日誌行數，預設 1000。
+	Limit int
+	// MaxPatterns 為轉為證據的模式數，依出現次數排序，預設 5。
+	MaxPatterns int
+	// MaxSamples 為每個模式保留的範例行數，預設 3。
+	MaxSamples int
+	// ServiceLabels 為判斷服務時依序採用的事件標籤，皆缺少時使用 resource_name。
+	ServiceLabels []string
+}
+
+// LogEnricher 查詢事件服務於事件期間的錯誤日誌，歸納為模式後轉為 LOG 證據。
+type LogEnricher struct {
+	source        LogSource
+	lookBehind    time.Duration
+	lookAhead     time.Duration
+	limit         int
+	maxPatterns   int
+	maxSamples    int
+	serviceLabels []string
+	// now 可於測試中替換。
+	now func() time.Time
+}
+
+// NewLogEnricher 建立日誌豐富化來源。
+func NewLogEnricher(cfg LogEnricherConfig) *LogEnricher {
+	if cfg.Source == nil {
+		panic("log source is required")
+	}
+	enricher := &LogEnricher{
+		source:        cfg.Source,
+		lookBehind:    cfg.LookBehind,
+		lookAhead:     cfg.LookAhead,
+		limit:         cfg.Limit,
+		maxPatterns:   cfg.MaxPatterns,
+		maxSamples:    cfg.MaxSamples,
+		serviceLabels: cfg.ServiceLabels,
+		now:           time.Now,
+	}
+	if enricher.lookBehind <= 0 {
+		enricher.lookBehind = defaultLogLookBehind
+	}
+	if enricher.lookAhead <= 0 {
+		enricher.lookAhead = defaultLogLookAhead
+	}
+	if enricher.limit <= 0 {
+		enricher.limit = defaultLogLimit
+	}
+	if enricher.maxPatterns <= 0 {
+		enricher.maxPatterns = defaultLogMaxPatterns
+	}
+	if enricher.maxSamples <= 0 {
+		enricher.maxSamples = defaultLogMaxSamples
+	}
+	if len(enricher.serviceLabels) == 0 {
+		enricher.serviceLabels = defaultLogServiceLabels
+	}
+	return enricher
+}
+
+// Name 實作 ContextEnricher。
+func (e *LogEnricher) Name() string {
+	return e.source.Name()
+}
+
+// Enrich 查詢錯誤日誌並回傳出現次數最多的模式；無法判斷事件服務時不查詢。
+func (e *LogEnricher) Enrich(ctx context.Context, event EventFacts) ([]EvidenceItem, error) {
+	service := e.service(event)
+	if service == "" {
+		return nil, nil
+	}
+	start, end := event.Window(e.lookBehind, e.lookAhead, e.now())
+	search := LogSearch{Service: service, Start: start, End: end, Limit: e.limit}
+
+	lines, err := e.source.Search(ctx, search)
+	if err != nil {
+		return nil, err
+	}
+	patterns := clusterLogLines(lines, e.maxSamples)
+	if len(patterns) > e.maxPatterns {
+		patterns = patterns[:e.maxPatterns]
+	}
+
+	query := e.source.Query(search)
+	var link *EvidenceLink
+	if target := e.source.Link(search); target != "" {
+		link = &EvidenceLink{Name: e.source.Name() + " 日誌", URL: target}
+	}
+	evidence := make([]EvidenceItem, len(patterns))
+	for i, pattern := range patterns {
+		firstSeen := pattern.FirstSeen
+		evidence[i] = EvidenceItem{
+			Type:        EvidenceTypeLog,
+			Description: fmt.Sprintf("%s 錯誤日誌出現 %d 次：%s", service, pattern.Count, pattern.Pattern),
+			Link:        link,
+			Timestamp:   &firstSeen,
+			Metadata: map[string]any{
+				"source":      e.source.Name(),
+				"query":       query,
+				"service":     service,
+				"pattern":     pattern.Pattern,
+				"count":       pattern.Count,
+				"samples":     pattern.Samples,
+				"first_seen":  pattern.FirstSeen.Format(time.RFC3339),
+				"last_seen":   pattern.LastSeen.Format(time.RFC3339),
+				"total_lines": len(lines),
+			},
+		}
+	}
+	return evidence, nil
+}
+
+func (e *LogEnricher) service(event EventFacts) string {
+	for _, label := range e.serviceLabels {
+		if value := event.Labels[label]; value != "" {
+			return value
+		}
+	}
+	return event.ResourceName
+}
+
+// LogPattern 為將變動內容遮蔽後相同的一群日誌行。
+type LogPattern struct {
+	Pattern   string
+	Count     int
+	Samples   []string
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// logVariablePatterns 依序遮蔽日誌中的時間、識別碼與數值，讓同類訊息歸為同一模式。
+var logVariablePatterns = []struct {
+	re          *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?`), logPatternWildcard},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), logPatternWildcard},
+	{regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}(?::\d+)?\b`), logPatternWildcard},
+	{regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b|\b[0-9a-f]{12,}\b`), logPatternWildcard},
+	// Kubernetes pod 名稱的 ReplicaSet 雜湊與隨機後綴，例如 checkout-7f9c5d8b6-x2kqz。
+	{regexp.MustCompile(`-[a-z0-9]{8,10}-[a-z0-9]{5}\b`), "-" + logPatternWildcard},
+	{regexp.MustCompile(`\b\d+(?:\.\d+)?(?:ms|us|ns|s|m|h|[KMGT]i?B?|%)?\b`), logPatternWildcard},
+}
+
+var logWhitespace = regexp.MustCompile(`\s+`)
+
+// logPattern 回傳日誌行遮蔽變動內容後的模式。
+func logPattern(message string) string {
+	pattern := message
+	for _, variable := range logVariablePatterns {
+		pattern = variable.re.ReplaceAllString(pattern, variable.replacement)
+	}
+	return strings.TrimSpace(logWhitespace.ReplaceAllString(pattern, " "))
+}
+
+// clusterLogLines 將日誌行依模式分組，依出現次數遞減排序，次數相同時較早出現者在前。
+func clusterLogLines(lines []LogLine, maxSamples int) []LogPattern {
+	byPattern := make(map[string]*LogPattern)
+	var order []*LogPattern
+	for _, line := range lines {
+		message := strings.TrimSpace(line.Message)
+		if message == "" {
+			continue
+		}
+		key := logPattern(message)
+		pattern, ok := byPattern[key]
+		if !ok {
+			pattern = &LogPattern{Pattern: key, FirstSeen: line.Timestamp, LastSeen: line.Timestamp}
+			byPattern[key] = pattern
+			order = append(order, pattern)
+		}
+		pattern.Count++
+		if line.Timestamp.Before(pattern.FirstSeen) {
+			pattern.FirstSeen = line.Timestamp
+		}
+		if line.Timestamp.After(pattern.LastSeen) {
+			pattern.LastSeen = line.Timestamp
+		}
+		if len(pattern.Samples) < maxSamples {
+			pattern.Samples = append(pattern.Samples, truncateRunes(message, maxLogSampleLength))
+		}
+	}
+
+	patterns := make([]LogPattern, len(order))
+	for i, pattern := range order {
+		patterns[i] = *pattern
+	}
+	sort.SliceStable(patterns, func(i, j int) bool {
+		if patterns[i].Count != patterns[j].Count {
+			return patterns[i].Count > patterns[j].Count
+		}
+		return patterns[i].FirstSeen.Before(patterns[j].FirstSeen)
+	})
+	return patterns
+}
+
+func truncateRunes(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	return string([]rune(text)[:limit]) + "…"
+}
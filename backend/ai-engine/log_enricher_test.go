@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var logTestTrigger = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestLogPatternMasksVariables(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{
+			line: "2024-05-01T12:00:01Z ERROR request 3f2b9c1e-8a7d-4e6f-9b0a-1c2d3e4f5a6b failed after 1500ms",
+			want: "<*> ERROR request <*> failed after <*>",
+		},
+		{
+			line: "dial tcp 10.0.3.7:5432: connect: connection refused",
+			want: "dial tcp <*>: connect: connection refused",
+		},
+		{
+			line: "pod checkout-7f9c5d8b6-x2kqz OOMKilled, limit 512Mi",
+			want: "pod checkout-<*> OOMKilled, limit <*>",
+		},
+	}
+	for _, tc := range tests {
+		if got := logPattern(tc.line); got != tc.want {
+			t.Fatalf("%q: 預期 %q，實際為 %q", tc.line, tc.want, got)
+		}
+	}
+}
+
+func TestClusterLogLines(t *testing.T) {
+	at := func(minutes int) time.Time { return logTestTrigger.Add(time.Duration(minutes) * time.Minute) }
+	lines := []LogLine{
+		{Timestamp: at(1), Message: "timeout calling payments after 3000ms"},
+		{Timestamp: at(-2), Message: "panic: nil pointer dereference"},
+		{Timestamp: at(2), Message: "timeout calling payments after 3100ms"},
+		{Timestamp: at(0), Message: "timeout calling payments after 2900ms"},
+		{Timestamp: at(3), Message: "   "},
+	}
+	patterns := clusterLogLines(lines, 2)
+	if len(patterns) != 2 {
+		t.Fatalf("應歸納為 2 個模式，實際為 %+v", patterns)
+	}
+	top := patterns[0]
+	if top.Pattern != "timeout calling payments after <*>" || top.Count != 3 || len(top.Samples) != 2 {
+		t.Fatalf("模式歸納錯誤: %+v", top)
+	}
+	if !top.FirstSeen.Equal(at(0)) || !top.LastSeen.Equal(at(2)) {
+		t.Fatalf("首次與最後出現時間錯誤: %+v", top)
+	}
+	if patterns[1].Count != 1 || patterns[1].Samples[0] != "panic: nil pointer dereference" {
+		t.Fatalf("次要模式錯誤: %+v", patterns[1])
+	}
+}
+
+func TestLokiLogEnricher(t *testing.T) {
+	var received url.Values
+	var tenant string
+	loki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/query_range" {
+			http.NotFound(w, r)
+			return
+		}
+		received = r.URL.Query()
+		tenant = r.Header.Get("X-Scope-OrgID")
+		entry := func(minutes int, line string) [2]string {
+			return [2]string{strconv.FormatInt(logTestTrigger.Add(time.Duration(minutes)*time.Minute).UnixNano(), 10), line}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"status": "success",
+			"data": map[string]any{
+				"resultType": "streams",
+				"result": []map[string]any{
+					{"stream": map[string]string{"pod": "checkout-0"}, "values": [][2]string{
+						entry(3, "ERROR db pool exhausted (max 50)"),
+						entry(1, "ERROR db pool exhausted (max 50)"),
+					}},
+					{"stream": map[string]string{"pod": "checkout-1"}, "values": [][2]string{
+						entry(2, "ERROR db pool exhausted (max 50)"),
+						entry(-5, "FATAL config reload failed"),
+					}},
+				},
+			},
+		})
+	}))
+	defer loki.Close()
+
+	source, err := NewLokiSource(LokiSourceConfig{
+		BaseURL:       loki.URL,
+		TenantID:      "team-sre",
+		ExploreURL:    "https://grafana.example.com",
+		DatasourceUID: "loki-prod",
+	})
+	if err != nil {
+		t.Fatalf("建立 Loki 來源失敗: %v", err)
+	}
+	enricher := NewLogEnricher(LogEnricherConfig{Source: source, MaxPatterns: 1})
+	enricher.now = func() time.Time { return logTestTrigger.Add(time.Hour) }
+
+	event := parseEventFacts("evt-log", map[string]any{
+		"resource_name": "checkout-0",
+		"triggered_at":  logTestTrigger.Format(time.RFC3339),
+		"tags":          []any{"service:checkout"},
+	}, time.Now())
+	evidence, err := enricher.Enrich(context.Background(), event)
+	if err != nil {
+		t.Fatalf("收集日誌失敗: %v", err)
+	}
+
+	wantQuery := `{service="checkout"} |~ "(?i)(error|fatal|panic|exception|out ?of ?memory|oomkilled)"`
+	if received.Get("query") != wantQuery || tenant != "team-sre" || received.Get("direction") != "backward" {
+		t.Fatalf("Loki 查詢錯誤: %v (tenant %q)", received, tenant)
+	}
+	if received.Get("start") != strconv.FormatInt(logTestTrigger.Add(-15*time.Minute).UnixNano(), 10) ||
+		received.Get("end") != strconv.FormatInt(logTestTrigger.Add(15*time.Minute).UnixNano(), 10) {
+		t.Fatalf("查詢區間錯誤: %v", received)
+	}
+
+	if len(evidence) != 1 {
+		t.Fatalf("應僅保留出現最多的模式，實際為 %+v", evidence)
+	}
+	item := evidence[0]
+	if item.Type != EvidenceTypeLog || item.Metadata["count"] != 3 || item.Metadata["pattern"] != "ERROR db pool exhausted (max <*>)" {
+		t.Fatalf("日誌證據錯誤: %+v", item)
+	}
+	if samples, _ := item.Metadata["samples"].([]string); len(samples) != 3 || item.Metadata["total_lines"] != 4 {
+		t.Fatalf("範例行錯誤: %+v", item.Metadata)
+	}
+	if item.Timestamp == nil || !item.Timestamp.Equal(logTestTrigger.Add(time.Minute)) {
+		t.Fatalf("證據時間應為首次出現時間: %v", item.Timestamp)
+	}
+
+	link, err := url.Parse(item.Link.URL)
+	if err != nil || link.Host != "grafana.example.com" || link.Path != "/explore" {
+		t.Fatalf("連結應指向 Grafana Explore: %s", item.Link.URL)
+	}
+	var panes map[string]struct {
+		Datasource string `json:"datasource"`
+		Queries    []struct {
+			Expr string `json:"expr"`
+		} `json:"queries"`
+	}
+	if err := json.Unmarshal([]byte(link.Query().Get("panes")), &panes); err != nil || panes["a"].Datasource != "loki-prod" || panes["a"].Queries[0].Expr != wantQuery {
+		t.Fatalf("Explore 連結內容錯誤: %s (%v)", link.Query().Get("panes"), err)
+	}
+}
+
+func TestElasticsearchLogEnricher(t *testing.T) {
+	var request map[string]any
+	var authorization string
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/app-logs/_search" {
+			http.NotFound(w, r)
+			return
+		}
+		authorization = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		hit := func(minutes int, message string) map[string]any {
+			return map[string]any{"_source": map[string]any{
+				"@timestamp": logTestTrigger.Add(time.Duration(minutes) * time.Minute).Format(time.RFC3339Nano),
+				"message":    message,
+			}}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"hits": map[string]any{"hits": []any{
+			hit(4, "java.lang.OutOfMemoryError: Java heap space"),
+			hit(2, "java.lang.OutOfMemoryError: Java heap space"),
+			hit(1, "upstream 10.1.2.3:8080 returned 503"),
+			map[string]any{"_source": map[string]any{"message": "缺少時間的日誌"}},
+		}}})
+	}))
+	defer es.Close()
+
+	source, err := NewElasticsearchSource(ElasticsearchSourceConfig{
+		BaseURL:     es.URL,
+		Index:       "app-logs",
+		APIKey:      "secret-key",
+		DiscoverURL: "https://kibana.example.com",
+	})
+	if err != nil {
+		t.Fatalf("建立 Elasticsearch 來源失敗: %v", err)
+	}
+	enricher := NewLogEnricher(LogEnricherConfig{Source: source})
+	enricher.now = func() time.Time { return logTestTrigger.Add(time.Hour) }
+
+	event := EventFacts{TriggeredAt: logTestTrigger, Labels: map[string]string{"app": "orders"}}
+	evidence, err := enricher.Enrich(context.Background(), event)
+	if err != nil {
+		t.Fatalf("收集日誌失敗: %v", err)
+	}
+	if authorization != "ApiKey secret-key" {
+		t.Fatalf("應以 API key 驗證，實際為 %q", authorization)
+	}
+	filters, _ := request["query"].(map[string]any)["bool"].(map[string]any)["filter"].([]any)
+	if len(filters) != 3 {
+		t.Fatalf("查詢應包含服務、等級與時間篩選: %+v", request)
+	}
+	if term := filters[0].(map[string]any)["term"].(map[string]any); term["service.name"] != "orders" {
+		t.Fatalf("應以事件的 app 標籤篩選服務: %+v", term)
+	}
+	timeRange := filters[2].(map[string]any)["range"].(map[string]any)["@timestamp"].(map[string]any)
+	if timeRange["gte"] != "2024-05-01T11:45:00Z" || timeRange["lte"] != "2024-05-01T12:15:00Z" {
+		t.Fatalf("時間篩選錯誤: %+v", timeRange)
+	}
+
+	if len(evidence) != 2 || evidence[0].Metadata["count"] != 2 || evidence[1].Metadata["pattern"] != "upstream <*> returned <*>" {
+		t.Fatalf("日誌證據錯誤: %+v", evidence)
+	}
+	wantQuery := `service.name:"orders" AND log.level:(error OR fatal OR critical)`
+	if evidence[0].Metadata["query"] != wantQuery {
+		t.Fatalf("查詢語句錯誤: %v", evidence[0].Metadata["query"])
+	}
+	link := evidence[0].Link.URL
+	if !strings.HasPrefix(link, "https://kibana.example.com/app/discover#/?_g=") {
+		t.Fatalf("連結應指向 Kibana Discover: %s", link)
+	}
+	if decoded, _ := url.QueryUnescape(link); !strings.Contains(decoded, "query:'"+wantQuery+"'") || !strings.Contains(decoded, "from:'2024-05-01T11:45:00Z'") {
+		t.Fatalf("Discover 連結內容錯誤: %s", decoded)
+	}
+}
+
+func TestLogEnricherSkipsUnknownService(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = true }))
+	defer server.Close()
+	source, _ := NewLokiSource(LokiSourceConfig{BaseURL: server.URL})
+	evidence, err := NewLogEnricher(LogEnricherConfig{Source: source}).Enrich(context.Background(), EventFacts{TriggeredAt: logTestTrigger})
+	if err != nil || evidence != nil || called {
+		t.Fatalf("無法判斷服務時不應查詢: %+v (%v)", evidence, err)
+	}
+}
+
+func TestLogSourceResponseLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat(" ", maxLogSourceResponseBytes+1)))
+	}))
+	defer server.Close()
+	source, _ := NewLokiSource(LokiSourceConfig{BaseURL: server.URL})
+	_, err := source.Search(context.Background(), LogSearch{Service: "checkout", Start: logTestTrigger.Add(-time.Hour), End: logTestTrigger, Limit: 10})
+	if err == nil || !strings.Contains(err.Error(), "上限") {
+		t.Fatalf("回應超過上限時應回傳錯誤，實際為 %v", err)
+	}
+}
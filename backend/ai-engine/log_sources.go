@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLogSourceTimeout = 10 * time.Second
+	// defaultLokiLineFilter 篩選錯誤等級的日誌行，不依賴各服務一致的 level 標籤。
+	defaultLokiLineFilter = `(?i)(error|fatal|panic|exception|out ?of ?memory|oomkilled)`
+	maxLogSourceErrorBody = 512
+	// maxLogSourceResponseBytes 為日誌查詢回應的讀取上限。預設每次取回 1000 行，
+	// 即使每行附帶數 KB 的堆疊也遠低於此值，可避免異常回應耗盡記憶體。
+	maxLogSourceResponseBytes = 16 << 20
+)
+
+var (
+	// ErrLokiURLRequired 代表未設定 Loki 位址。
+	ErrLokiURLRequired = errors.New("loki url is required")
+	// ErrElasticsearchURLRequired 代表未設定 Elasticsearch 位址。
+	ErrElasticsearchURLRequired = errors.New("elasticsearch url is required")
+)
+
+// defaultElasticsearchLevels 為視為錯誤等級的日誌等級。
+var defaultElasticsearchLevels = []string{"error", "fatal", "critical"}
+
+// LokiSourceConfig 設定 Loki 查詢 API。
+type LokiSourceConfig struct {
+	// BaseURL 為 Loki 根路徑，例如 http://loki:3100。
+	BaseURL string
+	// TenantID 設定時以 X-Scope-OrgID 標頭指定租戶。
+	TenantID    string
+	BearerToken string
+	// ServiceLabel 為承載服務名稱的串流標籤，預設 service。
+	ServiceLabel string
+	// LineFilter 為篩選錯誤日誌的正規表示式，預設比對 error、fatal、panic 等關鍵字。
+	LineFilter string
+	// ExploreURL 為 Grafana 位址，設定時證據連結指向 Explore；DatasourceUID 為 Loki 資料來源。
+	ExploreURL    string
+	DatasourceUID string
+	// Timeout 僅在未提供 HTTPClient 時套用。
+	Timeout    time.Duration
+	HTTPClient *http.Client
+}
+
+// LokiSource 以 LogQL 查詢 Loki。
+type LokiSource struct {
+	baseURL       string
+	tenantID      string
+	bearerToken   string
+	serviceLabel  string
+	lineFilter    string
+	exploreURL    string
+	datasourceUID string
+	client        *http.Client
+}
+
+// NewLokiSource 建立 Loki 日誌來源。
+func NewLokiSource(cfg LokiSourceConfig) (*LokiSource, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if baseURL == "" {
+		return nil, ErrLokiURLRequired
+	}
+	source := &LokiSource{
+		baseURL:       baseURL,
+		tenantID:      cfg.TenantID,
+		bearerToken:   cfg.BearerToken,
+		serviceLabel:  cfg.ServiceLabel,
+		lineFilter:    cfg.LineFilter,
+		exploreURL:    strings.TrimRight(strings.TrimSpace(cfg.ExploreURL), "/"),
+		datasourceUID: cfg.DatasourceUID,
+		client:        logSourceClient(cfg.HTTPClient, cfg.Timeout),
+	}
+	if source.serviceLabel == "" {
+		source.serviceLabel = "service"
+	}
+	if source.lineFilter == "" {
+		source.lineFilter = defaultLokiLineFilter
+	}
+	return source, nil
+}
+
+// Name 實作 LogSource。
+func (l *LokiSource) Name() string {
+	return "loki"
+}
+
+// Query 回傳 LogQL 查詢語句。
+func (l *LokiSource) Query(search LogSearch) string {
+	return fmt.Sprintf("{%s} |~ %s", formatLabels(map[string]string{l.serviceLabel: search.Service}), strconv.Quote(l.lineFilter))
+}
+
+type lokiResponse struct {
+	Data struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Values [][2]string `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// Search 呼叫 /loki/api/v1/query_range，由新到舊取回最多 search.Limit 行。
+func (l *LokiSource) Search(ctx context.Context, search LogSearch) ([]LogLine, error) {
+	params := url.Values{}
+	params.Set("query", l.Query(search))
+	params.Set("start", strconv.FormatInt(search.Start.UnixNano(), 10))
+	params.Set("end", strconv.FormatInt(search.End.UnixNano(), 10))
+	params.Set("limit", strconv.Itoa(search.Limit))
+	params.Set("direction", "backward")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.baseURL+"/loki/api/v1/query_range?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("無法建立 Loki 請求: %w", err)
+	}
+	if l.tenantID != "" {
+		req.Header.Set("X-Scope-OrgID", l.tenantID)
+	}
+	if l.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+l.bearerToken)
+	}
+	raw, err := doLogSourceRequest(l.client, req, "Loki")
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded lokiResponse
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, fmt.Errorf("Loki 回應格式錯誤: %w", err)
+	}
+	if decoded.Data.ResultType != "streams" {
+		return nil, fmt.Errorf("Loki 回應類型 %q 不是 streams", decoded.Data.ResultType)
+	}
+	var lines []LogLine
+	for _, stream := range decoded.Data.Result {
+		for _, value := range stream.Values {
+			nanos, err := strconv.ParseInt(value[0], 10, 64)
+			if err != nil {
+				continue
+			}
+			lines = append(lines, LogLine{Timestamp: time.Unix(0, nanos).UTC(), Message: value[1]})
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Timestamp.Before(lines[j].Timestamp)
+	})
+	return lines, nil
+}
+
+// Link 回傳 Grafana Explore 連結，未設定 ExploreURL 時回傳 Loki 查詢 API 網址。
+func (l *LokiSource) Link(search LogSearch) string {
+	query := l.Query(search)
+	if l.exploreURL == "" {
+		params := url.Values{}
+		params.Set("query", query)
+		params.Set("start", strconv.FormatInt(search.Start.UnixNano(), 10))
+		params.Set("end", strconv.FormatInt(search.End.UnixNano(), 10))
+		return l.baseURL + "/loki/api/v1/query_range?" + params.Encode()
+	}
+	datasource := map[string]string{"type": "loki", "uid": l.datasourceUID}
+	panes, _ := json.Marshal(map[string]any{
+		"a": map[string]any{
+			"datasource": l.datasourceUID,
+			"queries":    []map[string]any{{"refId": "A", "expr": query, "datasource": datasource}},
+			"range": map[string]string{
+				"from": strconv.FormatInt(search.Start.UnixMilli(), 10),
+				"to":   strconv.FormatInt(search.End.UnixMilli(), 10),
+			},
+		},
+	})
+	params := url.Values{}
+	params.Set("schemaVersion", "1")
+	params.Set("panes", string(panes))
+	return l.exploreURL + "/explore?" + params.Encode()
+}
+
+// ElasticsearchSourceConfig 設定 Elasticsearch 或 OpenSearch 相容的 _search API。
+type ElasticsearchSourceConfig struct {
+	// BaseURL 為叢集位址，例如 http://elasticsearch:9200。
+	BaseURL string
+	// Index 為查詢的索引或索引樣式，預設 logs-*。
+	Index    string
+	Username string
+	Password string
+	// APIKey 設定時優先於帳號密碼。
+	APIKey string
+	// 欄位名稱預設依 Elastic Common Schema：@timestamp、message、log.level 與 service.name。
+	TimestampField string
+	MessageField   string
+	LevelField     string
+	ServiceField   string
+	// Levels 為視為錯誤的日誌等級，預設 error、fatal 與 critical。
+	Levels []string
+	// DiscoverURL 為 Kibana 位址，設定時證據連結指向 Discover。
+	DiscoverURL string
+	// Timeout 僅在未提供 HTTPClient 時套用。
+	Timeout    time.Duration
+	HTTPClient *http.Client
+}
+
+// ElasticsearchSource 以 _search API 查詢錯誤日誌。
+type ElasticsearchSource struct {
+	baseURL        string
+	index          string
+	username       string
+	password       string
+	apiKey         string
+	timestampField string
+	messageField   string
+	levelField     string
+	serviceField   string
+	levels         []string
+	discoverURL    string
+	client         *http.Client
+}
+
+// NewElasticsearchSource 建立 Elasticsearch 日誌來源。
+func NewElasticsearchSource(cfg ElasticsearchSourceConfig) (*ElasticsearchSource, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if baseURL == "" {
+		return nil, ErrElasticsearchURLRequired
+	}
+	source := &ElasticsearchSource{
+		baseURL:        baseURL,
+		index:          cfg.Index,
+		username:       cfg.Username,
+		password:       cfg.Password,
+		apiKey:         cfg.APIKey,
+		timestampField: cfg.TimestampField,
+		messageField:   cfg.MessageField,
+		levelField:     cfg.LevelField,
+		serviceField:   cfg.ServiceField,
+		levels:         cfg.Levels,
+		discoverURL:    strings.TrimRight(strings.TrimSpace(cfg.DiscoverURL), "/"),
+		client:         logSourceClient(cfg.HTTPClient, cfg.Timeout),
+	}
+	if source.index == "" {
+		source.index = "logs-*"
+	}
+	if source.timestampField == "" {
+		source.timestampField = "@timestamp"
+	}
+	if source.messageField == "" {
+		source.messageField = "message"
+	}
+	if source.levelField == "" {
+		source.levelField = "log.level"
+	}
+	if source.serviceField == "" {
+		source.serviceField = "service.name"
+	}
+	if len(source.levels) == 0 {
+		source.levels = defaultElasticsearchLevels
+	}
+	return source, nil
+}
+
+// Name 實作 LogSource。
+func (e *ElasticsearchSource) Name() string {
+	return "elasticsearch"
+}
+
+// Query 回傳 KQL 與 Lucene 皆可解析的查詢字串。
+func (e *ElasticsearchSource) Query(search LogSearch) string {
+	return fmt.Sprintf("%s:%s AND %s:(%s)", e.serviceField, strconv.Quote(search.Service), e.levelField, strings.Join(e.levels, " OR "))
+}
+
+type elasticsearchResponse struct {
+	Hits struct {
+		Hits []struct {
+			Source map[string]any `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// Search 以 bool 篩選服務、等級與時間區間，由新到舊取回最多 search.Limit 筆。
+func (e *ElasticsearchSource) Search(ctx context.Context, search LogSearch) ([]LogLine, error) {
+	body, err := json.Marshal(map[string]any{
+		"size":    search.Limit,
+		"sort":    []map[string]any{{e.timestampField: map[string]string{"order": "desc"}}},
+		"_source": []string{e.timestampField, e.messageField},
+		"query": map[string]any{
+			"bool": map[string]any{
+				"filter": []map[string]any{
+					{"term": map[string]any{e.serviceField: search.Service}},
+					{"terms": map[string]any{e.levelField: e.levels}},
+					{"range": map[string]any{e.timestampField: map[string]string{
+						"gte": search.Start.Format(time.RFC3339),
+						"lte": search.End.Format(time.RFC3339),
+					}}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("無法編碼 Elasticsearch 查詢: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+"/"+url.PathEscape(e.index)+"/_search", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("無法建立 Elasticsearch 請求: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	switch {
+	case e.apiKey != "":
+		req.Header.Set("Authorization", "ApiKey "+e.apiKey)
+	case e.username != "":
+		req.SetBasicAuth(e.username, e.password)
+	}
+	raw, err := doLogSourceRequest(e.client, req, "Elasticsearch")
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded elasticsearchResponse
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, fmt.Errorf("Elasticsearch 回應格式錯誤: %w", err)
+	}
+	lines := make([]LogLine, 0, len(decoded.Hits.Hits))
+	for _, hit := range decoded.Hits.Hits {
+		message, _ := sourceField(hit.Source, e.messageField).(string)
+		text, _ := sourceField(hit.Source, e.timestampField).(string)
+		timestamp, err := time.Parse(time.RFC3339Nano, text)
+		if message == "" || err != nil {
+			continue
+		}
+		lines = append(lines, LogLine{Timestamp: timestamp.UTC(), Message: message})
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Timestamp.Before(lines[j].Timestamp)
+	})
+	return lines, nil
+}
+
+// Link 回傳 Kibana Discover 連結，未設定 DiscoverURL 時回傳 _search API 網址。
+func (e *ElasticsearchSource) Link(search LogSearch) string {
+	query := e.Query(search)
+	if e.discoverURL == "" {
+		return e.baseURL + "/" + url.PathEscape(e.index) + "/_search?q=" + url.QueryEscape(query)
+	}
+	global := fmt.Sprintf("(time:(from:%s,to:%s))", risonString(search.Start.Format(time.RFC3339)), risonString(search.End.Format(time.RFC3339)))
+	app := fmt.Sprintf("(query:(language:kuery,query:%s))", risonString(query))
+	return e.discoverURL + "/app/discover#/?_g=" + url.QueryEscape(global) + "&_a=" + url.QueryEscape(app)
+}
+
+// sourceField 依點分隔路徑讀取 _source 欄位，同時支援扁平鍵 (log.level) 與巢狀物件。
+func sourceField(source map[string]any, path string) any {
+	if value, ok := source[path]; ok {
+		return value
+	}
+	head, rest, found := strings.Cut(path, ".")
+	if !found {
+		return nil
+	}
+	nested, ok := source[head].(map[string]any)
+	if !ok {
+		return nil
+	}
+	return sourceField(nested, rest)
+}
+
+// risonString 以 Rison 格式輸出字串，供 Kibana 網址參數使用。
+func risonString(value string) string {
+	return "'" + strings.NewReplacer("!", "!!", "'", "!'").Replace(value) + "'"
+}
+
+func logSourceClient(client *http.Client, timeout time.Duration) *http.Client {
+	if client != nil {
+		return client
+	}
+	if timeout <= 0 {
+		timeout = defaultLogSourceTimeout
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// doLogSourceRequest 送出請求並回傳回應內容，非 2xx 狀態碼時附上截斷的回應內容作為錯誤。
+func doLogSourceRequest(client *http.Client, req *http.Request, name string) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("呼叫 %s 失敗: %w", name, err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxLogSourceResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("讀取 %s 回應失敗: %w", name, err)
+	}
+	if len(raw) > maxLogSourceResponseBytes {
+		return nil, fmt.Errorf("%s 回應超過 %d 位元組上限，請降低查詢筆數", name, maxLogSourceResponseBytes)
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("%s 查詢失敗 (%d): %s", name, resp.StatusCode, strings.TrimSpace(string(raw[:min(len(raw), maxLogSourceErrorBody)])))
+	}
+	return raw, nil
+}
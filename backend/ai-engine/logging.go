@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 支援的日誌輸出格式。
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// 結構化日誌的共用欄位名稱，Loki 以此擷取標籤與欄位。
+const (
+	logKeyReportID = "report_id"
+	logKeyEventID  = "event_id"
+	logKeyStatus   = "status"
+	logKeyAttempt  = "attempt"
+	logKeyDuration = "duration_ms"
+	logKeyError    = "error"
+	logKeyTraceID  = "trace_id"
+	logKeySpanID   = "span_id"
+)
+
+// ErrUnsupportedLogFormat 代表設定了不支援的日誌格式。
+var ErrUnsupportedLogFormat = errors.New("unsupported log format")
+
+// LoggingConfig 設定結構化日誌。
+type LoggingConfig struct {
+	// Level 為 debug、info、warn 或 error，預設 info。
+	Level string
+	// Format 為 json 或 text，預設 json。
+	Format string
+	// Output 預設為標準輸出。
+	Output io.Writer
+}
+
+// NewLogger 依設定建立 slog logger；以 context 記錄的日誌會自動附帶 trace_id 與 span_id。
+func NewLogger(cfg LoggingConfig) (*slog.Logger, error) {
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf("無效的日誌等級 %q: %w", cfg.Level, err)
+		}
+	}
+	output := cfg.Output
+	if output == nil {
+		output = os.Stdout
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", LogFormatJSON:
+		handler = slog.NewJSONHandler(output, opts)
+	case LogFormatText:
+		handler = slog.NewTextHandler(output, opts)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedLogFormat, cfg.Format)
+	}
+	return slog.New(traceContextHandler{handler}), nil
+}
+
+// traceContextHandler 自 context 取出 OpenTelemetry span，將 trace_id 與 span_id 加入日誌。
+type traceContextHandler struct {
+	slog.Handler
+}
+
+func (h traceContextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(
+			slog.String(logKeyTraceID, spanContext.TraceID().String()),
+			slog.String(logKeySpanID, spanContext.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h traceContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceContextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceContextHandler) WithGroup(name string) slog.Handler {
+	return traceContextHandler{h.Handler.WithGroup(name)}
+}
+
+// durationAttr 以毫秒記錄耗時，方便日誌查詢直接比較數值。
+func durationAttr(elapsed time.Duration) slog.Attr {
+	return slog.Float64(logKeyDuration, float64(elapsed.Microseconds())/1000)
+}
+
+// reportLogger 回傳附帶報告與事件編號的 logger。
+func reportLogger(logger *slog.Logger, reportID, eventID string) *slog.Logger {
+	return logger.With(slog.String(logKeyReportID, reportID), slog.String(logKeyEventID, eventID))
+}
+
+// AccessLogMiddleware 為每個 HTTP 請求記錄一行存取日誌，5xx 為 error、4xx 為 warn。
+// 路由包含 eventId 或 reportId 時一併記錄，便於與分析日誌串接。
+func AccessLogMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int(logKeyStatus, status),
+			durationAttr(time.Since(start)),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("client_ip", c.ClientIP()),
+		}
+		if reportID := c.Param("reportId"); reportID != "" {
+			attrs = append(attrs, slog.String(logKeyReportID, reportID))
+		}
+		if eventID := c.Param("eventId"); eventID != "" {
+			attrs = append(attrs, slog.String(logKeyEventID, eventID))
+		}
+		if principal, ok := PrincipalFromContext(c.Request.Context()); ok {
+			attrs = append(attrs, slog.String("user", principal.Name()))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String(logKeyError, c.Errors.String()))
+		}
+		logger.LogAttrs(c.Request.Context(), level, "HTTP 請求", attrs...)
+	}
+}
+
+// recoveryMiddleware 攔截 handler 的 panic，以結構化日誌記錄並回傳 500。
+func recoveryMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		logger.ErrorContext(c.Request.Context(), "HTTP handler 發生 panic",
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.Any("panic", recovered),
+		)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
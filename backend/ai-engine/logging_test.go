@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// decodeLogLines 解析 JSON 日誌輸出，每行一筆紀錄。
+func decodeLogLines(t *testing.T, output *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, raw := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		if raw == "" {
+			continue
+		}
+		var line map[string]any
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("日誌不是合法的 JSON: %q (%v)", raw, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func findLogLine(lines []map[string]any, msg string) map[string]any {
+	for _, line := range lines {
+		if line["msg"] == msg {
+			return line
+		}
+	}
+	return nil
+}
+
+func TestNewLoggerConfig(t *testing.T) {
+	if _, err := NewLogger(LoggingConfig{Format: "xml"}); !errors.Is(err, ErrUnsupportedLogFormat) {
+		t.Fatalf("不支援的格式應回傳 ErrUnsupportedLogFormat，實際為 %v", err)
+	}
+	if _, err := NewLogger(LoggingConfig{Level: "verbose"}); err == nil {
+		t.Fatalf("無效的日誌等級應回傳錯誤")
+	}
+
+	var output bytes.Buffer
+	logger, err := NewLogger(LoggingConfig{Level: "warn", Format: "text", Output: &output})
+	if err != nil {
+		t.Fatalf("建立 logger 失敗: %v", err)
+	}
+	logger.Info("略過")
+	logger.Warn("保留", logKeyReportID, "rpt-1")
+	if got := output.String(); strings.Contains(got, "略過") || !strings.Contains(got, "report_id=rpt-1") {
+		t.Fatalf("日誌等級或格式錯誤: %q", got)
+	}
+}
+
+func TestAnalysisLogsCarryReportFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useSpanRecorder(t)
+
+	var output bytes.Buffer
+	logger, err := NewLogger(LoggingConfig{Level: "debug", Output: &output})
+	if err != nil {
+		t.Fatalf("建立 logger 失敗: %v", err)
+	}
+	service := NewAnalysisService(NewInMemoryReportRepository(), &flakyGenerator{
+		errs:   []error{&LLMStatusError{StatusCode: http.StatusBadGateway}},
+		result: GeneratedReport{EventSummary: "完成"},
+	}, AnalysisServiceConfig{ProcessingTimeout: time.Second, Retry: fastRetryPolicy(3), Logger: logger})
+	router := SetupRouter(service)
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/v1/events/evt-log/ai-analysis", nil))
+	if resp.Code != http.StatusAccepted {
+		t.Fatalf("預期 202，實際為 %d", resp.Code)
+	}
+	service.Wait()
+	var created createAnalysisResponse
+	_ = json.Unmarshal(resp.Body.Bytes(), &created)
+
+	lines := decodeLogLines(t, &output)
+	for _, tc := range []struct {
+		msg     string
+		status  string
+		attempt float64
+	}{
+		{msg: "開始 AI 分析", status: "RUNNING", attempt: 1},
+		{msg: "AI 分析暫時性失敗，稍後重試", status: "RUNNING", attempt: 1},
+		{msg: "AI 分析完成", status: "SUCCESS", attempt: 2},
+	} {
+		line := findLogLine(lines, tc.msg)
+		if line == nil {
+			t.Fatalf("缺少日誌 %q: %s", tc.msg, output.String())
+		}
+		if line[logKeyReportID] != created.ReportID || line[logKeyEventID] != "evt-log" {
+			t.Fatalf("%q 缺少報告或事件編號: %v", tc.msg, line)
+		}
+		if line[logKeyStatus] != tc.status || line[logKeyAttempt] != tc.attempt {
+			t.Fatalf("%q 的狀態或嘗試次數錯誤: %v", tc.msg, line)
+		}
+		if _, ok := line[logKeyTraceID]; !ok {
+			t.Fatalf("%q 應附帶 trace_id: %v", tc.msg, line)
+		}
+	}
+	if _, ok := findLogLine(lines, "AI 分析完成")[logKeyDuration].(float64); !ok {
+		t.Fatalf("完成日誌應包含耗時")
+	}
+
+	access := findLogLine(lines, "HTTP 請求")
+	if access == nil {
+		t.Fatalf("缺少存取日誌")
+	}
+	if access["route"] != "/api/v1/events/:eventId/ai-analysis" || access[logKeyStatus] != float64(http.StatusAccepted) ||
+		access[logKeyEventID] != "evt-log" || access["level"] != "INFO" {
+		t.Fatalf("存取日誌欄位錯誤: %v", access)
+	}
+}
+
+func TestAccessLogLevelFollowsStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var output bytes.Buffer
+	logger, _ := NewLogger(LoggingConfig{Output: &output})
+
+	router := gin.New()
+	router.Use(AccessLogMiddleware(logger), recoveryMiddleware(logger))
+	router.GET("/reports/:reportId", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+	router.GET("/panic", func(*gin.Context) { panic("boom") })
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/reports/rpt-404", nil))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	if resp.Code != http.StatusInternalServerError {
+		t.Fatalf("panic 應回傳 500，實際為 %d", resp.Code)
+	}
+
+	lines := decodeLogLines(t, &output)
+	if len(lines) != 3 {
+		t.Fatalf("預期 3 行日誌，實際為 %d: %s", len(lines), output.String())
+	}
+	if lines[0]["level"] != "WARN" || lines[0][logKeyReportID] != "rpt-404" {
+		t.Fatalf("4xx 應記錄為 WARN 並附帶報告編號: %v", lines[0])
+	}
+	if lines[1]["msg"] != "HTTP handler 發生 panic" || lines[2]["level"] != "ERROR" {
+		t.Fatalf("panic 應記錄錯誤並以 ERROR 記錄存取日誌: %v", lines[1:])
+	}
+}
@@ -1,47 +1,673 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
 	gin.SetMode(gin.ReleaseMode)
 
-	promptsPath := os.Getenv("AI_ENGINE_PROMPTS_PATH")
-	if promptsPath == "" {
-		promptsPath = "data/prompts.json"
+	logger, err := NewLogger(LoggingConfig{
+		Level:  envOrDefault("AI_ENGINE_LOG_LEVEL", "info"),
+		Format: envOrDefault("AI_ENGINE_LOG_FORMAT", LogFormatJSON),
+	})
+	if err != nil {
+		// logger 尚未建立，退回標準錯誤輸出。
+		fmt.Fprintf(os.Stderr, "日誌設定錯誤: %v\n", err)
+		os.Exit(1)
+	}
+	// 第三方套件透過標準 log 輸出的內容也轉為結構化日誌。
+	slog.SetDefault(logger)
+
+	// run 回傳後其中註冊的 defer (資料庫、Redis 連線等) 皆已執行，才結束程式。
+	if err := run(logger); err != nil {
+		logger.Error("AI Engine 執行失敗", slog.String(logKeyError, err.Error()))
+		os.Exit(1)
+	}
+}
+
+// run 讀取設定、啟動服務並等待關閉訊號，啟動或執行失敗時回傳錯誤。
+func run(logger *slog.Logger) error {
+	tracing, err := tracingConfigFromEnv()
+	if err != nil {
+		return fmt.Errorf("追蹤設定錯誤: %w", err)
+	}
+	shutdownTracing, err := InitTracing(context.Background(), tracing)
+	if err != nil {
+		return fmt.Errorf("無法初始化追蹤: %w", err)
+	}
+
+	repo, closeRepo, err := newRepositoryFromEnv()
+	if err != nil {
+		return fmt.Errorf("無法初始化報告儲存庫: %w", err)
+	}
+	defer closeRepo()
+
+	generator, err := newGeneratorFromEnv()
+	if err != nil {
+		return fmt.Errorf("無法初始化報告產生器: %w", err)
+	}
+
+	recoveryPolicy, err := ParseRecoveryPolicy(os.Getenv("AI_ENGINE_RECOVERY_POLICY"))
+	if err != nil {
+		return fmt.Errorf("AI_ENGINE_RECOVERY_POLICY 設定錯誤: %w", err)
+	}
+	recoveryStaleAfter, err := time.ParseDuration(envOrDefault("AI_ENGINE_RECOVERY_STALE_AFTER", "0s"))
+	if err != nil {
+		return fmt.Errorf("AI_ENGINE_RECOVERY_STALE_AFTER 格式錯誤: %w", err)
+	}
+
+	workers, err := strconv.Atoi(envOrDefault("AI_ENGINE_WORKERS", "4"))
+	if err != nil {
+		return fmt.Errorf("AI_ENGINE_WORKERS 格式錯誤: %w", err)
+	}
+	queueSize, err := strconv.Atoi(envOrDefault("AI_ENGINE_QUEUE_SIZE", "100"))
+	if err != nil {
+		return fmt.Errorf("AI_ENGINE_QUEUE_SIZE 格式錯誤: %w", err)
+	}
+
+	retryPolicy, err := retryPolicyFromEnv()
+	if err != nil {
+		return fmt.Errorf("重試策略設定錯誤: %w", err)
+	}
+	webhooks, err := webhookConfigFromEnv()
+	if err != nil {
+		return fmt.Errorf("回呼通知設定錯誤: %w", err)
+	}
+	// 請求可隨時註冊回呼網址，未設定密鑰時所有回呼皆不附簽章。
+	if webhooks.Secret == "" {
+		logger.Warn("未設定 AI_ENGINE_WEBHOOK_SECRET，回呼通知不附簽章，接收端無法驗證來源與時間戳記")
 	}
 
-	repo := NewInMemoryReportRepository()
-	generator, err := NewTemplateReportGenerator(promptsPath, 750*time.Millisecond)
+	auth, err := authenticatorFromEnv()
 	if err != nil {
-		log.Fatalf("無法載入分析模板: %v", err)
+		return fmt.Errorf("JWT 驗證設定錯誤: %w", err)
+	}
+	if auth == nil {
+		logger.Warn("未設定 AI_ENGINE_JWT_HS256_SECRET、AI_ENGINE_JWT_JWKS_FILE 或 AI_ENGINE_JWT_JWKS_URL，API 不需驗證")
+	}
+
+	rateLimiter, closeRateLimiter, err := rateLimiterFromEnv(auth != nil)
+	if err != nil {
+		return fmt.Errorf("限流設定錯誤: %w", err)
+	}
+	defer closeRateLimiter()
+
+	budget, err := budgetConfigFromEnv()
+	if err != nil {
+		return fmt.Errorf("預算設定錯誤: %w", err)
+	}
+
+	// 價格表範例見 data/llm_prices.json；未設定時 LLM 報告仍記錄 token 用量，但不計成本。
+	var prices PriceTable
+	if path := os.Getenv("AI_ENGINE_LLM_PRICES_PATH"); path != "" {
+		if prices, err = LoadPriceTable(path); err != nil {
+			return fmt.Errorf("載入 LLM 價格表失敗: %w", err)
+		}
+	}
+	if budget.Enabled() && len(prices) == 0 {
+		logger.Warn("已設定團隊預算但未設定 AI_ENGINE_LLM_PRICES_PATH，LLM 成本皆計為 0，預算不會用盡")
+	}
+
+	readinessTimeout, err := time.ParseDuration(envOrDefault("AI_ENGINE_READINESS_TIMEOUT", "3s"))
+	if err != nil {
+		return fmt.Errorf("AI_ENGINE_READINESS_TIMEOUT 格式錯誤: %w", err)
+	}
+
+	// SQL 儲存庫同時保存 CI/CD 回報的變更，記憶體模式下重啟後變更紀錄不保留。
+	changes := changeRepositoryFor(repo)
+	topology, err := topologyFromEnv()
+	if err != nil {
+		return fmt.Errorf("拓撲設定錯誤: %w", err)
+	}
+	blastRadiusDepth, err := strconv.Atoi(envOrDefault("AI_ENGINE_TOPOLOGY_MAX_DEPTH", "3"))
+	if err != nil {
+		return fmt.Errorf("AI_ENGINE_TOPOLOGY_MAX_DEPTH 格式錯誤: %w", err)
+	}
+	relatedEvents, err := relatedEventsFromEnv()
+	if err != nil {
+		return fmt.Errorf("關聯事件設定錯誤: %w", err)
+	}
+	correlationWindow, err := time.ParseDuration(envOrDefault("AI_ENGINE_CORRELATION_WINDOW", "30m"))
+	if err != nil {
+		return fmt.Errorf("AI_ENGINE_CORRELATION_WINDOW 格式錯誤: %w", err)
+	}
+	enrichers, err := enrichersFromEnv(changes, topology)
+	if err != nil {
+		return fmt.Errorf("證據收集設定錯誤: %w", err)
+	}
+	enrichmentTimeout, err := time.ParseDuration(envOrDefault("AI_ENGINE_ENRICHMENT_TIMEOUT", "15s"))
+	if err != nil {
+		return fmt.Errorf("AI_ENGINE_ENRICHMENT_TIMEOUT 格式錯誤: %w", err)
 	}
 
 	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
-		ProcessingTimeout: 2 * time.Minute,
+		ProcessingTimeout:  2 * time.Minute,
+		RecoveryPolicy:     recoveryPolicy,
+		RecoveryStaleAfter: recoveryStaleAfter,
+		Workers:            workers,
+		QueueSize:          queueSize,
+		Retry:              retryPolicy,
+		Webhooks:           webhooks,
+		Logger:             logger,
+		ReadinessTimeout:   readinessTimeout,
+		Auth:               auth,
+		RateLimiter:        rateLimiter,
+		Prices:             prices,
+		Budget:             budget,
+		Enrichers:          enrichers,
+		EnrichmentTimeout:  enrichmentTimeout,
+		Changes:            changes,
+		Topology:           topology,
+		BlastRadiusDepth:   blastRadiusDepth,
+		RelatedEvents:      relatedEvents,
+		CorrelationWindow:  correlationWindow,
 	})
 
+	summary, err := service.RecoverOrphanedReports(context.Background())
+	if err != nil {
+		// 停止已啟動的 worker，避免關閉儲存庫時仍有分析存取。
+		stopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = service.Shutdown(stopCtx)
+		return fmt.Errorf("復原遺留報告失敗: %w", err)
+	}
+	if len(summary.Requeued) > 0 || len(summary.Failed) > 0 {
+		logger.Info("已復原遺留報告",
+			slog.String("recovery_policy", string(recoveryPolicy)),
+			slog.Int("requeued", len(summary.Requeued)),
+			slog.Int("failed", len(summary.Failed)),
+		)
+	}
+
 	router := SetupRouter(service)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+	port := envOrDefault("PORT", "8080")
 
 	srv := &http.Server{
 		Addr:    ":" + port,
 		Handler: router,
 	}
 
-	log.Printf("AI Engine 服務啟動於埠號 %s", port)
+	shutdownTimeout, err := time.ParseDuration(envOrDefault("AI_ENGINE_SHUTDOWN_TIMEOUT", "30s"))
+	if err != nil {
+		return fmt.Errorf("AI_ENGINE_SHUTDOWN_TIMEOUT 格式錯誤: %w", err)
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("AI Engine 服務啟動", slog.String("port", port))
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var runErr error
+	select {
+	case err := <-serverErr:
+		if err == nil {
+			return nil
+		}
+		// 伺服器無法啟動時同樣停止分析服務，再回傳錯誤。
+		runErr = fmt.Errorf("服務啟動失敗: %w", err)
+	case <-signalCtx.Done():
+		logger.Info("收到關閉訊號，等待執行中的分析完成", slog.String("shutdown_timeout", shutdownTimeout.String()))
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// 先停止分析服務 (新的分析請求回傳 503，查詢仍可使用)，再關閉 HTTP 伺服器。
+	if err := service.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("分析未於期限內完成，已中止並依策略處理剩餘報告",
+			slog.String("recovery_policy", string(recoveryPolicy)),
+			slog.String(logKeyError, err.Error()),
+		)
+	}
+
+	httpCtx, cancelHTTP := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelHTTP()
+	if err := srv.Shutdown(httpCtx); err != nil {
+		logger.Error("HTTP 伺服器關閉失敗", slog.String(logKeyError, err.Error()))
+	}
+	// 送出尚未匯出的 span。
+	if err := shutdownTracing(httpCtx); err != nil {
+		logger.Error("追蹤匯出器關閉失敗", slog.String(logKeyError, err.Error()))
+	}
+	if runErr != nil {
+		return runErr
+	}
+	logger.Info("AI Engine 服務已關閉")
+	return nil
+}
+
+// newRepositoryFromEnv 依 AI_ENGINE_DB_TYPE 選擇報告儲存庫 (memory、sqlite 或 postgres)。
+func newRepositoryFromEnv() (ReportRepository, func(), error) {
+	driver := strings.ToLower(envOrDefault("AI_ENGINE_DB_TYPE", "memory"))
+	if driver == "memory" {
+		return NewInMemoryReportRepository(), func() {}, nil
+	}
+
+	repo, err := NewSQLReportRepository(SQLRepositoryConfig{
+		Driver: driver,
+		DSN:    os.Getenv("AI_ENGINE_DB_DSN"),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return repo, func() {
+		if err := repo.Close(); err != nil {
+			slog.Error("關閉資料庫連線失敗", slog.String(logKeyError, err.Error()))
+		}
+	}, nil
+}
+
+// newGeneratorFromEnv 依 AI_ENGINE_GENERATOR 選擇報告產生器 (template 或 openai)。
+func newGeneratorFromEnv() (ReportGenerator, error) {
+	switch strings.ToLower(envOrDefault("AI_ENGINE_GENERATOR", "template")) {
+	case "template":
+		return templateGeneratorFromEnv()
+	case "openai":
+		return openAIGeneratorFromEnv(os.Getenv("AI_ENGINE_LLM_MODEL"))
+	default:
+		return nil, fmt.Errorf("不支援的 AI_ENGINE_GENERATOR: %s", os.Getenv("AI_ENGINE_GENERATOR"))
+	}
+}
+
+func templateGeneratorFromEnv() (ReportGenerator, error) {
+	generator, err := NewTemplateReportGenerator(envOrDefault("AI_ENGINE_PROMPTS_PATH", "data/prompts.json"), 750*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	return generator, nil
+}
+
+// openAIGeneratorFromEnv 以 AI_ENGINE_LLM_* 設定建立指定模型的產生器。
+func openAIGeneratorFromEnv(model string) (ReportGenerator, error) {
+	timeout, err := time.ParseDuration(envOrDefault("AI_ENGINE_LLM_TIMEOUT", "60s"))
+	if err != nil {
+		return nil, fmt.Errorf("AI_ENGINE_LLM_TIMEOUT 格式錯誤: %w", err)
+	}
+	temperature, err := strconv.ParseFloat(envOrDefault("AI_ENGINE_LLM_TEMPERATURE", "0.2"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("AI_ENGINE_LLM_TEMPERATURE 格式錯誤: %w", err)
+	}
+	generator, err := NewOpenAIReportGenerator(OpenAIGeneratorConfig{
+		BaseURL:     envOrDefault("AI_ENGINE_LLM_BASE_URL", "https://api.openai.com/v1"),
+		Provider:    os.Getenv("AI_ENGINE_LLM_PROVIDER"),
+		APIKey:      os.Getenv("AI_ENGINE_LLM_API_KEY"),
+		Model:       model,
+		Temperature: temperature,
+		Timeout:     timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return generator, nil
+}
+
+// budgetConfigFromEnv 讀取 AI_ENGINE_BUDGET_* 預算設定。預算用盡時依 AI_ENGINE_BUDGET_POLICY
+// 改用 AI_ENGINE_BUDGET_ECONOMY_MODEL (economy)、模板產生器 (template) 或拒絕請求 (reject)。
+func budgetConfigFromEnv() (BudgetConfig, error) {
+	var cfg BudgetConfig
+	var err error
+	if cfg.Default.DailyUSD, err = strconv.ParseFloat(envOrDefault("AI_ENGINE_BUDGET_DAILY_USD", "0"), 64); err != nil {
+		return cfg, fmt.Errorf("AI_ENGINE_BUDGET_DAILY_USD 格式錯誤: %w", err)
+	}
+	if cfg.Default.MonthlyUSD, err = strconv.ParseFloat(envOrDefault("AI_ENGINE_BUDGET_MONTHLY_USD", "0"), 64); err != nil {
+		return cfg, fmt.Errorf("AI_ENGINE_BUDGET_MONTHLY_USD 格式錯誤: %w", err)
+	}
+	if path := os.Getenv("AI_ENGINE_BUDGET_TEAMS_PATH"); path != "" {
+		if cfg.Teams, err = LoadBudgetLimits(path); err != nil {
+			return cfg, err
+		}
+	}
+	if !cfg.Enabled() {
+		return cfg, nil
+	}
+
+	cfg.Policy = BudgetPolicy(strings.ToLower(envOrDefault("AI_ENGINE_BUDGET_POLICY", string(BudgetPolicyReject))))
+	switch cfg.Policy {
+	case BudgetPolicyEconomy:
+		model := os.Getenv("AI_ENGINE_BUDGET_ECONOMY_MODEL")
+		if model == "" {
+			return cfg, fmt.Errorf("%w: economy 策略需設定 AI_ENGINE_BUDGET_ECONOMY_MODEL", ErrInvalidBudgetConfig)
+		}
+		if cfg.EconomyGenerator, err = openAIGeneratorFromEnv(model); err != nil {
+			return cfg, err
+		}
+	case BudgetPolicyTemplate:
+		if cfg.TemplateGenerator, err = templateGeneratorFromEnv(); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// retryPolicyFromEnv 讀取 AI_ENGINE_RETRY_* 重試設定。
+func retryPolicyFromEnv() (RetryPolicy, error) {
+	maxAttempts, err := strconv.Atoi(envOrDefault("AI_ENGINE_RETRY_MAX_ATTEMPTS", "3"))
+	if err != nil {
+		return RetryPolicy{}, fmt.Errorf("AI_ENGINE_RETRY_MAX_ATTEMPTS 格式錯誤: %w", err)
+	}
+	initialBackoff, err := time.ParseDuration(envOrDefault("AI_ENGINE_RETRY_INITIAL_BACKOFF", "1s"))
+	if err != nil {
+		return RetryPolicy{}, fmt.Errorf("AI_ENGINE_RETRY_INITIAL_BACKOFF 格式錯誤: %w", err)
+	}
+	maxBackoff, err := time.ParseDuration(envOrDefault("AI_ENGINE_RETRY_MAX_BACKOFF", "30s"))
+	if err != nil {
+		return RetryPolicy{}, fmt.Errorf("AI_ENGINE_RETRY_MAX_BACKOFF 格式錯誤: %w", err)
+	}
+	return RetryPolicy{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: initialBackoff,
+		MaxBackoff:     maxBackoff,
+	}, nil
+}
+
+// webhookConfigFromEnv 讀取 AI_ENGINE_WEBHOOK_* 回呼通知設定，URLS 與 ALLOWED_HOSTS 以逗號分隔。
+func webhookConfigFromEnv() (WebhookConfig, error) {
+	var urls []string
+	for _, raw := range strings.Split(os.Getenv("AI_ENGINE_WEBHOOK_URLS"), ",") {
+		if raw = strings.TrimSpace(raw); raw != "" {
+			urls = append(urls, raw)
+		}
+	}
+	urls, err := validateCallbackURLs(urls)
+	if err != nil {
+		return WebhookConfig{}, fmt.Errorf("AI_ENGINE_WEBHOOK_URLS 格式錯誤: %w", err)
+	}
+	maxAttempts, err := strconv.Atoi(envOrDefault("AI_ENGINE_WEBHOOK_MAX_ATTEMPTS", "5"))
+	if err != nil {
+		return WebhookConfig{}, fmt.Errorf("AI_ENGINE_WEBHOOK_MAX_ATTEMPTS 格式錯誤: %w", err)
+	}
+	timeout, err := time.ParseDuration(envOrDefault("AI_ENGINE_WEBHOOK_TIMEOUT", "10s"))
+	if err != nil {
+		return WebhookConfig{}, fmt.Errorf("AI_ENGINE_WEBHOOK_TIMEOUT 格式錯誤: %w", err)
+	}
+	var allowedHosts []string
+	for _, raw := range strings.Split(os.Getenv("AI_ENGINE_WEBHOOK_ALLOWED_HOSTS"), ",") {
+		if raw = strings.TrimSpace(raw); raw != "" {
+			allowedHosts = append(allowedHosts, raw)
+		}
+	}
+	return WebhookConfig{
+		URLs:         urls,
+		Secret:       os.Getenv("AI_ENGINE_WEBHOOK_SECRET"),
+		AllowedHosts: allowedHosts,
+		Retry:        RetryPolicy{MaxAttempts: maxAttempts},
+		Timeout:      timeout,
+	}, nil
+}
+
+// enrichersFromEnv 依設定建立呼叫產生器前收集證據的來源。
+func enrichersFromEnv(changes ChangeRepository, topology TopologyProvider) ([]ContextEnricher, error) {
+	var enrichers []ContextEnricher
+	prometheus, err := prometheusEnricherFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if prometheus != nil {
+		enrichers = append(enrichers, prometheus)
+	}
+	logSources, err := logSourcesFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if len(logSources) > 0 {
+		lookBehind, err := time.ParseDuration(envOrDefault("AI_ENGINE_LOG_LOOKBEHIND", "15m"))
+		if err != nil {
+			return nil, fmt.Errorf("AI_ENGINE_LOG_LOOKBEHIND 格式錯誤: %w", err)
+		}
+		lookAhead, err := time.ParseDuration(envOrDefault("AI_ENGINE_LOG_LOOKAHEAD", "15m"))
+		if err != nil {
+			return nil, fmt.Errorf("AI_ENGINE_LOG_LOOKAHEAD 格式錯誤: %w", err)
+		}
+		maxPatterns, err := strconv.Atoi(envOrDefault("AI_ENGINE_LOG_MAX_PATTERNS", "5"))
+		if err != nil {
+			return nil, fmt.Errorf("AI_ENGINE_LOG_MAX_PATTERNS 格式錯誤: %w", err)
+		}
+		for _, source := range logSources {
+			enrichers = append(enrichers, NewLogEnricher(LogEnricherConfig{
+				Source:      source,
+				LookBehind:  lookBehind,
+				LookAhead:   lookAhead,
+				MaxPatterns: maxPatterns,
+			}))
+		}
+	}
+
+	changeLookBehind, err := time.ParseDuration(envOrDefault("AI_ENGINE_CHANGE_LOOKBEHIND", "2h"))
+	if err != nil {
+		return nil, fmt.Errorf("AI_ENGINE_CHANGE_LOOKBEHIND 格式錯誤: %w", err)
+	}
+	enrichers = append(enrichers, NewChangeCorrelator(ChangeCorrelatorConfig{
+		Changes:    changes,
+		Topology:   topology,
+		LookBehind: changeLookBehind,
+	}))
+	return enrichers, nil
+}
+
+// relatedEventsFromEnv 讀取 AI_ENGINE_EVENTS_URL (主後端 API 根路徑) 與 AI_ENGINE_EVENTS_TOKEN，未設定時回傳 nil。
+func relatedEventsFromEnv() (RelatedEventsProvider, error) {
+	baseURL := os.Getenv("AI_ENGINE_EVENTS_URL")
+	if baseURL == "" {
+		return nil, nil
+	}
+	return NewHTTPRelatedEventsProvider(HTTPRelatedEventsProviderConfig{
+		BaseURL:     baseURL,
+		BearerToken: os.Getenv("AI_ENGINE_EVENTS_TOKEN"),
+	})
+}
+
+// topologyFromEnv 讀取 AI_ENGINE_TOPOLOGY_* 設定；AI_ENGINE_TOPOLOGY_PATH 為 JSON 快照，優先於 API，皆未設定時回傳 nil。
+func topologyFromEnv() (TopologyProvider, error) {
+	if path := os.Getenv("AI_ENGINE_TOPOLOGY_PATH"); path != "" {
+		graph, err := LoadTopologyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return StaticTopologyProvider{Graph: graph}, nil
+	}
+	baseURL := os.Getenv("AI_ENGINE_TOPOLOGY_URL")
+	if baseURL == "" {
+		return nil, nil
+	}
+	cacheTTL, err := time.ParseDuration(envOrDefault("AI_ENGINE_TOPOLOGY_CACHE_TTL", "5m"))
+	if err != nil {
+		return nil, fmt.Errorf("AI_ENGINE_TOPOLOGY_CACHE_TTL 格式錯誤: %w", err)
+	}
+	return NewHTTPTopologyProvider(HTTPTopologyProviderConfig{
+		BaseURL:     baseURL,
+		BearerToken: os.Getenv("AI_ENGINE_TOPOLOGY_TOKEN"),
+		CacheTTL:    cacheTTL,
+	})
+}
+
+// logSourcesFromEnv 讀取 AI_ENGINE_LOKI_* 與 AI_ENGINE_ELASTICSEARCH_* 設定，未設定 URL 的來源不啟用。
+func logSourcesFromEnv() ([]LogSource, error) {
+	var sources []LogSource
+	if baseURL := os.Getenv("AI_ENGINE_LOKI_URL"); baseURL != "" {
+		loki, err := NewLokiSource(LokiSourceConfig{
+			BaseURL:       baseURL,
+			TenantID:      os.Getenv("AI_ENGINE_LOKI_TENANT_ID"),
+			BearerToken:   os.Getenv("AI_ENGINE_LOKI_BEARER_TOKEN"),
+			ServiceLabel:  os.Getenv("AI_ENGINE_LOKI_SERVICE_LABEL"),
+			LineFilter:    os.Getenv("AI_ENGINE_LOKI_LINE_FILTER"),
+			ExploreURL:    os.Getenv("AI_ENGINE_LOKI_GRAFANA_URL"),
+			DatasourceUID: os.Getenv("AI_ENGINE_LOKI_DATASOURCE_UID"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, loki)
+	}
+	if baseURL := os.Getenv("AI_ENGINE_ELASTICSEARCH_URL"); baseURL != "" {
+		cfg := ElasticsearchSourceConfig{
+			BaseURL:        baseURL,
+			Index:          os.Getenv("AI_ENGINE_ELASTICSEARCH_INDEX"),
+			Username:       os.Getenv("AI_ENGINE_ELASTICSEARCH_USERNAME"),
+			Password:       os.Getenv("AI_ENGINE_ELASTICSEARCH_PASSWORD"),
+			APIKey:         os.Getenv("AI_ENGINE_ELASTICSEARCH_API_KEY"),
+			TimestampField: os.Getenv("AI_ENGINE_ELASTICSEARCH_TIMESTAMP_FIELD"),
+			MessageField:   os.Getenv("AI_ENGINE_ELASTICSEARCH_MESSAGE_FIELD"),
+			LevelField:     os.Getenv("AI_ENGINE_ELASTICSEARCH_LEVEL_FIELD"),
+			ServiceField:   os.Getenv("AI_ENGINE_ELASTICSEARCH_SERVICE_FIELD"),
+			DiscoverURL:    os.Getenv("AI_ENGINE_ELASTICSEARCH_KIBANA_URL"),
+		}
+		for _, level := range strings.Split(os.Getenv("AI_ENGINE_ELASTICSEARCH_LEVELS"), ",") {
+			if level = strings.TrimSpace(level); level != "" {
+				cfg.Levels = append(cfg.Levels, level)
+			}
+		}
+		elasticsearch, err := NewElasticsearchSource(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, elasticsearch)
+	}
+	return sources, nil
+}
+
+// prometheusEnricherFromEnv 讀取 AI_ENGINE_PROMETHEUS_* 設定，未設定 URL 時回傳 nil 代表不查詢指標。
+// 查詢模板檔案格式見 LoadMetricQueries，未設定時使用內建的黃金指標查詢。
+func prometheusEnricherFromEnv() (*PrometheusEnricher, error) {
+	baseURL := os.Getenv("AI_ENGINE_PROMETHEUS_URL")
+	if baseURL == "" {
+		return nil, nil
+	}
+	lookBehind, err := time.ParseDuration(envOrDefault("AI_ENGINE_PROMETHEUS_LOOKBEHIND", "1h"))
+	if err != nil {
+		return nil, fmt.Errorf("AI_ENGINE_PROMETHEUS_LOOKBEHIND 格式錯誤: %w", err)
+	}
+	lookAhead, err := time.ParseDuration(envOrDefault("AI_ENGINE_PROMETHEUS_LOOKAHEAD", "30m"))
+	if err != nil {
+		return nil, fmt.Errorf("AI_ENGINE_PROMETHEUS_LOOKAHEAD 格式錯誤: %w", err)
+	}
+	cfg := PrometheusEnricherConfig{
+		BaseURL:       baseURL,
+		GraphURL:      os.Getenv("AI_ENGINE_PROMETHEUS_GRAPH_URL"),
+		BearerToken:   os.Getenv("AI_ENGINE_PROMETHEUS_BEARER_TOKEN"),
+		ResourceLabel: os.Getenv("AI_ENGINE_PROMETHEUS_RESOURCE_LABEL"),
+		LookBehind:    lookBehind,
+		LookAhead:     lookAhead,
+	}
+	for _, label := range strings.Split(os.Getenv("AI_ENGINE_PROMETHEUS_SELECTOR_LABELS"), ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			cfg.SelectorLabels = append(cfg.SelectorLabels, label)
+		}
+	}
+	if path := os.Getenv("AI_ENGINE_PROMETHEUS_QUERIES_PATH"); path != "" {
+		if cfg.Queries, err = LoadMetricQueries(path); err != nil {
+			return nil, err
+		}
+	}
+	return NewPrometheusEnricher(cfg)
+}
+
+// authenticatorFromEnv 讀取 AI_ENGINE_JWT_* 驗證設定，未設定任何金鑰時回傳 nil 代表停用驗證。
+func authenticatorFromEnv() (*Authenticator, error) {
+	leeway, err := time.ParseDuration(envOrDefault("AI_ENGINE_JWT_LEEWAY", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("AI_ENGINE_JWT_LEEWAY 格式錯誤: %w", err)
+	}
+	refresh, err := time.ParseDuration(envOrDefault("AI_ENGINE_JWT_JWKS_REFRESH_INTERVAL", "1h"))
+	if err != nil {
+		return nil, fmt.Errorf("AI_ENGINE_JWT_JWKS_REFRESH_INTERVAL 格式錯誤: %w", err)
+	}
+	auth, err := NewAuthenticator(AuthConfig{
+		HS256Secret:         os.Getenv("AI_ENGINE_JWT_HS256_SECRET"),
+		JWKSFile:            os.Getenv("AI_ENGINE_JWT_JWKS_FILE"),
+		JWKSURL:             os.Getenv("AI_ENGINE_JWT_JWKS_URL"),
+		JWKSRefreshInterval: refresh,
+		Issuer:              os.Getenv("AI_ENGINE_JWT_ISSUER"),
+		Audience:            os.Getenv("AI_ENGINE_JWT_AUDIENCE"),
+		RolesClaim:          os.Getenv("AI_ENGINE_JWT_ROLES_CLAIM"),
+		TeamsClaim:          os.Getenv("AI_ENGINE_JWT_TEAMS_CLAIM"),
+		Leeway:              leeway,
+	})
+	if errors.Is(err, ErrAuthNotConfigured) {
+		return nil, nil
+	}
+	return auth, err
+}
+
+// rateLimiterFromEnv 讀取 AI_ENGINE_RATE_LIMIT_* 限流設定 (格式如 10/1m)，皆未設定時回傳 nil。
+// 使用者與團隊限流依 JWT 身分計算，未啟用驗證時設定兩者視為錯誤，未驗證的請求僅能以 GLOBAL 限流。
+// 設定 AI_ENGINE_RATE_LIMIT_REDIS_URL 時以 Redis 保存額度，讓多個副本共用。
+func rateLimiterFromEnv(authEnabled bool) (*RateLimiter, func(), error) {
+	var cfg RateLimitConfig
+	for _, item := range []struct {
+		key   string
+		limit *RateLimit
+	}{
+		{"AI_ENGINE_RATE_LIMIT_USER", &cfg.User},
+		{"AI_ENGINE_RATE_LIMIT_TEAM", &cfg.Team},
+		{"AI_ENGINE_RATE_LIMIT_GLOBAL", &cfg.Global},
+	} {
+		limit, err := ParseRateLimit(os.Getenv(item.key))
+		if err != nil {
+			return nil, func() {}, fmt.Errorf("%s 格式錯誤: %w", item.key, err)
+		}
+		*item.limit = limit
+	}
+	if !authEnabled && cfg.RequiresAuth() {
+		return nil, func() {}, fmt.Errorf("%w: AI_ENGINE_RATE_LIMIT_USER 與 AI_ENGINE_RATE_LIMIT_TEAM 需啟用 JWT 驗證，未驗證的請求請改用 AI_ENGINE_RATE_LIMIT_GLOBAL", ErrInvalidRateLimit)
+	}
+
+	closeStore := func() {}
+	if redisURL := os.Getenv("AI_ENGINE_RATE_LIMIT_REDIS_URL"); redisURL != "" {
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return nil, closeStore, fmt.Errorf("AI_ENGINE_RATE_LIMIT_REDIS_URL 格式錯誤: %w", err)
+		}
+		client := redis.NewClient(opts)
+		cfg.Store = NewRedisRateLimitStore(client, os.Getenv("AI_ENGINE_RATE_LIMIT_REDIS_PREFIX"))
+		closeStore = func() { _ = client.Close() }
+	}
+	return NewRateLimiter(cfg), closeStore, nil
+}
+
+// tracingConfigFromEnv 讀取 AI_ENGINE_TRACING_* 追蹤設定，預設不匯出。
+func tracingConfigFromEnv() (TracingConfig, error) {
+	insecure, err := strconv.ParseBool(envOrDefault("AI_ENGINE_TRACING_INSECURE", "false"))
+	if err != nil {
+		return TracingConfig{}, fmt.Errorf("AI_ENGINE_TRACING_INSECURE 格式錯誤: %w", err)
+	}
+	ratio, err := strconv.ParseFloat(envOrDefault("AI_ENGINE_TRACING_SAMPLE_RATIO", "1"), 64)
+	if err != nil {
+		return TracingConfig{}, fmt.Errorf("AI_ENGINE_TRACING_SAMPLE_RATIO 格式錯誤: %w", err)
+	}
+	return TracingConfig{
+		Exporter:    envOrDefault("AI_ENGINE_TRACING_EXPORTER", TracingExporterNone),
+		Endpoint:    os.Getenv("AI_ENGINE_TRACING_ENDPOINT"),
+		Insecure:    insecure,
+		SampleRatio: ratio,
+		ServiceName: envOrDefault("OTEL_SERVICE_NAME", defaultServiceName),
+	}, nil
+}
 
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("服務啟動失敗: %v", err)
+func envOrDefault(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
 	}
+	return fallback
 }
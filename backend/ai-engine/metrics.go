@@ -0,0 +1,234 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "ai_engine"
+
+// Metrics 收集分析服務與 HTTP 層的 Prometheus 指標，使用獨立的 registry 以便測試隔離。
+type Metrics struct {
+	registry *prometheus.Registry
+
+	reportsCreated    prometheus.Counter
+	reportsSucceeded  prometheus.Counter
+	reportsFailed     prometheus.Counter
+	reportsCancelled  prometheus.Counter
+	reportsConflicted prometheus.Counter
+	reportsTimedOut   prometheus.Counter
+
+	generationDuration *prometheus.HistogramVec
+	queueWait          prometheus.Histogram
+	inFlight           prometheus.Gauge
+	confidenceScore    prometheus.Histogram
+
+	rateLimited *prometheus.CounterVec
+	// rateLimitErrors 計數限流儲存無法使用而放行 (fail-open) 的請求。
+	rateLimitErrors prometheus.Counter
+	llmTokens       *prometheus.CounterVec
+	llmCost         *prometheus.CounterVec
+	// budgetExceeded 依處理結果 (economy、template 或 rejected) 計數預算用盡的請求。
+	budgetExceeded *prometheus.CounterVec
+	// enrichmentDuration 依來源與結果記錄收集事件證據的耗時。
+	enrichmentDuration *prometheus.HistogramVec
+
+	httpRequests        *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
+}
+
+// NewMetrics 建立並註冊所有指標，包含 Go runtime 與 process 指標。
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		reportsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reports_created_total",
+			Help:      "已建立並排入佇列的分析報告數。",
+		}),
+		reportsSucceeded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reports_succeeded_total",
+			Help:      "分析成功的報告數。",
+		}),
+		reportsFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reports_failed_total",
+			Help:      "分析失敗的報告數。",
+		}),
+		reportsCancelled: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reports_cancelled_total",
+			Help:      "被取消的報告數。",
+		}),
+		reportsConflicted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reports_conflicted_total",
+			Help:      "因報告已存在或仍在處理中而被拒絕的請求數。",
+		}),
+		reportsTimedOut: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reports_timed_out_total",
+			Help:      "最後一次嘗試超過處理逾時而失敗的報告數；逐次嘗試的逾時見 generation_duration_seconds{outcome=\"timeout\"}。",
+		}),
+		generationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "generation_duration_seconds",
+			Help:      "單次產生器呼叫的耗時。",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120},
+		}, []string{"outcome"}),
+		queueWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "queue_wait_seconds",
+			Help:      "報告自排入佇列至開始處理的等待時間。",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300},
+		}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "reports_in_flight",
+			Help:      "目前正在分析中的報告數。",
+		}),
+		confidenceScore: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "report_confidence_score",
+			Help:      "成功報告的根本原因信心分數分布。",
+			Buckets:   []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1},
+		}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rate_limited_total",
+			Help:      "因超過限流而被拒絕的分析建立請求數。",
+		}, []string{"scope"}),
+		rateLimitErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rate_limit_errors_total",
+			Help:      "限流儲存無法使用而直接放行的分析建立請求數。",
+		}),
+		llmTokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "llm_tokens_total",
+			Help:      "產生器呼叫 (含失敗與重試的嘗試) 所使用的 LLM token 數。",
+		}, []string{"model", "type"}),
+		llmCost: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "llm_cost_usd_total",
+			Help:      "依價格表計算的 LLM 成本 (USD)。",
+		}, []string{"model"}),
+		budgetExceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "budget_exceeded_total",
+			Help:      "團隊預算用盡時降級或拒絕的分析請求數。",
+		}, []string{"outcome"}),
+		enrichmentDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "enrichment_duration_seconds",
+			Help:      "自外部系統收集事件證據的耗時。",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15, 30},
+		}, []string{"enricher", "outcome"}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP 請求數。",
+		}, []string{"method", "route", "status"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP 請求處理時間。",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.reportsCreated,
+		m.reportsSucceeded,
+		m.reportsFailed,
+		m.reportsCancelled,
+		m.reportsConflicted,
+		m.reportsTimedOut,
+		m.generationDuration,
+		m.queueWait,
+		m.inFlight,
+		m.confidenceScore,
+		m.rateLimited,
+		m.rateLimitErrors,
+		m.llmTokens,
+		m.llmCost,
+		m.budgetExceeded,
+		m.enrichmentDuration,
+		m.httpRequests,
+		m.httpRequestDuration,
+	)
+	return m
+}
+
+// Registry 回傳指標使用的 registry，供額外的收集器註冊。
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler 回傳 Prometheus 文字格式的指標輸出。
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware 記錄每個 HTTP 請求的次數與耗時，route 使用路由樣板以避免高基數標籤。
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		m.httpRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		m.httpRequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// 產生器呼叫結果標籤。
+const (
+	generationOutcomeSuccess = "success"
+	generationOutcomeError   = "error"
+	generationOutcomeTimeout = "timeout"
+)
+
+// observeGeneration 記錄單次產生器呼叫的耗時與結果。
+func (m *Metrics) observeGeneration(elapsed time.Duration, outcome string) {
+	m.generationDuration.WithLabelValues(outcome).Observe(elapsed.Seconds())
+}
+
+// observeUsage 累計 token 與成本，模型數量有限，可作為標籤。
+func (m *Metrics) observeUsage(usage LLMUsage) {
+	model := usage.Model
+	if model == "" {
+		model = usageUnknownKey
+	}
+	m.llmTokens.WithLabelValues(model, "prompt").Add(float64(usage.PromptTokens))
+	m.llmTokens.WithLabelValues(model, "completion").Add(float64(usage.CompletionTokens))
+	m.llmCost.WithLabelValues(model).Add(usage.CostUSD)
+}
+
+// observeTransition 依報告進入的狀態更新結果計數。
+func (m *Metrics) observeTransition(report AnalysisReport) {
+	switch report.Status {
+	case ReportStatusSuccess:
+		m.reportsSucceeded.Inc()
+		if report.RootCauseAnalysis != nil {
+			m.confidenceScore.Observe(report.RootCauseAnalysis.ConfidenceScore)
+		}
+	case ReportStatusFailed:
+		m.reportsFailed.Inc()
+	case ReportStatusCancelled:
+		m.reportsCancelled.Inc()
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// slowGenerator 在 context 結束前不會回傳，用於觸發處理逾時。
+type slowGenerator struct{}
+
+func (slowGenerator) Generate(ctx context.Context, _ GenerationInput) (*GeneratedReport, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestAnalysisMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	metrics := NewMetrics()
+	service := NewAnalysisService(NewInMemoryReportRepository(), &stubGenerator{result: &GeneratedReport{
+		RootCauseAnalysis: RootCauseAnalysis{Text: "磁碟已滿", ConfidenceScore: 0.85},
+	}}, AnalysisServiceConfig{ProcessingTimeout: time.Second, Metrics: metrics})
+	router := SetupRouter(service)
+
+	for _, eventID := range []string{"evt-m1", "evt-m1"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/events/"+eventID+"/ai-analysis", nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		service.Wait()
+	}
+
+	if got := testutil.ToFloat64(metrics.reportsCreated); got != 1 {
+		t.Fatalf("建立數應為 1，實際為 %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.reportsConflicted); got != 1 {
+		t.Fatalf("衝突數應為 1，實際為 %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.reportsSucceeded); got != 1 {
+		t.Fatalf("成功數應為 1，實際為 %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.inFlight); got != 0 {
+		t.Fatalf("完成後執行中數量應為 0，實際為 %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.httpRequests.WithLabelValues(http.MethodPost, "/api/v1/events/:eventId/ai-analysis", "409")); got != 1 {
+		t.Fatalf("HTTP 409 計數應為 1，實際為 %v", got)
+	}
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/v1/metrics", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("指標端點應回傳 200，實際為 %d", resp.Code)
+	}
+	body := resp.Body.String()
+	for _, name := range []string{
+		"ai_engine_reports_created_total 1",
+		"ai_engine_generation_duration_seconds_count{outcome=\"success\"} 1",
+		"ai_engine_queue_wait_seconds_count 1",
+		"ai_engine_report_confidence_score_bucket{le=\"0.9\"} 1",
+		"ai_engine_http_request_duration_seconds_count",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, name) {
+			t.Fatalf("指標輸出缺少 %q", name)
+		}
+	}
+}
+
+func TestAnalysisMetricsTimeoutAndFailure(t *testing.T) {
+	metrics := NewMetrics()
+	service := NewAnalysisService(NewInMemoryReportRepository(), slowGenerator{}, AnalysisServiceConfig{
+		ProcessingTimeout: 10 * time.Millisecond,
+		Retry:             fastRetryPolicy(2),
+		Metrics:           metrics,
+	})
+	if _, err := service.CreateReport(context.Background(), "evt-timeout", CreateAnalysisRequest{}); err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	service.Wait()
+
+	if got := testutil.ToFloat64(metrics.reportsTimedOut); got != 1 {
+		t.Fatalf("逾時應以報告為單位計數一次，實際為 %v", got)
+	}
+	families, err := metrics.registry.Gather()
+	if err != nil {
+		t.Fatalf("收集指標失敗: %v", err)
+	}
+	var timedOutAttempts uint64
+	for _, family := range families {
+		if family.GetName() == "ai_engine_generation_duration_seconds" {
+			for _, metric := range family.GetMetric() {
+				timedOutAttempts += metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	if timedOutAttempts != 2 {
+		t.Fatalf("每次逾時的嘗試應記錄於 generation_duration_seconds，實際為 %d", timedOutAttempts)
+	}
+	if got := testutil.ToFloat64(metrics.reportsFailed); got != 1 {
+		t.Fatalf("失敗數應為 1，實際為 %v", got)
+	}
+
+	// 一般錯誤不計為逾時。
+	metrics = NewMetrics()
+	service = NewAnalysisService(NewInMemoryReportRepository(), &stubGenerator{err: errors.New("boom")}, AnalysisServiceConfig{Metrics: metrics})
+	service.CreateReport(context.Background(), "evt-error", CreateAnalysisRequest{})
+	service.Wait()
+	if testutil.ToFloat64(metrics.reportsTimedOut) != 0 || testutil.ToFloat64(metrics.reportsFailed) != 1 {
+		t.Fatalf("一般錯誤的計數錯誤")
+	}
+}
@@ -13,6 +13,8 @@ const (
 	ReportStatusRunning ReportStatus = "RUNNING"
 	ReportStatusSuccess ReportStatus = "SUCCESS"
 	ReportStatusFailed  ReportStatus = "FAILED"
+	// ReportStatusCancelled 代表分析已由操作人員取消。
+	ReportStatusCancelled ReportStatus = "CANCELLED"
 )
 
 // EvidenceLink 描述可供驗證的外部連結。
@@ -44,6 +46,10 @@ type AffectedResource struct {
 	Name string `json:"name"`
 	Type string `json:"type,omitempty"`
 	Role string `json:"role,omitempty"`
+	// Depth 為距事件資源的拓撲層數，事件資源本身為 0。
+	Depth int `json:"depth,omitempty"`
+	// Status 沿用拓撲節點的狀態 (healthy、warning、critical 或 offline)，供影響評估判斷資源是否已受影響。
+	Status string `json:"status,omitempty"`
 }
 
 // ImpactAssessment 描述事件影響範圍。
@@ -68,7 +74,9 @@ type RecommendedAction struct {
 type AnalysisReport struct {
 	ReportID           string              `json:"report_id"`
 	EventID            string              `json:"event_id"`
+	Version            int                 `json:"version"`
 	Status             ReportStatus        `json:"status"`
+	EventContext       map[string]any      `json:"event_context,omitempty"`
 	EventSummary       string              `json:"event_summary,omitempty"`
 	RootCauseAnalysis  *RootCauseAnalysis  `json:"root_cause_analysis,omitempty"`
 	ImpactAssessment   *ImpactAssessment   `json:"impact_assessment,omitempty"`
@@ -78,7 +86,28 @@ type AnalysisReport struct {
 	CreatedAt          time.Time           `json:"created_at"`
 	UpdatedAt          time.Time           `json:"updated_at"`
 	CompletedAt        *time.Time          `json:"completed_at,omitempty"`
-	RawLLMResponse     json.RawMessage     `json:"raw_llm_response,omitempty"`
+	CancelledBy        string              `json:"cancelled_by,omitempty"`
+	CancelledAt        *time.Time          `json:"cancelled_at,omitempty"`
+	AttemptCount       int                 `json:"attempt_count,omitempty"`
+	Attempts           []AnalysisAttempt   `json:"attempts,omitempty"`
+	// CallbackURLs 與 WebhookDeliveries 僅保存在儲存庫，不出現在 API 回應與回呼內容，
+	// 避免訂閱者或其他讀取者看到彼此的回呼網址與傳送紀錄。
+	CallbackURLs      []string          `json:"-"`
+	WebhookDeliveries []WebhookDelivery `json:"-"`
+	// TraceID 為建立報告請求的 OpenTelemetry trace ID，供串接請求與背景分析的追蹤。
+	TraceID string `json:"trace_id,omitempty"`
+	// RequestedBy 為建立報告的使用者，未啟用驗證時為空。
+	RequestedBy string `json:"requested_by,omitempty"`
+	// Team 為建立者所屬的第一個團隊，供成本彙總使用。
+	Team string `json:"team,omitempty"`
+	// Tier 為依團隊預算選用的模型等級 (primary、economy 或 template)。
+	Tier string `json:"tier,omitempty"`
+	// Usage 為成功產生報告時的模型、token 用量、耗時與成本。
+	Usage          *LLMUsage       `json:"usage,omitempty"`
+	RawLLMResponse json.RawMessage `json:"raw_llm_response,omitempty"`
+	// QueuePosition 與 QueueDepth 為查詢當下的佇列狀態，不會寫入儲存庫。
+	QueuePosition int `json:"queue_position,omitempty"`
+	QueueDepth    int `json:"queue_depth,omitempty"`
 }
 
 // Clone 建立報告的深拷貝，避免外部修改內部狀態。
@@ -89,6 +118,14 @@ func (r *AnalysisReport) Clone() AnalysisReport {
 
 	clone := *r
 
+	if r.EventContext != nil {
+		contextCopy := make(map[string]any, len(r.EventContext))
+		for k, v := range r.EventContext {
+			contextCopy[k] = v
+		}
+		clone.EventContext = contextCopy
+	}
+
 	if r.RootCauseAnalysis != nil {
 		rootCopy := *r.RootCauseAnalysis
 		rootCopy.ProbableCauses = append([]string(nil), r.RootCauseAnalysis.ProbableCauses...)
@@ -109,14 +146,43 @@ func (r *AnalysisReport) Clone() AnalysisReport {
 		clone.RawLLMResponse = append(json.RawMessage(nil), r.RawLLMResponse...)
 	}
 
+	if r.Usage != nil {
+		usage := *r.Usage
+		clone.Usage = &usage
+	}
+
 	if r.CompletedAt != nil {
 		completed := *r.CompletedAt
 		clone.CompletedAt = &completed
 	}
 
+	if r.CancelledAt != nil {
+		cancelled := *r.CancelledAt
+		clone.CancelledAt = &cancelled
+	}
+
+	clone.Attempts = append([]AnalysisAttempt(nil), r.Attempts...)
+	for i, attempt := range clone.Attempts {
+		if attempt.Usage != nil {
+			usage := *attempt.Usage
+			clone.Attempts[i].Usage = &usage
+		}
+	}
+	clone.CallbackURLs = append([]string(nil), r.CallbackURLs...)
+	clone.WebhookDeliveries = append([]WebhookDelivery(nil), r.WebhookDeliveries...)
+
 	return clone
 }
 
+// recordAttempt 追加一次產生器嘗試並同步更新嘗試次數與累計用量。
+func (r *AnalysisReport) recordAttempt(attempt AnalysisAttempt) {
+	r.Attempts = append(r.Attempts, attempt)
+	r.AttemptCount = len(r.Attempts)
+	if attempt.Usage != nil {
+		r.Usage = totalUsage(r.Attempts)
+	}
+}
+
 func cloneEvidence(items []EvidenceItem) []EvidenceItem {
 	if len(items) == 0 {
 		return nil
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLLMRequestTimeout = 60 * time.Second
+	defaultLLMProvider       = "openai"
+	maxLLMErrorBodyBytes     = 2048
+	// maxLLMResponseBytes 為 LLM 回應內容的讀取上限。報告 JSON 連同 usage 通常不超過數十 KB，
+	// 4 MiB 足以容納長篇回應，同時避免異常端點以超大回應耗盡記憶體。
+	maxLLMResponseBytes = 4 << 20
+)
+
+var (
+	// ErrLLMEndpointRequired 代表未設定 LLM 端點位址。
+	ErrLLMEndpointRequired = errors.New("llm endpoint is required")
+	// ErrLLMModelRequired 代表未設定 LLM 模型名稱。
+	ErrLLMModelRequired = errors.New("llm model is required")
+	// ErrLLMEmptyResponse 代表 LLM 回應中沒有任何可用內容。
+	ErrLLMEmptyResponse = errors.New("llm returned empty response")
+	// ErrLLMInvalidResponse 代表 LLM 回應內容無法解析為報告格式。
+	ErrLLMInvalidResponse = errors.New("llm returned invalid report payload")
+	// ErrLLMResponseTooLarge 代表 LLM 回應超過讀取上限；重新呼叫多半得到同樣的結果，因此不重試。
+	ErrLLMResponseTooLarge = errors.New("llm response exceeds size limit")
+)
+
+// LLMStatusError 描述 LLM 端點回傳的非 2xx 狀態。
+type LLMStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *LLMStatusError) Error() string {
+	return fmt.Sprintf("llm endpoint returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// OpenAIGeneratorConfig 設定 OpenAI 相容 chat-completions 端點。
+type OpenAIGeneratorConfig struct {
+	// BaseURL 為 API 根路徑，例如 https://api.openai.com/v1。
+	BaseURL string
+	// Provider 為成本統計使用的供應商名稱，預設 openai。
+	Provider    string
+	APIKey      string
+	Model       string
+	Temperature float64
+	MaxTokens   int
+	// Timeout 僅在未提供 HTTPClient 時套用。
+	Timeout    time.Duration
+	HTTPClient *http.Client
+}
+
+// OpenAIReportGenerator 透過 OpenAI 相容的 chat-completions API 產生報告。
+type OpenAIReportGenerator struct {
+	endpoint       string
+	modelsEndpoint string
+	provider       string
+	apiKey         string
+	model          string
+	temperature    float64
+	maxTokens      int
+	client         *http.Client
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatResponseFormat struct {
+	Type string `json:"type"`
+}
+
+type chatCompletionRequest struct {
+	Model          string              `json:"model"`
+	Messages       []chatMessage       `json:"messages"`
+	Temperature    float64             `json:"temperature"`
+	MaxTokens      int                 `json:"max_tokens,omitempty"`
+	ResponseFormat *chatResponseFormat `json:"response_format,omitempty"`
+}
+
+type chatCompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type chatCompletionResponse struct {
+	// Model 為實際回應的模型版本，可能比請求的模型名稱更精確。
+	Model   string `json:"model"`
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Usage *chatCompletionUsage `json:"usage"`
+}
+
+const analysisSystemPrompt = `你是一位資深 SRE，負責針對監控事件產出根本原因分析報告。
+請僅輸出單一 JSON 物件，不要包含任何額外說明文字，格式如下：
+{
+  "event_summary": "事件摘要",
+  "root_cause_analysis": {
+    "text": "根本原因說明",
+    "confidence_score": 0.0,
+    "probable_causes": ["可能原因"],
+    "evidence": [{"type": "METRIC|LOG|TRACE|CHANGE", "description": "證據描述", "metadata": {}}]
+  },
+  "impact_assessment": {
+    "text": "影響說明",
+    "affected_resources": [{"id": "資源編號", "name": "資源名稱", "type": "資源類型", "role": "角色"}],
+    "user_impact": "使用者影響",
+    "duration_minutes": 0,
+    "severity": "LOW|MEDIUM|HIGH|CRITICAL"
+  },
+  "recommended_actions": [{"title": "建議標題", "action_type": "MANUAL|AUTOMATION|WORKFLOW", "risk": "LOW|MEDIUM|HIGH", "summary": "說明", "action_data": {}}],
+  "evidence": [{"type": "METRIC|LOG|TRACE|CHANGE", "description": "證據描述", "metadata": {}}]
+}
+confidence_score 介於 0 與 1 之間；若資訊不足，請降低信心分數並說明需要補充的資料。`
+
+// NewOpenAIReportGenerator 建立呼叫 OpenAI 相容端點的產生器。
+func NewOpenAIReportGenerator(cfg OpenAIGeneratorConfig) (*OpenAIReportGenerator, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if baseURL == "" {
+		return nil, ErrLLMEndpointRequired
+	}
+	if strings.TrimSpace(cfg.Model) == "" {
+		return nil, ErrLLMModelRequired
+	}
+
+	client := cfg.HTTPClient
+	if client == nil {
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = defaultLLMRequestTimeout
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	provider := strings.TrimSpace(cfg.Provider)
+	if provider == "" {
+		provider = defaultLLMProvider
+	}
+
+	return &OpenAIReportGenerator{
+		endpoint:       baseURL + "/chat/completions",
+		modelsEndpoint: baseURL + "/models",
+		provider:       provider,
+		apiKey:         cfg.APIKey,
+		model:          cfg.Model,
+		temperature:    cfg.Temperature,
+		maxTokens:      cfg.MaxTokens,
+		client:         client,
+	}, nil
+}
+
+// Generate 將事件資料組成提示詞並解析 LLM 回傳的 JSON 報告。
+func (g *OpenAIReportGenerator) Generate(ctx context.Context, input GenerationInput) (*GeneratedReport, error) {
+	userPrompt, err := buildAnalysisPrompt(input)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(chatCompletionRequest{
+		Model: g.model,
+		Messages: []chatMessage{
+			{Role: "system", Content: analysisSystemPrompt},
+			{Role: "user", Content: userPrompt},
+		},
+		Temperature:    g.temperature,
+		MaxTokens:      g.maxTokens,
+		ResponseFormat: &chatResponseFormat{Type: "json_object"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("無法編碼 LLM 請求: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("無法建立 LLM 請求: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+g.apiKey)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("呼叫 LLM 端點失敗: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxLLMResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("讀取 LLM 回應失敗: %w", err)
+	}
+	if len(raw) > maxLLMResponseBytes {
+		return nil, fmt.Errorf("%w: 回應超過 %d 位元組上限", ErrLLMResponseTooLarge, maxLLMResponseBytes)
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		snippet := raw
+		if len(snippet) > maxLLMErrorBodyBytes {
+			snippet = snippet[:maxLLMErrorBodyBytes]
+		}
+		return nil, &LLMStatusError{StatusCode: resp.StatusCode, Body: string(snippet)}
+	}
+
+	report, err := parseChatCompletion(raw)
+	if err != nil {
+		var usageErr *UsageError
+		if errors.As(err, &usageErr) {
+			g.labelUsage(&usageErr.Usage)
+		}
+		return nil, err
+	}
+	g.labelUsage(report.Usage)
+	return report, nil
+}
+
+// labelUsage 補上提供者名稱，回應未帶模型時使用設定的模型。
+func (g *OpenAIReportGenerator) labelUsage(usage *LLMUsage) {
+	usage.Provider = g.provider
+	if usage.Model == "" {
+		usage.Model = g.model
+	}
+}
+
+func buildAnalysisPrompt(input GenerationInput) (string, error) {
+	var builder strings.Builder
+	builder.WriteString("請分析以下事件並依指定 JSON 格式回覆。\n")
+	fmt.Fprintf(&builder, "事件編號: %s\n", input.EventID)
+
+	eventContext := input.EventContext
+	if input.Incident != nil && eventContext[relatedEventsContextKey] != nil {
+		// 關聯事件另於事故段落列出，避免重複佔用提示詞。
+		eventContext = maps.Clone(eventContext)
+		delete(eventContext, relatedEventsContextKey)
+	}
+	if len(eventContext) > 0 {
+		contextJSON, err := json.MarshalIndent(eventContext, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("無法編碼事件上下文: %w", err)
+		}
+		builder.WriteString("事件上下文:\n")
+		builder.Write(contextJSON)
+		builder.WriteString("\n")
+	} else {
+		builder.WriteString("事件上下文: (未提供)\n")
+	}
+
+	if len(input.Evidence) > 0 {
+		evidenceJSON, err := json.MarshalIndent(input.Evidence, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("無法編碼已收集的證據: %w", err)
+		}
+		builder.WriteString("已收集的證據 (請據此推論並於 evidence 中引用):\n")
+		builder.Write(evidenceJSON)
+		builder.WriteString("\n")
+	}
+
+	if input.Incident != nil {
+		incidentJSON, err := json.MarshalIndent(input.Incident, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("無法編碼關聯事件: %w", err)
+		}
+		fmt.Fprintf(&builder, "以下 %d 個關聯事件與本事件屬於同一事故，請將整體事故納入根因分析，而非僅分析第一個告警:\n", len(input.Incident.Events))
+		builder.Write(incidentJSON)
+		builder.WriteString("\n")
+	}
+
+	if input.Impact != nil {
+		impactJSON, err := json.MarshalIndent(input.Impact, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("無法編碼影響範圍: %w", err)
+		}
+		builder.WriteString("依服務拓撲計算的影響範圍 (affected_resources 與 user_impact 將以此為準，請據此評估影響):\n")
+		builder.Write(impactJSON)
+		builder.WriteString("\n")
+	}
+
+	return builder.String(), nil
+}
+
+func parseChatCompletion(raw []byte) (*GeneratedReport, error) {
+	var completion chatCompletionResponse
+	if err := json.Unmarshal(raw, &completion); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLLMInvalidResponse, err)
+	}
+	usage := LLMUsage{Model: completion.Model}
+	if completion.Usage != nil {
+		usage.PromptTokens = completion.Usage.PromptTokens
+		usage.CompletionTokens = completion.Usage.CompletionTokens
+		usage.TotalTokens = completion.Usage.TotalTokens
+		if usage.TotalTokens == 0 {
+			usage.TotalTokens = completion.Usage.PromptTokens + completion.Usage.CompletionTokens
+		}
+	}
+
+	report, err := parseCompletionContent(completion)
+	if err != nil {
+		// 內容無法使用時 token 仍已計費，隨錯誤回傳用量。
+		return nil, &UsageError{Usage: usage, Err: err}
+	}
+	report.RawLLMResponse = append(json.RawMessage(nil), raw...)
+	report.Usage = &usage
+	return report, nil
+}
+
+func parseCompletionContent(completion chatCompletionResponse) (*GeneratedReport, error) {
+	if len(completion.Choices) == 0 {
+		return nil, ErrLLMEmptyResponse
+	}
+
+	content := stripCodeFence(completion.Choices[0].Message.Content)
+	if content == "" {
+		return nil, ErrLLMEmptyResponse
+	}
+
+	var report GeneratedReport
+	if err := json.Unmarshal([]byte(content), &report); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLLMInvalidResponse, err)
+	}
+	if report.EventSummary == "" && report.RootCauseAnalysis.Text == "" {
+		return nil, fmt.Errorf("%w: missing event_summary and root_cause_analysis", ErrLLMInvalidResponse)
+	}
+	return &report, nil
+}
+
+// stripCodeFence 移除模型偶爾包覆在 JSON 外層的 markdown 區塊標記。
+func stripCodeFence(content string) string {
+	trimmed := strings.TrimSpace(content)
+	if !strings.HasPrefix(trimmed, "```") {
+		return trimmed
+	}
+	trimmed = strings.TrimPrefix(trimmed, "```")
+	if newline := strings.IndexByte(trimmed, '\n'); newline >= 0 {
+		trimmed = trimmed[newline+1:]
+	}
+	trimmed = strings.TrimSuffix(strings.TrimSpace(trimmed), "```")
+	return strings.TrimSpace(trimmed)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newChatCompletionBody(t *testing.T, content string) []byte {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{
+		"id":     "chatcmpl-test",
+		"object": "chat.completion",
+		"choices": []map[string]any{{
+			"index":         0,
+			"message":       map[string]any{"role": "assistant", "content": content},
+			"finish_reason": "stop",
+		}},
+	})
+	if err != nil {
+		t.Fatalf("無法建立測試回應: %v", err)
+	}
+	return body
+}
+
+func TestOpenAIReportGeneratorGenerate(t *testing.T) {
+	var captured chatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("非預期的路徑: %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization 標頭錯誤: %s", got)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&captured); err != nil {
+			t.Errorf("無法解析請求: %v", err)
+		}
+		content := `{"event_summary":"資料庫連線耗盡","root_cause_analysis":{"text":"連線池設定過小","confidence_score":0.72,"probable_causes":["連線池上限 10"]},"impact_assessment":{"text":"API 逾時","severity":"HIGH"},"recommended_actions":[{"title":"調整連線池","action_type":"MANUAL","risk":"LOW"}]}`
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(newChatCompletionBody(t, content))
+	}))
+	defer server.Close()
+
+	generator, err := NewOpenAIReportGenerator(OpenAIGeneratorConfig{
+		BaseURL: server.URL + "/v1/",
+		APIKey:  "test-key",
+		Model:   "gpt-test",
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("建立產生器失敗: %v", err)
+	}
+
+	report, err := generator.Generate(context.Background(), GenerationInput{
+		EventID:      "evt-db-01",
+		EventContext: map[string]any{"service": "order-api", "metric": "db_connections"},
+	})
+	if err != nil {
+		t.Fatalf("產生報告失敗: %v", err)
+	}
+
+	if captured.Model != "gpt-test" {
+		t.Fatalf("請求模型錯誤: %s", captured.Model)
+	}
+	if len(captured.Messages) != 2 {
+		t.Fatalf("預期兩則訊息，實際為 %d", len(captured.Messages))
+	}
+	userPrompt := captured.Messages[1].Content
+	if !strings.Contains(userPrompt, "evt-db-01") || !strings.Contains(userPrompt, "order-api") {
+		t.Fatalf("提示詞應包含事件編號與上下文: %s", userPrompt)
+	}
+
+	if report.EventSummary != "資料庫連線耗盡" {
+		t.Fatalf("事件摘要錯誤: %s", report.EventSummary)
+	}
+	if report.RootCauseAnalysis.ConfidenceScore != 0.72 {
+		t.Fatalf("信心分數錯誤: %v", report.RootCauseAnalysis.ConfidenceScore)
+	}
+	if len(report.RecommendedActions) != 1 {
+		t.Fatalf("預期一項建議措施，實際為 %d", len(report.RecommendedActions))
+	}
+	if !strings.Contains(string(report.RawLLMResponse), "chatcmpl-test") {
+		t.Fatalf("應保留原始 LLM 回應")
+	}
+}
+
+func TestOpenAIReportGeneratorCodeFence(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := "```json\n{\"event_summary\":\"摘要\",\"root_cause_analysis\":{\"text\":\"原因\"}}\n```"
+		_, _ = w.Write(newChatCompletionBody(t, content))
+	}))
+	defer server.Close()
+
+	generator, err := NewOpenAIReportGenerator(OpenAIGeneratorConfig{BaseURL: server.URL, Model: "gpt-test"})
+	if err != nil {
+		t.Fatalf("建立產生器失敗: %v", err)
+	}
+
+	report, err := generator.Generate(context.Background(), GenerationInput{EventID: "evt-1"})
+	if err != nil {
+		t.Fatalf("產生報告失敗: %v", err)
+	}
+	if report.RootCauseAnalysis.Text != "原因" {
+		t.Fatalf("應能解析包覆在區塊標記內的 JSON")
+	}
+}
+
+func TestOpenAIReportGeneratorErrors(t *testing.T) {
+	status := http.StatusInternalServerError
+	content := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			http.Error(w, "upstream unavailable", status)
+			return
+		}
+		_, _ = w.Write(newChatCompletionBody(t, content))
+	}))
+	defer server.Close()
+
+	generator, err := NewOpenAIReportGenerator(OpenAIGeneratorConfig{BaseURL: server.URL, Model: "gpt-test"})
+	if err != nil {
+		t.Fatalf("建立產生器失敗: %v", err)
+	}
+
+	_, err = generator.Generate(context.Background(), GenerationInput{EventID: "evt-1"})
+	var statusErr *LLMStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("預期 LLMStatusError 500，實際為 %v", err)
+	}
+
+	status = http.StatusOK
+	content = "not json"
+	if _, err := generator.Generate(context.Background(), GenerationInput{EventID: "evt-1"}); !errors.Is(err, ErrLLMInvalidResponse) {
+		t.Fatalf("預期 ErrLLMInvalidResponse，實際為 %v", err)
+	}
+
+	content = ""
+	if _, err := generator.Generate(context.Background(), GenerationInput{EventID: "evt-1"}); !errors.Is(err, ErrLLMEmptyResponse) {
+		t.Fatalf("預期 ErrLLMEmptyResponse，實際為 %v", err)
+	}
+
+	content = strings.Repeat("x", maxLLMResponseBytes)
+	if _, err := generator.Generate(context.Background(), GenerationInput{EventID: "evt-1"}); !errors.Is(err, ErrLLMResponseTooLarge) || !strings.Contains(err.Error(), "上限") {
+		t.Fatalf("超過讀取上限應回傳 ErrLLMResponseTooLarge，實際為 %v", err)
+	}
+}
+
+func TestNewOpenAIReportGeneratorValidate(t *testing.T) {
+	if _, err := NewOpenAIReportGenerator(OpenAIGeneratorConfig{Model: "gpt-test"}); !errors.Is(err, ErrLLMEndpointRequired) {
+		t.Fatalf("缺少端點應回傳 ErrLLMEndpointRequired，實際為 %v", err)
+	}
+	if _, err := NewOpenAIReportGenerator(OpenAIGeneratorConfig{BaseURL: "http://localhost"}); !errors.Is(err, ErrLLMModelRequired) {
+		t.Fatalf("缺少模型應回傳 ErrLLMModelRequired，實際為 %v", err)
+	}
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultPrometheusLookBehind = time.Hour
+	defaultPrometheusLookAhead  = 30 * time.Minute
+	defaultPrometheusTimeout    = 10 * time.Second
+	defaultPrometheusMaxSeries  = 3
+	// prometheusTargetPoints 為每條時間序列的目標點數，依時間區間換算查詢步長。
+	prometheusTargetPoints = 120
+	prometheusMinStep      = 15 * time.Second
+	// maxChangePoints 為每條時間序列最多回報的變化點數。
+	maxChangePoints = 3
+	// changePointMinSegment 為切分後每段的最少點數，避免單點雜訊被視為變化。
+	changePointMinSegment = 3
+	// changePointMinRelative 為變化量相對於前後平均值的最小比例。
+	changePointMinRelative = 0.1
+	maxPrometheusErrorBody = 512
+	// maxPrometheusResponseBytes 為 query_range 回應的讀取上限。每條序列約 prometheusTargetPoints 個點，
+	// 在 maxPrometheusResultSeries 條序列下約 1 MiB，保留餘裕仍可避免異常查詢耗盡記憶體。
+	maxPrometheusResponseBytes = 8 << 20
+	// maxPrometheusResultSeries 為單一查詢可處理的時間序列上限，超過時代表查詢模板未充分彙總。
+	maxPrometheusResultSeries = 200
+)
+
+var (
+	// ErrPrometheusURLRequired 代表未設定 Prometheus 查詢 API 位址。
+	ErrPrometheusURLRequired = errors.New("prometheus url is required")
+	// ErrInvalidMetricQuery 代表指標查詢模板無效。
+	ErrInvalidMetricQuery = errors.New("invalid metric query")
+)
+
+// MetricQuery 為一組 PromQL 查詢模板。
+// 模板以 text/template 撰寫，可使用 {{.Selector}} (含大括號的標籤選擇器)、
+// {{.Matchers}} (不含大括號，可與其他條件合併)、{{.Labels.<name>}}、{{.ResourceName}} 與 {{.ResourceID}}；
+// 引用事件缺少的標籤時略過該查詢。
+type MetricQuery struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	Unit  string `json:"unit,omitempty"`
+}
+
+// defaultMetricQueries 涵蓋常見的服務與容器黃金指標。
+var defaultMetricQueries = []MetricQuery{
+	{Name: "請求速率", Query: `sum(rate(http_requests_total{{.Selector}}[5m]))`, Unit: "req/s"},
+	{Name: "5xx 錯誤比例", Query: `sum(rate(http_requests_total{ {{.Matchers}},status=~"5.."}[5m])) / sum(rate(http_requests_total{{.Selector}}[5m]))`, Unit: "ratio"},
+	{Name: "P95 延遲", Query: `histogram_quantile(0.95, sum by (le) (rate(http_request_duration_seconds_bucket{{.Selector}}[5m])))`, Unit: "s"},
+	{Name: "CPU 使用量", Query: `sum(rate(container_cpu_usage_seconds_total{{.Selector}}[5m]))`, Unit: "cores"},
+	{Name: "記憶體使用量", Query: `sum(container_memory_working_set_bytes{{.Selector}})`, Unit: "bytes"},
+}
+
+// defaultSelectorLabels 為用於組成選擇器的事件標籤。
+var defaultSelectorLabels = []string{"service", "namespace", "pod", "instance", "job"}
+
+// PrometheusEnricherConfig 設定 Prometheus 或 VictoriaMetrics 查詢 API。
+type PrometheusEnricherConfig struct {
+	// BaseURL 為查詢 API 根路徑，例如 http://prometheus:9090。
+	BaseURL string
+	// GraphURL 為證據連結使用的 UI 位址，預設與 BaseURL 相同。
+	GraphURL    string
+	BearerToken string
+	// Queries 未設定時使用 defaultMetricQueries。
+	Queries []MetricQuery
+	// SelectorLabels 為組成選擇器時採用的事件標籤，預設 service、namespace、pod、instance 與 job。
+	SelectorLabels []string
+	// ResourceLabel 為事件沒有任何選擇器標籤時，承載 resource_name 的標籤名稱，預設 instance。
+	ResourceLabel string
+	// LookBehind 與 LookAhead 為事件觸發前後的查詢區間，預設 1 小時與 30 分鐘。
+	LookBehind time.Duration
+	LookAhead  time.Duration
+	// MaxSeries 為每個查詢最多轉為證據的時間序列數，依變化幅度排序，預設 3。
+	MaxSeries int
+	// Timeout 僅在未提供 HTTPClient 時套用。
+	Timeout    time.Duration
+	HTTPClient *http.Client
+}
+
+type compiledMetricQuery struct {
+	MetricQuery
+	tmpl *template.Template
+}
+
+// PrometheusEnricher 查詢事件前後的指標並摘要為 METRIC 證據。
+type PrometheusEnricher struct {
+	endpoint       string
+	graphURL       string
+	bearerToken    string
+	queries        []compiledMetricQuery
+	selectorLabels []string
+	resourceLabel  string
+	lookBehind     time.Duration
+	lookAhead      time.Duration
+	maxSeries      int
+	client         *http.Client
+	// now 可於測試中替換。
+	now func() time.Time
+}
+
+// NewPrometheusEnricher 建立指標豐富化來源，查詢模板於建立時解析。
+func NewPrometheusEnricher(cfg PrometheusEnricherConfig) (*PrometheusEnricher, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if baseURL == "" {
+		return nil, ErrPrometheusURLRequired
+	}
+	graphURL := strings.TrimRight(strings.TrimSpace(cfg.GraphURL), "/")
+	if graphURL == "" {
+		graphURL = baseURL
+	}
+
+	queries := cfg.Queries
+	if len(queries) == 0 {
+		queries = defaultMetricQueries
+	}
+	compiled := make([]compiledMetricQuery, 0, len(queries))
+	for _, query := range queries {
+		if strings.TrimSpace(query.Name) == "" || strings.TrimSpace(query.Query) == "" {
+			return nil, fmt.Errorf("%w: 查詢需設定 name 與 query", ErrInvalidMetricQuery)
+		}
+		tmpl, err := template.New(query.Name).Option("missingkey=error").Parse(query.Query)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidMetricQuery, query.Name, err)
+		}
+		compiled = append(compiled, compiledMetricQuery{MetricQuery: query, tmpl: tmpl})
+	}
+
+	selectorLabels := cfg.SelectorLabels
+	if len(selectorLabels) == 0 {
+		selectorLabels = defaultSelectorLabels
+	}
+	resourceLabel := cfg.ResourceLabel
+	if resourceLabel == "" {
+		resourceLabel = "instance"
+	}
+	lookBehind := cfg.LookBehind
+	if lookBehind <= 0 {
+		lookBehind = defaultPrometheusLookBehind
+	}
+	lookAhead := cfg.LookAhead
+	if lookAhead <= 0 {
+		lookAhead = defaultPrometheusLookAhead
+	}
+	maxSeries := cfg.MaxSeries
+	if maxSeries <= 0 {
+		maxSeries = defaultPrometheusMaxSeries
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = defaultPrometheusTimeout
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+
+	return &PrometheusEnricher{
+		endpoint:       baseURL + "/api/v1/query_range",
+		graphURL:       graphURL,
+		bearerToken:    cfg.BearerToken,
+		queries:        compiled,
+		selectorLabels: selectorLabels,
+		resourceLabel:  resourceLabel,
+		lookBehind:     lookBehind,
+		lookAhead:      lookAhead,
+		maxSeries:      maxSeries,
+		client:         client,
+		now:            time.Now,
+	}, nil
+}
+
+// LoadMetricQueries 讀取 JSON 格式的查詢模板，例如 [{"name": "請求速率", "query": "...", "unit": "req/s"}]。
+func LoadMetricQueries(path string) ([]MetricQuery, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("無法讀取指標查詢: %w", err)
+	}
+	var queries []MetricQuery
+	if err := json.Unmarshal(raw, &queries); err != nil {
+		return nil, fmt.Errorf("指標查詢格式錯誤: %w", err)
+	}
+	return queries, nil
+}
+
+// Name 實作 ContextEnricher。
+func (p *PrometheusEnricher) Name() string {
+	return "prometheus"
+}
+
+// metricTemplateData 為查詢模板可使用的欄位。
+type metricTemplateData struct {
+	Selector     string
+	Matchers     string
+	Labels       map[string]string
+	ResourceName string
+	ResourceID   string
+}
+
+// Enrich 並行執行所有查詢；事件沒有可用的資源標籤時不查詢。
+func (p *PrometheusEnricher) Enrich(ctx context.Context, event EventFacts) ([]EvidenceItem, error) {
+	selector := p.selector(event)
+	if len(selector) == 0 {
+		return nil, nil
+	}
+	matchers := formatLabels(selector)
+	data := metricTemplateData{
+		Selector:     "{" + matchers + "}",
+		Matchers:     matchers,
+		Labels:       event.Labels,
+		ResourceName: event.ResourceName,
+		ResourceID:   event.ResourceID,
+	}
+	start, end := event.Window(p.lookBehind, p.lookAhead, p.now())
+
+	results := make([][]EvidenceItem, len(p.queries))
+	errs := make([]error, len(p.queries))
+	var wg sync.WaitGroup
+	for i, query := range p.queries {
+		var rendered bytes.Buffer
+		if err := query.tmpl.Execute(&rendered, data); err != nil {
+			// 模板引用了事件缺少的標籤，此查詢不適用於該事件。
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = p.evaluate(ctx, query.MetricQuery, rendered.String(), event, start, end)
+		}()
+	}
+	wg.Wait()
+
+	var evidence []EvidenceItem
+	for _, items := range results {
+		evidence = append(evidence, items...)
+	}
+	return evidence, errors.Join(errs...)
+}
+
+// selector 自事件標籤挑出選擇器標籤，皆缺少時以 resource_name 代替。
+func (p *PrometheusEnricher) selector(event EventFacts) map[string]string {
+	selector := make(map[string]string)
+	for _, name := range p.selectorLabels {
+		if value := event.Labels[name]; value != "" {
+			selector[name] = value
+		}
+	}
+	if len(selector) == 0 && event.ResourceName != "" {
+		selector[p.resourceLabel] = event.ResourceName
+	}
+	return selector
+}
+
+// evaluate 執行單一查詢並將變化最大的時間序列轉為證據。
+func (p *PrometheusEnricher) evaluate(ctx context.Context, query MetricQuery, expr string, event EventFacts, start, end time.Time) ([]EvidenceItem, error) {
+	series, err := p.queryRange(ctx, expr, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", query.Name, err)
+	}
+
+	summaries := make([]metricSummary, 0, len(series))
+	for _, item := range series {
+		if summary, ok := summarizeSeries(item.Metric, item.points, event.TriggeredAt); ok {
+			summaries = append(summaries, summary)
+		}
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].score() > summaries[j].score()
+	})
+	if len(summaries) > p.maxSeries {
+		summaries = summaries[:p.maxSeries]
+	}
+
+	link := p.graphLink(expr, start, end)
+	evidence := make([]EvidenceItem, len(summaries))
+	for i, summary := range summaries {
+		evidence[i] = summary.evidence(query, expr, link, start, end)
+	}
+	return evidence, nil
+}
+
+// graphLink 回傳 Prometheus UI 上對應查詢與時間區間的圖表連結。
+func (p *PrometheusEnricher) graphLink(expr string, start, end time.Time) *EvidenceLink {
+	values := url.Values{}
+	values.Set("g0.expr", expr)
+	values.Set("g0.tab", "0")
+	values.Set("g0.range_input", fmt.Sprintf("%ds", int(end.Sub(start).Seconds())))
+	values.Set("g0.end_input", end.UTC().Format(time.DateTime))
+	return &EvidenceLink{Name: "Prometheus 圖表", URL: p.graphURL + "/graph?" + values.Encode()}
+}
+
+type metricPoint struct {
+	At    time.Time
+	Value float64
+}
+
+type metricSeries struct {
+	Metric map[string]string
+	points []metricPoint
+}
+
+type prometheusResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string `json:"resultType"`
+		// Result 先保留原始內容，確認序列數未超過上限後才逐條解碼。
+		Result []json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+type prometheusMatrixSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]any          `json:"values"`
+}
+
+// queryRange 呼叫 /api/v1/query_range，步長依區間長度換算為約 prometheusTargetPoints 個點。
+func (p *PrometheusEnricher) queryRange(ctx context.Context, expr string, start, end time.Time) ([]metricSeries, error) {
+	step := end.Sub(start) / prometheusTargetPoints
+	if step < prometheusMinStep {
+		step = prometheusMinStep
+	}
+	form := url.Values{}
+	form.Set("query", expr)
+	form.Set("start", strconv.FormatInt(start.Unix(), 10))
+	form.Set("end", strconv.FormatInt(end.Unix(), 10))
+	form.Set("step", strconv.Itoa(int(step.Seconds())))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("無法建立 Prometheus 請求: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.bearerToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("呼叫 Prometheus 失敗: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxPrometheusResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("讀取 Prometheus 回應失敗: %w", err)
+	}
+	if len(raw) > maxPrometheusResponseBytes {
+		return nil, fmt.Errorf("Prometheus 回應超過 %d 位元組上限，請縮小查詢範圍或彙總標籤", maxPrometheusResponseBytes)
+	}
+
+	var decoded prometheusResponse
+	decodeErr := json.Unmarshal(raw, &decoded)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices || decoded.Status == "error" {
+		message := decoded.Error
+		if message == "" {
+			message = strings.TrimSpace(string(raw[:min(len(raw), maxPrometheusErrorBody)]))
+		}
+		return nil, fmt.Errorf("Prometheus 查詢失敗 (%d): %s", resp.StatusCode, message)
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("Prometheus 回應格式錯誤: %w", decodeErr)
+	}
+	if decoded.Data.ResultType != "matrix" {
+		return nil, fmt.Errorf("Prometheus 回應類型 %q 不是 matrix", decoded.Data.ResultType)
+	}
+
+	if len(decoded.Data.Result) > maxPrometheusResultSeries {
+		return nil, fmt.Errorf("Prometheus 查詢回傳 %d 條時間序列，超過上限 %d，請彙總查詢標籤", len(decoded.Data.Result), maxPrometheusResultSeries)
+	}
+
+	series := make([]metricSeries, 0, len(decoded.Data.Result))
+	for _, element := range decoded.Data.Result {
+		var result prometheusMatrixSeries
+		if err := json.Unmarshal(element, &result); err != nil {
+			return nil, fmt.Errorf("Prometheus 回應格式錯誤: %w", err)
+		}
+		points := make([]metricPoint, 0, len(result.Values))
+		for _, sample := range result.Values {
+			ts, ok := sample[0].(float64)
+			text, _ := sample[1].(string)
+			value, err := strconv.ParseFloat(text, 64)
+			if !ok || err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			points = append(points, metricPoint{At: time.UnixMilli(int64(ts * 1000)).UTC(), Value: value})
+		}
+		series = append(series, metricSeries{Metric: result.Metric, points: points})
+	}
+	return series, nil
+}
+
+// ChangePoint 為時間序列平均值明顯改變的位置。
+type ChangePoint struct {
+	At     time.Time `json:"at"`
+	Before float64   `json:"before"`
+	After  float64   `json:"after"`
+}
+
+// metricSummary 為單一時間序列的摘要。
+type metricSummary struct {
+	Labels map[string]string
+	// Baseline 為事件觸發前的中位數，觸發前沒有資料時取整段區間的中位數。
+	Baseline     float64
+	Peak         float64
+	PeakAt       time.Time
+	ChangePoints []ChangePoint
+}
+
+// score 為峰值相對於基準的變化幅度，用於挑選最值得注意的時間序列。
+func (m metricSummary) score() float64 {
+	deviation := math.Abs(m.Peak - m.Baseline)
+	for _, point := range m.ChangePoints {
+		deviation = math.Max(deviation, math.Abs(point.After-point.Before))
+	}
+	if m.Baseline == 0 {
+		return deviation
+	}
+	return deviation / math.Abs(m.Baseline)
+}
+
+// summarizeSeries 計算基準、峰值與變化點，沒有資料點時回傳 false。
+func summarizeSeries(labels map[string]string, points []metricPoint, triggeredAt time.Time) (metricSummary, bool) {
+	if len(points) == 0 {
+		return metricSummary{}, false
+	}
+	var before []float64
+	values := make([]float64, len(points))
+	peak := points[0]
+	for i, point := range points {
+		values[i] = point.Value
+		if point.At.Before(triggeredAt) {
+			before = append(before, point.Value)
+		}
+		if point.Value > peak.Value {
+			peak = point
+		}
+	}
+	if len(before) == 0 {
+		before = values
+	}
+
+	summary := metricSummary{
+		Labels:   labels,
+		Baseline: median(before),
+		Peak:     peak.Value,
+		PeakAt:   peak.At,
+	}
+	for _, index := range detectChangePoints(values) {
+		summary.ChangePoints = append(summary.ChangePoints, ChangePoint{
+			At:     points[index].At,
+			Before: mean(values[max(0, index-changePointMinSegment):index]),
+			After:  mean(values[index:min(len(values), index+changePointMinSegment)]),
+		})
+	}
+	return summary, true
+}
+
+// detectChangePoints 以二元切分法找出平均值改變的位置，回傳遞增排序的索引。
+// 切分點需同時滿足：前後平均差超過雜訊的三倍，且相對變化達 changePointMinRelative。
+// 雜訊以相鄰點差值的中位數估計，不受平均值改變本身影響。
+func detectChangePoints(values []float64) []int {
+	if len(values) < 2*changePointMinSegment {
+		return nil
+	}
+	diffs := make([]float64, len(values)-1)
+	for i := 1; i < len(values); i++ {
+		diffs[i-1] = math.Abs(values[i] - values[i-1])
+	}
+	// 常態分布下相鄰差值絕對值的中位數約為 0.954 倍標準差。
+	noise := median(diffs) / 0.954
+
+	var found []int
+	var split func(lo, hi int)
+	split = func(lo, hi int) {
+		if len(found) >= maxChangePoints || hi-lo < 2*changePointMinSegment {
+			return
+		}
+		best, bestShift := -1, 0.0
+		for i := lo + changePointMinSegment; i <= hi-changePointMinSegment; i++ {
+			shift := math.Abs(mean(values[lo:i]) - mean(values[i:hi]))
+			if shift > bestShift {
+				best, bestShift = i, shift
+			}
+		}
+		if best < 0 {
+			return
+		}
+		scale := math.Max(math.Abs(mean(values[lo:best])), math.Abs(mean(values[best:hi])))
+		if bestShift <= 3*noise || bestShift < changePointMinRelative*scale {
+			return
+		}
+		found = append(found, best)
+		split(lo, best)
+		split(best, hi)
+	}
+	split(0, len(values))
+	slices.Sort(found)
+	return found
+}
+
+// evidence 將摘要轉為 METRIC 證據，時間戳記為首個變化點，沒有變化點時為峰值時間。
+func (m metricSummary) evidence(query MetricQuery, expr string, link *EvidenceLink, start, end time.Time) EvidenceItem {
+	name := query.Name
+	if labels := formatLabels(m.Labels); labels != "" {
+		name += " {" + labels + "}"
+	}
+	description := fmt.Sprintf("%s：事件前基準 %s，峰值 %s (%s)",
+		name, formatMetricValue(m.Baseline, query.Unit), formatMetricValue(m.Peak, query.Unit), m.PeakAt.Format(time.RFC3339))
+
+	changePoints := make([]map[string]any, len(m.ChangePoints))
+	for i, point := range m.ChangePoints {
+		changePoints[i] = map[string]any{
+			"at":     point.At.Format(time.RFC3339),
+			"before": point.Before,
+			"after":  point.After,
+		}
+	}
+	timestamp := m.PeakAt
+	if len(m.ChangePoints) > 0 {
+		first := m.ChangePoints[0]
+		timestamp = first.At
+		description += fmt.Sprintf("，%d 個變化點，首次於 %s 由 %s 變為 %s",
+			len(m.ChangePoints), first.At.Format(time.RFC3339),
+			formatMetricValue(first.Before, query.Unit), formatMetricValue(first.After, query.Unit))
+	}
+
+	metadata := map[string]any{
+		"source":        "prometheus",
+		"query":         expr,
+		"baseline":      m.Baseline,
+		"peak":          m.Peak,
+		"peak_at":       m.PeakAt.Format(time.RFC3339),
+		"change_points": changePoints,
+		"window_start":  start.Format(time.RFC3339),
+		"window_end":    end.Format(time.RFC3339),
+	}
+	if len(m.Labels) > 0 {
+		metadata["series"] = m.Labels
+	}
+	if query.Unit != "" {
+		metadata["unit"] = query.Unit
+	}
+	if m.Baseline != 0 {
+		metadata["change_ratio"] = (m.Peak - m.Baseline) / math.Abs(m.Baseline)
+	}
+	return EvidenceItem{
+		Type:        EvidenceTypeMetric,
+		Description: description,
+		Link:        link,
+		Timestamp:   &timestamp,
+		Metadata:    metadata,
+	}
+}
+
+func formatMetricValue(value float64, unit string) string {
+	text := strconv.FormatFloat(value, 'g', 4, 64)
+	if unit == "" {
+		return text
+	}
+	return text + " " + unit
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
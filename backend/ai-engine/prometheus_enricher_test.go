@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var promTestTrigger = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// fakePrometheus 模擬 /api/v1/query_range，依查詢內容回傳對應的時間序列。
+type fakePrometheus struct {
+	mu      sync.Mutex
+	queries []url.Values
+	series  func(query string, start, end, step int64) []map[string]any
+}
+
+func (f *fakePrometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v1/query_range" || r.ParseForm() != nil {
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	f.queries = append(f.queries, r.PostForm)
+	f.mu.Unlock()
+
+	start, _ := strconv.ParseInt(r.PostForm.Get("start"), 10, 64)
+	end, _ := strconv.ParseInt(r.PostForm.Get("end"), 10, 64)
+	step, _ := strconv.ParseInt(r.PostForm.Get("step"), 10, 64)
+	query := r.PostForm.Get("query")
+	if strings.Contains(query, "syntax_error") {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "error", "errorType": "bad_data", "error": "parse error"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"status": "success",
+		"data":   map[string]any{"resultType": "matrix", "result": f.series(query, start, end, step)},
+	})
+}
+
+// stepSeries 產生事件觸發時由 before 跳升至 after 的時間序列。
+func stepSeries(labels map[string]string, before, after float64) func(start, end, step int64) map[string]any {
+	return func(start, end, step int64) map[string]any {
+		var values [][]any
+		for ts := start; ts <= end; ts += step {
+			value := before
+			if ts >= promTestTrigger.Unix() {
+				value = after
+			}
+			values = append(values, []any{float64(ts), strconv.FormatFloat(value, 'f', -1, 64)})
+		}
+		return map[string]any{"metric": labels, "values": values}
+	}
+}
+
+func newTestPrometheusEnricher(t *testing.T, server *httptest.Server, queries []MetricQuery) *PrometheusEnricher {
+	t.Helper()
+	enricher, err := NewPrometheusEnricher(PrometheusEnricherConfig{
+		BaseURL:  server.URL,
+		GraphURL: "https://prometheus.example.com/",
+		Queries:  queries,
+	})
+	if err != nil {
+		t.Fatalf("建立指標豐富化來源失敗: %v", err)
+	}
+	enricher.now = func() time.Time { return promTestTrigger.Add(2 * time.Hour) }
+	return enricher
+}
+
+func TestPrometheusEnricherSummarizesSeries(t *testing.T) {
+	fake := &fakePrometheus{series: func(query string, start, end, step int64) []map[string]any {
+		if strings.Contains(query, "http_requests_total") {
+			return []map[string]any{
+				stepSeries(map[string]string{"route": "/checkout"}, 100, 400)(start, end, step),
+				stepSeries(map[string]string{"route": "/health"}, 10, 10)(start, end, step),
+			}
+		}
+		return nil
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	enricher := newTestPrometheusEnricher(t, server, []MetricQuery{
+		{Name: "請求速率", Query: `sum by (route) (rate(http_requests_total{{.Selector}}[5m]))`, Unit: "req/s"},
+		{Name: "CPU", Query: `rate(process_cpu_seconds_total{{.Selector}}[5m])`},
+		{Name: "佇列延遲", Query: `queue_lag{topic="{{.Labels.topic}}"}`},
+	})
+	event := parseEventFacts("evt-metric", map[string]any{
+		"resource_name": "checkout-7f9c",
+		"triggered_at":  promTestTrigger.Format(time.RFC3339),
+		"tags":          []any{"service:checkout", "env=prod", "critical"},
+	}, time.Now())
+
+	evidence, err := enricher.Enrich(context.Background(), event)
+	if err != nil {
+		t.Fatalf("收集指標失敗: %v", err)
+	}
+	// 缺少 topic 標籤的查詢應略過，CPU 查詢沒有資料不產生證據。
+	if len(fake.queries) != 2 {
+		t.Fatalf("應執行 2 個查詢，實際為 %d", len(fake.queries))
+	}
+	for _, form := range fake.queries {
+		if !strings.Contains(form.Get("query"), `{service="checkout"}`) {
+			t.Fatalf("查詢應使用事件的服務標籤: %s", form.Get("query"))
+		}
+		start, _ := strconv.ParseInt(form.Get("start"), 10, 64)
+		end, _ := strconv.ParseInt(form.Get("end"), 10, 64)
+		if start != promTestTrigger.Add(-time.Hour).Unix() || end != promTestTrigger.Add(30*time.Minute).Unix() {
+			t.Fatalf("查詢區間錯誤: %s ~ %s", form.Get("start"), form.Get("end"))
+		}
+	}
+
+	if len(evidence) != 2 {
+		t.Fatalf("應產生 2 筆證據，實際為 %d: %+v", len(evidence), evidence)
+	}
+	top := evidence[0]
+	if top.Type != EvidenceTypeMetric || !strings.Contains(top.Description, `route="/checkout"`) {
+		t.Fatalf("變化最大的時間序列應排在最前: %+v", top)
+	}
+	if top.Metadata["baseline"] != 100.0 || top.Metadata["peak"] != 400.0 || top.Metadata["change_ratio"] != 3.0 {
+		t.Fatalf("基準或峰值錯誤: %+v", top.Metadata)
+	}
+	changePoints, _ := top.Metadata["change_points"].([]map[string]any)
+	if len(changePoints) != 1 || changePoints[0]["at"] != promTestTrigger.Format(time.RFC3339) || top.Timestamp == nil || !top.Timestamp.Equal(promTestTrigger) {
+		t.Fatalf("變化點應位於事件觸發時間: %+v", changePoints)
+	}
+	if steady, _ := evidence[1].Metadata["change_points"].([]map[string]any); len(steady) != 0 {
+		t.Fatalf("平穩的時間序列不應有變化點: %+v", evidence[1].Metadata)
+	}
+
+	link, err := url.Parse(top.Link.URL)
+	if err != nil || link.Host != "prometheus.example.com" || link.Path != "/graph" {
+		t.Fatalf("圖表連結錯誤: %s", top.Link.URL)
+	}
+	if got := link.Query().Get("g0.expr"); got != `sum by (route) (rate(http_requests_total{service="checkout"}[5m]))` {
+		t.Fatalf("圖表連結應帶入查詢: %s", got)
+	}
+	if link.Query().Get("g0.range_input") != "5400s" || link.Query().Get("g0.end_input") != "2024-05-01 12:30:00" {
+		t.Fatalf("圖表連結時間區間錯誤: %s", top.Link.URL)
+	}
+}
+
+func TestPrometheusEnricherPartialFailure(t *testing.T) {
+	fake := &fakePrometheus{series: func(query string, start, end, step int64) []map[string]any {
+		if strings.Contains(query, "by_pod") {
+			// 未彙總的查詢回傳超過上限的時間序列。
+			series := make([]map[string]any, maxPrometheusResultSeries+1)
+			for i := range series {
+				series[i] = map[string]any{"metric": map[string]string{"pod": strconv.Itoa(i)}, "values": [][]any{}}
+			}
+			return series
+		}
+		return []map[string]any{stepSeries(nil, 1, 5)(start, end, step)}
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	enricher := newTestPrometheusEnricher(t, server, []MetricQuery{
+		{Name: "錯誤查詢", Query: `syntax_error{{.Selector}}`},
+		{Name: "連線數", Query: `db_connections{{.Selector}}`},
+		{Name: "各 Pod 記憶體", Query: `by_pod{{.Selector}}`},
+	})
+	// 沒有選擇器標籤時以 resource_name 作為 instance。
+	event := EventFacts{ResourceName: "db-1:9100", TriggeredAt: promTestTrigger, Labels: map[string]string{}}
+	evidence, err := enricher.Enrich(context.Background(), event)
+	if err == nil || !strings.Contains(err.Error(), "parse error") {
+		t.Fatalf("應回傳查詢錯誤，實際為 %v", err)
+	}
+	if !strings.Contains(err.Error(), "超過上限") {
+		t.Fatalf("時間序列過多時應回傳錯誤，實際為 %v", err)
+	}
+	if len(evidence) != 1 || evidence[0].Metadata["query"] != `db_connections{instance="db-1:9100"}` {
+		t.Fatalf("部分失敗時應保留其他查詢的證據: %+v", evidence)
+	}
+
+	// 沒有任何可用的資源資訊時不查詢。
+	fake.queries = nil
+	evidence, err = enricher.Enrich(context.Background(), EventFacts{TriggeredAt: promTestTrigger})
+	if err != nil || evidence != nil || len(fake.queries) != 0 {
+		t.Fatalf("缺少資源資訊時不應查詢: %+v (%v)", evidence, err)
+	}
+}
+
+func TestNewPrometheusEnricherValidation(t *testing.T) {
+	if _, err := NewPrometheusEnricher(PrometheusEnricherConfig{}); !errors.Is(err, ErrPrometheusURLRequired) {
+		t.Fatalf("缺少 URL 應回傳 ErrPrometheusURLRequired，實際為 %v", err)
+	}
+	_, err := NewPrometheusEnricher(PrometheusEnricherConfig{BaseURL: "http://prometheus", Queries: []MetricQuery{{Name: "壞模板", Query: "up{{.Selector"}}})
+	if !errors.Is(err, ErrInvalidMetricQuery) {
+		t.Fatalf("模板錯誤應回傳 ErrInvalidMetricQuery，實際為 %v", err)
+	}
+}
+
+func TestDetectChangePoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   []int
+	}{
+		{name: "平穩", values: []float64{5, 5, 5, 5, 5, 5, 5, 5}},
+		{name: "微小雜訊", values: []float64{100, 101, 99, 100, 102, 100, 99, 101}},
+		{name: "單次跳升", values: []float64{1, 1, 1, 1, 9, 9, 9, 9}, want: []int{4}},
+		{name: "升高後回落", values: []float64{1, 1, 1, 8, 8, 8, 1, 1, 1}, want: []int{3, 6}},
+	}
+	for _, tc := range tests {
+		got := detectChangePoints(tc.values)
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Fatalf("%s: 預期 %v，實際為 %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+// recordingGenerator 記錄收到的輸入並回傳固定報告。
+type recordingGenerator struct {
+	mu     sync.Mutex
+	inputs []GenerationInput
+	result *GeneratedReport
+}
+
+func (g *recordingGenerator) Generate(_ context.Context, input GenerationInput) (*GeneratedReport, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inputs = append(g.inputs, input)
+	clone := g.result.Clone()
+	return &clone, nil
+}
+
+type failingEnricher struct{}
+
+func (failingEnricher) Name() string { return "failing" }
+
+func (failingEnricher) Enrich(context.Context, EventFacts) ([]EvidenceItem, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestAnalysisIncludesMetricEvidence(t *testing.T) {
+	fake := &fakePrometheus{series: func(query string, start, end, step int64) []map[string]any {
+		return []map[string]any{stepSeries(nil, 0.01, 0.2)(start, end, step)}
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	enricher := newTestPrometheusEnricher(t, server, []MetricQuery{{Name: "錯誤比例", Query: `errors{{.Selector}}`, Unit: "ratio"}})
+
+	generator := &recordingGenerator{result: &GeneratedReport{
+		EventSummary: "錯誤率上升",
+		Evidence:     []EvidenceItem{{Type: EvidenceTypeLog, Description: "大量 502"}},
+	}}
+	repo := NewInMemoryReportRepository()
+	metrics := NewMetrics()
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		Metrics:   metrics,
+		Enrichers: []ContextEnricher{failingEnricher{}, enricher},
+	})
+	report, err := service.CreateReport(context.Background(), "evt-enrich", CreateAnalysisRequest{EventContext: map[string]any{
+		"triggered_at": promTestTrigger.Format(time.RFC3339),
+		"labels":       map[string]any{"service": "api"},
+	}})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	service.Wait()
+
+	if len(generator.inputs) != 1 || len(generator.inputs[0].Evidence) != 1 || generator.inputs[0].Evidence[0].Type != EvidenceTypeMetric {
+		t.Fatalf("產生器應收到指標證據: %+v", generator.inputs)
+	}
+	prompt, err := buildAnalysisPrompt(generator.inputs[0])
+	if err != nil || !strings.Contains(prompt, "已收集的證據") || !strings.Contains(prompt, `errors{service=\"api\"}`) {
+		t.Fatalf("提示詞應包含收集到的證據: %s (%v)", prompt, err)
+	}
+
+	stored, _ := repo.Get(report.ReportID)
+	if stored.Status != ReportStatusSuccess || len(stored.Evidence) != 2 ||
+		stored.Evidence[0].Type != EvidenceTypeMetric || stored.Evidence[1].Type != EvidenceTypeLog {
+		t.Fatalf("報告應包含收集與產生的證據: %+v", stored.Evidence)
+	}
+	// 失敗與成功的來源各記錄一組耗時。
+	if count := testutil.CollectAndCount(metrics.enrichmentDuration); count != 2 {
+		t.Fatalf("豐富化耗時應有 2 組標籤，實際為 %d", count)
+	}
+}
+
+func TestParseEventFacts(t *testing.T) {
+	now := time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)
+	facts := parseEventFacts("evt-1", map[string]any{
+		"resource_id":  "res-1",
+		"triggered_at": "2024-05-01T12:00:00+08:00",
+		"resolved_at":  "invalid",
+		"tags":         []any{"service:web", 42},
+		"metadata":     map[string]any{"labels": map[string]any{"service": "api", "pod": "api-0", "replicas": 3}},
+	}, now)
+	if facts.ResourceID != "res-1" || !facts.TriggeredAt.Equal(time.Date(2024, 5, 1, 4, 0, 0, 0, time.UTC)) || facts.ResolvedAt != nil {
+		t.Fatalf("事件欄位解析錯誤: %+v", facts)
+	}
+	if facts.Labels["service"] != "api" || facts.Labels["pod"] != "api-0" || len(facts.Labels) != 2 {
+		t.Fatalf("metadata.labels 應覆寫 tags: %+v", facts.Labels)
+	}
+
+	// 缺少觸發時間時以現在為準，區間結束時間不超過現在。
+	facts = parseEventFacts("evt-2", nil, now)
+	start, end := facts.Window(time.Hour, 30*time.Minute, now)
+	if !start.Equal(now.Add(-time.Hour)) || !end.Equal(now) {
+		t.Fatalf("區間錯誤: %s ~ %s", start, end)
+	}
+}
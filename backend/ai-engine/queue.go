@@ -0,0 +1,163 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// analysisJob 為等待背景工作者處理的分析任務。
+type analysisJob struct {
+	reportID   string
+	input      GenerationInput
+	enqueuedAt time.Time
+	// spanContext 為建立報告請求的追蹤資訊，背景分析以此延續同一條 trace。
+	spanContext trace.SpanContext
+}
+
+// QueuedJob 描述佇列中等待處理的任務。
+type QueuedJob struct {
+	ReportID   string    `json:"report_id"`
+	EventID    string    `json:"event_id"`
+	Position   int       `json:"position"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+}
+
+// QueueStats 為工作佇列的即時狀態。
+type QueueStats struct {
+	Workers  int         `json:"workers"`
+	Capacity int         `json:"capacity"`
+	Depth    int         `json:"depth"`
+	InFlight int         `json:"in_flight"`
+	Jobs     []QueuedJob `json:"jobs"`
+}
+
+// jobQueue 為具容量上限的 FIFO 佇列，位置資訊可供 API 查詢。
+type jobQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	jobs     []analysisJob
+	reserved int
+	capacity int
+	inFlight int
+	closed   bool
+}
+
+func newJobQueue(capacity int) *jobQueue {
+	q := &jobQueue{capacity: capacity}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// reserve 預留一個佇列位置，佇列已滿或已關閉時回傳 false。
+func (q *jobQueue) reserve() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || len(q.jobs)+q.reserved >= q.capacity {
+		return false
+	}
+	q.reserved++
+	return true
+}
+
+// release 釋放未使用的預留位置。
+func (q *jobQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.reserved > 0 {
+		q.reserved--
+	}
+}
+
+// push 將任務放入佇列；reserved 為 true 時會消耗先前的預留位置。
+func (q *jobQueue) push(job analysisJob, reserved bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if reserved && q.reserved > 0 {
+		q.reserved--
+	}
+	if q.closed {
+		return false
+	}
+	q.jobs = append(q.jobs, job)
+	q.cond.Signal()
+	return true
+}
+
+// pop 阻塞直到取得任務；佇列關閉且清空後回傳 false。
+func (q *jobQueue) pop() (analysisJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.jobs) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.jobs) == 0 {
+		return analysisJob{}, false
+	}
+	job := q.jobs[0]
+	q.jobs[0] = analysisJob{}
+	q.jobs = q.jobs[1:]
+	q.inFlight++
+	return job, true
+}
+
+// done 標記一個執行中的任務已完成。
+func (q *jobQueue) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.inFlight > 0 {
+		q.inFlight--
+	}
+}
+
+// remove 將尚未開始的任務自佇列移除，找不到時回傳 false。
+func (q *jobQueue) remove(reportID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, job := range q.jobs {
+		if job.reportID == reportID {
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// position 回傳報告在佇列中的位置 (從 1 開始) 與目前深度。
+func (q *jobQueue) position(reportID string) (int, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, job := range q.jobs {
+		if job.reportID == reportID {
+			return i + 1, len(q.jobs)
+		}
+	}
+	return 0, len(q.jobs)
+}
+
+func (q *jobQueue) stats() (depth, inFlight int, jobs []QueuedJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs = make([]QueuedJob, len(q.jobs))
+	for i, job := range q.jobs {
+		jobs[i] = QueuedJob{
+			ReportID:   job.reportID,
+			EventID:    job.input.EventID,
+			Position:   i + 1,
+			EnqueuedAt: job.enqueuedAt,
+		}
+	}
+	return len(q.jobs), q.inFlight, jobs
+}
+
+// drain 關閉佇列並取出所有尚未開始的任務。
+func (q *jobQueue) drain() []analysisJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := q.jobs
+	q.jobs = nil
+	q.closed = true
+	q.cond.Broadcast()
+	return jobs
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// blockingGenerator 會阻塞至 release 關閉，用於模擬長時間執行的分析。
+type blockingGenerator struct {
+	started chan string
+	release chan struct{}
+}
+
+func newBlockingGenerator() *blockingGenerator {
+	return &blockingGenerator{
+		started: make(chan string, 16),
+		release: make(chan struct{}),
+	}
+}
+
+func (g *blockingGenerator) Generate(ctx context.Context, input GenerationInput) (*GeneratedReport, error) {
+	g.started <- input.EventID
+	select {
+	case <-g.release:
+		return &GeneratedReport{EventSummary: "完成"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestAnalysisServiceQueueBounded(t *testing.T) {
+	repo := NewInMemoryReportRepository()
+	generator := newBlockingGenerator()
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		ProcessingTimeout: 5 * time.Second,
+		Workers:           1,
+		QueueSize:         2,
+	})
+
+	running, err := service.CreateReport(context.Background(), "evt-q1", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立第一份報告失敗: %v", err)
+	}
+	<-generator.started
+
+	if _, err := service.CreateReport(context.Background(), "evt-q2", CreateAnalysisRequest{}); err != nil {
+		t.Fatalf("建立第二份報告失敗: %v", err)
+	}
+	third, err := service.CreateReport(context.Background(), "evt-q3", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立第三份報告失敗: %v", err)
+	}
+	if third.QueuePosition != 2 || third.QueueDepth != 2 {
+		t.Fatalf("預期佇列位置 2/2，實際為 %d/%d", third.QueuePosition, third.QueueDepth)
+	}
+
+	if _, err := service.CreateReport(context.Background(), "evt-q4", CreateAnalysisRequest{}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("佇列已滿應回傳 ErrQueueFull，實際為 %v", err)
+	}
+	if reports, _ := repo.ListByStatus(ReportStatusPending, ReportStatusRunning); len(reports) != 3 {
+		t.Fatalf("佇列已滿時不應建立報告，實際共有 %d 份", len(reports))
+	}
+
+	stats := service.QueueStats()
+	if stats.Depth != 2 || stats.InFlight != 1 || stats.Capacity != 2 || stats.Workers != 1 {
+		t.Fatalf("佇列統計錯誤: %+v", stats)
+	}
+	if len(stats.Jobs) != 2 || stats.Jobs[1].ReportID != third.ReportID {
+		t.Fatalf("佇列任務清單錯誤: %+v", stats.Jobs)
+	}
+
+	fetched, err := service.GetReport(context.Background(), running.ReportID)
+	if err != nil {
+		t.Fatalf("取得報告失敗: %v", err)
+	}
+	if fetched.QueuePosition != 0 {
+		t.Fatalf("執行中的報告不應有佇列位置")
+	}
+
+	close(generator.release)
+	service.Wait()
+
+	if stats := service.QueueStats(); stats.Depth != 0 || stats.InFlight != 0 {
+		t.Fatalf("完成後佇列應為空: %+v", stats)
+	}
+}
+
+func TestCreateReportEndpointQueueFull(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := NewInMemoryReportRepository()
+	generator := newBlockingGenerator()
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		ProcessingTimeout: 5 * time.Second,
+		Workers:           1,
+		QueueSize:         1,
+		QueueRetryAfter:   1500 * time.Millisecond,
+	})
+	router := SetupRouter(service)
+	defer func() {
+		close(generator.release)
+		service.Wait()
+	}()
+
+	post := func(eventID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/events/"+eventID+"/ai-analysis", strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := post("evt-a"); resp.Code != http.StatusAccepted {
+		t.Fatalf("預期回傳 202，實際為 %d", resp.Code)
+	}
+	<-generator.started
+
+	queued := post("evt-b")
+	if queued.Code != http.StatusAccepted {
+		t.Fatalf("預期回傳 202，實際為 %d", queued.Code)
+	}
+	var body createAnalysisResponse
+	if err := json.Unmarshal(queued.Body.Bytes(), &body); err != nil {
+		t.Fatalf("無法解析回應: %v", err)
+	}
+	if body.QueuePosition != 1 || body.QueueDepth != 1 {
+		t.Fatalf("回應應包含佇列位置，實際為 %+v", body)
+	}
+
+	rejected := post("evt-c")
+	if rejected.Code != http.StatusTooManyRequests {
+		t.Fatalf("佇列已滿應回傳 429，實際為 %d", rejected.Code)
+	}
+	if got := rejected.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("Retry-After 應為 2，實際為 %q", got)
+	}
+
+	statsReq := httptest.NewRequest(http.MethodGet, "/api/v1/admin/analysis-queue", nil)
+	statsResp := httptest.NewRecorder()
+	router.ServeHTTP(statsResp, statsReq)
+	if statsResp.Code != http.StatusOK {
+		t.Fatalf("預期回傳 200，實際為 %d", statsResp.Code)
+	}
+	var stats QueueStats
+	if err := json.Unmarshal(statsResp.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("無法解析佇列狀態: %v", err)
+	}
+	if stats.Depth != 1 || stats.InFlight != 1 {
+		t.Fatalf("佇列狀態錯誤: %+v", stats)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// 限流範圍，亦作為 ai_engine_rate_limited_total 的 scope 標籤。
+const (
+	RateLimitScopeUser   = "user"
+	RateLimitScopeTeam   = "team"
+	RateLimitScopeGlobal = "global"
+)
+
+const (
+	defaultRateLimitKeyPrefix = "ai-engine:ratelimit:"
+	// inMemoryRateLimitSweepInterval 為清除已回滿的閒置桶的最短間隔。
+	inMemoryRateLimitSweepInterval = time.Minute
+)
+
+// ErrInvalidRateLimit 代表限流設定格式錯誤。
+var ErrInvalidRateLimit = errors.New("invalid rate limit")
+
+// RateLimit 以 token bucket 描述限流：每 Per 補充 Requests 個 token，最多累積 Burst 個。
+// Requests 為 0 時停用。
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	// Burst 預設等於 Requests。
+	Burst int
+}
+
+// Enabled 回傳限流是否啟用。
+func (l RateLimit) Enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+func (l RateLimit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// ratePerMillisecond 回傳每毫秒補充的 token 數。
+func (l RateLimit) ratePerMillisecond() float64 {
+	return float64(l.Requests) / float64(l.Per.Milliseconds())
+}
+
+// ParseRateLimit 解析 "N/duration" 或 "N/duration:burst" 格式，例如 "10/1m" 或 "100/1h:20"；
+// 時間單位可省略數字，如 "10/m"。空字串代表停用。
+func ParseRateLimit(value string) (RateLimit, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return RateLimit{}, nil
+	}
+	requestsPart, periodPart, ok := strings.Cut(value, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("%w: %q 應為 N/duration", ErrInvalidRateLimit, value)
+	}
+	var limit RateLimit
+	var err error
+	if limit.Requests, err = strconv.Atoi(strings.TrimSpace(requestsPart)); err != nil || limit.Requests < 0 {
+		return RateLimit{}, fmt.Errorf("%w: %q 的請求數無效", ErrInvalidRateLimit, value)
+	}
+	periodPart, burstPart, hasBurst := strings.Cut(periodPart, ":")
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(strings.TrimSpace(burstPart)); err != nil || limit.Burst < 0 {
+			return RateLimit{}, fmt.Errorf("%w: %q 的 burst 無效", ErrInvalidRateLimit, value)
+		}
+	}
+	period := strings.TrimSpace(periodPart)
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	if limit.Per, err = time.ParseDuration(period); err != nil || limit.Per < time.Millisecond {
+		return RateLimit{}, fmt.Errorf("%w: %q 的期間無效", ErrInvalidRateLimit, value)
+	}
+	return limit, nil
+}
+
+// rateLimitBucket 為單次請求需扣除 token 的桶。
+type rateLimitBucket struct {
+	Scope string
+	Key   string
+	Limit RateLimit
+}
+
+// RateLimitDecision 為限流判斷結果；拒絕時 Scope 為耗盡的限流範圍。
+type RateLimitDecision struct {
+	Allowed    bool
+	Scope      string
+	RetryAfter time.Duration
+}
+
+// RateLimitStore 保存 token bucket 狀態。Take 須以原子方式判斷所有桶，
+// 僅在每個桶皆有剩餘 token 時同時扣除，避免被拒絕的請求消耗其他範圍的額度。
+type RateLimitStore interface {
+	Take(ctx context.Context, buckets []rateLimitBucket, now time.Time) (RateLimitDecision, error)
+}
+
+// RateLimitConfig 設定分析建立的限流。
+type RateLimitConfig struct {
+	// User 為每位使用者的限流，依 JWT subject 計算，未啟用驗證時無法套用。
+	User RateLimit
+	// Team 為每個團隊的限流，使用者屬於多個團隊時每個團隊皆扣除；同樣需要啟用驗證。
+	Team RateLimit
+	// Global 為整個服務的限流，跨副本共用時需搭配 Redis store。
+	Global RateLimit
+	// Store 預設為單一程序內的 InMemoryRateLimitStore。
+	Store RateLimitStore
+}
+
+// RequiresAuth 回傳是否設定了需依驗證身分計算的使用者或團隊限流。
+func (c RateLimitConfig) RequiresAuth() bool {
+	return c.User.Enabled() || c.Team.Enabled()
+}
+
+// RateLimiter 依驗證後的使用者、團隊與全域額度限制分析建立請求。
+type RateLimiter struct {
+	store  RateLimitStore
+	user   RateLimit
+	team   RateLimit
+	global RateLimit
+}
+
+// NewRateLimiter 建立限流器；所有限流皆停用時回傳 nil。
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	if !cfg.User.Enabled() && !cfg.Team.Enabled() && !cfg.Global.Enabled() {
+		return nil
+	}
+	store := cfg.Store
+	if store == nil {
+		store = NewInMemoryRateLimitStore()
+	}
+	return &RateLimiter{store: store, user: cfg.User, team: cfg.Team, global: cfg.Global}
+}
+
+// Allow 為請求者扣除一個 token；未驗證的請求僅套用全域限流。nil 限流器永遠允許。
+func (r *RateLimiter) Allow(ctx context.Context) (RateLimitDecision, error) {
+	if r == nil {
+		return RateLimitDecision{Allowed: true}, nil
+	}
+	buckets := r.buckets(ctx)
+	if len(buckets) == 0 {
+		return RateLimitDecision{Allowed: true}, nil
+	}
+	return r.store.Take(ctx, buckets, time.Now())
+}
+
+func (r *RateLimiter) buckets(ctx context.Context) []rateLimitBucket {
+	var buckets []rateLimitBucket
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		if r.user.Enabled() {
+			buckets = append(buckets, rateLimitBucket{Scope: RateLimitScopeUser, Key: RateLimitScopeUser + ":" + principal.Subject, Limit: r.user})
+		}
+		if r.team.Enabled() {
+			seen := make(map[string]bool, len(principal.Teams))
+			for _, team := range principal.Teams {
+				if team == "" || seen[team] {
+					continue
+				}
+				seen[team] = true
+				buckets = append(buckets, rateLimitBucket{Scope: RateLimitScopeTeam, Key: RateLimitScopeTeam + ":" + team, Limit: r.team})
+			}
+		}
+	}
+	if r.global.Enabled() {
+		buckets = append(buckets, rateLimitBucket{Scope: RateLimitScopeGlobal, Key: RateLimitScopeGlobal, Limit: r.global})
+	}
+	return buckets
+}
+
+// rateLimitMiddleware 於建立分析前扣除限流額度，超過時回傳 429 與 Retry-After。
+// 限流儲存無法使用時記錄警告並放行，避免 Redis 故障導致無法建立分析。
+func (s *AnalysisService) rateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		decision, err := s.rateLimiter.Allow(c.Request.Context())
+		if err != nil {
+			s.metrics.rateLimitErrors.Inc()
+			s.logger.WarnContext(c.Request.Context(), "限流檢查失敗，放行請求", slog.String(logKeyError, err.Error()))
+			c.Next()
+			return
+		}
+		if !decision.Allowed {
+			s.metrics.rateLimited.WithLabelValues(decision.Scope).Inc()
+			c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(decision.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, errorResponse{Error: rateLimitMessage(decision.Scope)})
+			return
+		}
+		c.Next()
+	}
+}
+
+func rateLimitMessage(scope string) string {
+	switch scope {
+	case RateLimitScopeUser:
+		return "分析請求過於頻繁，已超過個人限額，請稍後再試"
+	case RateLimitScopeTeam:
+		return "分析請求過於頻繁，已超過團隊限額，請稍後再試"
+	default:
+		return "分析請求過於頻繁，請稍後再試"
+	}
+}
+
+// InMemoryRateLimitStore 將 token bucket 保存在程序內，適用於單一副本與測試。
+type InMemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	limit   RateLimit
+}
+
+// refill 回傳 now 時的 token 數，不超過容量。
+func (b *tokenBucket) refill(now time.Time) float64 {
+	elapsed := float64(now.Sub(b.updated).Milliseconds())
+	if elapsed <= 0 {
+		return b.tokens
+	}
+	return math.Min(b.limit.capacity(), b.tokens+elapsed*b.limit.ratePerMillisecond())
+}
+
+// NewInMemoryRateLimitStore 建立程序內的限流儲存。
+func NewInMemoryRateLimitStore() *InMemoryRateLimitStore {
+	return &InMemoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+}
+
+// Take 實作 RateLimitStore。
+func (s *InMemoryRateLimitStore) Take(_ context.Context, buckets []rateLimitBucket, now time.Time) (RateLimitDecision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	states := make([]*tokenBucket, len(buckets))
+	decision := RateLimitDecision{Allowed: true}
+	for i, bucket := range buckets {
+		state, ok := s.buckets[bucket.Key]
+		if !ok || state.limit != bucket.Limit {
+			state = &tokenBucket{tokens: bucket.Limit.capacity(), updated: now, limit: bucket.Limit}
+			s.buckets[bucket.Key] = state
+		}
+		state.tokens = state.refill(now)
+		state.updated = now
+		states[i] = state
+		if state.tokens < 1 {
+			wait := time.Duration(math.Ceil((1-state.tokens)/bucket.Limit.ratePerMillisecond())) * time.Millisecond
+			if decision.Allowed || wait > decision.RetryAfter {
+				decision = RateLimitDecision{Scope: bucket.Scope, RetryAfter: wait}
+			}
+		}
+	}
+	if decision.Allowed {
+		for _, state := range states {
+			state.tokens--
+		}
+	}
+	return decision, nil
+}
+
+// sweep 移除已回滿的桶，避免大量使用者造成記憶體持續成長。
+func (s *InMemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < inMemoryRateLimitSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, state := range s.buckets {
+		if state.refill(now) >= state.limit.capacity() {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// redisTakeScript 以 Lua 腳本原子判斷所有桶。每個桶以 hash 保存 tokens 與 ts (毫秒)，
+// 回傳 {允許與否, 拒絕的桶索引 (1 起算), 需等待的毫秒數}。
+var redisTakeScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local n = #KEYS
+local tokens = {}
+local denied = 0
+local wait = 0
+for i = 1, n do
+  local rate = tonumber(ARGV[2 + (i - 1) * 2])
+  local capacity = tonumber(ARGV[3 + (i - 1) * 2])
+  local state = redis.call("HMGET", KEYS[i], "tokens", "ts")
+  local current = tonumber(state[1])
+  local ts = tonumber(state[2])
+  if current == nil or ts == nil then
+    current = capacity
+  elseif now > ts then
+    current = math.min(capacity, current + (now - ts) * rate)
+  end
+  tokens[i] = current
+  if current < 1 then
+    local w = math.ceil((1 - current) / rate)
+    if denied == 0 or w > wait then
+      denied = i
+      wait = w
+    end
+  end
+end
+for i = 1, n do
+  local rate = tonumber(ARGV[2 + (i - 1) * 2])
+  local capacity = tonumber(ARGV[3 + (i - 1) * 2])
+  local current = tokens[i]
+  if denied == 0 then
+    current = current - 1
+  end
+  redis.call("HSET", KEYS[i], "tokens", tostring(current), "ts", tostring(now))
+  redis.call("PEXPIRE", KEYS[i], math.ceil(capacity / rate) + 1000)
+end
+if denied == 0 then
+  return {1, 0, 0}
+end
+return {0, denied, wait}
+`)
+
+// RedisRateLimitStore 將 token bucket 保存在 Redis，讓多個副本共用限額。
+// 時間由呼叫端提供，各副本需同步系統時鐘。
+// 腳本一次存取多個鍵，鍵名以 prefix 作為 hash tag (例如 {ai-engine:ratelimit:}user:alice)，
+// 讓同一請求的所有桶落在 Redis Cluster 的同一個 slot，避免 CROSSSLOT 錯誤。
+type RedisRateLimitStore struct {
+	client redis.Scripter
+	prefix string
+}
+
+// NewRedisRateLimitStore 建立 Redis 限流儲存，prefix 預設為 ai-engine:ratelimit:。
+func NewRedisRateLimitStore(client redis.Scripter, prefix string) *RedisRateLimitStore {
+	if prefix == "" {
+		prefix = defaultRateLimitKeyPrefix
+	}
+	// 已自帶 hash tag 的 prefix 沿用，Redis 只取第一組大括號計算 slot。
+	if !strings.Contains(prefix, "{") {
+		prefix = "{" + prefix + "}"
+	}
+	return &RedisRateLimitStore{client: client, prefix: prefix}
+}
+
+// Take 實作 RateLimitStore。
+func (s *RedisRateLimitStore) Take(ctx context.Context, buckets []rateLimitBucket, now time.Time) (RateLimitDecision, error) {
+	keys := make([]string, len(buckets))
+	args := make([]any, 0, 1+len(buckets)*2)
+	args = append(args, now.UnixMilli())
+	for i, bucket := range buckets {
+		keys[i] = s.prefix + bucket.Key
+		args = append(args,
+			strconv.FormatFloat(bucket.Limit.ratePerMillisecond(), 'g', -1, 64),
+			strconv.FormatFloat(bucket.Limit.capacity(), 'g', -1, 64),
+		)
+	}
+	result, err := redisTakeScript.Run(ctx, s.client, keys, args...).Int64Slice()
+	if err != nil {
+		return RateLimitDecision{}, fmt.Errorf("redis 限流失敗: %w", err)
+	}
+	if len(result) != 3 {
+		return RateLimitDecision{}, fmt.Errorf("redis 限流回傳格式錯誤: %v", result)
+	}
+	if result[0] == 1 {
+		return RateLimitDecision{Allowed: true}, nil
+	}
+	denied := int(result[1]) - 1
+	if denied < 0 || denied >= len(buckets) {
+		return RateLimitDecision{}, fmt.Errorf("redis 限流回傳無效的桶索引 %d", result[1])
+	}
+	return RateLimitDecision{Scope: buckets[denied].Scope, RetryAfter: time.Duration(result[2]) * time.Millisecond}, nil
+}
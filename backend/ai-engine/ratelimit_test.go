@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		want  RateLimit
+	}{
+		{value: "", want: RateLimit{}},
+		{value: "10/1m", want: RateLimit{Requests: 10, Per: time.Minute}},
+		{value: "100/h", want: RateLimit{Requests: 100, Per: time.Hour}},
+		{value: "5/30s:20", want: RateLimit{Requests: 5, Per: 30 * time.Second, Burst: 20}},
+	}
+	for _, tc := range tests {
+		got, err := ParseRateLimit(tc.value)
+		if err != nil || got != tc.want {
+			t.Fatalf("%q: 預期 %+v，實際為 %+v (%v)", tc.value, tc.want, got, err)
+		}
+	}
+	for _, value := range []string{"10", "x/1m", "10/forever", "10/1m:x", "-1/1m"} {
+		if _, err := ParseRateLimit(value); !errors.Is(err, ErrInvalidRateLimit) {
+			t.Fatalf("%q 應回傳 ErrInvalidRateLimit，實際為 %v", value, err)
+		}
+	}
+}
+
+// testRateLimitStore 驗證 token bucket 的補充、拒絕與多桶原子扣除，供各儲存實作共用。
+func testRateLimitStore(t *testing.T, store RateLimitStore) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	user := rateLimitBucket{Scope: RateLimitScopeUser, Key: "user:alice", Limit: RateLimit{Requests: 2, Per: time.Minute}}
+	global := rateLimitBucket{Scope: RateLimitScopeGlobal, Key: "global", Limit: RateLimit{Requests: 10, Per: time.Minute}}
+
+	for i := 0; i < 2; i++ {
+		decision, err := store.Take(ctx, []rateLimitBucket{user, global}, now)
+		if err != nil || !decision.Allowed {
+			t.Fatalf("第 %d 次請求應允許，實際為 %+v (%v)", i+1, decision, err)
+		}
+	}
+	decision, err := store.Take(ctx, []rateLimitBucket{user, global}, now)
+	if err != nil || decision.Allowed || decision.Scope != RateLimitScopeUser {
+		t.Fatalf("超過個人額度應拒絕，實際為 %+v (%v)", decision, err)
+	}
+	if decision.RetryAfter != 30*time.Second {
+		t.Fatalf("每分鐘 2 次應於 30 秒後補充，實際為 %s", decision.RetryAfter)
+	}
+
+	// 被拒絕的請求不消耗全域額度：另一位使用者仍可使用剩餘的 8 個 token。
+	bob := rateLimitBucket{Scope: RateLimitScopeUser, Key: "user:bob", Limit: RateLimit{Requests: 100, Per: time.Minute}}
+	for i := 0; i < 8; i++ {
+		if decision, _ := store.Take(ctx, []rateLimitBucket{bob, global}, now); !decision.Allowed {
+			t.Fatalf("全域額度第 %d 次應允許，實際為 %+v", i+3, decision)
+		}
+	}
+	if decision, _ := store.Take(ctx, []rateLimitBucket{bob, global}, now); decision.Allowed || decision.Scope != RateLimitScopeGlobal {
+		t.Fatalf("超過全域額度應拒絕，實際為 %+v", decision)
+	}
+
+	if decision, _ := store.Take(ctx, []rateLimitBucket{user}, now.Add(30*time.Second)); !decision.Allowed {
+		t.Fatalf("30 秒後應補充一個 token，實際為 %+v", decision)
+	}
+	if decision, _ := store.Take(ctx, []rateLimitBucket{user}, now.Add(30*time.Second)); decision.Allowed {
+		t.Fatalf("補充的 token 已用完，應拒絕")
+	}
+}
+
+func TestInMemoryRateLimitStore(t *testing.T) {
+	testRateLimitStore(t, NewInMemoryRateLimitStore())
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	testRateLimitStore(t, NewRedisRateLimitStore(client, "test:"))
+
+	// 不同副本透過同一個 Redis 共用額度。
+	replicaA := NewRedisRateLimitStore(client, "shared:")
+	replicaB := NewRedisRateLimitStore(redis.NewClient(&redis.Options{Addr: server.Addr()}), "shared:")
+	bucket := []rateLimitBucket{{Scope: RateLimitScopeGlobal, Key: "global", Limit: RateLimit{Requests: 1, Per: time.Hour}}}
+	now := time.Now()
+	if decision, err := replicaA.Take(context.Background(), bucket, now); err != nil || !decision.Allowed {
+		t.Fatalf("第一次請求應允許，實際為 %+v (%v)", decision, err)
+	}
+	if decision, _ := replicaB.Take(context.Background(), bucket, now); decision.Allowed {
+		t.Fatalf("另一副本應看到已用完的額度")
+	}
+	if ttl := server.TTL("{shared:}global"); ttl <= 0 || ttl > time.Hour+2*time.Second {
+		t.Fatalf("限流鍵應設定約一小時的過期時間，實際為 %s", ttl)
+	}
+
+	server.Close()
+	if _, err := replicaA.Take(context.Background(), bucket, now); err == nil {
+		t.Fatalf("Redis 無法連線時應回傳錯誤")
+	}
+}
+
+func TestRateLimitAnalysisCreation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth, err := NewAuthenticator(AuthConfig{HS256Secret: testJWTSecret})
+	if err != nil {
+		t.Fatalf("建立驗證器失敗: %v", err)
+	}
+	limiter := NewRateLimiter(RateLimitConfig{
+		User: RateLimit{Requests: 2, Per: time.Minute},
+		Team: RateLimit{Requests: 3, Per: time.Minute},
+	})
+	metrics := NewMetrics()
+	service := NewAnalysisService(NewInMemoryReportRepository(), &stubGenerator{result: &GeneratedReport{}}, AnalysisServiceConfig{
+		Auth:        auth,
+		RateLimiter: limiter,
+		Metrics:     metrics,
+	})
+	defer service.Wait()
+	router := SetupRouter(service)
+
+	tokenFor := func(username string) string {
+		claims := userClaims(username, RoleTeamMember)
+		claims["teams"] = []string{"sre"}
+		return signHS256(t, claims)
+	}
+	create := func(token, eventID string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, authedRequest(http.MethodPost, "/api/v1/events/"+eventID+"/ai-analysis", token, nil))
+		return resp
+	}
+
+	alice, bob, carol := tokenFor("alice"), tokenFor("bob"), tokenFor("carol")
+	for i, eventID := range []string{"evt-rl-1", "evt-rl-2"} {
+		if resp := create(alice, eventID); resp.Code != http.StatusAccepted {
+			t.Fatalf("第 %d 次請求應回傳 202，實際為 %d", i+1, resp.Code)
+		}
+	}
+	resp := create(alice, "evt-rl-3")
+	if resp.Code != http.StatusTooManyRequests || !strings.Contains(resp.Body.String(), "個人限額") {
+		t.Fatalf("超過個人額度應回傳 429，實際為 %d: %s", resp.Code, resp.Body.String())
+	}
+	if seconds, err := strconv.Atoi(resp.Header().Get("Retry-After")); err != nil || seconds < 1 || seconds > 30 {
+		t.Fatalf("Retry-After 應介於 1 至 30 秒，實際為 %q", resp.Header().Get("Retry-After"))
+	}
+
+	// 同團隊的其他成員共用團隊額度。
+	if resp := create(bob, "evt-rl-4"); resp.Code != http.StatusAccepted {
+		t.Fatalf("團隊仍有額度時應回傳 202，實際為 %d", resp.Code)
+	}
+	if resp := create(carol, "evt-rl-5"); resp.Code != http.StatusTooManyRequests || !strings.Contains(resp.Body.String(), "團隊限額") {
+		t.Fatalf("超過團隊額度應回傳 429，實際為 %d: %s", resp.Code, resp.Body.String())
+	}
+
+	// 重新產生同樣受限，查詢不受影響。
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, authedRequest(http.MethodPost, "/api/v1/events/evt-rl-1/analysis", alice, nil))
+	if resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("重新產生應受限流，實際為 %d", resp.Code)
+	}
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, authedRequest(http.MethodGet, "/api/v1/analysis/ai-insights", alice, nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("查詢不應受限流，實際為 %d", resp.Code)
+	}
+
+	if got := testutil.ToFloat64(metrics.rateLimited.WithLabelValues(RateLimitScopeUser)); got != 2 {
+		t.Fatalf("個人限流次數應為 2，實際為 %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.rateLimited.WithLabelValues(RateLimitScopeTeam)); got != 1 {
+		t.Fatalf("團隊限流次數應為 1，實際為 %v", got)
+	}
+}
+
+func TestRateLimitGlobalWithoutAuthAndFailOpen(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	limiter := NewRateLimiter(RateLimitConfig{
+		User:   RateLimit{Requests: 1, Per: time.Minute},
+		Global: RateLimit{Requests: 1, Per: time.Minute},
+		Store:  NewRedisRateLimitStore(client, ""),
+	})
+	metrics := NewMetrics()
+	service := NewAnalysisService(NewInMemoryReportRepository(), &stubGenerator{result: &GeneratedReport{}}, AnalysisServiceConfig{RateLimiter: limiter, Metrics: metrics})
+	defer service.Wait()
+	router := SetupRouter(service)
+
+	// 未啟用驗證時僅套用全域額度。
+	for i, want := range []int{http.StatusAccepted, http.StatusTooManyRequests} {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/v1/events/evt-global-"+strconv.Itoa(i)+"/ai-analysis", nil))
+		if resp.Code != want {
+			t.Fatalf("第 %d 次請求預期 %d，實際為 %d", i+1, want, resp.Code)
+		}
+	}
+
+	// Redis 故障時放行請求。
+	server.Close()
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/v1/events/evt-global-open/ai-analysis", nil))
+	if resp.Code != http.StatusAccepted {
+		t.Fatalf("限流儲存故障時應放行，實際為 %d", resp.Code)
+	}
+	if got := testutil.ToFloat64(metrics.rateLimitErrors); got != 1 {
+		t.Fatalf("放行的請求應計入 rate_limit_errors_total，實際為 %v", got)
+	}
+
+	if NewRateLimiter(RateLimitConfig{}) != nil {
+		t.Fatalf("未設定任何限流時應回傳 nil")
+	}
+}
+
+func TestRateLimiterFromEnvRequiresAuth(t *testing.T) {
+	t.Setenv("AI_ENGINE_RATE_LIMIT_TEAM", "10/1m")
+	if _, _, err := rateLimiterFromEnv(false); !errors.Is(err, ErrInvalidRateLimit) {
+		t.Fatalf("未啟用驗證時設定團隊限流應回傳 ErrInvalidRateLimit，實際為 %v", err)
+	}
+	limiter, closeStore, err := rateLimiterFromEnv(true)
+	if err != nil || limiter == nil {
+		t.Fatalf("啟用驗證時應建立限流器: %v", err)
+	}
+	closeStore()
+
+	t.Setenv("AI_ENGINE_RATE_LIMIT_TEAM", "")
+	t.Setenv("AI_ENGINE_RATE_LIMIT_GLOBAL", "10/1m")
+	if limiter, _, err := rateLimiterFromEnv(false); err != nil || limiter == nil {
+		t.Fatalf("全域限流不需驗證: %v", err)
+	}
+}
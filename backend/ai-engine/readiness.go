@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReadinessTimeout = 3 * time.Second
+	// queueDegradedRatio 為佇列使用率達此比例時回報 degraded。
+	queueDegradedRatio = 0.8
+)
+
+// 就緒檢查元件名稱。
+const (
+	ComponentService    = "service"
+	ComponentRepository = "repository"
+	ComponentGenerator  = "generator"
+	ComponentQueue      = "queue"
+)
+
+// HealthStatus 為元件或整體的健康狀態。
+type HealthStatus string
+
+const (
+	HealthStatusOK       HealthStatus = "ok"
+	HealthStatusDegraded HealthStatus = "degraded"
+	HealthStatusDown     HealthStatus = "down"
+)
+
+// ErrLLMUnauthorized 代表 LLM 端點拒絕目前的憑證。
+var ErrLLMUnauthorized = errors.New("llm credentials rejected")
+
+// HealthChecker 為可回報自身可用性的儲存庫或產生器，未實作時視為永遠可用。
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// ComponentStatus 為單一依賴元件的檢查結果。
+type ComponentStatus struct {
+	Name          string       `json:"name"`
+	Status        HealthStatus `json:"status"`
+	Message       string       `json:"message,omitempty"`
+	LatencyMS     int64        `json:"latency_ms"`
+	Dependency    string       `json:"dependency,omitempty"`
+	LastCheckedAt time.Time    `json:"last_checked_at"`
+}
+
+// ReadinessStatus 對應 API 合約的 ReadinessCheckStatus。
+type ReadinessStatus struct {
+	Status              HealthStatus      `json:"status"`
+	CheckedAt           time.Time         `json:"checked_at"`
+	Components          []ComponentStatus `json:"components"`
+	Notes               []string          `json:"notes,omitempty"`
+	PendingDependencies []string          `json:"pending_dependencies,omitempty"`
+}
+
+// Ready 代表服務可接收流量；degraded 仍視為就緒。
+func (r ReadinessStatus) Ready() bool {
+	return r.Status != HealthStatusDown
+}
+
+// CheckReadiness 並行檢查儲存庫、產生器與工作佇列，任一元件 down 時整體為 down。
+func (s *AnalysisService) CheckReadiness(ctx context.Context) ReadinessStatus {
+	ctx, cancel := context.WithTimeout(ctx, s.readinessTimeout)
+	defer cancel()
+
+	components := []ComponentStatus{
+		s.checkServiceState(),
+		{Name: ComponentRepository, Dependency: describeDependency(s.repo)},
+		{Name: ComponentGenerator, Dependency: describeDependency(s.generator)},
+		s.checkQueue(),
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		checkComponent(ctx, &components[1], s.repo)
+	}()
+	go func() {
+		defer wg.Done()
+		checkComponent(ctx, &components[2], s.generator)
+	}()
+	wg.Wait()
+
+	status := ReadinessStatus{Status: HealthStatusOK, CheckedAt: time.Now().UTC(), Components: components}
+	for _, component := range components {
+		switch component.Status {
+		case HealthStatusDown:
+			status.Status = HealthStatusDown
+			status.PendingDependencies = append(status.PendingDependencies, component.Name)
+		case HealthStatusDegraded:
+			if status.Status == HealthStatusOK {
+				status.Status = HealthStatusDegraded
+			}
+		}
+		if component.Status != HealthStatusOK && component.Message != "" {
+			status.Notes = append(status.Notes, fmt.Sprintf("%s: %s", component.Name, component.Message))
+		}
+	}
+	return status
+}
+
+// checkComponent 呼叫元件的 HealthCheck 並記錄耗時。
+func checkComponent(ctx context.Context, component *ComponentStatus, target any) {
+	started := time.Now()
+	component.Status = HealthStatusOK
+	if checker, ok := target.(HealthChecker); ok {
+		if err := checker.HealthCheck(ctx); err != nil {
+			component.Status = HealthStatusDown
+			component.Message = err.Error()
+		}
+	}
+	component.LatencyMS = time.Since(started).Milliseconds()
+	component.LastCheckedAt = time.Now().UTC()
+}
+
+// checkServiceState 於關閉流程開始後回報 down，讓負載平衡器停止導入流量。
+func (s *AnalysisService) checkServiceState() ComponentStatus {
+	component := ComponentStatus{Name: ComponentService, Status: HealthStatusOK, LastCheckedAt: time.Now().UTC()}
+	if s.closing.Load() {
+		component.Status = HealthStatusDown
+		component.Message = "服務正在關閉"
+	}
+	return component
+}
+
+// checkQueue 依等待中任務佔容量的比例判斷佇列是否飽和。
+func (s *AnalysisService) checkQueue() ComponentStatus {
+	depth, inFlight, _ := s.queue.stats()
+	component := ComponentStatus{Name: ComponentQueue, Status: HealthStatusOK, LastCheckedAt: time.Now().UTC()}
+	usage := float64(depth) / float64(s.queue.capacity)
+	switch {
+	case depth >= s.queue.capacity:
+		component.Status = HealthStatusDown
+		component.Message = fmt.Sprintf("佇列已滿 (%d/%d，執行中 %d)", depth, s.queue.capacity, inFlight)
+	case usage >= queueDegradedRatio:
+		component.Status = HealthStatusDegraded
+		component.Message = fmt.Sprintf("佇列接近飽和 (%d/%d，執行中 %d)", depth, s.queue.capacity, inFlight)
+	}
+	return component
+}
+
+// describeDependency 回傳元件背後的依賴名稱，供就緒檢查輸出。
+func describeDependency(target any) string {
+	switch v := target.(type) {
+	case *InMemoryReportRepository:
+		return "memory"
+	case *SQLReportRepository:
+		return v.db.Dialector.Name()
+	case *TemplateReportGenerator:
+		return "template"
+	case *OpenAIReportGenerator:
+		if u, err := url.Parse(v.modelsEndpoint); err == nil {
+			return u.Host
+		}
+		return "openai"
+	default:
+		return ""
+	}
+}
+
+// HealthCheck 確認資料庫連線可用。
+func (r *SQLReportRepository) HealthCheck(ctx context.Context) error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("資料庫無法連線: %w", err)
+	}
+	return nil
+}
+
+// HealthCheck 確認模板已載入。
+func (g *TemplateReportGenerator) HealthCheck(context.Context) error {
+	if len(g.templates) == 0 {
+		return ErrNoTemplates
+	}
+	return nil
+}
+
+// HealthCheck 呼叫 LLM 端點的 models API，確認端點可連線且憑證有效。
+// 不提供 models API 的相容端點 (404/405) 視為可用。
+func (g *OpenAIReportGenerator) HealthCheck(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.modelsEndpoint, nil)
+	if err != nil {
+		return fmt.Errorf("無法建立 LLM 健康檢查請求: %w", err)
+	}
+	if g.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+g.apiKey)
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("LLM 端點無法連線: %w", err)
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w (status %d)", ErrLLMUnauthorized, resp.StatusCode)
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed:
+		return nil
+	case resp.StatusCode >= http.StatusBadRequest:
+		return &LLMStatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
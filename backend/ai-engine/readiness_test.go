@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func componentByName(status ReadinessStatus, name string) ComponentStatus {
+	for _, component := range status.Components {
+		if component.Name == name {
+			return component
+		}
+	}
+	return ComponentStatus{}
+}
+
+func TestReadinessEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	service := NewAnalysisService(newTestSQLRepository(t), newTestTemplateGenerator(0), AnalysisServiceConfig{})
+	router := SetupRouter(service)
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("依賴正常時應回傳 200，實際為 %d: %s", resp.Code, resp.Body.String())
+	}
+	var status ReadinessStatus
+	if err := json.Unmarshal(resp.Body.Bytes(), &status); err != nil {
+		t.Fatalf("無法解析回應: %v", err)
+	}
+	if status.Status != HealthStatusOK || len(status.Components) != 4 {
+		t.Fatalf("就緒狀態錯誤: %+v", status)
+	}
+	if repo := componentByName(status, ComponentRepository); repo.Status != HealthStatusOK || repo.Dependency != "sqlite" {
+		t.Fatalf("儲存庫狀態錯誤: %+v", repo)
+	}
+	if generator := componentByName(status, ComponentGenerator); generator.Dependency != "template" {
+		t.Fatalf("產生器狀態錯誤: %+v", generator)
+	}
+
+	// 關閉中的服務不再接收流量。
+	if err := service.Shutdown(context.Background()); err != nil {
+		t.Fatalf("關閉失敗: %v", err)
+	}
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if resp.Code != http.StatusServiceUnavailable || !strings.Contains(resp.Body.String(), `"pending_dependencies":["service"]`) {
+		t.Fatalf("關閉中應回傳 503，實際為 %d: %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestReadinessDetectsUnavailableDependencies(t *testing.T) {
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || r.Header.Get("Authorization") != "Bearer expired" {
+			t.Errorf("非預期的健康檢查請求: %s %s", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer llm.Close()
+
+	generator, err := NewOpenAIReportGenerator(OpenAIGeneratorConfig{BaseURL: llm.URL + "/v1", APIKey: "expired", Model: "gpt-test"})
+	if err != nil {
+		t.Fatalf("建立產生器失敗: %v", err)
+	}
+	repo := newTestSQLRepository(t)
+	if err := repo.Close(); err != nil {
+		t.Fatalf("關閉資料庫失敗: %v", err)
+	}
+
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{})
+	status := service.CheckReadiness(context.Background())
+	if status.Ready() || status.Status != HealthStatusDown {
+		t.Fatalf("依賴失效時不應就緒: %+v", status)
+	}
+	if !slices.Equal(status.PendingDependencies, []string{ComponentRepository, ComponentGenerator}) {
+		t.Fatalf("未就緒的依賴錯誤: %v", status.PendingDependencies)
+	}
+	if err := generator.HealthCheck(context.Background()); !errors.Is(err, ErrLLMUnauthorized) {
+		t.Fatalf("憑證失效應回傳 ErrLLMUnauthorized，實際為 %v", err)
+	}
+}
+
+func TestReadinessReportsQueueSaturation(t *testing.T) {
+	generator := newBlockingGenerator()
+	service := NewAnalysisService(NewInMemoryReportRepository(), generator, AnalysisServiceConfig{
+		ProcessingTimeout: 5 * time.Second,
+		Workers:           1,
+		QueueSize:         5,
+	})
+	defer func() {
+		close(generator.release)
+		service.Wait()
+	}()
+
+	// 第一筆由工作者取出執行，其餘留在佇列中。
+	for i, eventID := range []string{"evt-q0", "evt-q1", "evt-q2", "evt-q3", "evt-q4"} {
+		if _, err := service.CreateReport(context.Background(), eventID, CreateAnalysisRequest{}); err != nil {
+			t.Fatalf("建立報告失敗: %v", err)
+		}
+		if i == 0 {
+			<-generator.started
+		}
+	}
+	if queue := componentByName(service.CheckReadiness(context.Background()), ComponentQueue); queue.Status != HealthStatusDegraded {
+		t.Fatalf("佇列使用率 80%% 應為 degraded，實際為 %+v", queue)
+	}
+
+	if _, err := service.CreateReport(context.Background(), "evt-q5", CreateAnalysisRequest{}); err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	status := service.CheckReadiness(context.Background())
+	if queue := componentByName(status, ComponentQueue); queue.Status != HealthStatusDown || status.Ready() {
+		t.Fatalf("佇列已滿時應為 down，實際為 %+v", queue)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RecoveryPolicy 決定服務重啟後如何處理未完成的報告。
+type RecoveryPolicy string
+
+const (
+	// RecoveryPolicyFail 將遺留報告標記為 FAILED 並記錄中斷原因。
+	RecoveryPolicyFail RecoveryPolicy = "fail"
+	// RecoveryPolicyRequeue 將遺留報告重設為 PENDING 並重新排入分析。
+	RecoveryPolicyRequeue RecoveryPolicy = "requeue"
+)
+
+// orphanedReportMessage 為遺留報告被標記失敗時寫入的錯誤訊息。
+const orphanedReportMessage = "分析於服務重新啟動時中斷，報告已標記為失敗"
+
+// errRecoverySkipped 代表報告在處理期間已被其他流程更新，不需復原。
+var errRecoverySkipped = errors.New("report no longer orphaned")
+
+// ParseRecoveryPolicy 解析環境變數中的復原策略。
+func ParseRecoveryPolicy(value string) (RecoveryPolicy, error) {
+	switch policy := RecoveryPolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case "":
+		return RecoveryPolicyFail, nil
+	case RecoveryPolicyFail, RecoveryPolicyRequeue:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unsupported recovery policy: %s", value)
+	}
+}
+
+// RecoverySummary 統計啟動復原的處理結果。
+type RecoverySummary struct {
+	Requeued []string `json:"requeued,omitempty"`
+	Failed   []string `json:"failed,omitempty"`
+}
+
+// RecoverOrphanedReports 找出遺留的 PENDING/RUNNING 報告，依策略重新排入或標記失敗。
+func (s *AnalysisService) RecoverOrphanedReports(ctx context.Context) (RecoverySummary, error) {
+	var summary RecoverySummary
+
+	reports, err := traceRepo(ctx, "ListByStatus", "", func() ([]AnalysisReport, error) {
+		return s.repo.ListByStatus(ReportStatusPending, ReportStatusRunning)
+	})
+	if err != nil {
+		return summary, fmt.Errorf("無法查詢遺留報告: %w", err)
+	}
+
+	cutoff := time.Now().UTC().Add(-s.recoveryStale)
+	for _, report := range reports {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+		if report.UpdatedAt.After(cutoff) {
+			continue
+		}
+
+		switch s.recoveryPolicy {
+		case RecoveryPolicyRequeue:
+			if err := s.requeueOrphan(ctx, report.ReportID); err != nil {
+				if errors.Is(err, errRecoverySkipped) {
+					continue
+				}
+				return summary, err
+			}
+			// 啟動復原不受佇列容量限制，避免遺留報告再次卡住。
+			s.enqueue(report.ReportID, GenerationInput{EventID: report.EventID, EventContext: report.EventContext}, false, trace.SpanContextFromContext(ctx))
+			summary.Requeued = append(summary.Requeued, report.ReportID)
+		default:
+			if err := s.failOrphan(ctx, report.ReportID); err != nil {
+				if errors.Is(err, errRecoverySkipped) {
+					continue
+				}
+				return summary, err
+			}
+			summary.Failed = append(summary.Failed, report.ReportID)
+		}
+	}
+
+	return summary, nil
+}
+
+func (s *AnalysisService) requeueOrphan(ctx context.Context, reportID string) error {
+	_, err := s.updateReport(ctx, reportID, func(report *AnalysisReport) error {
+		if !isOrphanedStatus(report.Status) {
+			return errRecoverySkipped
+		}
+		report.Status = ReportStatusPending
+		report.ErrorMessage = ""
+		report.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+	return err
+}
+
+func (s *AnalysisService) failOrphan(ctx context.Context, reportID string) error {
+	_, err := s.updateReport(ctx, reportID, func(report *AnalysisReport) error {
+		if !isOrphanedStatus(report.Status) {
+			return errRecoverySkipped
+		}
+		now := time.Now().UTC()
+		report.Status = ReportStatusFailed
+		report.ErrorMessage = orphanedReportMessage
+		report.CompletedAt = &now
+		report.UpdatedAt = now
+		return nil
+	})
+	return err
+}
+
+func isOrphanedStatus(status ReportStatus) bool {
+	return status == ReportStatusPending || status == ReportStatusRunning
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func seedOrphanedReports(t *testing.T, repo ReportRepository) {
+	t.Helper()
+	now := time.Now().UTC().Add(-time.Minute)
+	seeds := []AnalysisReport{
+		{ReportID: "rpt-pending", EventID: "evt-pending", Status: ReportStatusPending, EventContext: map[string]any{"service": "auth"}, CreatedAt: now, UpdatedAt: now},
+		{ReportID: "rpt-running", EventID: "evt-running", Status: ReportStatusRunning, CreatedAt: now, UpdatedAt: now},
+		{ReportID: "rpt-done", EventID: "evt-done", Status: ReportStatusSuccess, CreatedAt: now, UpdatedAt: now},
+	}
+	for _, report := range seeds {
+		if _, err := repo.Create(report); err != nil {
+			t.Fatalf("建立測試報告失敗: %v", err)
+		}
+	}
+}
+
+func TestRecoverOrphanedReportsFail(t *testing.T) {
+	repo := NewInMemoryReportRepository()
+	seedOrphanedReports(t, repo)
+	service := NewAnalysisService(repo, &stubGenerator{}, AnalysisServiceConfig{RecoveryPolicy: RecoveryPolicyFail})
+
+	summary, err := service.RecoverOrphanedReports(context.Background())
+	if err != nil {
+		t.Fatalf("復原失敗: %v", err)
+	}
+	if len(summary.Failed) != 2 || len(summary.Requeued) != 0 {
+		t.Fatalf("預期標記兩筆失敗，實際為 %+v", summary)
+	}
+
+	for _, id := range []string{"rpt-pending", "rpt-running"} {
+		report, err := repo.Get(id)
+		if err != nil {
+			t.Fatalf("取得報告失敗: %v", err)
+		}
+		if report.Status != ReportStatusFailed || report.ErrorMessage != orphanedReportMessage {
+			t.Fatalf("遺留報告應標記為 FAILED，實際為 %s (%s)", report.Status, report.ErrorMessage)
+		}
+		if report.CompletedAt == nil {
+			t.Fatalf("失敗報告應記錄完成時間")
+		}
+	}
+
+	done, _ := repo.Get("rpt-done")
+	if done.Status != ReportStatusSuccess {
+		t.Fatalf("已完成的報告不應被變更")
+	}
+}
+
+func TestRecoverOrphanedReportsRequeue(t *testing.T) {
+	repo := NewInMemoryReportRepository()
+	seedOrphanedReports(t, repo)
+	generator := &stubGenerator{result: &GeneratedReport{EventSummary: "復原後完成"}}
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		ProcessingTimeout: time.Second,
+		RecoveryPolicy:    RecoveryPolicyRequeue,
+	})
+
+	summary, err := service.RecoverOrphanedReports(context.Background())
+	if err != nil {
+		t.Fatalf("復原失敗: %v", err)
+	}
+	if len(summary.Requeued) != 2 {
+		t.Fatalf("預期重新排入兩筆，實際為 %+v", summary)
+	}
+
+	service.Wait()
+
+	report, err := repo.Get("rpt-pending")
+	if err != nil {
+		t.Fatalf("取得報告失敗: %v", err)
+	}
+	if report.Status != ReportStatusSuccess || report.EventSummary != "復原後完成" {
+		t.Fatalf("重新排入的報告應完成分析，實際為 %s", report.Status)
+	}
+	if report.EventContext["service"] != "auth" {
+		t.Fatalf("重新排入時應保留原始事件上下文")
+	}
+}
+
+func TestRecoverOrphanedReportsStaleThreshold(t *testing.T) {
+	repo := NewInMemoryReportRepository()
+	seedOrphanedReports(t, repo)
+	service := NewAnalysisService(repo, &stubGenerator{}, AnalysisServiceConfig{
+		RecoveryPolicy:     RecoveryPolicyFail,
+		RecoveryStaleAfter: time.Hour,
+	})
+
+	summary, err := service.RecoverOrphanedReports(context.Background())
+	if err != nil {
+		t.Fatalf("復原失敗: %v", err)
+	}
+	if len(summary.Failed) != 0 {
+		t.Fatalf("未超過閾值的報告不應被處理，實際為 %+v", summary)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import "time"
+
+// ReportVersionSummary 為報告版本清單中的摘要資訊。
+type ReportVersionSummary struct {
+	ReportID        string       `json:"report_id"`
+	Version         int          `json:"version"`
+	Status          ReportStatus `json:"status"`
+	EventSummary    string       `json:"event_summary,omitempty"`
+	ConfidenceScore *float64     `json:"confidence_score,omitempty"`
+	CreatedAt       time.Time    `json:"created_at"`
+	CompletedAt     *time.Time   `json:"completed_at,omitempty"`
+}
+
+// RootCauseChange 描述兩個版本間根本原因的差異。
+type RootCauseChange struct {
+	Changed  bool   `json:"changed"`
+	Previous string `json:"previous,omitempty"`
+	Current  string `json:"current,omitempty"`
+}
+
+// ReportDiff 為兩個報告版本的結構化差異。
+type ReportDiff struct {
+	EventID         string              `json:"event_id"`
+	FromVersion     int                 `json:"from_version"`
+	ToVersion       int                 `json:"to_version"`
+	FromReportID    string              `json:"from_report_id"`
+	ToReportID      string              `json:"to_report_id"`
+	RootCause       RootCauseChange     `json:"root_cause"`
+	ConfidenceDelta float64             `json:"confidence_delta"`
+	AddedActions    []RecommendedAction `json:"added_actions"`
+	RemovedActions  []RecommendedAction `json:"removed_actions"`
+	AddedEvidence   []EvidenceItem      `json:"added_evidence"`
+	RemovedEvidence []EvidenceItem      `json:"removed_evidence"`
+}
+
+func summarizeReportVersion(report AnalysisReport) ReportVersionSummary {
+	summary := ReportVersionSummary{
+		ReportID:     report.ReportID,
+		Version:      report.Version,
+		Status:       report.Status,
+		EventSummary: report.EventSummary,
+		CreatedAt:    report.CreatedAt,
+	}
+	if report.RootCauseAnalysis != nil {
+		score := report.RootCauseAnalysis.ConfidenceScore
+		summary.ConfidenceScore = &score
+	}
+	if report.CompletedAt != nil {
+		completed := *report.CompletedAt
+		summary.CompletedAt = &completed
+	}
+	return summary
+}
+
+// DiffReports 比較兩個報告版本；建議措施以標題與類型比對，證據以類型與描述比對。
+func DiffReports(from, to AnalysisReport) ReportDiff {
+	diff := ReportDiff{
+		EventID:         to.EventID,
+		FromVersion:     from.Version,
+		ToVersion:       to.Version,
+		FromReportID:    from.ReportID,
+		ToReportID:      to.ReportID,
+		AddedActions:    []RecommendedAction{},
+		RemovedActions:  []RecommendedAction{},
+		AddedEvidence:   []EvidenceItem{},
+		RemovedEvidence: []EvidenceItem{},
+	}
+
+	var fromText, toText string
+	var fromScore, toScore float64
+	if from.RootCauseAnalysis != nil {
+		fromText = from.RootCauseAnalysis.Text
+		fromScore = from.RootCauseAnalysis.ConfidenceScore
+	}
+	if to.RootCauseAnalysis != nil {
+		toText = to.RootCauseAnalysis.Text
+		toScore = to.RootCauseAnalysis.ConfidenceScore
+	}
+	diff.RootCause = RootCauseChange{Changed: fromText != toText, Previous: fromText, Current: toText}
+	diff.ConfidenceDelta = toScore - fromScore
+
+	fromActions := indexActions(from.RecommendedActions)
+	toActions := indexActions(to.RecommendedActions)
+	for _, action := range to.RecommendedActions {
+		if _, ok := fromActions[actionKey(action)]; !ok {
+			diff.AddedActions = append(diff.AddedActions, action)
+		}
+	}
+	for _, action := range from.RecommendedActions {
+		if _, ok := toActions[actionKey(action)]; !ok {
+			diff.RemovedActions = append(diff.RemovedActions, action)
+		}
+	}
+
+	fromEvidence := reportEvidence(from)
+	toEvidence := reportEvidence(to)
+	fromEvidenceIndex := indexEvidence(fromEvidence)
+	toEvidenceIndex := indexEvidence(toEvidence)
+	for _, item := range toEvidence {
+		if _, ok := fromEvidenceIndex[evidenceKey(item)]; !ok {
+			diff.AddedEvidence = append(diff.AddedEvidence, item)
+		}
+	}
+	for _, item := range fromEvidence {
+		if _, ok := toEvidenceIndex[evidenceKey(item)]; !ok {
+			diff.RemovedEvidence = append(diff.RemovedEvidence, item)
+		}
+	}
+
+	return diff
+}
+
+// reportEvidence 合併根本原因與報告層級的證據並去除重複項目。
+func reportEvidence(report AnalysisReport) []EvidenceItem {
+	var items []EvidenceItem
+	seen := make(map[string]struct{})
+	appendUnique := func(list []EvidenceItem) {
+		for _, item := range list {
+			key := evidenceKey(item)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			items = append(items, item)
+		}
+	}
+	if report.RootCauseAnalysis != nil {
+		appendUnique(report.RootCauseAnalysis.Evidence)
+	}
+	appendUnique(report.Evidence)
+	return items
+}
+
+func actionKey(action RecommendedAction) string {
+	return action.ActionType + "\x00" + action.Title
+}
+
+func evidenceKey(item EvidenceItem) string {
+	return item.Type + "\x00" + item.Description
+}
+
+func indexActions(actions []RecommendedAction) map[string]struct{} {
+	index := make(map[string]struct{}, len(actions))
+	for _, action := range actions {
+		index[actionKey(action)] = struct{}{}
+	}
+	return index
+}
+
+func indexEvidence(items []EvidenceItem) map[string]struct{} {
+	index := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		index[evidenceKey(item)] = struct{}{}
+	}
+	return index
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sequenceGenerator 依呼叫順序回傳不同的結果，用於模擬事件演進。
+type sequenceGenerator struct {
+	mu      sync.Mutex
+	results []GeneratedReport
+	calls   int
+}
+
+func (g *sequenceGenerator) Generate(_ context.Context, _ GenerationInput) (*GeneratedReport, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	result := g.results[g.calls%len(g.results)].Clone()
+	g.calls++
+	return &result, nil
+}
+
+func TestDiffReports(t *testing.T) {
+	from := AnalysisReport{
+		ReportID: "rpt-1",
+		EventID:  "evt-1",
+		Version:  1,
+		RootCauseAnalysis: &RootCauseAnalysis{
+			Text:            "記憶體洩漏",
+			ConfidenceScore: 0.5,
+			Evidence:        []EvidenceItem{{Type: "METRIC", Description: "記憶體上升"}},
+		},
+		RecommendedActions: []RecommendedAction{
+			{Title: "重啟服務", ActionType: "MANUAL"},
+			{Title: "擴充記憶體", ActionType: "AUTOMATION"},
+		},
+		Evidence: []EvidenceItem{{Type: "METRIC", Description: "記憶體上升"}},
+	}
+	to := AnalysisReport{
+		ReportID: "rpt-2",
+		EventID:  "evt-1",
+		Version:  2,
+		RootCauseAnalysis: &RootCauseAnalysis{
+			Text:            "部署後設定錯誤",
+			ConfidenceScore: 0.8,
+		},
+		RecommendedActions: []RecommendedAction{
+			{Title: "重啟服務", ActionType: "MANUAL"},
+			{Title: "回滾部署", ActionType: "AUTOMATION"},
+		},
+		Evidence: []EvidenceItem{{Type: "CHANGE", Description: "v2.3.1 部署"}},
+	}
+
+	diff := DiffReports(from, to)
+	if !diff.RootCause.Changed || diff.RootCause.Previous != "記憶體洩漏" || diff.RootCause.Current != "部署後設定錯誤" {
+		t.Fatalf("根本原因差異錯誤: %+v", diff.RootCause)
+	}
+	if math.Abs(diff.ConfidenceDelta-0.3) > 1e-9 {
+		t.Fatalf("信心分數差異錯誤: %v", diff.ConfidenceDelta)
+	}
+	if len(diff.AddedActions) != 1 || diff.AddedActions[0].Title != "回滾部署" {
+		t.Fatalf("新增措施錯誤: %+v", diff.AddedActions)
+	}
+	if len(diff.RemovedActions) != 1 || diff.RemovedActions[0].Title != "擴充記憶體" {
+		t.Fatalf("移除措施錯誤: %+v", diff.RemovedActions)
+	}
+	if len(diff.AddedEvidence) != 1 || diff.AddedEvidence[0].Type != "CHANGE" {
+		t.Fatalf("新增證據錯誤: %+v", diff.AddedEvidence)
+	}
+	if len(diff.RemovedEvidence) != 1 || diff.RemovedEvidence[0].Description != "記憶體上升" {
+		t.Fatalf("移除證據應去除重複，實際為 %+v", diff.RemovedEvidence)
+	}
+}
+
+func TestEventAnalysisVersionEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := NewInMemoryReportRepository()
+	generator := &sequenceGenerator{results: []GeneratedReport{
+		{RootCauseAnalysis: RootCauseAnalysis{Text: "初步判斷", ConfidenceScore: 0.4}, RecommendedActions: []RecommendedAction{{Title: "觀察"}}},
+		{RootCauseAnalysis: RootCauseAnalysis{Text: "確認根因", ConfidenceScore: 0.9}, RecommendedActions: []RecommendedAction{{Title: "回滾"}}},
+	}}
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{ProcessingTimeout: time.Second})
+	router := SetupRouter(service)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/events/evt-hist/analysis", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		if resp.Code != http.StatusAccepted {
+			t.Fatalf("重新產生應回傳 202，實際為 %d", resp.Code)
+		}
+		service.Wait()
+	}
+
+	versionsReq := httptest.NewRequest(http.MethodGet, "/api/v1/events/evt-hist/analysis/versions", nil)
+	versionsResp := httptest.NewRecorder()
+	router.ServeHTTP(versionsResp, versionsReq)
+	if versionsResp.Code != http.StatusOK {
+		t.Fatalf("預期回傳 200，實際為 %d", versionsResp.Code)
+	}
+	var versions reportVersionsResponse
+	if err := json.Unmarshal(versionsResp.Body.Bytes(), &versions); err != nil {
+		t.Fatalf("解析版本清單失敗: %v", err)
+	}
+	if len(versions.Items) != 2 || versions.Items[0].Version != 1 || versions.Items[1].Version != 2 {
+		t.Fatalf("版本清單錯誤: %+v", versions.Items)
+	}
+	if versions.Items[1].ConfidenceScore == nil || *versions.Items[1].ConfidenceScore != 0.9 {
+		t.Fatalf("版本摘要應包含信心分數")
+	}
+
+	diffReq := httptest.NewRequest(http.MethodGet, "/api/v1/events/evt-hist/analysis/diff", nil)
+	diffResp := httptest.NewRecorder()
+	router.ServeHTTP(diffResp, diffReq)
+	if diffResp.Code != http.StatusOK {
+		t.Fatalf("預期回傳 200，實際為 %d", diffResp.Code)
+	}
+	var diff ReportDiff
+	if err := json.Unmarshal(diffResp.Body.Bytes(), &diff); err != nil {
+		t.Fatalf("解析差異失敗: %v", err)
+	}
+	if diff.FromVersion != 1 || diff.ToVersion != 2 || !diff.RootCause.Changed {
+		t.Fatalf("差異內容錯誤: %+v", diff)
+	}
+	if len(diff.AddedActions) != 1 || len(diff.RemovedActions) != 1 {
+		t.Fatalf("措施差異錯誤: %+v", diff)
+	}
+
+	for _, query := range []string{"?from=1&to=1", "?from=abc"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/events/evt-hist/analysis/diff"+query, nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("%s 應回傳 400，實際為 %d", query, resp.Code)
+		}
+	}
+
+	missingReq := httptest.NewRequest(http.MethodGet, "/api/v1/events/evt-hist/analysis/diff?from=1&to=5", nil)
+	missingResp := httptest.NewRecorder()
+	router.ServeHTTP(missingResp, missingReq)
+	if missingResp.Code != http.StatusNotFound {
+		t.Fatalf("不存在的版本應回傳 404，實際為 %d", missingResp.Code)
+	}
+}
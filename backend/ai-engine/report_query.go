@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// 可用於列表排序的欄位。
+const (
+	ReportSortCreatedAt       = "created_at"
+	ReportSortUpdatedAt       = "updated_at"
+	ReportSortConfidenceScore = "confidence_score"
+)
+
+// 列表排序方向。
+const (
+	SortOrderAsc  = "asc"
+	SortOrderDesc = "desc"
+)
+
+// ErrInvalidReportQuery 代表列表查詢參數無效。
+var ErrInvalidReportQuery = errors.New("invalid report query")
+
+// ReportQuery 描述報告列表的篩選、排序與分頁條件。
+type ReportQuery struct {
+	Statuses    []ReportStatus
+	EventID     string
+	Severity    string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	SortBy      string
+	SortOrder   string
+	Page        int
+	PageSize    int
+	// Scoped 為 true 時僅列出 Teams 內團隊的報告、Requesters 建立的報告，
+	// 以及未記錄建立者與團隊的報告，由 scopeReportQuery 依請求者設定。
+	Scoped     bool
+	Teams      []string
+	Requesters []string
+}
+
+// ReportPage 為分頁後的報告列表，欄位對應 openapi 的 PaginatedResponse。
+type ReportPage struct {
+	Page     int              `json:"page"`
+	PageSize int              `json:"page_size"`
+	Total    int              `json:"total"`
+	Items    []AnalysisReport `json:"items"`
+}
+
+// Normalize 套用預設值並驗證排序欄位與分頁範圍。
+func (q ReportQuery) Normalize() (ReportQuery, error) {
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	if q.PageSize == 0 {
+		q.PageSize = defaultPageSize
+	}
+	if q.Page < 1 || q.PageSize < 1 || q.PageSize > maxPageSize {
+		return q, ErrInvalidReportQuery
+	}
+
+	switch q.SortBy {
+	case "":
+		q.SortBy = ReportSortCreatedAt
+	case ReportSortCreatedAt, ReportSortUpdatedAt, ReportSortConfidenceScore:
+	default:
+		return q, ErrInvalidReportQuery
+	}
+	switch strings.ToLower(q.SortOrder) {
+	case "", SortOrderDesc:
+		q.SortOrder = SortOrderDesc
+	case SortOrderAsc:
+		q.SortOrder = SortOrderAsc
+	default:
+		return q, ErrInvalidReportQuery
+	}
+
+	if q.CreatedFrom != nil && q.CreatedTo != nil && q.CreatedFrom.After(*q.CreatedTo) {
+		return q, ErrInvalidReportQuery
+	}
+	q.Severity = strings.ToUpper(strings.TrimSpace(q.Severity))
+	return q, nil
+}
+
+// Offset 回傳目前頁面的起始位置。
+func (q ReportQuery) Offset() int {
+	return (q.Page - 1) * q.PageSize
+}
+
+// Descending 回傳是否以遞減排序，未指定時預設為遞減。
+func (q ReportQuery) Descending() bool {
+	return q.SortOrder != SortOrderAsc
+}
+
+// Matches 判斷報告是否符合篩選條件 (供記憶體儲存庫使用)。
+func (q ReportQuery) Matches(report *AnalysisReport) bool {
+	if len(q.Statuses) > 0 {
+		matched := false
+		for _, status := range q.Statuses {
+			if report.Status == status {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if q.EventID != "" && report.EventID != q.EventID {
+		return false
+	}
+	if q.Severity != "" && reportSeverity(report) != q.Severity {
+		return false
+	}
+	if q.CreatedFrom != nil && report.CreatedAt.Before(*q.CreatedFrom) {
+		return false
+	}
+	if q.CreatedTo != nil && report.CreatedAt.After(*q.CreatedTo) {
+		return false
+	}
+	if q.Scoped && (report.RequestedBy != "" || report.Team != "") &&
+		!slices.Contains(q.Teams, report.Team) && !slices.Contains(q.Requesters, report.RequestedBy) {
+		return false
+	}
+	return true
+}
+
+// sortReports 依查詢條件排序，相同值時以報告編號確保結果穩定。
+func sortReports(reports []AnalysisReport, q ReportQuery) {
+	sort.SliceStable(reports, func(i, j int) bool {
+		a, b := reports[i], reports[j]
+		var less, equal bool
+		switch q.SortBy {
+		case ReportSortUpdatedAt:
+			less, equal = a.UpdatedAt.Before(b.UpdatedAt), a.UpdatedAt.Equal(b.UpdatedAt)
+		case ReportSortConfidenceScore:
+			sa, sb := reportConfidence(&a), reportConfidence(&b)
+			less, equal = sa < sb, sa == sb
+		default:
+			less, equal = a.CreatedAt.Before(b.CreatedAt), a.CreatedAt.Equal(b.CreatedAt)
+		}
+		if equal {
+			return a.ReportID < b.ReportID
+		}
+		if q.Descending() {
+			return !less
+		}
+		return less
+	})
+}
+
+func reportSeverity(report *AnalysisReport) string {
+	if report.ImpactAssessment == nil {
+		return ""
+	}
+	return strings.ToUpper(report.ImpactAssessment.Severity)
+}
+
+func reportConfidence(report *AnalysisReport) float64 {
+	if report.RootCauseAnalysis == nil {
+		return 0
+	}
+	return report.RootCauseAnalysis.ConfidenceScore
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// seedListReports 建立不同狀態、嚴重程度與建立時間的報告。
+func seedListReports(t *testing.T, repo ReportRepository) time.Time {
+	t.Helper()
+	base := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	seeds := []struct {
+		id       string
+		eventID  string
+		status   ReportStatus
+		severity string
+		score    float64
+	}{
+		{"rpt-a", "evt-1", ReportStatusSuccess, "Critical", 0.9},
+		{"rpt-b", "evt-2", ReportStatusSuccess, "WARNING", 0.4},
+		{"rpt-c", "evt-3", ReportStatusFailed, "", 0},
+		{"rpt-d", "evt-4", ReportStatusPending, "", 0},
+		{"rpt-e", "evt-5", ReportStatusSuccess, "CRITICAL", 0.7},
+	}
+	for i, seed := range seeds {
+		created := base.Add(time.Duration(i) * time.Hour)
+		report := AnalysisReport{
+			ReportID:  seed.id,
+			EventID:   seed.eventID,
+			Status:    seed.status,
+			CreatedAt: created,
+			UpdatedAt: created,
+		}
+		if seed.severity != "" {
+			report.ImpactAssessment = &ImpactAssessment{Severity: seed.severity}
+			report.RootCauseAnalysis = &RootCauseAnalysis{ConfidenceScore: seed.score}
+		}
+		if _, err := repo.Create(report); err != nil {
+			t.Fatalf("建立報告失敗: %v", err)
+		}
+	}
+	return base
+}
+
+func reportIDs(reports []AnalysisReport) []string {
+	ids := make([]string, len(reports))
+	for i, report := range reports {
+		ids[i] = report.ReportID
+	}
+	return ids
+}
+
+func assertReportIDs(t *testing.T, name string, got []AnalysisReport, want ...string) {
+	t.Helper()
+	ids := reportIDs(got)
+	if len(ids) != len(want) {
+		t.Fatalf("%s: 預期 %v，實際為 %v", name, want, ids)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("%s: 預期 %v，實際為 %v", name, want, ids)
+		}
+	}
+}
+
+func TestReportRepositoryList(t *testing.T) {
+	repos := map[string]func(t *testing.T) ReportRepository{
+		"memory": func(t *testing.T) ReportRepository { return NewInMemoryReportRepository() },
+		"sqlite": func(t *testing.T) ReportRepository { return newTestSQLRepository(t) },
+	}
+
+	for name, newRepo := range repos {
+		t.Run(name, func(t *testing.T) {
+			repo := newRepo(t)
+			base := seedListReports(t, repo)
+
+			list := func(query ReportQuery) ([]AnalysisReport, int) {
+				t.Helper()
+				query, err := query.Normalize()
+				if err != nil {
+					t.Fatalf("查詢參數無效: %v", err)
+				}
+				reports, total, err := repo.List(query)
+				if err != nil {
+					t.Fatalf("查詢列表失敗: %v", err)
+				}
+				return reports, total
+			}
+
+			reports, total := list(ReportQuery{})
+			if total != 5 {
+				t.Fatalf("總筆數應為 5，實際為 %d", total)
+			}
+			assertReportIDs(t, "預設依建立時間遞減", reports, "rpt-e", "rpt-d", "rpt-c", "rpt-b", "rpt-a")
+
+			reports, total = list(ReportQuery{Page: 2, PageSize: 2, SortOrder: SortOrderAsc})
+			if total != 5 {
+				t.Fatalf("分頁不應影響總筆數，實際為 %d", total)
+			}
+			assertReportIDs(t, "第二頁", reports, "rpt-c", "rpt-d")
+
+			reports, _ = list(ReportQuery{Page: 4, PageSize: 2})
+			assertReportIDs(t, "超出範圍的頁面", reports)
+
+			reports, total = list(ReportQuery{Statuses: []ReportStatus{ReportStatusFailed, ReportStatusPending}, SortOrder: SortOrderAsc})
+			if total != 2 {
+				t.Fatalf("狀態篩選總筆數應為 2，實際為 %d", total)
+			}
+			assertReportIDs(t, "狀態篩選", reports, "rpt-c", "rpt-d")
+
+			reports, _ = list(ReportQuery{Severity: "critical", SortBy: ReportSortConfidenceScore})
+			assertReportIDs(t, "嚴重程度篩選", reports, "rpt-a", "rpt-e")
+
+			reports, _ = list(ReportQuery{EventID: "evt-2"})
+			assertReportIDs(t, "事件篩選", reports, "rpt-b")
+
+			from, to := base.Add(time.Hour), base.Add(3*time.Hour)
+			reports, _ = list(ReportQuery{CreatedFrom: &from, CreatedTo: &to, SortOrder: SortOrderAsc})
+			assertReportIDs(t, "建立時間範圍", reports, "rpt-b", "rpt-c", "rpt-d")
+		})
+	}
+}
+
+func TestReportQueryNormalize(t *testing.T) {
+	query, err := ReportQuery{}.Normalize()
+	if err != nil {
+		t.Fatalf("預設查詢不應失敗: %v", err)
+	}
+	if query.Page != 1 || query.PageSize != defaultPageSize || query.SortBy != ReportSortCreatedAt || !query.Descending() {
+		t.Fatalf("預設值錯誤: %+v", query)
+	}
+
+	from := time.Now()
+	to := from.Add(-time.Hour)
+	invalid := []ReportQuery{
+		{Page: -1},
+		{PageSize: maxPageSize + 1},
+		{SortBy: "event_summary"},
+		{SortOrder: "sideways"},
+		{CreatedFrom: &from, CreatedTo: &to},
+	}
+	for _, q := range invalid {
+		if _, err := q.Normalize(); err == nil {
+			t.Fatalf("查詢 %+v 應回傳錯誤", q)
+		}
+	}
+}
+
+func TestAIInsightsEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := NewInMemoryReportRepository()
+	seedListReports(t, repo)
+	service := NewAnalysisService(repo, &stubGenerator{}, AnalysisServiceConfig{})
+	router := SetupRouter(service)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/analysis/ai-insights?status=success&severity=CRITICAL&sort_by=created_at&sort_order=asc&page_size=1", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("預期回傳 200，實際為 %d", resp.Code)
+	}
+	var page ReportPage
+	if err := json.Unmarshal(resp.Body.Bytes(), &page); err != nil {
+		t.Fatalf("解析列表失敗: %v", err)
+	}
+	if page.Page != 1 || page.PageSize != 1 || page.Total != 2 {
+		t.Fatalf("分頁資訊錯誤: %+v", page)
+	}
+	assertReportIDs(t, "列表端點", page.Items, "rpt-a")
+
+	for _, query := range []string{"?status=DONE", "?page=abc", "?page_size=500", "?created_from=yesterday", "?sort_by=status"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/analysis/ai-insights"+query, nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("%s 應回傳 400，實際為 %d", query, resp.Code)
+		}
+	}
+
+	detailReq := httptest.NewRequest(http.MethodGet, "/api/v1/analysis/ai-insights/rpt-b", nil)
+	detailResp := httptest.NewRecorder()
+	router.ServeHTTP(detailResp, detailReq)
+	if detailResp.Code != http.StatusOK {
+		t.Fatalf("預期回傳 200，實際為 %d", detailResp.Code)
+	}
+
+	missingReq := httptest.NewRequest(http.MethodGet, "/api/v1/analysis/ai-insights/rpt-missing", nil)
+	missingResp := httptest.NewRecorder()
+	router.ServeHTTP(missingResp, missingReq)
+	if missingResp.Code != http.StatusNotFound {
+		t.Fatalf("不存在的報告應回傳 404，實際為 %d", missingResp.Code)
+	}
+}
+
+func TestReportReadsScopedToOwnerAndTeam(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth, err := NewAuthenticator(AuthConfig{HS256Secret: testJWTSecret})
+	if err != nil {
+		t.Fatalf("建立驗證器失敗: %v", err)
+	}
+	for name, repo := range map[string]ReportRepository{
+		"memory": NewInMemoryReportRepository(),
+		"sqlite": newTestSQLRepository(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			service := NewAnalysisService(repo, &stubGenerator{result: &GeneratedReport{EventSummary: "完成"}}, AnalysisServiceConfig{Auth: auth})
+			router := SetupRouter(service)
+
+			alice := withPrincipal(context.Background(), Principal{Subject: "user-alice", Username: "alice", Roles: []string{RoleTeamMember}, Teams: []string{"payments"}})
+			bob := withPrincipal(context.Background(), Principal{Subject: "user-bob", Username: "bob", Roles: []string{RoleTeamMember}, Teams: []string{"search"}})
+			owned, err := service.CreateReport(alice, "evt-scope-a", CreateAnalysisRequest{})
+			if err != nil {
+				t.Fatalf("建立報告失敗: %v", err)
+			}
+			if _, err := service.CreateReport(bob, "evt-scope-b", CreateAnalysisRequest{}); err != nil {
+				t.Fatalf("建立報告失敗: %v", err)
+			}
+			// 未啟用驗證前建立的報告沒有建立者與團隊，開放給所有人。
+			if _, err := service.CreateReport(context.Background(), "evt-scope-legacy", CreateAnalysisRequest{}); err != nil {
+				t.Fatalf("建立報告失敗: %v", err)
+			}
+			service.Wait()
+
+			outsiderClaims := userClaims("bob", RoleTeamMember)
+			outsiderClaims["teams"] = []string{"search"}
+			outsider := signHS256(t, outsiderClaims)
+			for _, path := range []string{
+				"/api/v1/ai/analysis-reports/" + owned.ReportID,
+				"/api/v1/ai/analysis-reports/" + owned.ReportID + "/stream",
+				"/api/v1/analysis/ai-insights/" + owned.ReportID,
+				"/api/v1/events/evt-scope-a/analysis",
+				"/api/v1/events/evt-scope-a/analysis/versions",
+				"/api/v1/events/evt-scope-a/analysis/diff",
+			} {
+				resp := httptest.NewRecorder()
+				router.ServeHTTP(resp, authedRequest(http.MethodGet, path, outsider, nil))
+				if resp.Code != http.StatusForbidden {
+					t.Fatalf("其他團隊讀取 %s 應回傳 403，實際為 %d", path, resp.Code)
+				}
+			}
+
+			teammateClaims := userClaims("carol", RoleTeamMember)
+			teammateClaims["teams"] = []string{"sre", "payments"}
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, authedRequest(http.MethodGet, "/api/v1/ai/analysis-reports/"+owned.ReportID, signHS256(t, teammateClaims), nil))
+			if resp.Code != http.StatusOK {
+				t.Fatalf("同團隊成員應可讀取報告，實際為 %d", resp.Code)
+			}
+
+			listFor := func(token string) []string {
+				resp := httptest.NewRecorder()
+				router.ServeHTTP(resp, authedRequest(http.MethodGet, "/api/v1/analysis/ai-insights", token, nil))
+				var page ReportPage
+				if err := json.Unmarshal(resp.Body.Bytes(), &page); err != nil || resp.Code != http.StatusOK {
+					t.Fatalf("列表查詢失敗: %d (%v)", resp.Code, err)
+				}
+				if page.Total != len(page.Items) {
+					t.Fatalf("總數應只計算可存取的報告: %+v", page)
+				}
+				var events []string
+				for _, report := range page.Items {
+					events = append(events, report.EventID)
+				}
+				sort.Strings(events)
+				return events
+			}
+			if got := strings.Join(listFor(outsider), ","); got != "evt-scope-b,evt-scope-legacy" {
+				t.Fatalf("其他團隊的列表不應包含他人報告: %s", got)
+			}
+			if got := strings.Join(listFor(signHS256(t, userClaims("erin", RoleSuperAdmin))), ","); got != "evt-scope-a,evt-scope-b,evt-scope-legacy" {
+				t.Fatalf("超級管理員應看到所有報告: %s", got)
+			}
+		})
+	}
+}
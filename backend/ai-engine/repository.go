@@ -2,7 +2,9 @@ package main
 
 import (
 	"errors"
+	"sort"
 	"sync"
+	"time"
 )
 
 var (
@@ -10,6 +12,10 @@ var (
 	ErrReportNotFound = errors.New("analysis report not found")
 	// ErrReportAlreadyExists 在重複建立相同報告編號時回傳。
 	ErrReportAlreadyExists = errors.New("analysis report already exists")
+	// ErrReportInProgress 在事件仍有進行中的報告時拒絕建立新版本。
+	ErrReportInProgress = errors.New("analysis report is still in progress")
+	// ErrReportForbidden 代表請求者不是報告建立者、同團隊成員或超級管理員。
+	ErrReportForbidden = errors.New("analysis report belongs to another requester")
 )
 
 // ReportRepository 定義報告儲存介面。
@@ -17,20 +23,33 @@ type ReportRepository interface {
 	Create(report AnalysisReport) (AnalysisReport, error)
 	Get(reportID string) (AnalysisReport, error)
 	Update(reportID string, updater func(report *AnalysisReport) error) (AnalysisReport, error)
+	// CreateVersion 為事件建立下一個版本的報告；最新版本仍在處理中時回傳該版本與 ErrReportInProgress。
+	CreateVersion(report AnalysisReport) (AnalysisReport, error)
+	// GetLatestByEvent 取得事件最新版本的報告。
+	GetLatestByEvent(eventID string) (AnalysisReport, error)
+	// ListByEvent 依版本號遞增回傳事件的所有報告。
+	ListByEvent(eventID string) ([]AnalysisReport, error)
+	// ListByStatus 依建立時間排序回傳符合任一狀態的報告。
+	ListByStatus(statuses ...ReportStatus) ([]AnalysisReport, error)
+	// List 依篩選條件分頁查詢報告，回傳該頁資料與符合條件的總筆數。
+	List(query ReportQuery) ([]AnalysisReport, int, error)
+	// ListUsage 回傳開始時間介於 [from, to) 的產生器呼叫用量，依 BilledAt 排序。
+	ListUsage(from, to time.Time) ([]UsageRecord, error)
 }
 
 // InMemoryReportRepository 使用記憶體儲存報告，適用於原型開發。
 type InMemoryReportRepository struct {
-	mu         sync.RWMutex
-	reports    map[string]*AnalysisReport
-	eventIndex map[string]string
+	mu      sync.RWMutex
+	reports map[string]*AnalysisReport
+	// eventHistory 依版本順序記錄每個事件的報告編號。
+	eventHistory map[string][]string
 }
 
 // NewInMemoryReportRepository 建立記憶體儲存庫實例。
 func NewInMemoryReportRepository() *InMemoryReportRepository {
 	return &InMemoryReportRepository{
-		reports:    make(map[string]*AnalysisReport),
-		eventIndex: make(map[string]string),
+		reports:      make(map[string]*AnalysisReport),
+		eventHistory: make(map[string][]string),
 	}
 }
 
@@ -44,21 +63,81 @@ func (r *InMemoryReportRepository) Create(report AnalysisReport) (AnalysisReport
 	}
 
 	if report.EventID != "" {
-		if existingID, ok := r.eventIndex[report.EventID]; ok {
-			if existing, exists := r.reports[existingID]; exists {
-				return existing.Clone(), ErrReportAlreadyExists
-			}
+		if latest := r.latestLocked(report.EventID); latest != nil {
+			return latest.Clone(), ErrReportAlreadyExists
 		}
 	}
 
 	clone := report.Clone()
+	if clone.Version == 0 {
+		clone.Version = 1
+	}
 	r.reports[report.ReportID] = &clone
 	if report.EventID != "" {
-		r.eventIndex[report.EventID] = report.ReportID
+		r.eventHistory[report.EventID] = append(r.eventHistory[report.EventID], report.ReportID)
+	}
+	return clone.Clone(), nil
+}
+
+// CreateVersion 以事件最新版本號加一建立新報告，舊版本保留不變。
+func (r *InMemoryReportRepository) CreateVersion(report AnalysisReport) (AnalysisReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.reports[report.ReportID]; exists {
+		return AnalysisReport{}, ErrReportAlreadyExists
+	}
+
+	clone := report.Clone()
+	clone.Version = 1
+	if latest := r.latestLocked(report.EventID); latest != nil {
+		if isOrphanedStatus(latest.Status) {
+			return latest.Clone(), ErrReportInProgress
+		}
+		clone.Version = latest.Version + 1
 	}
+
+	r.reports[clone.ReportID] = &clone
+	r.eventHistory[clone.EventID] = append(r.eventHistory[clone.EventID], clone.ReportID)
 	return clone.Clone(), nil
 }
 
+// GetLatestByEvent 取得事件最新版本的報告。
+func (r *InMemoryReportRepository) GetLatestByEvent(eventID string) (AnalysisReport, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	latest := r.latestLocked(eventID)
+	if latest == nil {
+		return AnalysisReport{}, ErrReportNotFound
+	}
+	return latest.Clone(), nil
+}
+
+// ListByEvent 依版本號遞增回傳事件的所有報告。
+func (r *InMemoryReportRepository) ListByEvent(eventID string) ([]AnalysisReport, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	history := r.eventHistory[eventID]
+	reports := make([]AnalysisReport, 0, len(history))
+	for _, reportID := range history {
+		if report, ok := r.reports[reportID]; ok {
+			reports = append(reports, report.Clone())
+		}
+	}
+	return reports, nil
+}
+
+// latestLocked 回傳事件最新版本的報告，呼叫端需持有鎖。
+func (r *InMemoryReportRepository) latestLocked(eventID string) *AnalysisReport {
+	history := r.eventHistory[eventID]
+	if len(history) == 0 {
+		return nil
+	}
+	return r.reports[history[len(history)-1]]
+}
+
 // Get 依報告編號取得報告。
 func (r *InMemoryReportRepository) Get(reportID string) (AnalysisReport, error) {
 	r.mu.RLock()
@@ -88,8 +167,66 @@ func (r *InMemoryReportRepository) Update(reportID string, updater func(report *
 	// 以更新後的資料替換並回傳副本。
 	updated := report.Clone()
 	r.reports[reportID] = &updated
-	if updated.EventID != "" {
-		r.eventIndex[updated.EventID] = reportID
-	}
 	return updated.Clone(), nil
 }
+
+// ListByStatus 回傳符合指定狀態的報告副本。
+func (r *InMemoryReportRepository) ListByStatus(statuses ...ReportStatus) ([]AnalysisReport, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	wanted := make(map[ReportStatus]struct{}, len(statuses))
+	for _, status := range statuses {
+		wanted[status] = struct{}{}
+	}
+
+	var reports []AnalysisReport
+	for _, report := range r.reports {
+		if _, ok := wanted[report.Status]; ok {
+			reports = append(reports, report.Clone())
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].CreatedAt.Before(reports[j].CreatedAt)
+	})
+	return reports, nil
+}
+
+// ListUsage 回傳區間內開始的產生器呼叫用量。
+func (r *InMemoryReportRepository) ListUsage(from, to time.Time) ([]UsageRecord, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var records []UsageRecord
+	for _, report := range r.reports {
+		records = append(records, usageRecords(*report, from, to)...)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].BilledAt.Before(records[j].BilledAt)
+	})
+	return records, nil
+}
+
+// List 依篩選條件分頁查詢報告，回傳該頁資料與符合條件的總筆數。
+func (r *InMemoryReportRepository) List(query ReportQuery) ([]AnalysisReport, int, error) {
+	r.mu.RLock()
+	matched := make([]AnalysisReport, 0, len(r.reports))
+	for _, report := range r.reports {
+		if query.Matches(report) {
+			matched = append(matched, report.Clone())
+		}
+	}
+	r.mu.RUnlock()
+
+	sortReports(matched, query)
+	total := len(matched)
+	start := query.Offset()
+	if start >= total {
+		return []AnalysisReport{}, total, nil
+	}
+	end := start + query.PageSize
+	if query.PageSize <= 0 || end > total {
+		end = total
+	}
+	return matched[start:end], total, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = time.Second
+	defaultRetryMaxBackoff     = 30 * time.Second
+	defaultRetryMultiplier     = 2.0
+	defaultRetryJitter         = 0.2
+)
+
+// RetryPolicy 設定產生器暫時性錯誤的重試方式。
+type RetryPolicy struct {
+	// MaxAttempts 為包含第一次在內的最大嘗試次數，1 代表不重試。
+	MaxAttempts int
+	// InitialBackoff 為第一次重試前的等待時間。
+	InitialBackoff time.Duration
+	// MaxBackoff 為單次等待時間上限。
+	MaxBackoff time.Duration
+	// Multiplier 為每次重試後等待時間的倍數。
+	Multiplier float64
+	// Jitter 為等待時間的隨機浮動比例 (0 至 1，未設定時為 0.2)，避免多個任務同時重試。
+	Jitter float64
+}
+
+// withDefaults 為未設定的欄位套用預設值。
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		p.MaxBackoff = p.InitialBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = defaultRetryMultiplier
+	}
+	if p.Jitter <= 0 || p.Jitter > 1 {
+		p.Jitter = defaultRetryJitter
+	}
+	return p
+}
+
+// Backoff 回傳第 attempt 次嘗試失敗後的等待時間；random 為 [0, 1) 的亂數。
+func (p RetryPolicy) Backoff(attempt int, random float64) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	// 在 [1-jitter, 1+jitter) 範圍內浮動。
+	delay *= 1 + p.Jitter*(2*random-1)
+	return time.Duration(delay)
+}
+
+// AnalysisAttempt 記錄一次產生器呼叫的結果。
+type AnalysisAttempt struct {
+	Attempt    int       `json:"attempt"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Error      string    `json:"error,omitempty"`
+	// Transient 表示錯誤是否被判定為暫時性 (可重試)。
+	Transient bool `json:"transient,omitempty"`
+	// Usage 為此次呼叫的 LLM 用量，失敗的嘗試同樣記錄，報告用量為所有嘗試的總和。
+	Usage *LLMUsage `json:"usage,omitempty"`
+}
+
+// IsTransientGenerationError 判斷產生器錯誤是否為暫時性錯誤。
+// 逾時、網路錯誤、LLM 5xx/429 與無法解析的回應視為可重試；其餘錯誤 (含取消與超過大小上限的回應) 視為永久性。
+func IsTransientGenerationError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrLLMResponseTooLarge) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	if errors.Is(err, ErrLLMInvalidResponse) || errors.Is(err, ErrLLMEmptyResponse) {
+		return true
+	}
+	var statusErr *LLMStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError ||
+			statusErr.StatusCode == http.StatusTooManyRequests ||
+			statusErr.StatusCode == http.StatusRequestTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyGenerator 依序回傳預設的錯誤，錯誤用盡後回傳成功結果。
+type flakyGenerator struct {
+	mu     sync.Mutex
+	errs   []error
+	calls  int
+	result GeneratedReport
+}
+
+func (g *flakyGenerator) Generate(_ context.Context, _ GenerationInput) (*GeneratedReport, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls++
+	if g.calls <= len(g.errs) {
+		return nil, g.errs[g.calls-1]
+	}
+	result := g.result.Clone()
+	return &result, nil
+}
+
+func (g *flakyGenerator) callCount() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.calls
+}
+
+func fastRetryPolicy(maxAttempts int) RetryPolicy {
+	return RetryPolicy{MaxAttempts: maxAttempts, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2, Jitter: 0.5}.withDefaults()
+
+	cases := []struct {
+		attempt int
+		random  float64
+		want    time.Duration
+	}{
+		{1, 0.5, time.Second},
+		{2, 0.5, 2 * time.Second},
+		{3, 0.5, 4 * time.Second},
+		{4, 0.5, 5 * time.Second},
+		{1, 0, 500 * time.Millisecond},
+		{2, 1, 3 * time.Second},
+	}
+	for _, tc := range cases {
+		if got := policy.Backoff(tc.attempt, tc.random); got != tc.want {
+			t.Fatalf("第 %d 次 (random=%v) 等待時間應為 %s，實際為 %s", tc.attempt, tc.random, tc.want, got)
+		}
+	}
+
+	defaults := RetryPolicy{}.withDefaults()
+	if defaults.MaxAttempts != defaultRetryMaxAttempts || defaults.Jitter != defaultRetryJitter {
+		t.Fatalf("預設值錯誤: %+v", defaults)
+	}
+}
+
+func TestIsTransientGenerationError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"逾時", context.DeadlineExceeded, true},
+		{"取消", context.Canceled, false},
+		{"LLM 5xx", &LLMStatusError{StatusCode: 503}, true},
+		{"LLM 429", fmt.Errorf("wrap: %w", &LLMStatusError{StatusCode: 429}), true},
+		{"LLM 401", &LLMStatusError{StatusCode: 401}, false},
+		{"無法解析的回應", fmt.Errorf("%w: bad json", ErrLLMInvalidResponse), true},
+		{"空回應", ErrLLMEmptyResponse, true},
+		{"回應超過上限", fmt.Errorf("%w: 回應超過 4194304 位元組上限", ErrLLMResponseTooLarge), false},
+		{"網路錯誤", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"無模板", ErrNoTemplates, false},
+		{"未知錯誤", errors.New("boom"), false},
+	}
+	for _, tc := range cases {
+		if got := IsTransientGenerationError(tc.err); got != tc.want {
+			t.Fatalf("%s: 預期 %v，實際為 %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestAnalysisServiceRetriesTransientErrors(t *testing.T) {
+	repo := NewInMemoryReportRepository()
+	generator := &flakyGenerator{
+		errs:   []error{&LLMStatusError{StatusCode: 502}, fmt.Errorf("%w: truncated", ErrLLMInvalidResponse)},
+		result: GeneratedReport{EventSummary: "重試後成功"},
+	}
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{ProcessingTimeout: time.Second, Retry: fastRetryPolicy(3)})
+
+	report, err := service.CreateReport(context.Background(), "evt-retry", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	service.Wait()
+
+	stored, _ := repo.Get(report.ReportID)
+	if stored.Status != ReportStatusSuccess || stored.EventSummary != "重試後成功" {
+		t.Fatalf("重試後應成功，實際為 %s", stored.Status)
+	}
+	if stored.AttemptCount != 3 || len(stored.Attempts) != 3 {
+		t.Fatalf("應記錄 3 次嘗試，實際為 %d (%d)", stored.AttemptCount, len(stored.Attempts))
+	}
+	if !stored.Attempts[0].Transient || stored.Attempts[0].Error == "" || stored.Attempts[2].Error != "" {
+		t.Fatalf("嘗試記錄錯誤: %+v", stored.Attempts)
+	}
+	for i, attempt := range stored.Attempts {
+		if attempt.Attempt != i+1 {
+			t.Fatalf("嘗試編號錯誤: %+v", stored.Attempts)
+		}
+	}
+}
+
+func TestAnalysisServiceRetryExhaustedAndPermanent(t *testing.T) {
+	repo := NewInMemoryReportRepository()
+	transient := &flakyGenerator{errs: []error{context.DeadlineExceeded, context.DeadlineExceeded, context.DeadlineExceeded}}
+	service := NewAnalysisService(repo, transient, AnalysisServiceConfig{ProcessingTimeout: time.Second, Retry: fastRetryPolicy(2)})
+
+	report, _ := service.CreateReport(context.Background(), "evt-exhausted", CreateAnalysisRequest{})
+	service.Wait()
+	stored, _ := repo.Get(report.ReportID)
+	if stored.Status != ReportStatusFailed || stored.AttemptCount != 2 || transient.callCount() != 2 {
+		t.Fatalf("重試用盡後應失敗且僅嘗試 2 次，實際為 %s (%d)", stored.Status, stored.AttemptCount)
+	}
+
+	permanent := &flakyGenerator{errs: []error{&LLMStatusError{StatusCode: 400, Body: "bad request"}}}
+	service = NewAnalysisService(repo, permanent, AnalysisServiceConfig{ProcessingTimeout: time.Second, Retry: fastRetryPolicy(5)})
+	report, _ = service.CreateReport(context.Background(), "evt-permanent", CreateAnalysisRequest{})
+	service.Wait()
+	stored, _ = repo.Get(report.ReportID)
+	if stored.Status != ReportStatusFailed || stored.AttemptCount != 1 || permanent.callCount() != 1 {
+		t.Fatalf("永久性錯誤不應重試，實際為 %s (%d)", stored.Status, stored.AttemptCount)
+	}
+	if stored.Attempts[0].Transient {
+		t.Fatalf("永久性錯誤不應標記為暫時性")
+	}
+}
+
+func TestCancelReportDuringBackoff(t *testing.T) {
+	repo := NewInMemoryReportRepository()
+	generator := &flakyGenerator{errs: []error{ErrLLMEmptyResponse}}
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		ProcessingTimeout: time.Second,
+		Retry:             RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Minute},
+	})
+
+	report, _ := service.CreateReport(context.Background(), "evt-backoff", CreateAnalysisRequest{})
+	deadline := time.Now().Add(time.Second)
+	for {
+		stored, _ := repo.Get(report.ReportID)
+		if stored.AttemptCount == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("第一次嘗試應被記錄")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, err := service.CancelReport(context.Background(), report.ReportID, "alice"); err != nil {
+		t.Fatalf("取消失敗: %v", err)
+	}
+	service.Wait()
+	stored, _ := repo.Get(report.ReportID)
+	if stored.Status != ReportStatusCancelled || generator.callCount() != 1 {
+		t.Fatalf("退避期間取消應停止重試，實際為 %s (%d 次呼叫)", stored.Status, generator.callCount())
+	}
+}
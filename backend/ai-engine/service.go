@@ -4,37 +4,106 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const defaultProcessingTimeout = 45 * time.Second
+const (
+	defaultProcessingTimeout = 45 * time.Second
+	defaultWorkerCount       = 4
+	defaultQueueSize         = 100
+	defaultQueueRetryAfter   = 10 * time.Second
+)
 
 var (
 	// ErrEventIDRequired 代表建立報告時缺少事件編號。
 	ErrEventIDRequired = errors.New("eventID is required")
 	// ErrReportIDRequired 代表查詢報告時缺少報告編號。
 	ErrReportIDRequired = errors.New("reportID is required")
+	// ErrQueueFull 代表分析佇列已滿，需稍後重試。
+	ErrQueueFull = errors.New("analysis queue is full")
+	// ErrInvalidVersion 代表指定的報告版本號無效。
+	ErrInvalidVersion = errors.New("invalid report version")
+	// ErrServiceShuttingDown 代表服務正在關閉，不再接受新的分析請求。
+	ErrServiceShuttingDown = errors.New("analysis service is shutting down")
 )
 
 // AnalysisServiceConfig 用於調整服務行為。
 type AnalysisServiceConfig struct {
+	// ProcessingTimeout 為單次產生器呼叫的逾時，每次重試重新計算。
 	ProcessingTimeout time.Duration
-	Logger            *log.Logger
+	// Logger 為結構化日誌輸出，未設定時使用 slog.Default()。
+	Logger *slog.Logger
+	// Retry 設定暫時性錯誤的重試次數與退避時間。
+	Retry RetryPolicy
+	// Webhooks 設定報告完成 (SUCCESS 或 FAILED) 時的回呼通知。
+	Webhooks WebhookConfig
+	// Metrics 為 Prometheus 指標，未設定時自動建立。
+	Metrics *Metrics
+	// Auth 為 JWT 驗證器，未設定時 API 不需驗證。
+	Auth *Authenticator
+	// RateLimiter 限制分析建立的頻率，未設定時不限流。
+	RateLimiter *RateLimiter
+	// Prices 為計算 LLM 成本的模型價格表，未列出的模型不計成本。
+	Prices PriceTable
+	// Budget 設定各團隊的 LLM 預算與用盡後的降級策略，未設定預算時不限制。
+	Budget BudgetConfig
+	// Enrichers 於呼叫產生器前收集事件相關的證據，並附加於提示詞與報告中。
+	Enrichers []ContextEnricher
+	// EnrichmentTimeout 為所有豐富化來源的共同期限，預設 15 秒。
+	EnrichmentTimeout time.Duration
+	// Topology 提供資源相依關係，用於計算影響範圍；未設定時沿用產生器的判斷。
+	Topology TopologyProvider
+	// BlastRadiusDepth 為影響範圍向上下游展開的最大層數，預設 3。
+	BlastRadiusDepth int
+	// RelatedEvents 自主後端查詢關聯事件，未設定時僅使用請求提供的關聯事件。
+	RelatedEvents RelatedEventsProvider
+	// CorrelationWindow 為關聯事件與主事件觸發時間的最大間隔，預設 30 分鐘。
+	CorrelationWindow time.Duration
+	// Changes 儲存 CI/CD 回報的變更，未設定時使用報告儲存庫 (若支援) 或記憶體。
+	Changes ChangeRepository
+	// RecoveryPolicy 決定啟動時如何處理遺留的 PENDING/RUNNING 報告。
+	RecoveryPolicy RecoveryPolicy
+	// RecoveryStaleAfter 為報告最後更新後多久才視為遺留，0 代表全部視為遺留。
+	RecoveryStaleAfter time.Duration
+	// Workers 為同時執行分析的工作者數量。
+	Workers int
+	// QueueSize 為等待中任務的上限，超過時拒絕新請求。
+	QueueSize int
+	// QueueRetryAfter 為佇列已滿時建議客戶端重試的等待時間。
+	QueueRetryAfter time.Duration
+	// ReadinessTimeout 為就緒檢查呼叫儲存庫與產生器的期限。
+	ReadinessTimeout time.Duration
 }
 
 // CreateAnalysisRequest 為觸發分析時的輸入格式。
 type CreateAnalysisRequest struct {
 	EventContext map[string]any `json:"event_context,omitempty"`
+	// CallbackURLs 為報告完成時額外通知的網址。
+	CallbackURLs []string `json:"callback_urls,omitempty"`
+	// RelatedEvents 為呼叫端已知的關聯事件，與主後端查得的關聯事件合併後一併分析。
+	RelatedEvents []RelatedEvent `json:"related_events,omitempty"`
 }
 
 // GenerationInput 傳遞給生成器的上下文資料。
 type GenerationInput struct {
 	EventID      string
 	EventContext map[string]any
+	// Evidence 為呼叫產生器前自外部系統收集的證據。
+	Evidence []EvidenceItem
+	// Impact 為依拓撲計算的影響範圍，未設定拓撲或找不到事件資源時為 nil。
+	Impact *BlastRadius
+	// Incident 為與本事件同屬一次事故的關聯事件，沒有關聯事件時為 nil。
+	Incident *CorrelatedIncident
 }
 
 // GeneratedReport 代表 LLM 生成的報告內容。
@@ -45,6 +114,8 @@ type GeneratedReport struct {
 	RecommendedActions []RecommendedAction `json:"recommended_actions"`
 	Evidence           []EvidenceItem      `json:"evidence"`
 	RawLLMResponse     json.RawMessage     `json:"raw_llm_response"`
+	// Usage 由產生器填入模型與 token 用量，不接受來自 LLM 內容或模板檔案的值。
+	Usage *LLMUsage `json:"-"`
 }
 
 // Clone 建立生成結果的副本。
@@ -61,6 +132,10 @@ func (g *GeneratedReport) Clone() GeneratedReport {
 	if g.RawLLMResponse != nil {
 		clone.RawLLMResponse = append(json.RawMessage(nil), g.RawLLMResponse...)
 	}
+	if g.Usage != nil {
+		usage := *g.Usage
+		clone.Usage = &usage
+	}
 	return clone
 }
 
@@ -74,8 +149,40 @@ type AnalysisService struct {
 	repo              ReportRepository
 	generator         ReportGenerator
 	processingTimeout time.Duration
-	logger            *log.Logger
+	logger            *slog.Logger
+	recoveryPolicy    RecoveryPolicy
+	recoveryStale     time.Duration
+	workers           int
+	queue             *jobQueue
+	queueRetryAfter   time.Duration
+	readinessTimeout  time.Duration
+	retry             RetryPolicy
 	wg                sync.WaitGroup
+	// runCtx 為所有分析任務的父 context，關閉逾時時取消以中止執行中的分析。
+	runCtx     context.Context
+	cancelRuns context.CancelFunc
+	closing    atomic.Bool
+	// running 保存執行中分析的取消函式，供 CancelReport 使用。
+	runningMu sync.Mutex
+	running   map[string]context.CancelFunc
+	// events 將報告狀態與部分內容推送給串流訂閱者。
+	events   *reportBroker
+	webhooks *webhookNotifier
+	metrics  *Metrics
+	auth     *Authenticator
+	// rateLimiter 為 nil 時不限流。
+	rateLimiter *RateLimiter
+	prices      PriceTable
+	// budget 為 nil 時不檢查預算；tierGenerators 為各模型等級對應的產生器。
+	budget            *budgetTracker
+	tierGenerators    map[string]ReportGenerator
+	enrichers         []ContextEnricher
+	enrichmentTimeout time.Duration
+	changes           ChangeRepository
+	topology          TopologyProvider
+	blastRadiusDepth  int
+	relatedEvents     RelatedEventsProvider
+	correlationWindow time.Duration
 }
 
 // NewAnalysisService 建立分析服務。
@@ -94,101 +201,650 @@ func NewAnalysisService(repo ReportRepository, generator ReportGenerator, cfg An
 
 	logger := cfg.Logger
 	if logger == nil {
-		logger = log.Default()
+		logger = slog.Default()
+	}
+
+	policy := cfg.RecoveryPolicy
+	if policy == "" {
+		policy = RecoveryPolicyFail
+	}
+
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = defaultWorkerCount
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	retryAfter := cfg.QueueRetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultQueueRetryAfter
+	}
+	readinessTimeout := cfg.ReadinessTimeout
+	if readinessTimeout <= 0 {
+		readinessTimeout = defaultReadinessTimeout
+	}
+
+	enrichmentTimeout := cfg.EnrichmentTimeout
+	if enrichmentTimeout <= 0 {
+		enrichmentTimeout = defaultEnrichmentTimeout
 	}
 
-	return &AnalysisService{
+	correlationWindow := cfg.CorrelationWindow
+	if correlationWindow <= 0 {
+		correlationWindow = defaultCorrelationWindow
+	}
+
+	metrics := cfg.Metrics
+	if metrics == nil {
+		metrics = NewMetrics()
+	}
+
+	runCtx, cancelRuns := context.WithCancel(context.Background())
+	service := &AnalysisService{
 		repo:              repo,
 		generator:         generator,
 		processingTimeout: timeout,
 		logger:            logger,
+		recoveryPolicy:    policy,
+		recoveryStale:     cfg.RecoveryStaleAfter,
+		workers:           workers,
+		queue:             newJobQueue(queueSize),
+		queueRetryAfter:   retryAfter,
+		readinessTimeout:  readinessTimeout,
+		retry:             cfg.Retry.withDefaults(),
+		runCtx:            runCtx,
+		cancelRuns:        cancelRuns,
+		running:           make(map[string]context.CancelFunc),
+		events:            newReportBroker(),
+		metrics:           metrics,
+		auth:              cfg.Auth,
+		rateLimiter:       cfg.RateLimiter,
+		prices:            cfg.Prices,
+		budget:            newBudgetTracker(cfg.Budget, repo),
+		tierGenerators:    map[string]ReportGenerator{ModelTierPrimary: generator},
+		enrichers:         cfg.Enrichers,
+		enrichmentTimeout: enrichmentTimeout,
+		changes:           cfg.Changes,
+		topology:          cfg.Topology,
+		blastRadiusDepth:  cfg.BlastRadiusDepth,
+		relatedEvents:     cfg.RelatedEvents,
+		correlationWindow: correlationWindow,
+	}
+	if service.changes == nil {
+		service.changes = changeRepositoryFor(repo)
 	}
+	if cfg.Budget.EconomyGenerator != nil {
+		service.tierGenerators[ModelTierEconomy] = cfg.Budget.EconomyGenerator
+	}
+	if cfg.Budget.TemplateGenerator != nil {
+		service.tierGenerators[ModelTierTemplate] = cfg.Budget.TemplateGenerator
+	}
+	service.webhooks = newWebhookNotifier(cfg.Webhooks, logger, service.recordWebhookDelivery)
+	for i := 0; i < workers; i++ {
+		go service.worker()
+	}
+	return service
 }
 
 // CreateReport 建立新的分析報告草稿並觸發非同步處理。
 func (s *AnalysisService) CreateReport(ctx context.Context, eventID string, req CreateAnalysisRequest) (AnalysisReport, error) {
+	ctx, span := startSpan(ctx, "AnalysisService.CreateReport", attribute.String("ai_engine.event_id", eventID))
+	report, err := s.submit(ctx, eventID, req, "Create", s.repo.Create)
+	span.SetAttributes(attribute.String("ai_engine.report_id", report.ReportID))
+	endSpan(span, err)
+	return report, err
+}
+
+// RegenerateReport 為事件建立新版本的分析報告，保留既有版本。
+// 若最新版本仍在處理中，回傳該版本與 ErrReportInProgress。
+func (s *AnalysisService) RegenerateReport(ctx context.Context, eventID string, req CreateAnalysisRequest) (AnalysisReport, error) {
+	ctx, span := startSpan(ctx, "AnalysisService.RegenerateReport", attribute.String("ai_engine.event_id", eventID))
+	report, err := s.submit(ctx, eventID, req, "CreateVersion", s.repo.CreateVersion)
+	span.SetAttributes(attribute.String("ai_engine.report_id", report.ReportID))
+	endSpan(span, err)
+	return report, err
+}
+
+// GetLatestReport 取得事件最新版本的分析報告；請求者無權存取時回傳 ErrReportForbidden。
+func (s *AnalysisService) GetLatestReport(ctx context.Context, eventID string) (AnalysisReport, error) {
 	if eventID == "" {
 		return AnalysisReport{}, ErrEventIDRequired
 	}
+	report, err := traceRepo(ctx, "GetLatestByEvent", "", func() (AnalysisReport, error) {
+		return s.repo.GetLatestByEvent(eventID)
+	})
+	if err != nil {
+		return AnalysisReport{}, err
+	}
+	if !canAccessReport(ctx, report) {
+		return AnalysisReport{}, ErrReportForbidden
+	}
+	return s.withQueuePosition(report), nil
+}
+
+// ListReportVersions 依版本順序回傳事件中請求者可存取的報告摘要。
+func (s *AnalysisService) ListReportVersions(ctx context.Context, eventID string) ([]ReportVersionSummary, error) {
+	if eventID == "" {
+		return nil, ErrEventIDRequired
+	}
+	reports, err := s.listAccessibleVersions(ctx, eventID)
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]ReportVersionSummary, len(reports))
+	for i, report := range reports {
+		summaries[i] = summarizeReportVersion(report)
+	}
+	return summaries, nil
+}
+
+// listAccessibleVersions 依版本順序回傳事件中請求者可存取的報告；
+// 事件沒有報告時回傳 ErrReportNotFound，所有版本皆無權存取時回傳 ErrReportForbidden。
+func (s *AnalysisService) listAccessibleVersions(ctx context.Context, eventID string) ([]AnalysisReport, error) {
+	reports, err := traceRepo(ctx, "ListByEvent", "", func() ([]AnalysisReport, error) {
+		return s.repo.ListByEvent(eventID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, ErrReportNotFound
+	}
+	accessible := reports[:0]
+	for _, report := range reports {
+		if canAccessReport(ctx, report) {
+			accessible = append(accessible, report)
+		}
+	}
+	if len(accessible) == 0 {
+		return nil, ErrReportForbidden
+	}
+	return accessible, nil
+}
+
+// DiffReportVersions 比較事件中請求者可存取的兩個報告版本；版本為 0 時分別預設為前一版與最新版。
+func (s *AnalysisService) DiffReportVersions(ctx context.Context, eventID string, fromVersion, toVersion int) (ReportDiff, error) {
+	if eventID == "" {
+		return ReportDiff{}, ErrEventIDRequired
+	}
+	if fromVersion < 0 || toVersion < 0 {
+		return ReportDiff{}, ErrInvalidVersion
+	}
+
+	reports, err := s.listAccessibleVersions(ctx, eventID)
+	if err != nil {
+		return ReportDiff{}, err
+	}
+
+	if toVersion == 0 {
+		toVersion = reports[len(reports)-1].Version
+	}
+	if fromVersion == 0 {
+		fromVersion = toVersion - 1
+	}
+	if fromVersion == toVersion {
+		return ReportDiff{}, ErrInvalidVersion
+	}
+
+	byVersion := make(map[int]AnalysisReport, len(reports))
+	for _, report := range reports {
+		byVersion[report.Version] = report
+	}
+	from, okFrom := byVersion[fromVersion]
+	to, okTo := byVersion[toVersion]
+	if !okFrom || !okTo {
+		return ReportDiff{}, ErrReportNotFound
+	}
+	return DiffReports(from, to), nil
+}
+
+// submit 預留佇列位置、寫入報告草稿後排入背景分析；ctx 中的追蹤資訊會隨任務帶入背景處理。
+func (s *AnalysisService) submit(ctx context.Context, eventID string, req CreateAnalysisRequest, operation string, create func(AnalysisReport) (AnalysisReport, error)) (AnalysisReport, error) {
+	if eventID == "" {
+		return AnalysisReport{}, ErrEventIDRequired
+	}
+
+	if len(req.CallbackURLs) > maxCallbackURLs {
+		return AnalysisReport{}, ErrInvalidCallbackURL
+	}
+	callbackURLs, err := s.webhooks.guard.validateCallbackURLs(ctx, req.CallbackURLs)
+	if err != nil {
+		return AnalysisReport{}, err
+	}
+	if err := validateRelatedEvents(req.RelatedEvents); err != nil {
+		return AnalysisReport{}, err
+	}
+	eventContext := withRelatedEvents(req.EventContext, req.RelatedEvents)
+
+	if s.closing.Load() {
+		return AnalysisReport{}, ErrServiceShuttingDown
+	}
+	team := requesterTeam(ctx)
+	tier, err := s.selectTier(ctx, team)
+	if err != nil {
+		return AnalysisReport{}, err
+	}
+	if !s.queue.reserve() {
+		if s.closing.Load() {
+			return AnalysisReport{}, ErrServiceShuttingDown
+		}
+		return AnalysisReport{}, ErrQueueFull
+	}
 
 	now := time.Now().UTC()
 	report := AnalysisReport{
-		ReportID:  uuid.NewString(),
-		EventID:   eventID,
-		Status:    ReportStatusPending,
-		CreatedAt: now,
-		UpdatedAt: now,
+		ReportID:     uuid.NewString(),
+		EventID:      eventID,
+		Status:       ReportStatusPending,
+		EventContext: eventContext,
+		CallbackURLs: callbackURLs,
+		TraceID:      traceIDFromContext(ctx),
+		RequestedBy:  requesterName(ctx),
+		Team:         team,
+		Tier:         tier,
+		CreatedAt:    now,
+		UpdatedAt:    now,
 	}
 
-	created, err := s.repo.Create(report)
+	created, err := traceRepo(ctx, operation, report.ReportID, func() (AnalysisReport, error) {
+		return create(report)
+	})
 	if err != nil {
-		if errors.Is(err, ErrReportAlreadyExists) {
-			return created, ErrReportAlreadyExists
+		s.queue.release()
+		if errors.Is(err, ErrReportAlreadyExists) || errors.Is(err, ErrReportInProgress) {
+			s.metrics.reportsConflicted.Inc()
+			return s.withQueuePosition(created), err
 		}
 		return AnalysisReport{}, err
 	}
+	s.metrics.reportsCreated.Inc()
 
-	s.wg.Add(1)
-	go func(reportID string, input GenerationInput) {
-		defer s.wg.Done()
-		s.runAnalysis(reportID, input)
-	}(created.ReportID, GenerationInput{EventID: eventID, EventContext: req.EventContext})
+	s.enqueue(created.ReportID, GenerationInput{EventID: eventID, EventContext: eventContext}, true, trace.SpanContextFromContext(ctx))
 
-	return created, nil
+	return s.withQueuePosition(created), nil
 }
 
-// GetReport 取得報告內容。
+// GetReport 取得報告內容；請求者無權存取時回傳 ErrReportForbidden。
 func (s *AnalysisService) GetReport(ctx context.Context, reportID string) (AnalysisReport, error) {
 	if reportID == "" {
 		return AnalysisReport{}, ErrReportIDRequired
 	}
-	return s.repo.Get(reportID)
+	report, err := traceRepo(ctx, "Get", reportID, func() (AnalysisReport, error) {
+		return s.repo.Get(reportID)
+	})
+	if err != nil {
+		return AnalysisReport{}, err
+	}
+	if !canAccessReport(ctx, report) {
+		return AnalysisReport{}, ErrReportForbidden
+	}
+	return s.withQueuePosition(report), nil
 }
 
-// Wait 等待背景分析完成 (僅供測試使用)。
+// ListReports 依篩選條件分頁列出報告，未指定的分頁與排序參數套用預設值。
+// 非超級管理員僅列出可存取的報告 (見 canAccessReport)。
+func (s *AnalysisService) ListReports(ctx context.Context, query ReportQuery) (ReportPage, error) {
+	query, err := scopeReportQuery(ctx, query).Normalize()
+	if err != nil {
+		return ReportPage{}, err
+	}
+	page, err := traceRepo(ctx, "List", "", func() (ReportPage, error) {
+		reports, total, err := s.repo.List(query)
+		return ReportPage{Page: query.Page, PageSize: query.PageSize, Total: total, Items: reports}, err
+	})
+	if err != nil {
+		return ReportPage{}, err
+	}
+	reports := page.Items
+	for i := range reports {
+		reports[i] = s.withQueuePosition(reports[i])
+	}
+	return page, nil
+}
+
+// QueueStats 回傳工作佇列的即時狀態。
+func (s *AnalysisService) QueueStats() QueueStats {
+	depth, inFlight, jobs := s.queue.stats()
+	return QueueStats{
+		Workers:  s.workers,
+		Capacity: s.queue.capacity,
+		Depth:    depth,
+		InFlight: inFlight,
+		Jobs:     jobs,
+	}
+}
+
+// QueueRetryAfter 回傳佇列已滿時建議的重試等待時間。
+func (s *AnalysisService) QueueRetryAfter() time.Duration {
+	return s.queueRetryAfter
+}
+
+// Wait 等待背景分析與回呼通知完成 (僅供測試使用)。
 func (s *AnalysisService) Wait() {
 	s.wg.Wait()
+	s.webhooks.wg.Wait()
+}
+
+// enqueue 將分析任務排入佇列；reserved 代表已透過 reserve 預留位置，spanContext 為觸發分析的請求追蹤。
+func (s *AnalysisService) enqueue(reportID string, input GenerationInput, reserved bool, spanContext trace.SpanContext) {
+	s.wg.Add(1)
+	job := analysisJob{reportID: reportID, input: input, enqueuedAt: time.Now().UTC(), spanContext: spanContext}
+	if !s.queue.push(job, reserved) {
+		// 佇列已於關閉流程中停止，依復原策略處理尚未開始的報告。
+		s.abandonJob(trace.ContextWithSpanContext(context.Background(), spanContext), reportID)
+		s.wg.Done()
+	}
 }
 
-func (s *AnalysisService) runAnalysis(reportID string, input GenerationInput) {
-	if _, err := s.repo.Update(reportID, func(report *AnalysisReport) error {
+// worker 持續從佇列取出任務並執行分析。
+func (s *AnalysisService) worker() {
+	for {
+		job, ok := s.queue.pop()
+		if !ok {
+			return
+		}
+		s.metrics.queueWait.Observe(time.Since(job.enqueuedAt).Seconds())
+		s.metrics.inFlight.Inc()
+		s.runAnalysis(job)
+		s.metrics.inFlight.Dec()
+		s.queue.done()
+		s.wg.Done()
+	}
+}
+
+// withQueuePosition 為等待中的報告補上佇列位置與深度。
+func (s *AnalysisService) withQueuePosition(report AnalysisReport) AnalysisReport {
+	if report.Status != ReportStatusPending {
+		return report
+	}
+	position, depth := s.queue.position(report.ReportID)
+	if position > 0 {
+		report.QueuePosition = position
+		report.QueueDepth = depth
+	}
+	return report
+}
+
+// runAnalysis 執行分析，暫時性錯誤依重試策略以指數退避重試，每次嘗試皆記錄於報告。
+// 背景處理延續建立報告請求的 trace，排隊時間另以 span 記錄。
+func (s *AnalysisService) runAnalysis(job analysisJob) {
+	reportID, input := job.reportID, job.input
+	// reportCtx 涵蓋所有嘗試，取消報告或服務關閉時中止；每次嘗試另套用處理逾時。
+	reportCtx, cancel := context.WithCancel(trace.ContextWithSpanContext(s.runCtx, job.spanContext))
+	defer cancel()
+	// 先登記取消函式再標記 RUNNING，確保取消請求不會錯過執行中的分析。
+	defer s.trackRunning(reportID, cancel)()
+
+	reportAttr := attribute.String("ai_engine.report_id", reportID)
+	_, waitSpan := otelTracer().Start(reportCtx, "AnalysisService.queueWait", trace.WithTimestamp(job.enqueuedAt), trace.WithAttributes(reportAttr))
+	waitSpan.End()
+	reportCtx, span := startSpan(reportCtx, "AnalysisService.runAnalysis", reportAttr, attribute.String("ai_engine.event_id", input.EventID))
+	defer span.End()
+	logger := reportLogger(s.logger, reportID, input.EventID)
+	runStarted := time.Now()
+
+	started, err := s.updateReport(reportCtx, reportID, func(report *AnalysisReport) error {
+		if report.Status == ReportStatusCancelled {
+			return errReportCancelled
+		}
 		report.Status = ReportStatusRunning
 		report.ErrorMessage = ""
 		report.UpdatedAt = time.Now().UTC()
 		return nil
-	}); err != nil {
-		s.logger.Printf("無法更新報告狀態為 RUNNING: %v", err)
+	})
+	if err != nil {
+		if !errors.Is(err, errReportCancelled) {
+			logger.ErrorContext(reportCtx, "無法更新報告狀態",
+				slog.String(logKeyStatus, string(ReportStatusRunning)),
+				slog.String(logKeyError, err.Error()),
+			)
+		}
 		return
 	}
+	// 重新排入的報告延續先前的嘗試編號。
+	previousAttempts := len(started.Attempts)
+	logger.InfoContext(reportCtx, "開始 AI 分析",
+		slog.String(logKeyStatus, string(ReportStatusRunning)),
+		slog.Int(logKeyAttempt, previousAttempts+1),
+		slog.Float64("queue_wait_ms", float64(runStarted.Sub(job.enqueuedAt).Microseconds())/1000),
+	)
 
-	ctx := context.Background()
-	var cancel context.CancelFunc
-	if s.processingTimeout > 0 {
-		ctx, cancel = context.WithTimeout(ctx, s.processingTimeout)
-	} else {
-		ctx, cancel = context.WithCancel(ctx)
+	// 排隊期間其他報告可能已用盡團隊預算，開始執行前重新決定模型等級。
+	tier, err := s.recheckTier(reportCtx, started)
+	if err != nil {
+		logger.WarnContext(reportCtx, "團隊預算已用盡，放棄執行分析",
+			slog.String(logKeyStatus, string(ReportStatusFailed)),
+			slog.String(logKeyError, err.Error()),
+		)
+		span.SetStatus(codes.Error, err.Error())
+		s.failAnalysis(reportCtx, logger, reportID, err, nil)
+		return
+	}
+	if tier != started.Tier {
+		if _, err := s.updateReport(reportCtx, reportID, func(report *AnalysisReport) error {
+			if report.Status == ReportStatusCancelled {
+				return errReportCancelled
+			}
+			report.Tier = tier
+			report.UpdatedAt = time.Now().UTC()
+			return nil
+		}); err != nil {
+			if !errors.Is(err, errReportCancelled) {
+				logger.ErrorContext(reportCtx, "無法更新模型等級",
+					slog.String(logKeyStatus, string(ReportStatusRunning)),
+					slog.String(logKeyError, err.Error()),
+				)
+			}
+			return
+		}
 	}
+
+	generator := s.generatorForTier(tier)
+	// 證據於每次執行收集一次，重試時沿用。
+	input.Evidence = s.enrich(reportCtx, logger, input)
+	input.Impact = s.assessImpact(reportCtx, logger, input)
+	input.Incident = s.correlateEvents(reportCtx, logger, input)
+	for attempt := 1; ; attempt++ {
+		attemptStarted := time.Now().UTC()
+		result, usage, err := s.generateOnce(reportCtx, generator, reportID, input, previousAttempts+attempt)
+		record := AnalysisAttempt{
+			Attempt:    previousAttempts + attempt,
+			StartedAt:  attemptStarted,
+			FinishedAt: time.Now().UTC(),
+			Usage:      usage,
+		}
+		// 失敗或重試的呼叫同樣已計費，每次呼叫後即計入團隊花費。
+		s.budget.record(started.Team, attemptStarted, usage.CostUSD)
+		if err == nil {
+			// 串流的部分內容於合併前送出，合併後的完整報告隨 SUCCESS 狀態事件推送。
+			if result != nil {
+				collected := append(slices.Clone(input.Evidence), input.Incident.Evidence()...)
+				result.Evidence = mergeEvidence(collected, result.Evidence)
+				result.RootCauseAnalysis.ProbableCauses = mergeProbableCauses(input.Evidence, result.RootCauseAnalysis.ProbableCauses)
+				applyImpact(result, input.Impact)
+			}
+			if s.completeAnalysis(reportCtx, logger, reportID, result, record) {
+				logger.InfoContext(reportCtx, "AI 分析完成",
+					slog.String(logKeyStatus, string(ReportStatusSuccess)),
+					slog.Int(logKeyAttempt, record.Attempt),
+					durationAttr(time.Since(runStarted)),
+				)
+			}
+			return
+		}
+		record.Error = err.Error()
+		if s.runCtx.Err() != nil {
+			logger.WarnContext(reportCtx, "AI 分析因服務關閉而中斷",
+				slog.String(logKeyStatus, string(ReportStatusRunning)),
+				slog.Int(logKeyAttempt, record.Attempt),
+				durationAttr(time.Since(runStarted)),
+				slog.String(logKeyError, err.Error()),
+			)
+			s.persistAttempt(reportCtx, logger, reportID, record)
+			s.abandonJob(reportCtx, reportID)
+			return
+		}
+		if reportCtx.Err() != nil {
+			// 報告已被取消，狀態由 CancelReport 寫入；中斷的呼叫仍需保存用量。
+			s.persistAttempt(reportCtx, logger, reportID, record)
+			return
+		}
+
+		record.Transient = IsTransientGenerationError(err)
+		if !record.Transient || attempt >= s.retry.MaxAttempts {
+			logger.ErrorContext(reportCtx, "AI 分析失敗",
+				slog.String(logKeyStatus, string(ReportStatusFailed)),
+				slog.Int(logKeyAttempt, record.Attempt),
+				durationAttr(time.Since(runStarted)),
+				slog.Bool("transient", record.Transient),
+				slog.String(logKeyError, err.Error()),
+			)
+			span.SetStatus(codes.Error, err.Error())
+			// 逾時以報告為單位計數，重試後才成功的逾時嘗試不列入。
+			if errors.Is(err, context.DeadlineExceeded) {
+				s.metrics.reportsTimedOut.Inc()
+			}
+			s.failAnalysis(reportCtx, logger, reportID, err, &record)
+			return
+		}
+
+		delay := s.retry.Backoff(attempt, rand.Float64())
+		logger.WarnContext(reportCtx, "AI 分析暫時性失敗，稍後重試",
+			slog.String(logKeyStatus, string(ReportStatusRunning)),
+			slog.Int(logKeyAttempt, record.Attempt),
+			slog.Int("max_attempts", previousAttempts+s.retry.MaxAttempts),
+			durationAttr(record.FinishedAt.Sub(record.StartedAt)),
+			slog.Float64("retry_after_ms", float64(delay.Microseconds())/1000),
+			slog.String(logKeyError, err.Error()),
+		)
+		updated, err := s.persistAttempt(reportCtx, logger, reportID, record)
+		if err != nil || updated.Status == ReportStatusCancelled {
+			return
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-reportCtx.Done():
+			timer.Stop()
+			if s.runCtx.Err() != nil {
+				s.abandonJob(reportCtx, reportID)
+			}
+			return
+		}
+	}
+}
+
+// generateOnce 以單次處理逾時呼叫產生器；支援串流的產生器會逐段推送內容。
+// 回傳此次呼叫的用量，失敗的呼叫也會回傳，供報告累計成本。
+func (s *AnalysisService) generateOnce(parent context.Context, generator ReportGenerator, reportID string, input GenerationInput, attempt int) (*GeneratedReport, *LLMUsage, error) {
+	ctx, cancel := context.WithTimeout(parent, s.processingTimeout)
 	defer cancel()
+	ctx, span := startSpan(ctx, "ReportGenerator.Generate",
+		attribute.String("ai_engine.report_id", reportID),
+		attribute.Int("ai_engine.attempt", attempt),
+	)
 
-	result, err := s.generator.Generate(ctx, input)
-	if err != nil {
-		s.logger.Printf("AI 分析失敗 (report_id=%s): %v", reportID, err)
-		now := time.Now().UTC()
-		if _, updateErr := s.repo.Update(reportID, func(report *AnalysisReport) error {
-			report.Status = ReportStatusFailed
-			report.ErrorMessage = err.Error()
-			report.CompletedAt = &now
-			report.UpdatedAt = now
+	started := time.Now()
+	var result *GeneratedReport
+	var err error
+	if streaming, ok := generator.(StreamingReportGenerator); ok {
+		result, err = streaming.GenerateStream(ctx, input, func(chunk ReportChunk) error {
+			s.publishChunk(reportID, chunk)
 			return nil
-		}); updateErr != nil {
-			s.logger.Printf("無法更新失敗狀態 (report_id=%s): %v", reportID, updateErr)
+		})
+	} else {
+		result, err = generator.Generate(ctx, input)
+	}
+
+	outcome := generationOutcomeSuccess
+	if err != nil {
+		outcome = generationOutcomeError
+		// 僅在單次逾時觸發 (而非取消或服務關閉) 時計為逾時。
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && parent.Err() == nil {
+			outcome = generationOutcomeTimeout
+		}
+	}
+	elapsed := time.Since(started)
+	s.metrics.observeGeneration(elapsed, outcome)
+	usage := s.attemptUsage(result, err, elapsed)
+	if err == nil && result != nil {
+		copied := *result
+		copied.Usage = usage
+		result = &copied
+	}
+	reportLogger(s.logger, reportID, input.EventID).DebugContext(ctx, "產生器呼叫結束",
+		slog.String(logKeyStatus, string(ReportStatusRunning)),
+		slog.Int(logKeyAttempt, attempt),
+		slog.String("outcome", outcome),
+		durationAttr(elapsed),
+	)
+	span.SetAttributes(attribute.String("ai_engine.outcome", outcome))
+	endSpan(span, err)
+	return result, usage, err
+}
+
+// persistAttempt 寫入一次已計費的嘗試，不檢查報告是否已取消：取消或關閉中斷的呼叫同樣已計入團隊花費，
+// 需保存於報告，預算快取重新載入或服務重啟後才能自儲存庫算回。
+func (s *AnalysisService) persistAttempt(ctx context.Context, logger *slog.Logger, reportID string, record AnalysisAttempt) (AnalysisReport, error) {
+	updated, err := s.updateReport(ctx, reportID, func(report *AnalysisReport) error {
+		report.recordAttempt(record)
+		report.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+	if err != nil {
+		logger.ErrorContext(ctx, "無法記錄分析嘗試",
+			slog.String(logKeyStatus, string(ReportStatusRunning)),
+			slog.Int(logKeyAttempt, record.Attempt),
+			slog.String(logKeyError, err.Error()),
+		)
+	}
+	return updated, err
+}
+
+// failAnalysis 將報告標記為失敗；record 為 nil 代表尚未呼叫產生器 (例如預算用盡)。
+func (s *AnalysisService) failAnalysis(ctx context.Context, logger *slog.Logger, reportID string, cause error, record *AnalysisAttempt) {
+	now := time.Now().UTC()
+	attempt := 0
+	if record != nil {
+		attempt = record.Attempt
+	}
+	if _, err := s.updateReport(ctx, reportID, func(report *AnalysisReport) error {
+		if report.Status == ReportStatusCancelled {
+			return errReportCancelled
+		}
+		if record != nil {
+			report.recordAttempt(*record)
+		}
+		report.Status = ReportStatusFailed
+		report.ErrorMessage = cause.Error()
+		report.CompletedAt = &now
+		report.UpdatedAt = now
+		return nil
+	}); err != nil {
+		if !errors.Is(err, errReportCancelled) {
+			logger.ErrorContext(ctx, "無法更新報告狀態",
+				slog.String(logKeyStatus, string(ReportStatusFailed)),
+				slog.Int(logKeyAttempt, attempt),
+				slog.String(logKeyError, err.Error()),
+			)
+		} else if record != nil {
+			s.persistAttempt(ctx, logger, reportID, *record)
 		}
-		return
 	}
+}
 
+// completeAnalysis 寫入成功報告，報告已被取消或寫入失敗時回傳 false。
+func (s *AnalysisService) completeAnalysis(ctx context.Context, logger *slog.Logger, reportID string, result *GeneratedReport, record AnalysisAttempt) bool {
 	payload := result.Clone()
 	now := time.Now().UTC()
-	if _, err := s.repo.Update(reportID, func(report *AnalysisReport) error {
+	_, err := s.updateReport(ctx, reportID, func(report *AnalysisReport) error {
+		if report.Status == ReportStatusCancelled {
+			return errReportCancelled
+		}
+		report.recordAttempt(record)
 		report.Status = ReportStatusSuccess
 		report.EventSummary = payload.EventSummary
 		report.RootCauseAnalysis = &payload.RootCauseAnalysis
@@ -200,7 +856,18 @@ func (s *AnalysisService) runAnalysis(reportID string, input GenerationInput) {
 		report.CompletedAt = &now
 		report.UpdatedAt = now
 		return nil
-	}); err != nil {
-		s.logger.Printf("無法寫入成功報告 (report_id=%s): %v", reportID, err)
+	})
+	if err != nil {
+		if !errors.Is(err, errReportCancelled) {
+			logger.ErrorContext(ctx, "無法更新報告狀態",
+				slog.String(logKeyStatus, string(ReportStatusSuccess)),
+				slog.Int(logKeyAttempt, record.Attempt),
+				slog.String(logKeyError, err.Error()),
+			)
+		} else {
+			s.persistAttempt(ctx, logger, reportID, record)
+		}
+		return false
 	}
+	return true
 }
@@ -160,3 +160,39 @@ func TestGetReportValidateReportID(t *testing.T) {
 		t.Fatalf("缺少報告編號應回傳 ErrReportIDRequired，實際為 %v", err)
 	}
 }
+
+func TestRegenerateReportWhileInProgress(t *testing.T) {
+	repo := NewInMemoryReportRepository()
+	generator := newBlockingGenerator()
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{ProcessingTimeout: 5 * time.Second})
+
+	first, err := service.RegenerateReport(context.Background(), "evt-busy", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立第一版報告失敗: %v", err)
+	}
+	if first.Version != 1 {
+		t.Fatalf("第一版報告版本應為 1，實際為 %d", first.Version)
+	}
+	<-generator.started
+
+	current, err := service.RegenerateReport(context.Background(), "evt-busy", CreateAnalysisRequest{})
+	if !errors.Is(err, ErrReportInProgress) {
+		t.Fatalf("處理中應回傳 ErrReportInProgress，實際為 %v", err)
+	}
+	if current.ReportID != first.ReportID {
+		t.Fatalf("衝突時應回傳處理中的報告")
+	}
+
+	close(generator.release)
+	service.Wait()
+
+	second, err := service.RegenerateReport(context.Background(), "evt-busy", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("完成後應可重新產生: %v", err)
+	}
+	<-generator.started
+	service.Wait()
+	if second.Version != 2 {
+		t.Fatalf("第二版報告版本應為 2，實際為 %d", second.Version)
+	}
+}
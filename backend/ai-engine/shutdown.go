@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// shutdownAbandonedMessage 為關閉時未完成而標記失敗的報告錯誤訊息。
+const shutdownAbandonedMessage = "分析因服務關閉而中斷，報告已標記為失敗"
+
+// Shutdown 停止接受新報告，等待執行中的分析於期限內完成。
+// 尚未開始或逾時被中止的報告依復原策略標記為 FAILED，或保留為 PENDING 供下次啟動重新排入。
+func (s *AnalysisService) Shutdown(ctx context.Context) error {
+	if !s.closing.CompareAndSwap(false, true) {
+		s.wg.Wait()
+		return nil
+	}
+
+	for _, job := range s.queue.drain() {
+		s.abandonJob(trace.ContextWithSpanContext(ctx, job.spanContext), job.reportID)
+		s.wg.Done()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	// 分析結束後關閉所有串流訂閱，讓 HTTP 伺服器可結束長連線。
+	defer s.events.closeAll()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// 期限已到，中止執行中的分析並等待其寫回最終狀態。
+		err = ctx.Err()
+	}
+	s.cancelRuns()
+	<-done
+
+	// 在剩餘期限內送出最終狀態的回呼通知，逾時則中止。
+	if webhookErr := s.webhooks.wait(ctx); err == nil {
+		err = webhookErr
+	}
+	return err
+}
+
+// abandonJob 處理因關閉而未完成的報告。
+func (s *AnalysisService) abandonJob(ctx context.Context, reportID string) {
+	updated, err := s.updateReport(ctx, reportID, func(report *AnalysisReport) error {
+		if !isOrphanedStatus(report.Status) {
+			return errRecoverySkipped
+		}
+		now := time.Now().UTC()
+		if s.recoveryPolicy == RecoveryPolicyRequeue {
+			report.Status = ReportStatusPending
+			report.UpdatedAt = now
+			return nil
+		}
+		report.Status = ReportStatusFailed
+		report.ErrorMessage = shutdownAbandonedMessage
+		report.CompletedAt = &now
+		report.UpdatedAt = now
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, errRecoverySkipped) {
+			s.logger.ErrorContext(ctx, "無法處理關閉時未完成的報告",
+				slog.String(logKeyReportID, reportID),
+				slog.String(logKeyError, err.Error()),
+			)
+		}
+		return
+	}
+	reportLogger(s.logger, reportID, updated.EventID).WarnContext(ctx, "報告因服務關閉而未完成",
+		slog.String(logKeyStatus, string(updated.Status)),
+		slog.Int(logKeyAttempt, updated.AttemptCount),
+		durationAttr(time.Since(updated.CreatedAt)),
+		slog.String("recovery_policy", string(s.recoveryPolicy)),
+	)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAnalysisServiceShutdownDrainsInFlight(t *testing.T) {
+	repo := NewInMemoryReportRepository()
+	generator := newBlockingGenerator()
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		ProcessingTimeout: 5 * time.Second,
+		Workers:           1,
+		RecoveryPolicy:    RecoveryPolicyFail,
+	})
+
+	running, err := service.CreateReport(context.Background(), "evt-running", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	<-generator.started
+	queued, err := service.CreateReport(context.Background(), "evt-queued", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- service.Shutdown(context.Background())
+	}()
+
+	// 關閉流程開始後應拒絕新的分析請求。
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := service.CreateReport(context.Background(), "evt-late", CreateAnalysisRequest{})
+		if errors.Is(err, ErrServiceShuttingDown) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("關閉期間應回傳 ErrServiceShuttingDown，實際為 %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(generator.release)
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("關閉應成功完成: %v", err)
+	}
+
+	report, _ := repo.Get(running.ReportID)
+	if report.Status != ReportStatusSuccess {
+		t.Fatalf("執行中的分析應完成，實際為 %s", report.Status)
+	}
+	report, _ = repo.Get(queued.ReportID)
+	if report.Status != ReportStatusFailed || report.ErrorMessage != shutdownAbandonedMessage {
+		t.Fatalf("尚未開始的報告應標記為 FAILED，實際為 %s (%s)", report.Status, report.ErrorMessage)
+	}
+}
+
+func TestAnalysisServiceShutdownDeadlineRequeue(t *testing.T) {
+	repo := NewInMemoryReportRepository()
+	generator := newBlockingGenerator()
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		ProcessingTimeout: 5 * time.Second,
+		Workers:           1,
+		RecoveryPolicy:    RecoveryPolicyRequeue,
+	})
+
+	running, err := service.CreateReport(context.Background(), "evt-slow", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	<-generator.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := service.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("逾時應回傳 DeadlineExceeded，實際為 %v", err)
+	}
+
+	report, _ := repo.Get(running.ReportID)
+	if report.Status != ReportStatusPending {
+		t.Fatalf("requeue 策略下中止的報告應保留為 PENDING，實際為 %s", report.Status)
+	}
+
+	// 下次啟動時可由復原流程重新排入。
+	restarted := NewAnalysisService(repo, &stubGenerator{result: &GeneratedReport{EventSummary: "完成"}}, AnalysisServiceConfig{
+		ProcessingTimeout: time.Second,
+		RecoveryPolicy:    RecoveryPolicyRequeue,
+	})
+	summary, err := restarted.RecoverOrphanedReports(context.Background())
+	if err != nil || len(summary.Requeued) != 1 {
+		t.Fatalf("重新啟動後應重新排入報告，實際為 %+v (%v)", summary, err)
+	}
+	restarted.Wait()
+	report, _ = repo.Get(running.ReportID)
+	if report.Status != ReportStatusSuccess {
+		t.Fatalf("重新排入的報告應完成，實際為 %s", report.Status)
+	}
+}
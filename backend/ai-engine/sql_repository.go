@@ -0,0 +1,686 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// ErrUnsupportedDatabase 代表設定了不支援的資料庫類型。
+var ErrUnsupportedDatabase = errors.New("unsupported database type")
+
+// SQLRepositoryConfig 設定 SQL 儲存庫的連線方式。
+type SQLRepositoryConfig struct {
+	// Driver 為 sqlite 或 postgres。
+	Driver string
+	// DSN 為 SQLite 檔案路徑或 PostgreSQL 連線字串。
+	DSN string
+}
+
+// analysisReportRecord 為報告在資料庫中的列結構，巢狀內容以 JSON 欄位保存。
+type analysisReportRecord struct {
+	ReportID string  `gorm:"primaryKey;size:64"`
+	EventID  *string `gorm:"size:128;uniqueIndex:idx_ai_analysis_reports_event_version,priority:1"`
+	Version  int     `gorm:"not null;default:1;uniqueIndex:idx_ai_analysis_reports_event_version,priority:2"`
+	Status   string  `gorm:"size:16;not null;index"`
+	// Severity 與 ConfidenceScore 由巢狀內容衍生，供列表篩選與排序使用。
+	Severity           string  `gorm:"size:16;index"`
+	ConfidenceScore    float64 `gorm:"not null;default:0"`
+	EventContext       []byte
+	EventSummary       string `gorm:"type:text"`
+	RootCauseAnalysis  []byte
+	ImpactAssessment   []byte
+	RecommendedActions []byte
+	Evidence           []byte
+	ErrorMessage       string `gorm:"type:text"`
+	RawLLMResponse     []byte
+	CreatedAt          time.Time `gorm:"not null;index"`
+	UpdatedAt          time.Time `gorm:"not null"`
+	CompletedAt        *time.Time
+	CancelledBy        string `gorm:"size:128"`
+	CancelledAt        *time.Time
+	AttemptCount       int `gorm:"not null;default:0"`
+	Attempts           []byte
+	CallbackURLs       []byte
+	WebhookDeliveries  []byte
+	TraceID            string `gorm:"size:32"`
+	RequestedBy        string `gorm:"size:128;index"`
+	Team               string `gorm:"size:128;index"`
+	Tier               string `gorm:"size:16"`
+	Usage              []byte
+}
+
+func (analysisReportRecord) TableName() string {
+	return "ai_analysis_reports"
+}
+
+// SQLReportRepository 以 GORM 將報告持久化至 SQLite 或 PostgreSQL。
+type SQLReportRepository struct {
+	db *gorm.DB
+}
+
+// NewSQLReportRepository 依設定開啟資料庫連線並執行資料表遷移。
+func NewSQLReportRepository(cfg SQLRepositoryConfig) (*SQLReportRepository, error) {
+	var dialector gorm.Dialector
+	switch strings.ToLower(cfg.Driver) {
+	case "sqlite":
+		dsn := cfg.DSN
+		if dsn == "" {
+			dsn = "ai-engine.db"
+		}
+		dialector = sqlite.Open(dsn)
+	case "postgres", "postgresql":
+		if cfg.DSN == "" {
+			return nil, errors.New("postgres dsn is required")
+		}
+		dialector = postgres.Open(cfg.DSN)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDatabase, cfg.Driver)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Silent),
+		TranslateError: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("無法連線資料庫: %w", err)
+	}
+
+	if strings.EqualFold(cfg.Driver, "sqlite") {
+		// SQLite 僅允許單一寫入者，限制連線數避免 database is locked。
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	return newSQLReportRepository(db)
+}
+
+func newSQLReportRepository(db *gorm.DB) (*SQLReportRepository, error) {
+	if err := db.AutoMigrate(&analysisReportRecord{}, &changeEventRecord{}); err != nil {
+		return nil, fmt.Errorf("資料表遷移失敗: %w", err)
+	}
+	// 舊版結構以 event_id 單欄唯一索引限制一事件一報告，改為版本化後需移除。
+	if db.Migrator().HasIndex(&analysisReportRecord{}, "idx_ai_analysis_reports_event") {
+		if err := db.Migrator().DropIndex(&analysisReportRecord{}, "idx_ai_analysis_reports_event"); err != nil {
+			return nil, fmt.Errorf("移除舊索引失敗: %w", err)
+		}
+	}
+	return &SQLReportRepository{db: db}, nil
+}
+
+// Close 關閉底層資料庫連線。
+func (r *SQLReportRepository) Close() error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// Create 新增報告記錄，同一事件僅允許一份報告。
+func (r *SQLReportRepository) Create(report AnalysisReport) (AnalysisReport, error) {
+	if report.Version == 0 {
+		report.Version = 1
+	}
+	record, err := newAnalysisReportRecord(report)
+	if err != nil {
+		return AnalysisReport{}, err
+	}
+
+	var existing AnalysisReport
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&analysisReportRecord{}).Where("report_id = ?", report.ReportID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrReportAlreadyExists
+		}
+
+		if report.EventID != "" {
+			var current analysisReportRecord
+			err := tx.Where("event_id = ?", report.EventID).Order("version DESC").Take(&current).Error
+			switch {
+			case err == nil:
+				existing, err = current.toReport()
+				if err != nil {
+					return err
+				}
+				return ErrReportAlreadyExists
+			case !errors.Is(err, gorm.ErrRecordNotFound):
+				return err
+			}
+		}
+
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrReportAlreadyExists) {
+			return existing, ErrReportAlreadyExists
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return r.conflictingReport(report.EventID)
+		}
+		return AnalysisReport{}, err
+	}
+
+	return record.toReport()
+}
+
+// CreateVersion 在交易中以事件最新版本號加一建立新報告。
+func (r *SQLReportRepository) CreateVersion(report AnalysisReport) (AnalysisReport, error) {
+	var (
+		created AnalysisReport
+		latest  AnalysisReport
+	)
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		report.Version = 1
+		var current analysisReportRecord
+		err := tx.Where("event_id = ?", report.EventID).Order("version DESC").Take(&current).Error
+		switch {
+		case err == nil:
+			latest, err = current.toReport()
+			if err != nil {
+				return err
+			}
+			if isOrphanedStatus(latest.Status) {
+				return ErrReportInProgress
+			}
+			report.Version = latest.Version + 1
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return err
+		}
+
+		record, err := newAnalysisReportRecord(report)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		created, err = record.toReport()
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrReportInProgress) {
+			return latest, ErrReportInProgress
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			// 並行建立相同版本時，以目前最新版本回報衝突。
+			if current, getErr := r.GetLatestByEvent(report.EventID); getErr == nil {
+				return current, ErrReportInProgress
+			}
+			return AnalysisReport{}, ErrReportAlreadyExists
+		}
+		return AnalysisReport{}, err
+	}
+	return created, nil
+}
+
+// GetLatestByEvent 取得事件最新版本的報告。
+func (r *SQLReportRepository) GetLatestByEvent(eventID string) (AnalysisReport, error) {
+	var record analysisReportRecord
+	if err := r.db.Where("event_id = ?", eventID).Order("version DESC").Take(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return AnalysisReport{}, ErrReportNotFound
+		}
+		return AnalysisReport{}, err
+	}
+	return record.toReport()
+}
+
+// ListByEvent 依版本號遞增回傳事件的所有報告。
+func (r *SQLReportRepository) ListByEvent(eventID string) ([]AnalysisReport, error) {
+	var records []analysisReportRecord
+	if err := r.db.Where("event_id = ?", eventID).Order("version ASC").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	return recordsToReports(records)
+}
+
+// Get 依報告編號取得報告。
+func (r *SQLReportRepository) Get(reportID string) (AnalysisReport, error) {
+	var record analysisReportRecord
+	if err := r.db.Where("report_id = ?", reportID).Take(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return AnalysisReport{}, ErrReportNotFound
+		}
+		return AnalysisReport{}, err
+	}
+	return record.toReport()
+}
+
+// Update 在交易中鎖定報告列，套用閉包後寫回。
+func (r *SQLReportRepository) Update(reportID string, updater func(report *AnalysisReport) error) (AnalysisReport, error) {
+	var updated AnalysisReport
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var record analysisReportRecord
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("report_id = ?", reportID).Take(&record).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrReportNotFound
+			}
+			return err
+		}
+
+		report, err := record.toReport()
+		if err != nil {
+			return err
+		}
+		if err := updater(&report); err != nil {
+			return err
+		}
+		// 報告編號為主鍵，不允許於閉包中變更。
+		report.ReportID = reportID
+
+		next, err := newAnalysisReportRecord(report)
+		if err != nil {
+			return err
+		}
+		if err := tx.Save(&next).Error; err != nil {
+			return err
+		}
+		updated = report.Clone()
+		return nil
+	})
+	if err != nil {
+		return AnalysisReport{}, err
+	}
+	return updated, nil
+}
+
+// ListByStatus 依建立時間排序回傳符合任一狀態的報告。
+func (r *SQLReportRepository) ListByStatus(statuses ...ReportStatus) ([]AnalysisReport, error) {
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+	values := make([]string, len(statuses))
+	for i, status := range statuses {
+		values[i] = string(status)
+	}
+
+	var records []analysisReportRecord
+	if err := r.db.Where("status IN ?", values).Order("created_at ASC").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	return recordsToReports(records)
+}
+
+// List 依篩選條件分頁查詢報告，回傳該頁資料與符合條件的總筆數。
+func (r *SQLReportRepository) List(query ReportQuery) ([]AnalysisReport, int, error) {
+	db := r.db.Model(&analysisReportRecord{})
+	if len(query.Statuses) > 0 {
+		values := make([]string, len(query.Statuses))
+		for i, status := range query.Statuses {
+			values[i] = string(status)
+		}
+		db = db.Where("status IN ?", values)
+	}
+	if query.EventID != "" {
+		db = db.Where("event_id = ?", query.EventID)
+	}
+	if query.Severity != "" {
+		db = db.Where("severity = ?", query.Severity)
+	}
+	if query.CreatedFrom != nil {
+		db = db.Where("created_at >= ?", query.CreatedFrom.UTC())
+	}
+	if query.CreatedTo != nil {
+		db = db.Where("created_at <= ?", query.CreatedTo.UTC())
+	}
+	if query.Scoped {
+		visible := r.db.Where("requested_by = ? AND team = ?", "", "")
+		if len(query.Teams) > 0 {
+			visible = visible.Or("team IN ?", query.Teams)
+		}
+		if len(query.Requesters) > 0 {
+			visible = visible.Or("requested_by IN ?", query.Requesters)
+		}
+		db = db.Where(visible)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	// 排序欄位已由 ReportQuery.Normalize 限定於白名單內。
+	column := query.SortBy
+	if column == "" {
+		column = ReportSortCreatedAt
+	}
+	var records []analysisReportRecord
+	err := db.Order(clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: query.Descending()}).
+		Order("report_id ASC").
+		Offset(query.Offset()).
+		Limit(query.PageSize).
+		Find(&records).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	reports, err := recordsToReports(records)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reports, int(total), nil
+}
+
+// ListUsage 回傳區間內開始的產生器呼叫用量，僅讀取彙總所需的欄位。
+// 呼叫必定發生在報告建立與最後更新之間，以此篩選可能落在區間內的報告，再依嘗試展開。
+func (r *SQLReportRepository) ListUsage(from, to time.Time) ([]UsageRecord, error) {
+	var rows []struct {
+		ReportID  string
+		Team      string
+		CreatedAt time.Time
+		Attempts  []byte
+		Usage     []byte
+	}
+	err := r.db.Model(&analysisReportRecord{}).
+		Select("report_id", "team", "created_at", "attempts", "usage").
+		Where("usage IS NOT NULL AND created_at < ? AND updated_at >= ?", to.UTC(), from.UTC()).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	var records []UsageRecord
+	for _, row := range rows {
+		report := AnalysisReport{ReportID: row.ReportID, Team: row.Team, CreatedAt: row.CreatedAt, Usage: &LLMUsage{}}
+		if err := json.Unmarshal(row.Usage, report.Usage); err != nil {
+			return nil, fmt.Errorf("無法解析報告 %s 的 usage: %w", row.ReportID, err)
+		}
+		if len(row.Attempts) > 0 {
+			if err := json.Unmarshal(row.Attempts, &report.Attempts); err != nil {
+				return nil, fmt.Errorf("無法解析報告 %s 的 attempts: %w", row.ReportID, err)
+			}
+		}
+		records = append(records, usageRecords(report, from, to)...)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].BilledAt.Before(records[j].BilledAt)
+	})
+	return records, nil
+}
+
+// conflictingReport 於唯一索引衝突時 (並行建立) 取回既有報告。
+func (r *SQLReportRepository) conflictingReport(eventID string) (AnalysisReport, error) {
+	var record analysisReportRecord
+	if eventID == "" || r.db.Where("event_id = ?", eventID).Order("version DESC").Take(&record).Error != nil {
+		return AnalysisReport{}, ErrReportAlreadyExists
+	}
+	existing, err := record.toReport()
+	if err != nil {
+		return AnalysisReport{}, err
+	}
+	return existing, ErrReportAlreadyExists
+}
+
+func newAnalysisReportRecord(report AnalysisReport) (analysisReportRecord, error) {
+	record := analysisReportRecord{
+		ReportID:        report.ReportID,
+		Version:         report.Version,
+		Status:          string(report.Status),
+		Severity:        reportSeverity(&report),
+		ConfidenceScore: reportConfidence(&report),
+		EventSummary:    report.EventSummary,
+		ErrorMessage:    report.ErrorMessage,
+		CancelledBy:     report.CancelledBy,
+		AttemptCount:    report.AttemptCount,
+		TraceID:         report.TraceID,
+		RequestedBy:     report.RequestedBy,
+		Team:            report.Team,
+		Tier:            report.Tier,
+		CreatedAt:       report.CreatedAt.UTC(),
+		UpdatedAt:       report.UpdatedAt.UTC(),
+	}
+	if report.EventID != "" {
+		eventID := report.EventID
+		record.EventID = &eventID
+	}
+	if report.CompletedAt != nil {
+		completed := report.CompletedAt.UTC()
+		record.CompletedAt = &completed
+	}
+	if report.CancelledAt != nil {
+		cancelled := report.CancelledAt.UTC()
+		record.CancelledAt = &cancelled
+	}
+	if len(report.RawLLMResponse) > 0 {
+		record.RawLLMResponse = append([]byte(nil), report.RawLLMResponse...)
+	}
+
+	var err error
+	if record.EventContext, err = marshalNullable(report.EventContext, len(report.EventContext) == 0); err != nil {
+		return analysisReportRecord{}, err
+	}
+	if record.RootCauseAnalysis, err = marshalNullable(report.RootCauseAnalysis, report.RootCauseAnalysis == nil); err != nil {
+		return analysisReportRecord{}, err
+	}
+	if record.ImpactAssessment, err = marshalNullable(report.ImpactAssessment, report.ImpactAssessment == nil); err != nil {
+		return analysisReportRecord{}, err
+	}
+	if record.RecommendedActions, err = marshalNullable(report.RecommendedActions, len(report.RecommendedActions) == 0); err != nil {
+		return analysisReportRecord{}, err
+	}
+	if record.Evidence, err = marshalNullable(report.Evidence, len(report.Evidence) == 0); err != nil {
+		return analysisReportRecord{}, err
+	}
+	if record.Attempts, err = marshalNullable(report.Attempts, len(report.Attempts) == 0); err != nil {
+		return analysisReportRecord{}, err
+	}
+	if record.CallbackURLs, err = marshalNullable(report.CallbackURLs, len(report.CallbackURLs) == 0); err != nil {
+		return analysisReportRecord{}, err
+	}
+	if record.WebhookDeliveries, err = marshalNullable(report.WebhookDeliveries, len(report.WebhookDeliveries) == 0); err != nil {
+		return analysisReportRecord{}, err
+	}
+	if record.Usage, err = marshalNullable(report.Usage, report.Usage == nil); err != nil {
+		return analysisReportRecord{}, err
+	}
+	return record, nil
+}
+
+func (record analysisReportRecord) toReport() (AnalysisReport, error) {
+	report := AnalysisReport{
+		ReportID:     record.ReportID,
+		Version:      record.Version,
+		Status:       ReportStatus(record.Status),
+		EventSummary: record.EventSummary,
+		ErrorMessage: record.ErrorMessage,
+		CancelledBy:  record.CancelledBy,
+		AttemptCount: record.AttemptCount,
+		TraceID:      record.TraceID,
+		RequestedBy:  record.RequestedBy,
+		Team:         record.Team,
+		Tier:         record.Tier,
+		CreatedAt:    record.CreatedAt.UTC(),
+		UpdatedAt:    record.UpdatedAt.UTC(),
+	}
+	if record.EventID != nil {
+		report.EventID = *record.EventID
+	}
+	if record.CompletedAt != nil {
+		completed := record.CompletedAt.UTC()
+		report.CompletedAt = &completed
+	}
+	if record.CancelledAt != nil {
+		cancelled := record.CancelledAt.UTC()
+		report.CancelledAt = &cancelled
+	}
+	if len(record.RawLLMResponse) > 0 {
+		report.RawLLMResponse = append(json.RawMessage(nil), record.RawLLMResponse...)
+	}
+
+	if len(record.EventContext) > 0 {
+		if err := json.Unmarshal(record.EventContext, &report.EventContext); err != nil {
+			return AnalysisReport{}, fmt.Errorf("無法解析 event_context: %w", err)
+		}
+	}
+	if len(record.RootCauseAnalysis) > 0 {
+		report.RootCauseAnalysis = &RootCauseAnalysis{}
+		if err := json.Unmarshal(record.RootCauseAnalysis, report.RootCauseAnalysis); err != nil {
+			return AnalysisReport{}, fmt.Errorf("無法解析 root_cause_analysis: %w", err)
+		}
+	}
+	if len(record.ImpactAssessment) > 0 {
+		report.ImpactAssessment = &ImpactAssessment{}
+		if err := json.Unmarshal(record.ImpactAssessment, report.ImpactAssessment); err != nil {
+			return AnalysisReport{}, fmt.Errorf("無法解析 impact_assessment: %w", err)
+		}
+	}
+	if len(record.RecommendedActions) > 0 {
+		if err := json.Unmarshal(record.RecommendedActions, &report.RecommendedActions); err != nil {
+			return AnalysisReport{}, fmt.Errorf("無法解析 recommended_actions: %w", err)
+		}
+	}
+	if len(record.Evidence) > 0 {
+		if err := json.Unmarshal(record.Evidence, &report.Evidence); err != nil {
+			return AnalysisReport{}, fmt.Errorf("無法解析 evidence: %w", err)
+		}
+	}
+	if len(record.Attempts) > 0 {
+		if err := json.Unmarshal(record.Attempts, &report.Attempts); err != nil {
+			return AnalysisReport{}, fmt.Errorf("無法解析 attempts: %w", err)
+		}
+	}
+	if len(record.CallbackURLs) > 0 {
+		if err := json.Unmarshal(record.CallbackURLs, &report.CallbackURLs); err != nil {
+			return AnalysisReport{}, fmt.Errorf("無法解析 callback_urls: %w", err)
+		}
+	}
+	if len(record.WebhookDeliveries) > 0 {
+		if err := json.Unmarshal(record.WebhookDeliveries, &report.WebhookDeliveries); err != nil {
+			return AnalysisReport{}, fmt.Errorf("無法解析 webhook_deliveries: %w", err)
+		}
+	}
+	if len(record.Usage) > 0 {
+		report.Usage = &LLMUsage{}
+		if err := json.Unmarshal(record.Usage, report.Usage); err != nil {
+			return AnalysisReport{}, fmt.Errorf("無法解析 usage: %w", err)
+		}
+	}
+	return report, nil
+}
+
+func recordsToReports(records []analysisReportRecord) ([]AnalysisReport, error) {
+	reports := make([]AnalysisReport, 0, len(records))
+	for _, record := range records {
+		report, err := record.toReport()
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func marshalNullable(value any, empty bool) ([]byte, error) {
+	if empty {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
+
+// changeEventRecord 為變更事件在資料庫中的列結構。
+type changeEventRecord struct {
+	ChangeID    string `gorm:"primaryKey;size:128"`
+	Type        string `gorm:"size:16;not null"`
+	Service     string `gorm:"size:128;index"`
+	Environment string `gorm:"size:64"`
+	// Targets 以 |service|resource| 格式保存服務與資源，供 LIKE 比對。
+	Targets    string    `gorm:"type:text;not null"`
+	Payload    []byte    `gorm:"not null"`
+	OccurredAt time.Time `gorm:"not null;index"`
+	ReceivedAt time.Time `gorm:"not null"`
+}
+
+func (changeEventRecord) TableName() string {
+	return "ai_change_events"
+}
+
+// CreateChange 實作 ChangeRepository。
+func (r *SQLReportRepository) CreateChange(change ChangeEvent) (ChangeEvent, error) {
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return ChangeEvent{}, fmt.Errorf("無法序列化變更: %w", err)
+	}
+	record := changeEventRecord{
+		ChangeID:    change.ChangeID,
+		Type:        change.Type,
+		Service:     change.Service,
+		Environment: change.Environment,
+		Targets:     "|" + strings.Join(change.Targets(), "|") + "|",
+		Payload:     payload,
+		OccurredAt:  change.OccurredAt.UTC(),
+		ReceivedAt:  change.ReceivedAt.UTC(),
+	}
+	if err := r.db.Create(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			var existing changeEventRecord
+			if err := r.db.Where("change_id = ?", change.ChangeID).Take(&existing).Error; err != nil {
+				return ChangeEvent{}, ErrChangeAlreadyExists
+			}
+			stored, err := existing.toChange()
+			if err != nil {
+				return ChangeEvent{}, err
+			}
+			return stored, ErrChangeAlreadyExists
+		}
+		return ChangeEvent{}, err
+	}
+	return change.Clone(), nil
+}
+
+// ListChanges 實作 ChangeRepository。
+func (r *SQLReportRepository) ListChanges(query ChangeQuery) ([]ChangeEvent, error) {
+	tx := r.db.Model(&changeEventRecord{}).
+		Where("occurred_at >= ? AND occurred_at < ?", query.From.UTC(), query.To.UTC())
+	if len(query.Targets) > 0 {
+		conditions := make([]string, len(query.Targets))
+		args := make([]any, len(query.Targets))
+		for i, target := range query.Targets {
+			conditions[i] = "targets LIKE ? ESCAPE '\\'"
+			args[i] = "%|" + escapeLike(target) + "|%"
+		}
+		tx = tx.Where(strings.Join(conditions, " OR "), args...)
+	}
+	if query.Limit > 0 {
+		tx = tx.Limit(query.Limit)
+	}
+	var records []changeEventRecord
+	if err := tx.Order("occurred_at DESC").Order("change_id ASC").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	changes := make([]ChangeEvent, 0, len(records))
+	for _, record := range records {
+		change, err := record.toChange()
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func (r changeEventRecord) toChange() (ChangeEvent, error) {
+	var change ChangeEvent
+	if err := json.Unmarshal(r.Payload, &change); err != nil {
+		return ChangeEvent{}, fmt.Errorf("無法解析變更 %s: %w", r.ChangeID, err)
+	}
+	return change, nil
+}
+
+// escapeLike 跳脫 LIKE 模式中的萬用字元。
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
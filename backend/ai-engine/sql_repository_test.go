@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSQLRepository(t *testing.T) *SQLReportRepository {
+	t.Helper()
+	repo, err := NewSQLReportRepository(SQLRepositoryConfig{
+		Driver: "sqlite",
+		DSN:    filepath.Join(t.TempDir(), "reports.db"),
+	})
+	if err != nil {
+		t.Fatalf("建立 SQLite 儲存庫失敗: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	return repo
+}
+
+func TestSQLReportRepositoryRoundTrip(t *testing.T) {
+	repo := newTestSQLRepository(t)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	created, err := repo.Create(AnalysisReport{
+		ReportID:  "rpt-1",
+		EventID:   "evt-1",
+		Status:    ReportStatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	if created.Status != ReportStatusPending {
+		t.Fatalf("預期狀態為 PENDING，實際為 %s", created.Status)
+	}
+
+	completed := now.Add(time.Second)
+	_, err = repo.Update("rpt-1", func(report *AnalysisReport) error {
+		report.Status = ReportStatusSuccess
+		report.EventSummary = "測試摘要"
+		report.RootCauseAnalysis = &RootCauseAnalysis{
+			Text:            "測試根因",
+			ConfidenceScore: 0.8,
+			ProbableCauses:  []string{"部署"},
+			Evidence:        []EvidenceItem{{Type: "METRIC", Description: "CPU", Metadata: map[string]any{"value": "92%"}}},
+		}
+		report.ImpactAssessment = &ImpactAssessment{
+			Text:              "登入延遲",
+			AffectedResources: []AffectedResource{{ID: "svc-1", Name: "auth"}},
+		}
+		report.RecommendedActions = []RecommendedAction{{Title: "回滾", ActionType: "AUTOMATION", Risk: "HIGH"}}
+		report.Evidence = []EvidenceItem{{Type: "LOG", Description: "OOM", Link: &EvidenceLink{Name: "logs", URL: "/logs"}}}
+		report.RawLLMResponse = []byte(`{"mock":true}`)
+		report.CompletedAt = &completed
+		report.UpdatedAt = completed
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("更新報告失敗: %v", err)
+	}
+
+	report, err := repo.Get("rpt-1")
+	if err != nil {
+		t.Fatalf("取得報告失敗: %v", err)
+	}
+	if report.Status != ReportStatusSuccess || report.EventID != "evt-1" {
+		t.Fatalf("報告基本欄位錯誤: %+v", report)
+	}
+	if report.RootCauseAnalysis == nil || report.RootCauseAnalysis.Evidence[0].Metadata["value"] != "92%" {
+		t.Fatalf("根本原因內容未正確保存")
+	}
+	if report.ImpactAssessment == nil || len(report.ImpactAssessment.AffectedResources) != 1 {
+		t.Fatalf("影響評估內容未正確保存")
+	}
+	if len(report.RecommendedActions) != 1 || report.RecommendedActions[0].Title != "回滾" {
+		t.Fatalf("建議措施未正確保存")
+	}
+	if len(report.Evidence) != 1 || report.Evidence[0].Link == nil {
+		t.Fatalf("證據未正確保存")
+	}
+	if string(report.RawLLMResponse) != `{"mock":true}` {
+		t.Fatalf("原始回應未正確保存: %s", report.RawLLMResponse)
+	}
+	if report.CompletedAt == nil || !report.CompletedAt.Equal(completed) {
+		t.Fatalf("完成時間未正確保存")
+	}
+}
+
+func TestSQLReportRepositoryDuplicateEvent(t *testing.T) {
+	repo := newTestSQLRepository(t)
+
+	now := time.Now().UTC()
+	first, err := repo.Create(AnalysisReport{ReportID: "rpt-a", EventID: "evt-dup", Status: ReportStatusPending, CreatedAt: now, UpdatedAt: now})
+	if err != nil {
+		t.Fatalf("第一次建立應成功: %v", err)
+	}
+
+	existing, err := repo.Create(AnalysisReport{ReportID: "rpt-b", EventID: "evt-dup", Status: ReportStatusPending, CreatedAt: now, UpdatedAt: now})
+	if !errors.Is(err, ErrReportAlreadyExists) {
+		t.Fatalf("重複事件應回傳 ErrReportAlreadyExists，實際為 %v", err)
+	}
+	if existing.ReportID != first.ReportID {
+		t.Fatalf("衝突時應回傳既有報告")
+	}
+}
+
+func TestSQLReportRepositoryUpdateRollback(t *testing.T) {
+	repo := newTestSQLRepository(t)
+
+	now := time.Now().UTC()
+	if _, err := repo.Create(AnalysisReport{ReportID: "rpt-r", EventID: "evt-r", Status: ReportStatusPending, CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+
+	updateErr := errors.New("abort")
+	if _, err := repo.Update("rpt-r", func(report *AnalysisReport) error {
+		report.Status = ReportStatusRunning
+		return updateErr
+	}); !errors.Is(err, updateErr) {
+		t.Fatalf("預期回傳閉包錯誤，實際為 %v", err)
+	}
+
+	report, err := repo.Get("rpt-r")
+	if err != nil {
+		t.Fatalf("取得報告失敗: %v", err)
+	}
+	if report.Status != ReportStatusPending {
+		t.Fatalf("閉包失敗時不應寫入變更，實際狀態為 %s", report.Status)
+	}
+
+	if _, err := repo.Update("missing", func(report *AnalysisReport) error { return nil }); !errors.Is(err, ErrReportNotFound) {
+		t.Fatalf("預期 ErrReportNotFound，實際為 %v", err)
+	}
+}
+
+func TestAnalysisServiceWithSQLRepository(t *testing.T) {
+	repo := newTestSQLRepository(t)
+	generator := &stubGenerator{result: &GeneratedReport{
+		EventSummary:      "示範事件",
+		RootCauseAnalysis: RootCauseAnalysis{Text: "測試根本原因"},
+	}}
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{ProcessingTimeout: time.Second})
+
+	draft, err := service.CreateReport(context.Background(), "evt-sql", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告草稿失敗: %v", err)
+	}
+	service.Wait()
+
+	report, err := service.GetReport(context.Background(), draft.ReportID)
+	if err != nil {
+		t.Fatalf("取得報告失敗: %v", err)
+	}
+	if report.Status != ReportStatusSuccess {
+		t.Fatalf("預期狀態為 SUCCESS，實際為 %s", report.Status)
+	}
+}
+
+func TestSQLReportRepositoryRecoverOrphaned(t *testing.T) {
+	repo := newTestSQLRepository(t)
+	seedOrphanedReports(t, repo)
+
+	orphaned, err := repo.ListByStatus(ReportStatusPending, ReportStatusRunning)
+	if err != nil {
+		t.Fatalf("查詢遺留報告失敗: %v", err)
+	}
+	if len(orphaned) != 2 {
+		t.Fatalf("預期兩筆遺留報告，實際為 %d", len(orphaned))
+	}
+
+	service := NewAnalysisService(repo, &stubGenerator{}, AnalysisServiceConfig{RecoveryPolicy: RecoveryPolicyFail})
+	if _, err := service.RecoverOrphanedReports(context.Background()); err != nil {
+		t.Fatalf("復原失敗: %v", err)
+	}
+
+	report, err := repo.Get("rpt-pending")
+	if err != nil {
+		t.Fatalf("取得報告失敗: %v", err)
+	}
+	if report.Status != ReportStatusFailed || report.EventContext["service"] != "auth" {
+		t.Fatalf("遺留報告應標記為 FAILED 並保留上下文，實際為 %+v", report)
+	}
+}
+
+func TestSQLReportRepositoryCreateVersion(t *testing.T) {
+	repo := newTestSQLRepository(t)
+
+	now := time.Now().UTC()
+	if _, err := repo.Create(AnalysisReport{ReportID: "rpt-v1", EventID: "evt-v", Status: ReportStatusRunning, CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+
+	inProgress, err := repo.CreateVersion(AnalysisReport{ReportID: "rpt-v2", EventID: "evt-v", Status: ReportStatusPending, CreatedAt: now, UpdatedAt: now})
+	if !errors.Is(err, ErrReportInProgress) || inProgress.ReportID != "rpt-v1" {
+		t.Fatalf("最新版本處理中應回傳 ErrReportInProgress，實際為 %v", err)
+	}
+
+	if _, err := repo.Update("rpt-v1", func(report *AnalysisReport) error {
+		report.Status = ReportStatusSuccess
+		return nil
+	}); err != nil {
+		t.Fatalf("更新報告失敗: %v", err)
+	}
+
+	second, err := repo.CreateVersion(AnalysisReport{ReportID: "rpt-v2", EventID: "evt-v", Status: ReportStatusPending, CreatedAt: now, UpdatedAt: now})
+	if err != nil {
+		t.Fatalf("建立新版本失敗: %v", err)
+	}
+	if second.Version != 2 {
+		t.Fatalf("預期版本為 2，實際為 %d", second.Version)
+	}
+
+	latest, err := repo.GetLatestByEvent("evt-v")
+	if err != nil || latest.ReportID != "rpt-v2" {
+		t.Fatalf("最新版本應為 rpt-v2，實際為 %+v (%v)", latest, err)
+	}
+	if first, err := repo.Get("rpt-v1"); err != nil || first.Version != 1 {
+		t.Fatalf("舊版本應保留，實際為 %+v (%v)", first, err)
+	}
+	if _, err := repo.GetLatestByEvent("evt-none"); !errors.Is(err, ErrReportNotFound) {
+		t.Fatalf("預期 ErrReportNotFound，實際為 %v", err)
+	}
+}
+
+func TestSQLReportRepositoryPersistsCancellation(t *testing.T) {
+	repo := newTestSQLRepository(t)
+	service := NewAnalysisService(repo, newBlockingGenerator(), AnalysisServiceConfig{ProcessingTimeout: 5 * time.Second, Workers: 1})
+
+	report, err := service.CreateReport(context.Background(), "evt-sql-cancel", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	if _, err := service.CancelReport(context.Background(), report.ReportID, "alice"); err != nil {
+		t.Fatalf("取消報告失敗: %v", err)
+	}
+	service.Wait()
+
+	stored, err := repo.Get(report.ReportID)
+	if err != nil {
+		t.Fatalf("讀取報告失敗: %v", err)
+	}
+	if stored.Status != ReportStatusCancelled || stored.CancelledBy != "alice" || stored.CancelledAt == nil {
+		t.Fatalf("取消資訊未正確保存: %+v", stored)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// ReportSection 代表報告中可逐段推送的區塊。
+type ReportSection string
+
+const (
+	ReportSectionEventSummary       ReportSection = "event_summary"
+	ReportSectionRootCauseAnalysis  ReportSection = "root_cause_analysis"
+	ReportSectionImpactAssessment   ReportSection = "impact_assessment"
+	ReportSectionRecommendedActions ReportSection = "recommended_actions"
+	ReportSectionEvidence           ReportSection = "evidence"
+)
+
+// ReportChunk 為串流產生過程中完成的一個報告區塊。
+type ReportChunk struct {
+	Section ReportSection `json:"section"`
+	Content any           `json:"content"`
+}
+
+// StreamingReportGenerator 為可逐段輸出內容的產生器。
+// 每完成一個區塊即呼叫 emit，最後仍回傳完整報告；emit 回傳錯誤時應中止產生。
+type StreamingReportGenerator interface {
+	ReportGenerator
+	GenerateStream(ctx context.Context, input GenerationInput, emit func(ReportChunk) error) (*GeneratedReport, error)
+}
+
+// reportSections 依推送順序列出報告區塊：摘要、根本原因、影響、建議措施、證據。
+func reportSections(report *GeneratedReport) []ReportChunk {
+	return []ReportChunk{
+		{Section: ReportSectionEventSummary, Content: report.EventSummary},
+		{Section: ReportSectionRootCauseAnalysis, Content: report.RootCauseAnalysis},
+		{Section: ReportSectionImpactAssessment, Content: report.ImpactAssessment},
+		{Section: ReportSectionRecommendedActions, Content: report.RecommendedActions},
+		{Section: ReportSectionEvidence, Content: report.Evidence},
+	}
+}
+
+// 串流事件類型。
+const (
+	ReportEventStatus  = "status"
+	ReportEventPartial = "partial"
+)
+
+// ReportStreamEvent 為推送給訂閱者的報告進度事件。
+// status 事件附帶當下的完整報告；partial 事件僅包含新完成的區塊。
+// partial 事件為產生器的原始輸出，尚未合併服務端收集的證據、變更根因與拓撲影響範圍，僅供預覽；
+// 報告內容以狀態為 SUCCESS 的 status 事件 (串流的最後一個事件) 所附的完整報告為準。
+type ReportStreamEvent struct {
+	Type     string        `json:"type"`
+	ReportID string        `json:"report_id"`
+	Status   ReportStatus  `json:"status,omitempty"`
+	Section  ReportSection `json:"section,omitempty"`
+	Content  any           `json:"content,omitempty"`
+	// Provisional 標示 partial 事件的內容可能被最終報告取代。
+	Provisional bool            `json:"provisional,omitempty"`
+	Report      *AnalysisReport `json:"report,omitempty"`
+}
+
+// reportSubscriberBuffer 為每個訂閱者可暫存的事件數，超過時中斷該訂閱。
+const reportSubscriberBuffer = 32
+
+// reportBroker 將報告事件廣播給同一報告的所有訂閱者。
+type reportBroker struct {
+	mu     sync.Mutex
+	subs   map[string]map[chan ReportStreamEvent]struct{}
+	closed bool
+}
+
+func newReportBroker() *reportBroker {
+	return &reportBroker{subs: make(map[string]map[chan ReportStreamEvent]struct{})}
+}
+
+// subscribe 訂閱報告事件，回傳的函式用於取消訂閱。
+// 通道關閉代表訂閱已結束 (服務關閉或訂閱者來不及接收)，呼叫端應重新讀取報告。
+func (b *reportBroker) subscribe(reportID string) (<-chan ReportStreamEvent, func()) {
+	ch := make(chan ReportStreamEvent, reportSubscriberBuffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subs[reportID] == nil {
+		b.subs[reportID] = make(map[chan ReportStreamEvent]struct{})
+	}
+	b.subs[reportID][ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.removeLocked(reportID, ch)
+	}
+}
+
+// publish 以非阻塞方式推送事件，緩衝已滿的訂閱者會被中斷以免拖慢分析。
+func (b *reportBroker) publish(event ReportStreamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[event.ReportID] {
+		select {
+		case ch <- event:
+		default:
+			b.removeLocked(event.ReportID, ch)
+		}
+	}
+}
+
+// closeAll 結束所有訂閱並拒絕新的訂閱。
+func (b *reportBroker) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for reportID, subs := range b.subs {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(b.subs, reportID)
+	}
+}
+
+func (b *reportBroker) removeLocked(reportID string, ch chan ReportStreamEvent) {
+	subs := b.subs[reportID]
+	if _, ok := subs[ch]; !ok {
+		return
+	}
+	delete(subs, ch)
+	close(ch)
+	if len(subs) == 0 {
+		delete(b.subs, reportID)
+	}
+}
+
+// isTerminalStatus 判斷報告是否已結束，不會再有狀態變化。
+func isTerminalStatus(status ReportStatus) bool {
+	switch status {
+	case ReportStatusSuccess, ReportStatusFailed, ReportStatusCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// SubscribeReport 訂閱報告進度，回傳目前的報告快照與後續事件。
+// 先訂閱再讀取報告，避免錯過兩者之間發生的狀態變化。
+func (s *AnalysisService) SubscribeReport(ctx context.Context, reportID string) (AnalysisReport, <-chan ReportStreamEvent, func(), error) {
+	if reportID == "" {
+		return AnalysisReport{}, nil, nil, ErrReportIDRequired
+	}
+	events, unsubscribe := s.events.subscribe(reportID)
+	report, err := s.GetReport(ctx, reportID)
+	if err != nil {
+		unsubscribe()
+		return AnalysisReport{}, nil, nil, err
+	}
+	return report, events, unsubscribe, nil
+}
+
+// updateReport 更新報告，狀態改變時推送 status 事件給訂閱者；進入 SUCCESS 或 FAILED 時送出回呼通知。
+func (s *AnalysisService) updateReport(ctx context.Context, reportID string, updater func(report *AnalysisReport) error) (AnalysisReport, error) {
+	var previous ReportStatus
+	updated, err := traceRepo(ctx, "Update", reportID, func() (AnalysisReport, error) {
+		return s.repo.Update(reportID, func(report *AnalysisReport) error {
+			previous = report.Status
+			return updater(report)
+		})
+	})
+	if err == nil && updated.Status != previous {
+		s.metrics.observeTransition(updated)
+		snapshot := updated.Clone()
+		s.events.publish(ReportStreamEvent{
+			Type:     ReportEventStatus,
+			ReportID: reportID,
+			Status:   updated.Status,
+			Report:   &snapshot,
+		})
+		if updated.Status == ReportStatusSuccess || updated.Status == ReportStatusFailed {
+			s.webhooks.notify(updated.Clone())
+		}
+	}
+	return updated, err
+}
+
+// publishChunk 推送產生器完成的報告區塊，內容於合併服務端資料前送出，標示為暫定。
+func (s *AnalysisService) publishChunk(reportID string, chunk ReportChunk) {
+	s.events.publish(ReportStreamEvent{
+		Type:        ReportEventPartial,
+		ReportID:    reportID,
+		Section:     chunk.Section,
+		Content:     chunk.Content,
+		Provisional: true,
+	})
+}
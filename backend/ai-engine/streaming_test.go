@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gatedStreamingGenerator 於 gate 關閉後才開始串流，確保測試端已完成訂閱。
+type gatedStreamingGenerator struct {
+	*TemplateReportGenerator
+	gate chan struct{}
+}
+
+func (g *gatedStreamingGenerator) GenerateStream(ctx context.Context, input GenerationInput, emit func(ReportChunk) error) (*GeneratedReport, error) {
+	select {
+	case <-g.gate:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return g.TemplateReportGenerator.GenerateStream(ctx, input, emit)
+}
+
+func newTestTemplateGenerator(delay time.Duration) *TemplateReportGenerator {
+	return &TemplateReportGenerator{
+		templates: []GeneratedReport{{
+			EventSummary:       "資料庫連線耗盡",
+			RootCauseAnalysis:  RootCauseAnalysis{Text: "連線池設定過小", ConfidenceScore: 0.8},
+			RecommendedActions: []RecommendedAction{{Title: "調整連線池"}},
+		}},
+		delay: delay,
+	}
+}
+
+func TestTemplateGeneratorStreamsSections(t *testing.T) {
+	generator := newTestTemplateGenerator(10 * time.Millisecond)
+
+	var sections []ReportSection
+	report, err := generator.GenerateStream(context.Background(), GenerationInput{EventID: "evt-1"}, func(chunk ReportChunk) error {
+		sections = append(sections, chunk.Section)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("串流產生失敗: %v", err)
+	}
+	want := []ReportSection{
+		ReportSectionEventSummary,
+		ReportSectionRootCauseAnalysis,
+		ReportSectionImpactAssessment,
+		ReportSectionRecommendedActions,
+		ReportSectionEvidence,
+	}
+	if strings.Join(sectionNames(sections), ",") != strings.Join(sectionNames(want), ",") {
+		t.Fatalf("區塊順序錯誤: %v", sections)
+	}
+	if report.EventSummary != "資料庫連線耗盡" {
+		t.Fatalf("完整報告內容錯誤: %+v", report)
+	}
+}
+
+func sectionNames(sections []ReportSection) []string {
+	names := make([]string, len(sections))
+	for i, section := range sections {
+		names[i] = string(section)
+	}
+	return names
+}
+
+type sseMessage struct {
+	event string
+	data  ReportStreamEvent
+}
+
+func readSSE(t *testing.T, scanner *bufio.Scanner) (sseMessage, bool) {
+	t.Helper()
+	var msg sseMessage
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if msg.event != "" {
+				return msg, true
+			}
+		case strings.HasPrefix(line, "event:"):
+			msg.event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &msg.data); err != nil {
+				t.Fatalf("解析 SSE 資料失敗: %v", err)
+			}
+		}
+	}
+	return msg, false
+}
+
+func TestStreamAnalysisReportEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	generator := &gatedStreamingGenerator{TemplateReportGenerator: newTestTemplateGenerator(5 * time.Millisecond), gate: make(chan struct{})}
+	service := NewAnalysisService(NewInMemoryReportRepository(), generator, AnalysisServiceConfig{ProcessingTimeout: 5 * time.Second})
+	server := httptest.NewServer(SetupRouter(service))
+	defer server.Close()
+
+	report, err := service.CreateReport(context.Background(), "evt-stream", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+
+	resp, err := http.Get(server.URL + "/api/v1/ai/analysis-reports/" + report.ReportID + "/stream")
+	if err != nil {
+		t.Fatalf("連線串流失敗: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("串流回應錯誤: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	first, ok := readSSE(t, scanner)
+	if !ok || first.event != ReportEventStatus || first.data.Report == nil {
+		t.Fatalf("第一個事件應為目前報告快照，實際為 %+v", first)
+	}
+	close(generator.gate)
+
+	var partials []ReportSection
+	var last sseMessage
+	for {
+		msg, ok := readSSE(t, scanner)
+		if !ok {
+			break
+		}
+		if msg.event == ReportEventPartial {
+			partials = append(partials, msg.data.Section)
+		}
+		last = msg
+	}
+
+	if len(partials) != 5 || partials[0] != ReportSectionEventSummary || partials[1] != ReportSectionRootCauseAnalysis || partials[3] != ReportSectionRecommendedActions {
+		t.Fatalf("部分內容推送順序錯誤: %v", partials)
+	}
+	if last.event != ReportEventStatus || last.data.Status != ReportStatusSuccess || last.data.Report == nil || last.data.Report.EventSummary != "資料庫連線耗盡" {
+		t.Fatalf("最後事件應為 SUCCESS 完整報告，實際為 %+v", last)
+	}
+
+	// 已結束的報告只回傳快照並立即關閉。
+	done, err := http.Get(server.URL + "/api/v1/ai/analysis-reports/" + report.ReportID + "/stream")
+	if err != nil {
+		t.Fatalf("連線串流失敗: %v", err)
+	}
+	defer done.Body.Close()
+	doneScanner := bufio.NewScanner(done.Body)
+	if msg, ok := readSSE(t, doneScanner); !ok || msg.data.Status != ReportStatusSuccess {
+		t.Fatalf("已完成報告應回傳 SUCCESS 快照，實際為 %+v", msg)
+	}
+	if _, ok := readSSE(t, doneScanner); ok {
+		t.Fatalf("已完成報告的串流應立即結束")
+	}
+
+	missing := httptest.NewRecorder()
+	SetupRouter(service).ServeHTTP(missing, httptest.NewRequest(http.MethodGet, "/api/v1/ai/analysis-reports/rpt-missing/stream", nil))
+	if missing.Code != http.StatusNotFound {
+		t.Fatalf("不存在的報告應回傳 404，實際為 %d", missing.Code)
+	}
+}
+
+func TestStreamFinalReportIncludesMergedImpact(t *testing.T) {
+	generator := &gatedStreamingGenerator{TemplateReportGenerator: newTestTemplateGenerator(0), gate: make(chan struct{})}
+	service := NewAnalysisService(NewInMemoryReportRepository(), generator, AnalysisServiceConfig{
+		Topology: StaticTopologyProvider{Graph: impactTestTopology},
+	})
+	report, err := service.CreateReport(context.Background(), "evt-stream-merge", CreateAnalysisRequest{EventContext: map[string]any{"resource_name": "checkout"}})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	_, events, unsubscribe, err := service.SubscribeReport(context.Background(), report.ReportID)
+	if err != nil {
+		t.Fatalf("訂閱失敗: %v", err)
+	}
+	defer unsubscribe()
+	close(generator.gate)
+
+	var partialImpact *ReportStreamEvent
+	var final *AnalysisReport
+	for event := range events {
+		if event.Type == ReportEventPartial && event.Section == ReportSectionImpactAssessment {
+			partialImpact = &event
+		}
+		if event.Type == ReportEventStatus && event.Status == ReportStatusSuccess {
+			final = event.Report
+			break
+		}
+	}
+	service.Wait()
+
+	if partialImpact == nil || !partialImpact.Provisional {
+		t.Fatalf("產生器的部分內容應標示為暫定: %+v", partialImpact)
+	}
+	if impact, ok := partialImpact.Content.(ImpactAssessment); !ok || len(impact.AffectedResources) != 0 {
+		t.Fatalf("部分內容應為產生器原始輸出: %+v", partialImpact.Content)
+	}
+	if final == nil || final.ImpactAssessment == nil || len(final.ImpactAssessment.AffectedResources) != len(impactTestTopology.Nodes) {
+		t.Fatalf("SUCCESS 事件應附帶合併拓撲影響後的完整報告: %+v", final)
+	}
+}
+
+func TestReportBrokerDropsSlowSubscriber(t *testing.T) {
+	broker := newReportBroker()
+	events, unsubscribe := broker.subscribe("rpt-1")
+	defer unsubscribe()
+
+	for i := 0; i < reportSubscriberBuffer+1; i++ {
+		broker.publish(ReportStreamEvent{Type: ReportEventPartial, ReportID: "rpt-1"})
+	}
+	received := 0
+	for range events {
+		received++
+	}
+	if received != reportSubscriberBuffer {
+		t.Fatalf("緩衝已滿後應關閉訂閱，實際收到 %d 筆", received)
+	}
+
+	broker.closeAll()
+	late, _ := broker.subscribe("rpt-1")
+	if _, ok := <-late; ok {
+		t.Fatalf("關閉後的訂閱通道應立即關閉")
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTopologyCacheTTL = 5 * time.Minute
+	defaultTopologyTimeout  = 10 * time.Second
+	// topologyRetryInterval 為重新載入失敗後再次呼叫拓撲 API 的最短間隔，期間沿用先前的拓撲。
+	topologyRetryInterval = 30 * time.Second
+	// maxTopologyResponseBytes 為拓撲 API 回應的讀取上限；數千個節點的拓撲圖約數 MB，
+	// 16 MiB 保留餘裕，同時避免異常回應耗盡記憶體。
+	maxTopologyResponseBytes = 16 << 20
+)
+
+// ErrTopologyURLRequired 代表未設定拓撲 API 位址。
+var ErrTopologyURLRequired = errors.New("topology url is required")
+
+// TopologyNode 對應 API 合約的 TopologyNode，僅保留分析所需的欄位。
+type TopologyNode struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Type        string         `json:"type"`
+	Status      string         `json:"status"`
+	Environment string         `json:"environment,omitempty"`
+	Team        string         `json:"team,omitempty"`
+	Metrics     map[string]any `json:"metrics,omitempty"`
+}
+
+// TopologyEdge 對應 API 合約的 TopologyEdge，source 呼叫或依賴 target。
+type TopologyEdge struct {
+	Source       string  `json:"source"`
+	Target       string  `json:"target"`
+	Relation     string  `json:"relation,omitempty"`
+	TrafficLevel float64 `json:"traffic_level,omitempty"`
+	Status       string  `json:"status,omitempty"`
+}
+
+// TopologyGraph 對應 API 合約的 TopologyGraph。
+type TopologyGraph struct {
+	Nodes []TopologyNode `json:"nodes"`
+	Edges []TopologyEdge `json:"edges"`
+}
+
+// TopologyProvider 提供資源的相依關係圖。
+type TopologyProvider interface {
+	Topology(ctx context.Context) (TopologyGraph, error)
+}
+
+// Node 回傳指定編號的節點。
+func (g TopologyGraph) Node(id string) (TopologyNode, bool) {
+	for _, node := range g.Nodes {
+		if node.ID == id {
+			return node, true
+		}
+	}
+	return TopologyNode{}, false
+}
+
+// Locate 依 resource_id、resource_name 或服務名稱找出事件所在的節點。
+func (g TopologyGraph) Locate(event EventFacts) (TopologyNode, bool) {
+	if event.ResourceID != "" {
+		if node, ok := g.Node(event.ResourceID); ok {
+			return node, true
+		}
+	}
+	for _, name := range []string{event.ResourceName, event.Labels["service"]} {
+		if name == "" {
+			continue
+		}
+		for _, node := range g.Nodes {
+			if strings.EqualFold(node.Name, name) {
+				return node, true
+			}
+		}
+	}
+	return TopologyNode{}, false
+}
+
+// Dependencies 回傳節點直接依賴的節點 (上游)。
+func (g TopologyGraph) Dependencies(id string) []TopologyNode {
+	var nodes []TopologyNode
+	for _, edge := range g.Edges {
+		if edge.Source == id {
+			if node, ok := g.Node(edge.Target); ok {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return nodes
+}
+
+// Consumers 回傳直接依賴此節點的節點 (下游)。
+func (g TopologyGraph) Consumers(id string) []TopologyNode {
+	var nodes []TopologyNode
+	for _, edge := range g.Edges {
+		if edge.Target == id {
+			if node, ok := g.Node(edge.Source); ok {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return nodes
+}
+
+// LoadTopologyFile 讀取 TopologyGraph 格式的 JSON 快照。
+func LoadTopologyFile(path string) (TopologyGraph, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return TopologyGraph{}, fmt.Errorf("無法讀取拓撲快照: %w", err)
+	}
+	var graph TopologyGraph
+	if err := json.Unmarshal(raw, &graph); err != nil {
+		return TopologyGraph{}, fmt.Errorf("拓撲快照格式錯誤: %w", err)
+	}
+	return graph, nil
+}
+
+// StaticTopologyProvider 回傳固定的拓撲圖，適用於 JSON 快照。
+type StaticTopologyProvider struct {
+	Graph TopologyGraph
+}
+
+// Topology 實作 TopologyProvider。
+func (p StaticTopologyProvider) Topology(context.Context) (TopologyGraph, error) {
+	return p.Graph, nil
+}
+
+// HTTPTopologyProviderConfig 設定主後端的 /topology API。
+type HTTPTopologyProviderConfig struct {
+	// BaseURL 為主後端 API 根路徑，例如 http://backend:8080/api/v1。
+	BaseURL     string
+	BearerToken string
+	// CacheTTL 為拓撲圖快取時間，預設 5 分鐘。
+	CacheTTL time.Duration
+	// Timeout 僅在未提供 HTTPClient 時套用。
+	Timeout    time.Duration
+	HTTPClient *http.Client
+}
+
+// HTTPTopologyProvider 自主後端的 /topology API 取得拓撲圖並快取；重新載入失敗時沿用先前的結果。
+type HTTPTopologyProvider struct {
+	endpoint    string
+	bearerToken string
+	ttl         time.Duration
+	client      *http.Client
+
+	mu          sync.Mutex
+	graph       TopologyGraph
+	loaded      bool
+	loadedAt    time.Time
+	lastAttempt time.Time
+	lastErr     error
+	// refreshing 於抓取進行中時非 nil，抓取結束時關閉。
+	refreshing chan struct{}
+}
+
+// NewHTTPTopologyProvider 建立拓撲 API 提供者。
+func NewHTTPTopologyProvider(cfg HTTPTopologyProviderConfig) (*HTTPTopologyProvider, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if baseURL == "" {
+		return nil, ErrTopologyURLRequired
+	}
+	ttl := cfg.CacheTTL
+	if ttl <= 0 {
+		ttl = defaultTopologyCacheTTL
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = defaultTopologyTimeout
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	return &HTTPTopologyProvider{endpoint: baseURL + "/topology", bearerToken: cfg.BearerToken, ttl: ttl, client: client}, nil
+}
+
+// Topology 實作 TopologyProvider。快取過期時由單一請求於鎖外重新載入，
+// 其他請求沿用先前的拓撲；尚未載入過時則等待該次載入的結果。
+// 載入失敗後 topologyRetryInterval 內不再呼叫 API。
+func (p *HTTPTopologyProvider) Topology(ctx context.Context) (TopologyGraph, error) {
+	p.mu.Lock()
+	now := time.Now()
+	if p.loaded && (now.Sub(p.loadedAt) < p.ttl || now.Sub(p.lastAttempt) < topologyRetryInterval) {
+		graph := p.graph
+		p.mu.Unlock()
+		return graph, nil
+	}
+	if done := p.refreshing; done != nil {
+		if p.loaded {
+			graph := p.graph
+			p.mu.Unlock()
+			return graph, nil
+		}
+		p.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return TopologyGraph{}, ctx.Err()
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if !p.loaded {
+			return TopologyGraph{}, p.lastErr
+		}
+		return p.graph, nil
+	}
+	if !p.loaded && p.lastErr != nil && now.Sub(p.lastAttempt) < topologyRetryInterval {
+		err := p.lastErr
+		p.mu.Unlock()
+		return TopologyGraph{}, err
+	}
+	p.lastAttempt = now
+	done := make(chan struct{})
+	p.refreshing = done
+	p.mu.Unlock()
+
+	// 載入結果由等待中的請求共用，不隨發起請求的分析中斷而取消；逾時由 HTTP client 控制。
+	graph, err := p.fetch(context.WithoutCancel(ctx))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refreshing = nil
+	p.lastErr = err
+	close(done)
+	if err == nil {
+		p.graph, p.loaded, p.loadedAt = graph, true, time.Now()
+		return graph, nil
+	}
+	if p.loaded {
+		return p.graph, nil
+	}
+	return TopologyGraph{}, err
+}
+
+func (p *HTTPTopologyProvider) fetch(ctx context.Context) (TopologyGraph, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint, nil)
+	if err != nil {
+		return TopologyGraph{}, fmt.Errorf("無法建立拓撲請求: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.bearerToken)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return TopologyGraph{}, fmt.Errorf("呼叫拓撲 API 失敗: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return TopologyGraph{}, fmt.Errorf("拓撲 API 回應 %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxTopologyResponseBytes+1))
+	if err != nil {
+		return TopologyGraph{}, fmt.Errorf("讀取拓撲 API 回應失敗: %w", err)
+	}
+	if len(raw) > maxTopologyResponseBytes {
+		return TopologyGraph{}, fmt.Errorf("拓撲 API 回應超過 %d 位元組上限", maxTopologyResponseBytes)
+	}
+	var graph TopologyGraph
+	if err := json.Unmarshal(raw, &graph); err != nil {
+		return TopologyGraph{}, fmt.Errorf("拓撲 API 回應格式錯誤: %w", err)
+	}
+	return graph, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName         = "github.com/detectviz/sre-platform/backend/ai-engine"
+	defaultServiceName = "ai-engine"
+)
+
+// 支援的追蹤匯出方式。
+const (
+	TracingExporterNone   = "none"
+	TracingExporterOTLP   = "otlp"
+	TracingExporterStdout = "stdout"
+)
+
+// ErrUnsupportedTracingExporter 代表設定了不支援的追蹤匯出方式。
+var ErrUnsupportedTracingExporter = errors.New("unsupported tracing exporter")
+
+// TracingConfig 設定 OpenTelemetry 追蹤。
+type TracingConfig struct {
+	// Exporter 為 none、otlp 或 stdout。
+	Exporter string
+	// Endpoint 為 OTLP/HTTP collector 位址 (例如 localhost:4318)，未設定時使用 OTEL_EXPORTER_OTLP_* 環境變數。
+	Endpoint string
+	// Insecure 為 true 時以 HTTP 連線 collector。
+	Insecure bool
+	// SampleRatio 為取樣比例 (0 至 1)。
+	SampleRatio float64
+	ServiceName string
+}
+
+// InitTracing 設定全域 TracerProvider 與 W3C trace context 傳遞，回傳關閉時需呼叫的函式。
+func InitTracing(ctx context.Context, cfg TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(tracePropagator())
+
+	var exporter sdktrace.SpanExporter
+	switch strings.ToLower(cfg.Exporter) {
+	case "", TracingExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case TracingExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		otlpExporter, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("無法建立 OTLP 匯出器: %w", err)
+		}
+		exporter = otlpExporter
+	case TracingExporterStdout:
+		stdoutExporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, fmt.Errorf("無法建立 stdout 匯出器: %w", err)
+		}
+		exporter = stdoutExporter
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedTracingExporter, cfg.Exporter)
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("無法建立追蹤資源: %w", err)
+	}
+
+	ratio := cfg.SampleRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// tracePropagator 回傳 W3C trace context 與 baggage 傳遞格式。
+func tracePropagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// otelTracer 回傳服務使用的 tracer；每次呼叫取得全域 provider，測試可替換。
+func otelTracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// startSpan 以服務的 tracer 建立 span。
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otelTracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan 結束 span 並在發生錯誤時記錄錯誤狀態。
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceRepo 為一次 ReportRepository 呼叫建立 span。
+func traceRepo[T any](ctx context.Context, operation, reportID string, call func() (T, error)) (T, error) {
+	attrs := []attribute.KeyValue{attribute.String("db.operation", operation)}
+	if reportID != "" {
+		attrs = append(attrs, attribute.String("ai_engine.report_id", reportID))
+	}
+	_, span := startSpan(ctx, "ReportRepository."+operation, attrs...)
+	result, err := call()
+	// 找不到報告屬於預期結果，不標記為錯誤。
+	if errors.Is(err, ErrReportNotFound) {
+		span.End()
+		return result, err
+	}
+	endSpan(span, err)
+	return result, err
+}
+
+// traceIDFromContext 回傳 context 中有效的 trace ID，未追蹤時為空字串。
+func traceIDFromContext(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
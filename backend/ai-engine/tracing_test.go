@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// useSpanRecorder 以記錄用的 TracerProvider 取代全域設定，測試結束後還原。
+func useSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		_ = provider.Shutdown(context.Background())
+	})
+	return recorder
+}
+
+func TestTracingSpansRequestQueueAndGenerator(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := useSpanRecorder(t)
+
+	repo := NewInMemoryReportRepository()
+	service := NewAnalysisService(repo, &stubGenerator{result: &GeneratedReport{EventSummary: "完成"}}, AnalysisServiceConfig{ProcessingTimeout: time.Second})
+	router := SetupRouter(service)
+
+	// 上游以 W3C traceparent 傳入的 trace 應延續至背景分析。
+	const upstreamTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/events/evt-trace/ai-analysis", nil)
+	req.Header.Set("traceparent", "00-"+upstreamTraceID+"-00f067aa0ba902b7-01")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusAccepted {
+		t.Fatalf("預期 202，實際為 %d", resp.Code)
+	}
+	service.Wait()
+
+	report, err := repo.GetLatestByEvent("evt-trace")
+	if err != nil {
+		t.Fatalf("讀取報告失敗: %v", err)
+	}
+	if report.TraceID != upstreamTraceID {
+		t.Fatalf("報告應記錄上游 trace ID，實際為 %q", report.TraceID)
+	}
+
+	seen := make(map[string]int)
+	for _, span := range recorder.Ended() {
+		seen[span.Name()]++
+		if got := span.SpanContext().TraceID().String(); got != upstreamTraceID {
+			t.Fatalf("span %s 的 trace ID 應為 %s，實際為 %s", span.Name(), upstreamTraceID, got)
+		}
+	}
+	for _, name := range []string{
+		"POST /api/v1/events/:eventId/ai-analysis",
+		"analysisHandler.createAnalysisReport",
+		"AnalysisService.CreateReport",
+		"ReportRepository.Create",
+		"AnalysisService.queueWait",
+		"AnalysisService.runAnalysis",
+		"ReportGenerator.Generate",
+	} {
+		if seen[name] != 1 {
+			t.Fatalf("應有一個 %s span，實際為 %d (%v)", name, seen[name], seen)
+		}
+	}
+	// RUNNING 與 SUCCESS 兩次更新。
+	if seen["ReportRepository.Update"] != 2 {
+		t.Fatalf("應有兩個 ReportRepository.Update span，實際為 %d", seen["ReportRepository.Update"])
+	}
+}
+
+func TestTracingWithoutIncomingTraceStartsNewTrace(t *testing.T) {
+	recorder := useSpanRecorder(t)
+
+	service := NewAnalysisService(NewInMemoryReportRepository(), &stubGenerator{result: &GeneratedReport{}}, AnalysisServiceConfig{ProcessingTimeout: time.Second})
+	report, err := service.CreateReport(context.Background(), "evt-trace-root", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	service.Wait()
+
+	if report.TraceID == "" {
+		t.Fatalf("報告應記錄新建立的 trace ID")
+	}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() != report.TraceID {
+			t.Fatalf("span %s 未延續報告的 trace", span.Name())
+		}
+	}
+	if _, err := service.GetReport(context.Background(), "missing"); err == nil {
+		t.Fatalf("查詢不存在的報告應回傳錯誤")
+	}
+	last := recorder.Ended()[len(recorder.Ended())-1]
+	if last.Name() != "ReportRepository.Get" || last.Status().Code != codes.Unset {
+		t.Fatalf("找不到報告不應標記為 span 錯誤，實際為 %s %v", last.Name(), last.Status())
+	}
+}
+
+func TestInitTracingRejectsUnknownExporter(t *testing.T) {
+	if _, err := InitTracing(context.Background(), TracingConfig{Exporter: "jaeger"}); err == nil {
+		t.Fatalf("不支援的匯出方式應回傳錯誤")
+	}
+	shutdown, err := InitTracing(context.Background(), TracingConfig{})
+	if err != nil || shutdown(context.Background()) != nil {
+		t.Fatalf("未設定匯出方式時應停用追蹤: %v", err)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// UsageCurrency 為成本統計使用的幣別，價格表亦以此幣別填寫。
+	UsageCurrency = "USD"
+	// defaultUsageWindow 為未指定查詢區間時回溯的天數。
+	defaultUsageWindow = 30 * 24 * time.Hour
+	// maxUsageWindow 限制單次彙總的區間，避免一次載入過多報告。
+	maxUsageWindow = 366 * 24 * time.Hour
+	// usageUnknownKey 為缺少團隊或模型時的分組名稱。
+	usageUnknownKey = "unknown"
+)
+
+// 非 LLM 產生器的 provider 名稱。
+const usageProviderTemplate = "template"
+
+// ErrInvalidUsageQuery 代表成本彙總的查詢參數無效。
+var ErrInvalidUsageQuery = errors.New("invalid usage query")
+
+// LLMUsage 記錄單份報告產生時的模型、token 用量、耗時與成本。
+type LLMUsage struct {
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	LatencyMS        int64   `json:"latency_ms"`
+	CostUSD          float64 `json:"cost_usd"`
+	// Priced 為 false 代表價格表中沒有此模型，CostUSD 未計入。
+	Priced bool `json:"priced"`
+}
+
+// ModelPrice 為模型每百萬 token 的價格 (USD)。
+type ModelPrice struct {
+	PromptPerMillion     float64 `json:"prompt_per_1m_tokens"`
+	CompletionPerMillion float64 `json:"completion_per_1m_tokens"`
+}
+
+// PriceTable 以模型名稱對應價格；查無完全相符時使用最長的前綴，
+// 讓 gpt-4o 可涵蓋 gpt-4o-2024-08-06 等帶日期的模型版本。
+type PriceTable map[string]ModelPrice
+
+// LoadPriceTable 讀取 JSON 格式的價格表，例如 {"gpt-4o": {"prompt_per_1m_tokens": 2.5, "completion_per_1m_tokens": 10}}。
+func LoadPriceTable(path string) (PriceTable, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("無法讀取價格表: %w", err)
+	}
+	var table PriceTable
+	if err := json.Unmarshal(raw, &table); err != nil {
+		return nil, fmt.Errorf("價格表格式錯誤: %w", err)
+	}
+	for model, price := range table {
+		if price.PromptPerMillion < 0 || price.CompletionPerMillion < 0 {
+			return nil, fmt.Errorf("價格表中 %s 的價格不可為負數", model)
+		}
+	}
+	return table, nil
+}
+
+// Lookup 回傳模型的價格，模型名稱不分大小寫。
+func (t PriceTable) Lookup(model string) (ModelPrice, bool) {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if model == "" {
+		return ModelPrice{}, false
+	}
+	var (
+		best    ModelPrice
+		bestLen int
+	)
+	for name, price := range t {
+		name = strings.ToLower(name)
+		if name == model {
+			return price, true
+		}
+		if strings.HasPrefix(model, name) && len(name) > bestLen {
+			best, bestLen = price, len(name)
+		}
+	}
+	return best, bestLen > 0
+}
+
+// apply 依價格表計算成本；模板產生器不呼叫 LLM，成本固定為 0。
+func (t PriceTable) apply(usage *LLMUsage) {
+	if usage.Provider == usageProviderTemplate {
+		usage.CostUSD, usage.Priced = 0, true
+		return
+	}
+	price, ok := t.Lookup(usage.Model)
+	if !ok {
+		usage.CostUSD, usage.Priced = 0, false
+		return
+	}
+	cost := float64(usage.PromptTokens)*price.PromptPerMillion/1e6 +
+		float64(usage.CompletionTokens)*price.CompletionPerMillion/1e6
+	usage.CostUSD, usage.Priced = roundCost(cost), true
+}
+
+// roundCost 將成本四捨五入至小數點後 6 位，避免浮點累加誤差出現在回應中。
+func roundCost(cost float64) float64 {
+	return math.Round(cost*1e6) / 1e6
+}
+
+// UsageRecord 為單次產生器呼叫的用量，成本彙總與預算依 BilledAt 歸入期間。
+type UsageRecord struct {
+	ReportID  string
+	Team      string
+	CreatedAt time.Time
+	// BilledAt 為該次呼叫的開始時間，重試或復原後的呼叫計入實際發生的期間。
+	BilledAt time.Time
+	Usage    LLMUsage
+}
+
+// usageRecords 將報告的用量依嘗試展開，僅回傳 BilledAt 介於 [from, to) 的紀錄。
+// 未記錄逐次用量的舊報告以建立時間記錄總用量。
+func usageRecords(report AnalysisReport, from, to time.Time) []UsageRecord {
+	var records []UsageRecord
+	add := func(billedAt time.Time, usage LLMUsage) {
+		if billedAt.Before(from) || !billedAt.Before(to) {
+			return
+		}
+		records = append(records, UsageRecord{
+			ReportID:  report.ReportID,
+			Team:      report.Team,
+			CreatedAt: report.CreatedAt.UTC(),
+			BilledAt:  billedAt.UTC(),
+			Usage:     usage,
+		})
+	}
+	perAttempt := false
+	for _, attempt := range report.Attempts {
+		if attempt.Usage != nil {
+			perAttempt = true
+			add(attempt.StartedAt, *attempt.Usage)
+		}
+	}
+	if !perAttempt && report.Usage != nil {
+		add(report.CreatedAt, *report.Usage)
+	}
+	return records
+}
+
+// UsageQuery 描述成本彙總的區間 (含 From、不含 To) 與團隊篩選。
+type UsageQuery struct {
+	From time.Time
+	To   time.Time
+	Team string
+	// Teams 不為 nil 時僅彙總這些團隊，用於將非超級管理員限定在所屬團隊。
+	Teams []string
+}
+
+// Normalize 套用預設區間 (最近 30 天) 並驗證區間長度。
+func (q UsageQuery) Normalize(now time.Time) (UsageQuery, error) {
+	if q.To.IsZero() {
+		q.To = now
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-defaultUsageWindow)
+	}
+	q.From, q.To = q.From.UTC(), q.To.UTC()
+	if !q.From.Before(q.To) || q.To.Sub(q.From) > maxUsageWindow {
+		return q, ErrInvalidUsageQuery
+	}
+	q.Team = strings.TrimSpace(q.Team)
+	return q, nil
+}
+
+// UsageTotals 為一組報告的用量與成本合計。
+type UsageTotals struct {
+	Reports          int     `json:"reports"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	// UnpricedReports 為模型不在價格表中、成本未計入的報告數。
+	UnpricedReports int `json:"unpriced_reports,omitempty"`
+
+	// reports 與 unpriced 記錄已計入的報告，同一報告的多次呼叫只計為一份報告。
+	reports  map[string]bool
+	unpriced map[string]bool
+}
+
+func (t *UsageTotals) add(record UsageRecord) {
+	if t.reports == nil {
+		t.reports, t.unpriced = make(map[string]bool), make(map[string]bool)
+	}
+	if !t.reports[record.ReportID] {
+		t.reports[record.ReportID] = true
+		t.Reports++
+	}
+	usage := record.Usage
+	t.PromptTokens += int64(usage.PromptTokens)
+	t.CompletionTokens += int64(usage.CompletionTokens)
+	t.TotalTokens += int64(usage.TotalTokens)
+	t.CostUSD = roundCost(t.CostUSD + usage.CostUSD)
+	if !usage.Priced && !t.unpriced[record.ReportID] {
+		t.unpriced[record.ReportID] = true
+		t.UnpricedReports++
+	}
+}
+
+// UsageBucket 為單一分組 (日期、團隊或模型) 的合計。
+type UsageBucket struct {
+	Key string `json:"key"`
+	UsageTotals
+}
+
+// UsageSummary 為成本彙總回應，依日 (UTC)、團隊與模型分組。
+type UsageSummary struct {
+	From     time.Time     `json:"from"`
+	To       time.Time     `json:"to"`
+	Team     string        `json:"team,omitempty"`
+	Currency string        `json:"currency"`
+	Total    UsageTotals   `json:"total"`
+	ByDay    []UsageBucket `json:"by_day"`
+	ByTeam   []UsageBucket `json:"by_team"`
+	ByModel  []UsageBucket `json:"by_model"`
+}
+
+// summarizeUsage 彙總用量紀錄；日期遞增排列，團隊與模型依成本遞減排列。
+func summarizeUsage(query UsageQuery, records []UsageRecord) UsageSummary {
+	summary := UsageSummary{From: query.From, To: query.To, Team: query.Team, Currency: UsageCurrency}
+	byDay := map[string]*UsageTotals{}
+	byTeam := map[string]*UsageTotals{}
+	byModel := map[string]*UsageTotals{}
+	for _, record := range records {
+		if query.Team != "" && record.Team != query.Team {
+			continue
+		}
+		if query.Teams != nil && !slices.Contains(query.Teams, record.Team) {
+			continue
+		}
+		summary.Total.add(record)
+		usageBucketFor(byDay, record.BilledAt.UTC().Format(time.DateOnly)).add(record)
+		usageBucketFor(byTeam, record.Team).add(record)
+		usageBucketFor(byModel, record.Usage.Model).add(record)
+	}
+
+	summary.ByDay = usageBuckets(byDay, func(a, b UsageBucket) bool { return a.Key < b.Key })
+	byCost := func(a, b UsageBucket) bool {
+		if a.CostUSD != b.CostUSD {
+			return a.CostUSD > b.CostUSD
+		}
+		return a.Key < b.Key
+	}
+	summary.ByTeam = usageBuckets(byTeam, byCost)
+	summary.ByModel = usageBuckets(byModel, byCost)
+	return summary
+}
+
+func usageBucketFor(buckets map[string]*UsageTotals, key string) *UsageTotals {
+	if key == "" {
+		key = usageUnknownKey
+	}
+	totals, ok := buckets[key]
+	if !ok {
+		totals = &UsageTotals{}
+		buckets[key] = totals
+	}
+	return totals
+}
+
+func usageBuckets(buckets map[string]*UsageTotals, less func(a, b UsageBucket) bool) []UsageBucket {
+	result := make([]UsageBucket, 0, len(buckets))
+	for key, totals := range buckets {
+		result = append(result, UsageBucket{Key: key, UsageTotals: *totals})
+	}
+	sort.Slice(result, func(i, j int) bool { return less(result[i], result[j]) })
+	return result
+}
+
+// UsageError 包裝已消耗 LLM 用量的產生失敗 (例如回應無法解析)，讓失敗的嘗試仍計入成本。
+type UsageError struct {
+	Usage LLMUsage
+	Err   error
+}
+
+func (e *UsageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
+// attemptUsage 回傳單次產生器呼叫的用量並更新 token 與成本指標；成功時取自結果，
+// 失敗時取自 UsageError，產生器未回報用量 (例如逾時) 時僅記錄耗時。
+func (s *AnalysisService) attemptUsage(result *GeneratedReport, err error, elapsed time.Duration) *LLMUsage {
+	var usage LLMUsage
+	var usageErr *UsageError
+	switch {
+	case err == nil && result != nil && result.Usage != nil:
+		usage = *result.Usage
+	case errors.As(err, &usageErr):
+		usage = usageErr.Usage
+	}
+	usage.LatencyMS = elapsed.Milliseconds()
+	if usage.Provider != "" || usage.Model != "" {
+		s.prices.apply(&usage)
+		s.metrics.observeUsage(usage)
+	}
+	return &usage
+}
+
+// totalUsage 加總所有嘗試的用量，模型與提供者取自最後一次回報模型的嘗試；
+// 未回報模型的嘗試只計入耗時，也不影響 Priced。
+func totalUsage(attempts []AnalysisAttempt) *LLMUsage {
+	var total *LLMUsage
+	modeled := false
+	for _, attempt := range attempts {
+		if attempt.Usage == nil {
+			continue
+		}
+		if total == nil {
+			total = &LLMUsage{}
+		}
+		usage := attempt.Usage
+		total.PromptTokens += usage.PromptTokens
+		total.CompletionTokens += usage.CompletionTokens
+		total.TotalTokens += usage.TotalTokens
+		total.LatencyMS += usage.LatencyMS
+		total.CostUSD = roundCost(total.CostUSD + usage.CostUSD)
+		if usage.Provider != "" || usage.Model != "" {
+			total.Provider, total.Model = usage.Provider, usage.Model
+			total.Priced = usage.Priced && (total.Priced || !modeled)
+			modeled = true
+		}
+	}
+	return total
+}
+
+// SummarizeUsage 彙總區間內開始的產生器呼叫 (含失敗與重試) 的 LLM 用量與成本。
+func (s *AnalysisService) SummarizeUsage(query UsageQuery) (UsageSummary, error) {
+	query, err := query.Normalize(time.Now().UTC())
+	if err != nil {
+		return UsageSummary{}, err
+	}
+	records, err := s.repo.ListUsage(query.From, query.To)
+	if err != nil {
+		return UsageSummary{}, err
+	}
+	return summarizeUsage(query, records), nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPriceTableLookupAndCost(t *testing.T) {
+	prices, err := LoadPriceTable("data/llm_prices.json")
+	if err != nil {
+		t.Fatalf("載入價格表失敗: %v", err)
+	}
+
+	// 帶日期的模型版本以最長前綴對應，gpt-4o-mini 不應被 gpt-4o 覆蓋。
+	tests := []struct {
+		model string
+		want  ModelPrice
+		found bool
+	}{
+		{model: "gpt-4o-2024-08-06", want: prices["gpt-4o"], found: true},
+		{model: "GPT-4o-mini-2024-07-18", want: prices["gpt-4o-mini"], found: true},
+		{model: "claude-unknown", found: false},
+		{model: "", found: false},
+	}
+	for _, tc := range tests {
+		got, ok := prices.Lookup(tc.model)
+		if ok != tc.found || got != tc.want {
+			t.Fatalf("%s: 預期 %+v (%v)，實際為 %+v (%v)", tc.model, tc.want, tc.found, got, ok)
+		}
+	}
+
+	usage := LLMUsage{Provider: "openai", Model: "gpt-4o", PromptTokens: 1200, CompletionTokens: 300}
+	prices.apply(&usage)
+	// 1200 * 2.5 / 1e6 + 300 * 10 / 1e6 = 0.006
+	if !usage.Priced || usage.CostUSD != 0.006 {
+		t.Fatalf("成本計算錯誤: %+v", usage)
+	}
+	usage = LLMUsage{Provider: "openai", Model: "unlisted", PromptTokens: 1000}
+	prices.apply(&usage)
+	if usage.Priced || usage.CostUSD != 0 {
+		t.Fatalf("未列出的模型不應計成本: %+v", usage)
+	}
+}
+
+func TestAnalysisUsageAccounting(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		content := `{"event_summary":"快取失效","root_cause_analysis":{"text":"Redis 記憶體不足","confidence_score":0.6}}`
+		var body map[string]any
+		_ = json.Unmarshal(newChatCompletionBody(t, content), &body)
+		body["model"] = "gpt-4o-2024-08-06"
+		body["usage"] = map[string]int{"prompt_tokens": 2000, "completion_tokens": 500, "total_tokens": 2500}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	defer llm.Close()
+
+	generator, err := NewOpenAIReportGenerator(OpenAIGeneratorConfig{BaseURL: llm.URL, Model: "gpt-4o", Provider: "azure-openai"})
+	if err != nil {
+		t.Fatalf("建立產生器失敗: %v", err)
+	}
+	auth, err := NewAuthenticator(AuthConfig{HS256Secret: testJWTSecret})
+	if err != nil {
+		t.Fatalf("建立驗證器失敗: %v", err)
+	}
+	repo := newTestSQLRepository(t)
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		Auth:   auth,
+		Prices: PriceTable{"gpt-4o": {PromptPerMillion: 2.5, CompletionPerMillion: 10}},
+	})
+	router := SetupRouter(service)
+
+	for _, member := range []struct{ user, team, eventID string }{
+		{"alice", "sre", "evt-cost-1"},
+		{"bob", "sre", "evt-cost-2"},
+		{"carol", "payments", "evt-cost-3"},
+	} {
+		claims := userClaims(member.user, RoleTeamMember)
+		claims["teams"] = []string{member.team}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, authedRequest(http.MethodPost, "/api/v1/events/"+member.eventID+"/ai-analysis", signHS256(t, claims), nil))
+		if resp.Code != http.StatusAccepted {
+			t.Fatalf("建立報告應回傳 202，實際為 %d", resp.Code)
+		}
+	}
+	service.Wait()
+
+	report, err := repo.GetLatestByEvent("evt-cost-1")
+	if err != nil {
+		t.Fatalf("讀取報告失敗: %v", err)
+	}
+	usage := report.Usage
+	if report.Team != "sre" || usage == nil {
+		t.Fatalf("報告應記錄團隊與用量: %+v", report)
+	}
+	if usage.Provider != "azure-openai" || usage.Model != "gpt-4o-2024-08-06" || usage.PromptTokens != 2000 ||
+		usage.CompletionTokens != 500 || usage.TotalTokens != 2500 || usage.CostUSD != 0.01 || !usage.Priced {
+		t.Fatalf("用量記錄錯誤: %+v", usage)
+	}
+
+	adminToken := signHS256(t, userClaims("erin", RoleSuperAdmin))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, authedRequest(http.MethodGet, "/api/v1/analysis/ai-usage", adminToken, nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("成本彙總應回傳 200，實際為 %d: %s", resp.Code, resp.Body.String())
+	}
+	var summary UsageSummary
+	if err := json.Unmarshal(resp.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("無法解析回應: %v", err)
+	}
+	if summary.Total.Reports != 3 || summary.Total.TotalTokens != 7500 || summary.Total.CostUSD != 0.03 || summary.Currency != UsageCurrency {
+		t.Fatalf("總計錯誤: %+v", summary.Total)
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	if len(summary.ByDay) != 1 || summary.ByDay[0].Key != today {
+		t.Fatalf("每日彙總錯誤: %+v", summary.ByDay)
+	}
+	if len(summary.ByTeam) != 2 || summary.ByTeam[0].Key != "sre" || summary.ByTeam[0].Reports != 2 || summary.ByTeam[0].CostUSD != 0.02 {
+		t.Fatalf("團隊彙總錯誤: %+v", summary.ByTeam)
+	}
+	if len(summary.ByModel) != 1 || summary.ByModel[0].Key != "gpt-4o-2024-08-06" {
+		t.Fatalf("模型彙總錯誤: %+v", summary.ByModel)
+	}
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, authedRequest(http.MethodGet, "/api/v1/analysis/ai-usage?team=payments&from="+today+"&to="+today, adminToken, nil))
+	_ = json.Unmarshal(resp.Body.Bytes(), &summary)
+	if resp.Code != http.StatusOK || summary.Total.Reports != 1 || len(summary.ByTeam) != 1 {
+		t.Fatalf("團隊篩選錯誤: %d %+v", resp.Code, summary)
+	}
+
+	// 團隊管理者僅能查看所屬團隊，指定其他團隊時回傳 403。
+	managerClaims := userClaims("dave", RoleTeamManager)
+	managerClaims["teams"] = []string{"payments"}
+	managerToken := signHS256(t, managerClaims)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, authedRequest(http.MethodGet, "/api/v1/analysis/ai-usage", managerToken, nil))
+	summary = UsageSummary{}
+	_ = json.Unmarshal(resp.Body.Bytes(), &summary)
+	if resp.Code != http.StatusOK || summary.Total.Reports != 1 || len(summary.ByTeam) != 1 || summary.ByTeam[0].Key != "payments" {
+		t.Fatalf("團隊管理者應僅看到所屬團隊: %d %+v", resp.Code, summary)
+	}
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, authedRequest(http.MethodGet, "/api/v1/analysis/ai-usage?team=sre", managerToken, nil))
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("查看其他團隊的用量應回傳 403，實際為 %d", resp.Code)
+	}
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, authedRequest(http.MethodGet, "/api/v1/analysis/ai-usage", signHS256(t, userClaims("frank", RoleTeamManager)), nil))
+	summary = UsageSummary{}
+	_ = json.Unmarshal(resp.Body.Bytes(), &summary)
+	if resp.Code != http.StatusOK || summary.Total.Reports != 0 {
+		t.Fatalf("未屬於任何團隊的管理者不應看到用量: %d %+v", resp.Code, summary)
+	}
+
+	// 一般成員不可查看所有團隊的成本，區間無效時回傳 400。
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, authedRequest(http.MethodGet, "/api/v1/analysis/ai-usage", signHS256(t, userClaims("alice", RoleTeamMember)), nil))
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("團隊成員應回傳 403，實際為 %d", resp.Code)
+	}
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, authedRequest(http.MethodGet, "/api/v1/analysis/ai-usage?from=2024-01-01&to=2023-01-01", managerToken, nil))
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("區間顛倒應回傳 400，實際為 %d", resp.Code)
+	}
+}
+
+func TestTemplateGeneratorUsageHasNoCost(t *testing.T) {
+	repo := NewInMemoryReportRepository()
+	service := NewAnalysisService(repo, newTestTemplateGenerator(0), AnalysisServiceConfig{})
+	report, err := service.CreateReport(context.Background(), "evt-template-usage", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	service.Wait()
+
+	stored, _ := repo.Get(report.ReportID)
+	if stored.Usage == nil || stored.Usage.Provider != usageProviderTemplate || stored.Usage.CostUSD != 0 || !stored.Usage.Priced {
+		t.Fatalf("模板產生器應記錄零成本用量: %+v", stored.Usage)
+	}
+	records, err := repo.ListUsage(time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	if err != nil || len(records) != 1 || records[0].Team != "" {
+		t.Fatalf("用量紀錄錯誤: %+v (%v)", records, err)
+	}
+}
+
+func TestAnalysisUsageIncludesFailedAttempts(t *testing.T) {
+	var calls atomic.Int32
+	var failing atomic.Bool
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// 第一次回應內容無法解析，但 token 仍已計費。
+		content := `{"event_summary":"快取失效"}`
+		if calls.Add(1) == 1 || failing.Load() {
+			content = "not json"
+		}
+		var body map[string]any
+		_ = json.Unmarshal(newChatCompletionBody(t, content), &body)
+		body["usage"] = map[string]int{"prompt_tokens": 1000, "completion_tokens": 200}
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	defer llm.Close()
+
+	generator, err := NewOpenAIReportGenerator(OpenAIGeneratorConfig{BaseURL: llm.URL, Model: "gpt-4o"})
+	if err != nil {
+		t.Fatalf("建立產生器失敗: %v", err)
+	}
+	repo := NewInMemoryReportRepository()
+	service := NewAnalysisService(repo, generator, AnalysisServiceConfig{
+		Retry:  fastRetryPolicy(2),
+		Prices: PriceTable{"gpt-4o": {PromptPerMillion: 2.5, CompletionPerMillion: 10}},
+	})
+
+	report, err := service.CreateReport(context.Background(), "evt-retry-usage", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	service.Wait()
+	stored, _ := repo.Get(report.ReportID)
+	if stored.Status != ReportStatusSuccess || len(stored.Attempts) != 2 {
+		t.Fatalf("應於重試後成功: %+v", stored)
+	}
+	if usage := stored.Attempts[0].Usage; usage == nil || usage.PromptTokens != 1000 || usage.CostUSD != 0.0045 || usage.Model != "gpt-4o" {
+		t.Fatalf("失敗的嘗試應記錄用量: %+v", usage)
+	}
+	// 兩次嘗試各 1000 + 200 token，每次 0.0045 USD。
+	if usage := stored.Usage; usage == nil || usage.PromptTokens != 2000 || usage.CompletionTokens != 400 || usage.TotalTokens != 2400 || usage.CostUSD != 0.009 || !usage.Priced {
+		t.Fatalf("報告用量應為所有嘗試的總和: %+v", usage)
+	}
+
+	failing.Store(true)
+	failed, err := service.CreateReport(context.Background(), "evt-failed-usage", CreateAnalysisRequest{})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	service.Wait()
+	stored, _ = repo.Get(failed.ReportID)
+	if stored.Status != ReportStatusFailed || stored.Usage == nil || stored.Usage.TotalTokens != 2400 || stored.Usage.CostUSD != 0.009 {
+		t.Fatalf("失敗的報告也應記錄用量: %+v", stored.Usage)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultWebhookTimeout = 10 * time.Second
+	// maxCallbackURLs 為單一請求可註冊的回呼網址上限。
+	maxCallbackURLs = 5
+
+	// WebhookSignatureHeader 為「時間戳記.請求內容」的 HMAC-SHA256 簽章，格式為 sha256=<hex>。
+	WebhookSignatureHeader = "X-AI-Engine-Signature-256"
+	// WebhookTimestampHeader 為簽章時的 Unix 秒數，接收端應拒絕過舊的請求以防重送攻擊。
+	WebhookTimestampHeader = "X-AI-Engine-Timestamp"
+	// WebhookEventHeader 為通知事件類型。
+	WebhookEventHeader = "X-AI-Engine-Event"
+	// WebhookDeliveryHeader 為每次通知的唯一編號，重試時維持不變供接收端去重。
+	WebhookDeliveryHeader = "X-AI-Engine-Delivery"
+
+	webhookEventCompleted = "analysis.completed"
+)
+
+var (
+	// ErrInvalidCallbackURL 代表回呼網址格式錯誤、數量超過上限或指向內部網路。
+	ErrInvalidCallbackURL = errors.New("invalid callback url")
+	// errCallbackAddressBlocked 代表連線時解析出的位址屬於禁止的網段。
+	errCallbackAddressBlocked = errors.New("callback address is not allowed")
+)
+
+// blockedCallbackPrefixes 為 net/netip 分類以外、同樣不應自外部請求連入的保留網段。
+var blockedCallbackPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+// WebhookConfig 設定分析完成時的回呼通知。
+type WebhookConfig struct {
+	// URLs 為所有報告皆會通知的全域回呼網址。
+	URLs []string
+	// Secret 用於計算 HMAC-SHA256 簽章，未設定時不附加簽章與時間戳記標頭。
+	Secret string
+	// AllowedHosts 為請求註冊的回呼網址可連往內部網路的主機名稱、IP 或 CIDR。
+	// 全域回呼網址由維運設定不受限制，但僅限該網址本身，同一主機的其他路徑或埠仍需列入此清單。
+	AllowedHosts []string
+	// Retry 設定傳送失敗時的重試次數與退避時間。
+	Retry RetryPolicy
+	// Timeout 為單次傳送逾時，僅在未提供 HTTPClient 時套用。
+	Timeout time.Duration
+	// HTTPClient 為自訂的傳送用 client，提供時不套用連線位址檢查。
+	HTTPClient *http.Client
+}
+
+// WebhookDelivery 記錄一次回呼傳送的結果。
+type WebhookDelivery struct {
+	DeliveryID  string    `json:"delivery_id"`
+	URL         string    `json:"url"`
+	Attempt     int       `json:"attempt"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Success     bool      `json:"success"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+// validateCallbackURLs 驗證並去除重複的回呼網址，僅接受 http 與 https。
+func validateCallbackURLs(urls []string) ([]string, error) {
+	var cleaned []string
+	seen := make(map[string]struct{}, len(urls))
+	for _, raw := range urls {
+		raw = strings.TrimSpace(raw)
+		parsed, err := url.Parse(raw)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCallbackURL, raw)
+		}
+		if _, ok := seen[raw]; ok {
+			continue
+		}
+		seen[raw] = struct{}{}
+		cleaned = append(cleaned, raw)
+	}
+	return cleaned, nil
+}
+
+// signWebhookPayload 以共享密鑰計算「時間戳記.請求內容」的簽章，時間戳記納入簽章避免請求被重送。
+func signWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// callbackGuard 阻擋請求註冊的回呼網址連往迴路、私有、鏈路本地與未指定位址，避免 SSRF。
+type callbackGuard struct {
+	hosts    map[string]bool
+	prefixes []netip.Prefix
+	// globalURLs 為維運設定的全域回呼網址，globalAddrs 為其 host:port，供連線時放行。
+	globalURLs  map[string]bool
+	globalAddrs map[string]bool
+	resolver    *net.Resolver
+}
+
+func newCallbackGuard(allowed []string, globalURLs []string) *callbackGuard {
+	guard := &callbackGuard{
+		hosts:       make(map[string]bool),
+		globalURLs:  make(map[string]bool),
+		globalAddrs: make(map[string]bool),
+		resolver:    net.DefaultResolver,
+	}
+	for _, entry := range allowed {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			guard.prefixes = append(guard.prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			guard.prefixes = append(guard.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		} else if entry != "" {
+			guard.hosts[entry] = true
+		}
+	}
+	for _, raw := range globalURLs {
+		if parsed, err := url.Parse(raw); err == nil {
+			guard.globalURLs[raw] = true
+			guard.globalAddrs[callbackDialAddress(parsed)] = true
+		}
+	}
+	return guard
+}
+
+// callbackDialAddress 回傳 HTTP client 連線時使用的 host:port，未指定埠時依 scheme 補上預設埠。
+func callbackDialAddress(target *url.URL) string {
+	port := target.Port()
+	if port == "" {
+		port = "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(target.Hostname()), port)
+}
+
+// allowsHost 判斷主機是否由維運明確允許，允許的主機不檢查解析後的位址。
+func (g *callbackGuard) allowsHost(host string) bool {
+	return g.hosts[strings.ToLower(strings.TrimSuffix(host, "."))]
+}
+
+// allowsAddr 判斷位址是否可連線：公開位址，或位於允許的 CIDR 內。
+func (g *callbackGuard) allowsAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range g.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range blockedCallbackPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateCallbackURLs 驗證請求註冊的回呼網址，主機解析出的任一位址被阻擋即拒絕。
+// 連線時會再次檢查實際位址，避免 DNS rebinding 繞過此處的檢查。
+func (g *callbackGuard) validateCallbackURLs(ctx context.Context, urls []string) ([]string, error) {
+	cleaned, err := validateCallbackURLs(urls)
+	if err != nil {
+		return nil, err
+	}
+	for _, raw := range cleaned {
+		parsed, _ := url.Parse(raw)
+		host := parsed.Hostname()
+		if g.globalURLs[raw] || g.allowsHost(host) {
+			continue
+		}
+		addrs, err := g.lookup(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 無法解析 %s", ErrInvalidCallbackURL, host)
+		}
+		for _, addr := range addrs {
+			if !g.allowsAddr(addr) {
+				return nil, fmt.Errorf("%w: %s 指向內部網路位址 %s", ErrInvalidCallbackURL, raw, addr)
+			}
+		}
+	}
+	return cleaned, nil
+}
+
+func (g *callbackGuard) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr}, nil
+	}
+	return g.resolver.LookupNetIP(ctx, "ip", host)
+}
+
+// dialContext 回傳檢查實際連線位址的 DialContext；允許的主機與全域回呼網址的 host:port 直接連線。
+// 請求註冊的網址已於建立時排除全域網址以外的路徑，因此放行 host:port 不會擴大可連線的目標。
+func (g *callbackGuard) dialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	guarded := *dialer
+	guarded.Control = func(_, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		addr, err := netip.ParseAddr(host)
+		if err != nil || !g.allowsAddr(addr) {
+			return fmt.Errorf("%w: %s", errCallbackAddressBlocked, address)
+		}
+		return nil
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if g.globalAddrs[strings.ToLower(address)] {
+			return dialer.DialContext(ctx, network, address)
+		}
+		if host, _, err := net.SplitHostPort(address); err == nil && g.allowsHost(host) {
+			return dialer.DialContext(ctx, network, address)
+		}
+		return guarded.DialContext(ctx, network, address)
+	}
+}
+
+// webhookNotifier 於背景傳送回呼並透過 record 記錄每次傳送結果。
+type webhookNotifier struct {
+	urls   []string
+	secret string
+	retry  RetryPolicy
+	client *http.Client
+	guard  *callbackGuard
+	logger *slog.Logger
+	record func(reportID string, delivery WebhookDelivery)
+
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newWebhookNotifier(cfg WebhookConfig, logger *slog.Logger, record func(string, WebhookDelivery)) *webhookNotifier {
+	guard := newCallbackGuard(cfg.AllowedHosts, cfg.URLs)
+	client := cfg.HTTPClient
+	if client == nil {
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = defaultWebhookTimeout
+		}
+		// 回呼直接連線 (不經 proxy)，連線位址檢查才會作用於實際的目的地。
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		transport.DialContext = guard.dialContext(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second})
+		client = &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			// 重新導向可能指向內部網路，一律視為回應結果而不跟隨。
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &webhookNotifier{
+		urls:   cfg.URLs,
+		secret: cfg.Secret,
+		retry:  cfg.Retry.withDefaults(),
+		client: client,
+		guard:  guard,
+		logger: logger,
+		record: record,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// notify 將最終報告傳送至全域與報告註冊的回呼網址。
+func (n *webhookNotifier) notify(report AnalysisReport) {
+	targets := mergeCallbackURLs(n.urls, report.CallbackURLs)
+	if len(targets) == 0 {
+		return
+	}
+	logger := reportLogger(n.logger, report.ReportID, report.EventID).With(slog.String(logKeyStatus, string(report.Status)))
+	// 回呼網址與傳送紀錄不序列化 (json:"-")，接收端只會收到報告內容。
+	body, err := json.Marshal(report)
+	if err != nil {
+		logger.Error("無法序列化回呼內容", slog.String(logKeyError, err.Error()))
+		return
+	}
+	for _, target := range targets {
+		n.wg.Add(1)
+		go func(target string) {
+			defer n.wg.Done()
+			n.deliver(logger, report.ReportID, target, body)
+		}(target)
+	}
+}
+
+// deliver 傳送單一回呼，暫時性失敗依重試策略以指數退避重試。
+func (n *webhookNotifier) deliver(logger *slog.Logger, reportID, target string, body []byte) {
+	deliveryID := uuid.NewString()
+	started := time.Now()
+	for attempt := 1; ; attempt++ {
+		delivery := WebhookDelivery{
+			DeliveryID:  deliveryID,
+			URL:         target,
+			Attempt:     attempt,
+			AttemptedAt: time.Now().UTC(),
+		}
+		statusCode, err := n.post(target, deliveryID, body)
+		delivery.StatusCode = statusCode
+		if err == nil {
+			delivery.Success = true
+			n.record(reportID, delivery)
+			return
+		}
+		delivery.Error = err.Error()
+		n.record(reportID, delivery)
+
+		if n.ctx.Err() != nil || !isRetryableWebhookError(statusCode, err) || attempt >= n.retry.MaxAttempts {
+			logger.Warn("回呼傳送失敗",
+				slog.String("url", target),
+				slog.String("delivery_id", deliveryID),
+				slog.Int(logKeyAttempt, attempt),
+				slog.Int("status_code", statusCode),
+				durationAttr(time.Since(started)),
+				slog.String(logKeyError, err.Error()),
+			)
+			return
+		}
+		timer := time.NewTimer(n.retry.Backoff(attempt, rand.Float64()))
+		select {
+		case <-timer.C:
+		case <-n.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (n *webhookNotifier) post(target, deliveryID string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, webhookEventCompleted)
+	req.Header.Set(WebhookDeliveryHeader, deliveryID)
+	if n.secret != "" {
+		// 每次嘗試重新簽章，重試的請求時間戳記也是最新的。
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, signWebhookPayload(n.secret, timestamp, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("callback returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// wait 等待所有傳送完成；ctx 結束時中止剩餘的傳送。
+func (n *webhookNotifier) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		n.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// isRetryableWebhookError 判斷傳送失敗是否可重試：網路錯誤、5xx、408 與 429；被阻擋的位址不重試。
+func isRetryableWebhookError(statusCode int, err error) bool {
+	if statusCode == 0 {
+		return err != nil && !errors.Is(err, errCallbackAddressBlocked)
+	}
+	return statusCode >= http.StatusInternalServerError ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode == http.StatusRequestTimeout
+}
+
+func mergeCallbackURLs(global, perReport []string) []string {
+	merged := make([]string, 0, len(global)+len(perReport))
+	seen := make(map[string]struct{}, len(global)+len(perReport))
+	for _, list := range [][]string{global, perReport} {
+		for _, target := range list {
+			if _, ok := seen[target]; ok {
+				continue
+			}
+			seen[target] = struct{}{}
+			merged = append(merged, target)
+		}
+	}
+	return merged
+}
+
+// recordWebhookDelivery 將回呼傳送結果附加至報告。
+func (s *AnalysisService) recordWebhookDelivery(reportID string, delivery WebhookDelivery) {
+	if _, err := s.repo.Update(reportID, func(report *AnalysisReport) error {
+		report.WebhookDeliveries = append(report.WebhookDeliveries, delivery)
+		return nil
+	}); err != nil {
+		s.logger.Error("無法記錄回呼傳送結果",
+			slog.String(logKeyReportID, reportID),
+			slog.String("delivery_id", delivery.DeliveryID),
+			slog.Int(logKeyAttempt, delivery.Attempt),
+			slog.String(logKeyError, err.Error()),
+		)
+	}
+}
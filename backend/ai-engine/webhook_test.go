@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookReceiver 為測試用的本機回呼接收端，前 failures 次回傳 503。
+type webhookReceiver struct {
+	mu        sync.Mutex
+	failures  int
+	calls     int
+	bodies    [][]byte
+	headers   []http.Header
+	reportIDs []string
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	r.bodies = append(r.bodies, body)
+	r.headers = append(r.headers, req.Header.Clone())
+	if r.calls <= r.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var report AnalysisReport
+	_ = json.Unmarshal(body, &report)
+	r.reportIDs = append(r.reportIDs, report.ReportID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func fastWebhookConfig(urls ...string) WebhookConfig {
+	return WebhookConfig{
+		URLs:   urls,
+		Secret: "s3cret",
+		Retry:  RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+	}
+}
+
+func TestWebhookDeliveredOnSuccessWithRetry(t *testing.T) {
+	receiver := &webhookReceiver{failures: 1}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	repo := NewInMemoryReportRepository()
+	webhooks := fastWebhookConfig(server.URL + "/global")
+	// 測試接收端位於本機，請求註冊的 /chatops 需列入允許清單。
+	webhooks.AllowedHosts = []string{"127.0.0.1"}
+	service := NewAnalysisService(repo, &stubGenerator{result: &GeneratedReport{EventSummary: "完成"}}, AnalysisServiceConfig{
+		ProcessingTimeout: time.Second,
+		Webhooks:          webhooks,
+	})
+
+	report, err := service.CreateReport(context.Background(), "evt-hook", CreateAnalysisRequest{
+		CallbackURLs: []string{server.URL + "/global", server.URL + "/chatops"},
+	})
+	if err != nil {
+		t.Fatalf("建立報告失敗: %v", err)
+	}
+	service.Wait()
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	// 重複的網址只通知一次；第一次 503 後重試。
+	if receiver.calls != 3 || len(receiver.reportIDs) != 2 {
+		t.Fatalf("預期 3 次呼叫、2 次成功，實際為 %d / %d", receiver.calls, len(receiver.reportIDs))
+	}
+	for i, header := range receiver.headers {
+		timestamp := header.Get(WebhookTimestampHeader)
+		if timestamp == "" {
+			t.Fatalf("缺少時間戳記標頭: %v", header)
+		}
+		if got, want := header.Get(WebhookSignatureHeader), signWebhookPayload("s3cret", timestamp, receiver.bodies[i]); got != want {
+			t.Fatalf("簽章錯誤: %s != %s", got, want)
+		}
+		if header.Get(WebhookEventHeader) != webhookEventCompleted || header.Get(WebhookDeliveryHeader) == "" {
+			t.Fatalf("通知標頭錯誤: %v", header)
+		}
+	}
+	var delivered AnalysisReport
+	if err := json.Unmarshal(receiver.bodies[len(receiver.bodies)-1], &delivered); err != nil || delivered.Status != ReportStatusSuccess {
+		t.Fatalf("回呼內容應為最終報告，實際為 %+v (%v)", delivered, err)
+	}
+	// 接收端不應看到其他訂閱者的回呼網址與傳送紀錄。
+	for _, body := range receiver.bodies {
+		if bytes.Contains(body, []byte("/chatops")) || bytes.Contains(body, []byte("webhook_deliveries")) {
+			t.Fatalf("回呼內容不應包含回呼網址或傳送紀錄: %s", body)
+		}
+	}
+	resp := httptest.NewRecorder()
+	SetupRouter(service).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/v1/ai/analysis-reports/"+report.ReportID, nil))
+	if resp.Code != http.StatusOK || strings.Contains(resp.Body.String(), "/chatops") || strings.Contains(resp.Body.String(), "webhook_deliveries") {
+		t.Fatalf("API 回應不應包含回呼網址或傳送紀錄: %d %s", resp.Code, resp.Body.String())
+	}
+
+	stored, _ := repo.Get(report.ReportID)
+	if len(stored.WebhookDeliveries) != 3 {
+		t.Fatalf("應記錄 3 筆傳送紀錄，實際為 %d", len(stored.WebhookDeliveries))
+	}
+	var failed, succeeded int
+	for _, delivery := range stored.WebhookDeliveries {
+		if delivery.Success {
+			succeeded++
+		} else if delivery.StatusCode == http.StatusServiceUnavailable {
+			failed++
+		}
+	}
+	if failed != 1 || succeeded != 2 {
+		t.Fatalf("傳送紀錄錯誤: %+v", stored.WebhookDeliveries)
+	}
+}
+
+func TestWebhookOnFailureAndPermanentError(t *testing.T) {
+	var calls int
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	repo := NewInMemoryReportRepository()
+	service := NewAnalysisService(repo, &stubGenerator{err: errors.New("llm down")}, AnalysisServiceConfig{
+		ProcessingTimeout: time.Second,
+		Webhooks:          fastWebhookConfig(server.URL),
+	})
+	report, _ := service.CreateReport(context.Background(), "evt-hook-fail", CreateAnalysisRequest{})
+	service.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 1 {
+		t.Fatalf("4xx 回應不應重試，實際呼叫 %d 次", calls)
+	}
+	stored, _ := repo.Get(report.ReportID)
+	if stored.Status != ReportStatusFailed || len(stored.WebhookDeliveries) != 1 || stored.WebhookDeliveries[0].Success {
+		t.Fatalf("失敗報告應送出通知並記錄失敗，實際為 %s %+v", stored.Status, stored.WebhookDeliveries)
+	}
+}
+
+func TestCreateAnalysisRejectsInvalidCallbackURL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	service := NewAnalysisService(NewInMemoryReportRepository(), &stubGenerator{result: &GeneratedReport{}}, AnalysisServiceConfig{})
+	router := SetupRouter(service)
+
+	body := bytes.NewBufferString(`{"callback_urls":["ftp://example.com/hook"]}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/events/evt-bad-hook/ai-analysis", body)
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("無效的回呼網址應回傳 400，實際為 %d", resp.Code)
+	}
+}
+
+func TestCreateAnalysisRejectsInternalCallbackURL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	service := NewAnalysisService(NewInMemoryReportRepository(), &stubGenerator{result: &GeneratedReport{}}, AnalysisServiceConfig{})
+	router := SetupRouter(service)
+
+	for _, target := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://10.0.0.5/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://[::ffff:192.168.1.1]/hook",
+		"http://100.64.0.1/hook",
+		"http://0.0.0.0/hook",
+	} {
+		body, _ := json.Marshal(map[string]any{"callback_urls": []string{target}})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/events/evt-ssrf/ai-analysis", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("指向內部網路的回呼網址 %s 應回傳 400，實際為 %d", target, resp.Code)
+		}
+	}
+}
+
+func TestCallbackGuardAllowList(t *testing.T) {
+	guard := newCallbackGuard([]string{"10.1.0.0/16", "192.168.1.10", "hooks.internal"}, []string{"http://127.0.0.1:9000/global"})
+	allowed := []string{
+		"http://10.1.2.3/hook",
+		"http://192.168.1.10/hook",
+		"http://hooks.internal/hook",
+		"http://127.0.0.1:9000/global",
+	}
+	if _, err := guard.validateCallbackURLs(context.Background(), allowed); err != nil {
+		t.Fatalf("允許清單內的回呼網址應通過驗證: %v", err)
+	}
+	// 全域回呼網址只放行網址本身，同一主機的其他路徑或埠仍視為內部位址。
+	for _, target := range []string{"http://10.2.0.1/hook", "http://192.168.1.11/hook", "http://127.0.0.1:9000/admin", "http://127.0.0.1/admin"} {
+		if _, err := guard.validateCallbackURLs(context.Background(), []string{target}); !errors.Is(err, ErrInvalidCallbackURL) {
+			t.Fatalf("允許清單外的內部位址 %s 應回傳 ErrInvalidCallbackURL，實際為 %v", target, err)
+		}
+	}
+	if !guard.allowsAddr(netip.MustParseAddr("93.184.216.34")) {
+		t.Fatal("公開位址應允許連線")
+	}
+}
+
+func TestWebhookDialRejectsInternalAddress(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	// 未列入允許清單時，連線時解析出的迴路位址應被阻擋且不重試。
+	var deliveries []WebhookDelivery
+	var mu sync.Mutex
+	notifier := newWebhookNotifier(WebhookConfig{Retry: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}}, slog.Default(), func(_ string, delivery WebhookDelivery) {
+		mu.Lock()
+		defer mu.Unlock()
+		deliveries = append(deliveries, delivery)
+	})
+	notifier.notify(AnalysisReport{ReportID: "rpt-ssrf", CallbackURLs: []string{server.URL + "/hook"}})
+	if err := notifier.wait(context.Background()); err != nil {
+		t.Fatalf("等待傳送失敗: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if receiver.calls != 0 || len(deliveries) != 1 || deliveries[0].Success || !strings.Contains(deliveries[0].Error, errCallbackAddressBlocked.Error()) {
+		t.Fatalf("內部位址應於連線時被阻擋，實際呼叫 %d 次，紀錄 %+v", receiver.calls, deliveries)
+	}
+}
+
+func TestWebhookUnsignedWithoutSecret(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	var mu sync.Mutex
+	var deliveries []WebhookDelivery
+	notifier := newWebhookNotifier(WebhookConfig{URLs: []string{server.URL}}, slog.Default(), func(_ string, delivery WebhookDelivery) {
+		mu.Lock()
+		defer mu.Unlock()
+		deliveries = append(deliveries, delivery)
+	})
+	notifier.notify(AnalysisReport{ReportID: "rpt-unsigned", Status: ReportStatusSuccess})
+	if err := notifier.wait(context.Background()); err != nil {
+		t.Fatalf("等待傳送失敗: %v", err)
+	}
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if receiver.calls != 1 {
+		t.Fatalf("預期呼叫 1 次，實際為 %d", receiver.calls)
+	}
+	header := receiver.headers[0]
+	if header.Get(WebhookSignatureHeader) != "" || header.Get(WebhookTimestampHeader) != "" {
+		t.Fatalf("未設定密鑰時不應附加簽章與時間戳記: %v", header)
+	}
+	if header.Get(WebhookDeliveryHeader) == "" || header.Get(WebhookEventHeader) != webhookEventCompleted {
+		t.Fatalf("未簽章的回呼仍應附加通知標頭: %v", header)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deliveries) != 1 || !deliveries[0].Success {
+		t.Fatalf("未簽章的回呼應傳送成功: %+v", deliveries)
+	}
+}